@@ -99,6 +99,12 @@ cp -f scripts/install.sh "$BASE_DIR/"
 chmod +x "$BASE_DIR/install.sh"
 echo "✓ Installation script copied"
 
+# 复制卸载脚本
+echo "Copying uninstall script..."
+cp -f scripts/uninstall.sh "$BASE_DIR/"
+chmod +x "$BASE_DIR/uninstall.sh"
+echo "✓ Uninstall script copied"
+
 # 设置执行权限
 chmod +x "$BIN_DIR/$APP_NAME"
 
@@ -118,5 +124,8 @@ echo
 echo "To install the agent:"
 echo "  sudo $BASE_DIR/install.sh"
 echo
+echo "To uninstall the agent:"
+echo "  sudo $BASE_DIR/uninstall.sh"
+echo
 echo "To run the agent manually:"
 echo "  $BIN_DIR/$APP_NAME -c $CONF_DIR/conf.yaml"
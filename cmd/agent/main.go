@@ -1,74 +1,132 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/yuhai94/anywhere_agent/internal/agent"
 	"github.com/yuhai94/anywhere_agent/internal/config"
 	"github.com/yuhai94/anywhere_agent/internal/logger"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+	"github.com/yuhai94/anywhere_agent/internal/v2ray"
 )
 
+// shutdownTimeout 等待Agent优雅退出的最长时间
+const shutdownTimeout = 20 * time.Second
+
 func main() {
 	// 解析命令行参数
-	if err := config.InitCLI(); err != nil {
+	cli, err := config.ParseCLI(os.Args[0], os.Args[1:], os.Stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
 	// 检查是否显示版本
-	if config.CLIConfig.Version {
+	if cli.Version {
 		fmt.Printf("Anywhere Agent %s\n", config.GetVersion())
 		os.Exit(0)
 	}
 
+	// 所选代理核心不支持的功能在加载、更新和重载配置时即报错
+	loader := cli.Loader(config.WithValidator(v2ray.ValidateConfig))
+
+	// 输出生效配置
+	if cli.Command == "config show" {
+		if err := loader.Show(os.Stdout, cli.ShowSources); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// 加载配置文件
-	if err := config.LoadConfig(); err != nil {
+	cfg, _, err := loader.Load()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 		os.Exit(1)
 	}
 
 	// 初始化日志系统
-	logger.Init(
-		config.CLIConfig.LogDir,
-		config.AppConfig.Log.Level,
-		config.AppConfig.Log.MaxSize,
-		config.AppConfig.Log.MaxBackups,
-		config.AppConfig.Log.MaxAge,
-	)
-	defer logger.Sync()
+	log, logLevel, err := logger.New(logger.Options{
+		Dir:        cli.LogDir,
+		Level:      cfg.Log.Level,
+		MaxSize:    cfg.Log.MaxSize,
+		MaxBackups: cfg.Log.MaxBackups,
+		MaxAge:     cfg.Log.MaxAge,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create log directory %s: %v\n", cli.LogDir, err)
+		os.Exit(1)
+	}
+	defer log.Sync()
 
 	// 输出启动信息
-	logger.Info("Starting Anywhere Agent", logger.String("version", config.GetVersion()))
+	log.Info("Starting Anywhere Agent", zap.String("version", config.GetVersion()))
+
+	// 根context，贯穿Agent、调度器、V2Ray部署和AWS请求
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 打开状态文件，保存REALITY密钥等自动生成的数据
+	store, err := state.Open(cli.StateFile)
+	if err != nil {
+		log.Fatal("Failed to open state file", zap.Error(err))
+	}
+
+	// 在创建和启动Agent前注册信号，避免启动期间收到的SIGHUP按默认行为终止进程
+	// 启动完成前收到的信号在通道中排队，启动后再处理。
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// 创建Agent实例
-	logger.Info("Creating agent instance")
-	agentInstance, err := agent.NewAgent(&config.AppConfig)
+	log.Info("Creating agent instance")
+	agentInstance, err := agent.NewAgent(ctx, cfg,
+		agent.WithLogger(log),
+		agent.WithLogLevel(logLevel),
+		agent.WithConfigLoader(loader),
+		agent.WithWatchConfig(cli.WatchConfig),
+		agent.WithStateStore(store),
+	)
 	if err != nil {
-		logger.Fatal("Failed to create agent", zap.Error(err))
+		log.Fatal("Failed to create agent", zap.Error(err))
 	}
-	logger.Info("Agent instance created successfully")
+	log.Info("Agent instance created successfully")
 
 	// 启动Agent
-	logger.Info("Starting agent")
-	go func() {
-		if err := agentInstance.Start(); err != nil {
-			logger.Fatal("Agent error", zap.Error(err))
-		}
-	}()
+	log.Info("Starting agent")
+	if err := agentInstance.Start(ctx); err != nil {
+		log.Fatal("Agent error", zap.Error(err))
+	}
 
-	// 等待终止信号
-	logger.Info("Waiting for termination signal")
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// 等待终止信号，SIGHUP触发配置重载
+	log.Info("Waiting for termination signal")
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Info("Received SIGHUP, reloading config")
+		agentInstance.Reload(ctx)
+	}
 
-	// 优雅关闭Agent
-	logger.Info("Received termination signal, stopping agent")
-	agentInstance.Stop()
-	logger.Info("Agent exited gracefully")
+	// 优雅关闭Agent，在systemd的TimeoutStopSec之前完成
+	log.Info("Received termination signal, stopping agent")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	if err := agentInstance.Stop(shutdownCtx); err != nil {
+		log.Warn("Agent did not stop cleanly", zap.Error(err))
+		return
+	}
+	log.Info("Agent exited gracefully")
 }
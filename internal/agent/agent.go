@@ -1,173 +1,981 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/yuhai94/anywhere_agent/internal/api"
 	"github.com/yuhai94/anywhere_agent/internal/aws"
+	"github.com/yuhai94/anywhere_agent/internal/command"
 	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/decoy"
+	"github.com/yuhai94/anywhere_agent/internal/events"
+	"github.com/yuhai94/anywhere_agent/internal/firewall"
 	"github.com/yuhai94/anywhere_agent/internal/logger"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"github.com/yuhai94/anywhere_agent/internal/state"
 	"github.com/yuhai94/anywhere_agent/internal/v2ray"
 	"go.uber.org/zap"
 )
 
+// apiShutdownTimeout 重启API服务器或伪装站点时等待现有请求结束的时间
+const apiShutdownTimeout = 10 * time.Second
+
 // Agent Anywhere Agent核心结构
 type Agent struct {
+	mu         sync.Mutex
+	coreMu     sync.Mutex // 串行执行对代理核心的修改：部署、版本切换、geo数据更新和重新生成配置；需要同时持有时先获取mu
 	config     *config.Config
 	ec2Client  *aws.EC2Client
+	runner     command.Runner
+	backend    v2ray.ProxyBackend     // 按 backend 选择的代理核心
+	services   service.ServiceManager // 控制代理核心服务
+	store      *state.Store           // 保存REALITY密钥等自动生成的数据
 	apiServer  *api.APIServer
+	decoy      *decoy.Server // 伪装站点，v2ray.decoy.enabled 时运行
 	scheduler  *Scheduler
 	stats      *v2ray.TrafficMonitor
+	health     *v2ray.HealthMonitor // 上游出站健康检查
+	events     *events.Bus          // 凭据轮换等状态变化
+	firewall   firewall.Firewall    // 端口跳跃和端口轮换的重定向规则，受a.mu保护
+	sgPlan     *securityGroupPlan   // 待同步到安全组的端口，受a.mu保护
+	sgMu       sync.Mutex           // 串行执行安全组同步，AWS调用期间不持有a.mu
 	deployChan chan *v2ray.DeployStatus
 	wg         sync.WaitGroup
-	stopChan   chan struct{}
+	ctx        context.Context // Start时创建，Stop时取消
+	cancel     context.CancelFunc
+
+	log         *zap.Logger
+	logLevel    *zap.AtomicLevel // 为空时不支持运行时调整日志级别
+	loader      *config.Loader   // 为空时不支持配置更新和重载
+	watchConfig bool
+}
+
+// Option Agent可选参数
+type Option func(*Agent)
+
+// WithLogger 设置Agent及其子系统使用的日志实例
+func WithLogger(log *zap.Logger) Option {
+	return func(a *Agent) {
+		a.log = log
+	}
 }
 
-// NewAgent 创建新的Agent实例
-func NewAgent(cfg *config.Config) (*Agent, error) {
-	// 创建部署状态通道
-	deployChan := make(chan *v2ray.DeployStatus, 1)
+// WithLogLevel 设置运行时可调整的日志级别，log.level 变化时更新
+func WithLogLevel(level zap.AtomicLevel) Option {
+	return func(a *Agent) {
+		a.logLevel = &level
+	}
+}
 
-	// 创建流量监控器
-	stats := v2ray.NewTrafficMonitor(cfg.V2Ray.AccessLog, cfg.Checks.IdleTimeout)
+// WithConfigLoader 设置配置加载器，用于API更新配置和重载配置文件
+func WithConfigLoader(loader *config.Loader) Option {
+	return func(a *Agent) {
+		a.loader = loader
+	}
+}
+
+// WithWatchConfig 配置文件变化时自动重载，需要同时设置 WithConfigLoader
+func WithWatchConfig(watch bool) Option {
+	return func(a *Agent) {
+		a.watchConfig = watch
+	}
+}
+
+// WithEC2Client 使用指定的EC2客户端，不再从实例元数据创建
+func WithEC2Client(ec2Client *aws.EC2Client) Option {
+	return func(a *Agent) {
+		a.ec2Client = ec2Client
+	}
+}
+
+// WithCommandRunner 使用指定的命令执行器执行V2Ray相关的系统命令
+func WithCommandRunner(runner command.Runner) Option {
+	return func(a *Agent) {
+		a.runner = runner
+	}
+}
+
+// WithServiceManager 使用指定的服务管理器控制V2Ray，不再按 v2ray.service_manager 创建
+func WithServiceManager(svc service.ServiceManager) Option {
+	return func(a *Agent) {
+		a.services = svc
+	}
+}
+
+// WithStateStore 使用指定的状态存储，默认为 state.DefaultPath
+func WithStateStore(store *state.Store) Option {
+	return func(a *Agent) {
+		a.store = store
+	}
+}
+
+// NewAgent 创建新的Agent实例，ctx用于创建过程中的AWS请求
+func NewAgent(ctx context.Context, cfg *config.Config, opts ...Option) (*Agent, error) {
+	a := &Agent{
+		config:     cfg,
+		deployChan: make(chan *v2ray.DeployStatus, 1), // 部署状态通道
+		log:        zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.runner == nil {
+		a.runner = command.NewExecRunner(a.log.Named("exec"))
+	}
+	if a.store == nil {
+		store, err := state.Open(state.DefaultPath)
+		if err != nil {
+			return nil, err
+		}
+		a.store = store
+	}
+
+	// 选择代理核心
+	backend, err := v2ray.NewBackend(cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	a.backend = backend
+
+	// 创建代理核心的服务管理器
+	if a.services == nil {
+		svc, err := service.New(cfg.V2Ray.ServiceManager, backend.ServiceSpec(),
+			service.WithLogger(a.log.Named("service")),
+			service.WithCommandRunner(a.runner))
+		if err != nil {
+			return nil, err
+		}
+		a.services = svc
+	}
+
+	// 创建流量监控器，sing-box的Clash API密钥从状态文件读取
+	monitorConfig, err := v2ray.ApplyClashSecret(a.store, backend, cfg.V2Ray)
+	if err != nil {
+		return nil, err
+	}
+	a.stats = v2ray.NewTrafficMonitor(backend, monitorConfig, cfg.Checks.IdleTimeout.Std(),
+		v2ray.WithLogger(a.log.Named("traffic")))
 
 	// 创建AWS EC2客户端
-	ec2Client, err := aws.NewEC2Client()
+	if a.ec2Client == nil {
+		ec2Client, err := aws.NewEC2Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a.ec2Client = ec2Client
+	}
+
+	// 创建出站健康检查
+	a.health = v2ray.NewHealthMonitor(backend, monitorConfig,
+		v2ray.WithHealthLogger(a.log.Named("health")))
+
+	// 创建端口重定向使用的防火墙
+	fw, err := firewall.New(cfg.V2Ray.Firewall.Backend,
+		firewall.WithLogger(a.log.Named("firewall")),
+		firewall.WithCommandRunner(a.runner))
 	if err != nil {
 		return nil, err
 	}
+	a.firewall = fw
 
-	// 创建API服务器
-	apiServer := api.NewAPIServer(cfg, deployChan, stats)
+	// 创建事件总线
+	a.events = events.NewBus(events.WithLogger(a.log.Named("events")))
 
 	// 创建调度器
-	scheduler := NewScheduler(cfg, ec2Client, stats, deployChan)
+	a.scheduler = NewScheduler(cfg, a.ec2Client, a.stats, a.deployChan,
+		WithSchedulerLogger(a.log.Named("scheduler")),
+		WithHealthMonitor(a.health),
+		WithGeoDataUpdater(a),
+		WithCredentialRotator(a),
+		WithPortRotator(a),
+		WithClientAccessChecker(a),
+		WithUserTrafficCollector(a))
 
-	return &Agent{
-		config:     cfg,
-		ec2Client:  ec2Client,
-		apiServer:  apiServer,
-		scheduler:  scheduler,
-		stats:      stats,
-		deployChan: deployChan,
-		stopChan:   make(chan struct{}),
-	}, nil
+	// 创建伪装站点
+	a.decoy = decoy.NewServer(cfg.V2Ray.Decoy, decoy.WithLogger(a.log.Named("decoy")))
+
+	// 创建API服务器，配置更新由Agent负责应用
+	a.apiServer = api.NewAPIServer(cfg, a.deployChan, a.stats, a,
+		api.WithLogger(a.log.Named("api")),
+		api.WithCommandRunner(a.runner),
+		api.WithProxyBackend(a.backend),
+		api.WithServiceManager(a.services),
+		api.WithStateStore(a.store),
+		api.WithHealthMonitor(a.health),
+		api.WithV2RayManager(a),
+		api.WithCredentialManager(a),
+		api.WithPortManager(a),
+		api.WithEventBus(a.events))
+
+	return a, nil
 }
 
-// Start 启动Agent
-func (a *Agent) Start() error {
-	logger.Info("Starting Anywhere Agent...")
+// Start 启动Agent，ctx取消后所有后台任务随之停止
+func (a *Agent) Start(ctx context.Context) error {
+	a.log.Info("Starting Anywhere Agent...")
+
+	a.ctx, a.cancel = context.WithCancel(ctx)
 
 	// 1. 部署V2Ray
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
-		a.deployV2Ray()
+		a.deployV2Ray(a.ctx)
 	}()
 
 	// 2. 启动API服务器
-	a.wg.Add(1)
-	go func() {
-		defer a.wg.Done()
-		if err := a.apiServer.Start(); err != nil {
-			logger.Error("API server error", zap.Error(err))
-		}
-	}()
+	a.startAPIServer()
 
 	// 3. 启动调度器
-	a.scheduler.Start()
+	a.scheduler.Start(a.ctx)
+
+	// 4. 启动伪装站点（可选）
+	if a.config.V2Ray.Decoy.Enabled {
+		a.startDecoy()
+	}
+
+	// 5. 监听配置文件变化（可选）
+	if a.watchConfig && a.loader != nil {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.log.Info("Watching config file for changes", zap.String("path", a.loader.Path()))
+			err := config.Watch(a.ctx, a.loader.Path(), func() {
+				a.log.Info("Config file changed, reloading")
+				a.Reload(a.ctx)
+			})
+			if err != nil {
+				a.log.Error("Config watcher stopped", zap.Error(err))
+			}
+		}()
+	}
 
-	logger.Info("Anywhere Agent started successfully")
+	a.log.Info("Anywhere Agent started successfully")
 
 	// 不需要在这里等待，由main函数处理退出
 	return nil
 }
 
-// Stop 停止Agent
-func (a *Agent) Stop() {
-	logger.Info("Stopping Anywhere Agent...")
+// Stop 停止Agent，等待所有后台任务退出，ctx到期时不再等待并返回错误
+func (a *Agent) Stop(ctx context.Context) error {
+	a.log.Info("Stopping Anywhere Agent...")
 
-	// 关闭停止通道
-	close(a.stopChan)
+	// 取消所有后台任务，正在执行的命令和请求随之中止；Start未执行时没有后台任务
+	if a.cancel != nil {
+		a.cancel()
+	}
 
 	// 停止调度器
 	a.scheduler.Stop()
 
 	// 停止API服务器
-	if err := a.apiServer.Stop(); err != nil {
-		logger.Error("Failed to stop API server", zap.Error(err))
+	if err := a.apiServer.Stop(ctx); err != nil {
+		a.log.Error("Failed to stop API server", zap.Error(err))
+	}
+
+	// 停止伪装站点
+	if err := a.decoy.Stop(ctx); err != nil {
+		a.log.Error("Failed to stop decoy site", zap.Error(err))
+	}
+
+	// 删除端口重定向规则，端口跳跃和旧端口随之失效
+	a.mu.Lock()
+	fw := a.firewall
+	a.mu.Unlock()
+	if err := fw.Cleanup(ctx); err != nil {
+		a.log.Error("Failed to clean up firewall rules", zap.Error(err))
+	}
+
+	// 代理核心作为Agent子进程运行时随Agent一起停止
+	if a.services.Kind() == service.KindSupervisor {
+		if err := a.services.Stop(ctx); err != nil {
+			a.log.Error("Failed to stop supervised proxy", zap.Error(err))
+		}
 	}
 
 	// 等待所有goroutine完成
-	a.wg.Wait()
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
 
-	logger.Info("Anywhere Agent stopped successfully")
+	select {
+	case <-done:
+		a.log.Info("Anywhere Agent stopped successfully")
+		return nil
+	case <-ctx.Done():
+		a.log.Warn("Timed out waiting for background tasks to stop", zap.Error(ctx.Err()))
+		return ctx.Err()
+	}
 }
 
 // deployV2Ray 部署V2Ray
-func (a *Agent) deployV2Ray() {
-	logger.Info("Deploying V2Ray...")
+func (a *Agent) deployV2Ray(ctx context.Context) {
+	a.log.Info("Deploying proxy...", zap.String("backend", a.backend.Name()))
+	v2rayLog := a.log.Named(a.backend.Name())
 
-	// 检查是否已收到停止信号
-	select {
-	case <-a.stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
+	// 部署V2Ray，已安装时跳过安装，只确保配置最新且服务在运行；ctx取消时中止
+	a.mu.Lock()
+	v2rayConfig := a.config.V2Ray
+	a.mu.Unlock()
+	a.coreMu.Lock()
+	status, err := v2ray.Deploy(ctx, v2rayLog, a.runner, a.backend, a.services, a.store, v2rayConfig)
+	a.coreMu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		a.log.Info("V2Ray deployment canceled")
 		return
+	}
+	if err != nil {
+		a.log.Error("Failed to deploy V2Ray", zap.Error(err))
+		return
+	}
+
+	// 发送部署状态
+	select {
+	case a.deployChan <- status:
 	default:
-		// 继续执行
+		// 通道已满，忽略
 	}
 
-	// 检查V2Ray是否已安装
-	installed, version, err := v2ray.CheckV2Ray()
+	a.log.Info("V2Ray deployment completed")
+
+	// 端口跳跃、旧端口重定向和安全组
+	a.mu.Lock()
+	err = a.syncFirewall(ctx, a.config.V2Ray)
+	a.mu.Unlock()
 	if err != nil {
-		logger.Error("Failed to check V2Ray", zap.Error(err))
+		a.log.Error("Failed to sync firewall", zap.Error(err))
+	}
+	if err := a.syncSecurityGroup(ctx); err != nil {
+		a.log.Error("Failed to sync security group", zap.Error(err))
+	}
+
+	// 已安装的版本与 v2ray.version 不一致时切换版本
+	a.ensureV2RayVersion(ctx)
+
+	// 发布包自带的geo数据文件可能已过期，部署后立即检查一次
+	if v2rayConfig.GeoData.Interval > 0 {
+		if err := a.UpdateGeoData(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			a.log.Error("Failed to update geo data", zap.Error(err))
+		}
+	}
+}
+
+// ensureV2RayVersion 将已安装的V2Ray切换到 v2ray.version 指定的版本
+// v2ray.version 为 latest 时只在未安装时安装最新版本，不自动升级。
+func (a *Agent) ensureV2RayVersion(ctx context.Context) {
+	a.mu.Lock()
+	v2rayConfig := a.config.V2Ray
+	a.mu.Unlock()
+	if v2rayConfig.Version == "" || v2rayConfig.Version == "latest" {
 		return
 	}
 
-	// 检查是否已收到停止信号
-	select {
-	case <-a.stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
+	a.coreMu.Lock()
+	defer a.coreMu.Unlock()
+
+	// 升级后按入站当前的端口检查核心是否正常
+	resolved, err := v2ray.ApplyPorts(a.store, v2rayConfig)
+	if err != nil {
+		a.log.Error("Failed to load rotated ports", zap.Error(err))
 		return
-	default:
-		// 继续执行
 	}
+	result, err := v2ray.Upgrade(ctx, a.log.Named(a.backend.Name()), a.runner, a.backend, a.services, resolved, "")
+	if err != nil {
+		a.log.Error("Failed to switch V2Ray to desired version",
+			zap.String("version", v2rayConfig.Version),
+			zap.Error(err))
+		return
+	}
+	if result.Changed {
+		a.log.Info("V2Ray switched to desired version",
+			zap.String("from", result.From),
+			zap.String("to", result.To))
+	}
+}
+
+// UpgradeV2Ray 将V2Ray切换到指定版本，失败时自动回滚
+// version 为空时使用 v2ray.version；指定版本且切换成功时写回 v2ray.version，避免重启后回到旧版本。
+func (a *Agent) UpgradeV2Ray(ctx context.Context, version string) (*v2ray.UpgradeResult, error) {
+	version = strings.TrimPrefix(version, "v")
+
+	a.mu.Lock()
+	v2rayConfig := a.config.V2Ray
+	a.mu.Unlock()
+
+	resolved, err := v2ray.ApplyPorts(a.store, v2rayConfig)
+	if err != nil {
+		return nil, err
+	}
+	a.coreMu.Lock()
+	result, err := v2ray.Upgrade(ctx, a.log.Named(a.backend.Name()), a.runner, a.backend, a.services, resolved, version)
+	a.coreMu.Unlock()
+	if err != nil || version == "" || version == v2rayConfig.Version || a.loader == nil {
+		return result, err
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"v2ray": map[string]string{"version": version},
+	})
+	if err != nil {
+		return result, err
+	}
+	// 已切换到该版本，保存配置时不再触发版本切换
+	if _, _, err := a.updateConfig(ctx, patch, false); err != nil {
+		a.log.Warn("Failed to save upgraded V2Ray version to config", zap.Error(err))
+	}
+	return result, nil
+}
+
+// UpdateGeoData 检查并更新geo数据文件，与部署和版本切换串行执行
+func (a *Agent) UpdateGeoData(ctx context.Context) error {
+	a.mu.Lock()
+	v2rayConfig := a.config.V2Ray
+	a.mu.Unlock()
+
+	a.coreMu.Lock()
+	defer a.coreMu.Unlock()
+
+	_, err := v2ray.UpdateGeoData(ctx, a.log.Named(a.backend.Name()), a.backend, a.services, a.store, v2rayConfig)
+	return err
+}
+
+// CollectUserTraffic 收集每个用户的流量并累加到状态文件中，核心不支持按用户统计时跳过
+func (a *Agent) CollectUserTraffic(ctx context.Context) error {
+	a.mu.Lock()
+	v2rayConfig := a.config.V2Ray
+	a.mu.Unlock()
+
+	err := v2ray.CollectUserTraffic(ctx, a.runner, a.backend, a.store, v2rayConfig)
+	if errors.Is(err, v2ray.ErrUserTrafficUnsupported) {
+		return nil
+	}
+	return err
+}
+
+// RotateCredentials 为用户生成新的UUID并更新核心配置，旧UUID在 v2ray.rotation.overlap 内继续有效
+func (a *Agent) RotateCredentials(ctx context.Context, email string) (*v2ray.RotationResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v2rayConfig := a.config.V2Ray
+	result, err := v2ray.RotateCredentials(a.store, v2rayConfig, email, v2rayConfig.Rotation.Overlap.Std())
+	if err != nil {
+		return nil, err
+	}
+	a.publishRotation(*result, "api")
+
+	// 新UUID已保存，核心配置更新失败时下次部署或重新生成配置时生效
+	if err := a.reconfigure(ctx, v2rayConfig); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// RotateDueCredentials 轮换已到 v2ray.rotation.interval 的用户凭据，并移除重叠期已过的旧UUID
+func (a *Agent) RotateDueCredentials(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v2rayConfig := a.config.V2Ray
+	rotation := v2rayConfig.Rotation
+	results, changed, err := v2ray.RotateDueCredentials(a.store, v2rayConfig, rotation.Interval.Std(), rotation.Overlap.Std())
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		a.publishRotation(result, "schedule")
+	}
+	if !changed {
+		return nil
+	}
+	return a.reconfigure(ctx, v2rayConfig)
+}
+
+// publishRotation 发布凭据轮换事件，trigger 为 api 或 schedule
+func (a *Agent) publishRotation(result v2ray.RotationResult, trigger string) {
+	a.events.Publish(events.CredentialsRotated, result.Email, map[string]interface{}{
+		"rotated_at":          result.RotatedAt,
+		"previous_expires_at": result.PreviousExpiresAt,
+		"trigger":             trigger,
+	})
+}
+
+// RotatePort 将入站的监听端口换到轮换范围内的随机端口，并更新核心配置和防火墙
+func (a *Agent) RotatePort(ctx context.Context, tag string) (*v2ray.PortRotationResult, error) {
+	result, err := a.rotatePort(ctx, tag)
+	if err != nil {
+		return result, err
+	}
+	if err := a.syncSecurityGroup(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// rotatePort 在a.mu内轮换端口并更新核心配置和重定向规则
+func (a *Agent) rotatePort(ctx context.Context, tag string) (*v2ray.PortRotationResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v2rayConfig := a.config.V2Ray
+	result, err := v2ray.RotatePort(a.store, v2rayConfig, tag, a.config.API.Port)
+	if err != nil {
+		return nil, err
+	}
+	a.publishPortRotation(*result, "api")
+
+	if err := a.applyPorts(ctx, v2rayConfig); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// RotateDuePorts 轮换已到 port_rotation.interval 的入站端口，并移除重叠期已过的旧端口重定向
+func (a *Agent) RotateDuePorts(ctx context.Context) error {
+	if err := a.rotateDuePorts(ctx); err != nil {
+		return err
+	}
+	return a.syncSecurityGroup(ctx)
+}
+
+// rotateDuePorts 在a.mu内轮换端口并更新核心配置和重定向规则
+func (a *Agent) rotateDuePorts(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v2rayConfig := a.config.V2Ray
+	results, changed, err := v2ray.RotateDuePorts(a.store, v2rayConfig, a.config.API.Port)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		a.publishPortRotation(result, "schedule")
+	}
+	if !changed {
+		return nil
+	}
+	return a.applyPorts(ctx, v2rayConfig)
+}
+
+// applyPorts 端口变化后更新核心配置和防火墙，调用方需持有a.mu，释放后调用 syncSecurityGroup
+// 先让核心监听新端口，再将旧端口重定向过去。
+func (a *Agent) applyPorts(ctx context.Context, cfg config.V2RayConfig) error {
+	if err := a.reconfigure(ctx, cfg); err != nil {
+		return err
+	}
+	return a.syncFirewall(ctx, cfg)
+}
+
+// securityGroupPlan 安全组应放行的端口，在a.mu内计算，释放a.mu后由 syncSecurityGroup 同步
+type securityGroupPlan struct {
+	groupID string
+	ranges  []aws.PortRange
+}
 
-	if installed {
-		logger.Info("V2Ray already installed", logger.String("version", version))
-		// 发送部署状态
-		status := &v2ray.DeployStatus{
-			Installed: true,
-			Running:   v2ray.IsV2RayRunning(),
-			Version:   version,
-			Progress:  100,
-			Message:   "V2Ray already installed",
+// syncFirewall 按当前端口更新重定向规则，配置了 v2ray.firewall.security_group 时记录安全组应放行的端口
+// 调用方需持有a.mu，释放后调用 syncSecurityGroup。
+func (a *Agent) syncFirewall(ctx context.Context, cfg config.V2RayConfig) error {
+	redirects, err := v2ray.PortRedirects(a.store, cfg)
+	if err != nil {
+		return err
+	}
+	if err := a.firewall.Apply(ctx, redirects); err != nil {
+		return err
+	}
+
+	if cfg.Firewall.SecurityGroup == "" {
+		a.sgPlan = nil
+		return nil
+	}
+	open, err := v2ray.OpenPorts(a.store, cfg)
+	if err != nil {
+		return err
+	}
+	ranges := make([]aws.PortRange, len(open))
+	for i, r := range open {
+		ranges[i] = aws.PortRange{From: r.From, To: r.To}
+	}
+	a.sgPlan = &securityGroupPlan{groupID: cfg.Firewall.SecurityGroup, ranges: ranges}
+	return nil
+}
+
+// syncSecurityGroup 同步 syncFirewall 记录的安全组端口，调用方不能持有a.mu
+// AWS调用可能很慢，期间不阻塞配置读取和更新；同步之间串行执行，总是使用最新记录的端口。
+func (a *Agent) syncSecurityGroup(ctx context.Context) error {
+	a.sgMu.Lock()
+	defer a.sgMu.Unlock()
+
+	a.mu.Lock()
+	plan := a.sgPlan
+	a.sgPlan = nil
+	a.mu.Unlock()
+	if plan == nil {
+		return nil
+	}
+
+	err := a.applySecurityGroup(ctx, plan)
+	if err != nil {
+		// 同步失败时保留记录，下次同步时重试；期间记录了新端口时以新端口为准
+		a.mu.Lock()
+		if a.sgPlan == nil {
+			a.sgPlan = plan
 		}
-		select {
-		case a.deployChan <- status:
-		default:
-			// 通道已满，忽略
+		a.mu.Unlock()
+	}
+	return err
+}
+
+// applySecurityGroup 放行安全组中的端口，并删除Agent此前添加、现已不需要的规则
+func (a *Agent) applySecurityGroup(ctx context.Context, plan *securityGroupPlan) error {
+	instanceID, err := aws.GetInstanceID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get instance ID: %w", err)
+	}
+	added, removed, err := a.ec2Client.SyncSecurityGroupPorts(ctx, plan.groupID, instanceID, plan.ranges)
+	if err != nil {
+		return err
+	}
+	if added > 0 || removed > 0 {
+		a.log.Info("Security group rules updated",
+			zap.String("security_group", plan.groupID),
+			zap.Int("added", added),
+			zap.Int("removed", removed))
+	}
+	return nil
+}
+
+// publishPortRotation 发布端口轮换事件，trigger 为 api 或 schedule
+func (a *Agent) publishPortRotation(result v2ray.PortRotationResult, trigger string) {
+	a.events.Publish(events.PortRotated, result.Tag, map[string]interface{}{
+		"port":                result.Port,
+		"previous_port":       result.PreviousPort,
+		"rotated_at":          result.RotatedAt,
+		"previous_expires_at": result.PreviousExpiresAt,
+		"trigger":             trigger,
+	})
+}
+
+// CheckClientAccess 检查用户的有效期和允许的时间段，状态变化时发布事件并更新核心中的用户
+func (a *Agent) CheckClientAccess(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v2rayConfig := a.config.V2Ray
+	transitions, err := v2ray.CheckClientAccess(a.store, v2rayConfig)
+	if err != nil {
+		return err
+	}
+	if len(transitions) == 0 {
+		return nil
+	}
+	for _, transition := range transitions {
+		a.log.Info("Client access changed",
+			zap.String("email", transition.Email),
+			zap.String("from", transition.From),
+			zap.String("to", transition.To))
+		data := map[string]interface{}{
+			"from": transition.From,
+			"to":   transition.To,
+		}
+		if !transition.NextChange.IsZero() {
+			data["next_change"] = transition.NextChange
+		}
+		a.events.Publish(events.ClientAccessChanged, transition.Email, data)
+	}
+	return a.reconfigure(ctx, v2rayConfig)
+}
+
+// reconfigure 按配置重新生成代理核心配置，有变化时重启服务；与部署和版本切换串行执行
+func (a *Agent) reconfigure(ctx context.Context, cfg config.V2RayConfig) error {
+	a.coreMu.Lock()
+	defer a.coreMu.Unlock()
+	return v2ray.Reconfigure(ctx, a.log.Named(a.backend.Name()), a.runner, a.backend, a.services, a.store, cfg)
+}
+
+// startAPIServer 在后台启动API服务器
+func (a *Agent) startAPIServer() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := a.apiServer.Start(a.ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.log.Error("API server error", zap.Error(err))
 		}
+	}()
+}
+
+// restartAPIServer 以新的监听地址重启API服务器
+func (a *Agent) restartAPIServer(address string, port int) {
+	a.log.Info("Restarting API server with new listen address",
+		zap.String("address", address),
+		zap.Int("port", port))
+
+	stopCtx, cancel := context.WithTimeout(a.ctx, apiShutdownTimeout)
+	defer cancel()
+	if err := a.apiServer.Stop(stopCtx); err != nil {
+		a.log.Error("Failed to stop API server", zap.Error(err))
+	}
+
+	// Agent正在停止时不再重新启动
+	if a.ctx.Err() != nil {
 		return
 	}
 
-	// 部署V2Ray，使用支持取消的版本
-	status, err := v2ray.DeployV2RayWithContext(a.config.V2Ray.Port, a.config.V2Ray.UUID, a.config.V2Ray.AccessLog, a.stopChan)
-	if err != nil {
-		logger.Error("Failed to deploy V2Ray", zap.Error(err))
+	a.apiServer.SetListenAddress(address, port)
+	a.startAPIServer()
+}
+
+// startDecoy 在后台启动伪装站点
+func (a *Agent) startDecoy() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := a.decoy.Start(a.ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.log.Error("Decoy site error", zap.Error(err))
+		}
+	}()
+}
+
+// restartDecoy 按新配置重启伪装站点，未启用时只停止
+func (a *Agent) restartDecoy(cfg config.DecoyConfig) {
+	stopCtx, cancel := context.WithTimeout(a.ctx, apiShutdownTimeout)
+	defer cancel()
+	if err := a.decoy.Stop(stopCtx); err != nil {
+		a.log.Error("Failed to stop decoy site", zap.Error(err))
+	}
+
+	if a.ctx.Err() != nil || !cfg.Enabled {
 		return
 	}
 
-	// 发送部署状态
-	select {
-	case a.deployChan <- status:
-	default:
-		// 通道已满，忽略
+	a.log.Info("Restarting decoy site with new config")
+	a.decoy.SetConfig(cfg)
+	a.startDecoy()
+}
+
+// Config 返回当前生效的配置
+func (a *Agent) Config() *config.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.config
+}
+
+// UpdateConfig 合并配置补丁，校验并写回配置文件后应用到运行中的子系统
+func (a *Agent) UpdateConfig(ctx context.Context, patch []byte) ([]string, []string, error) {
+	return a.updateConfig(ctx, patch, true)
+}
+
+// updateConfig 合并补丁并应用，switchVersion 为false时 v2ray.version 的变化已由调用方完成
+func (a *Agent) updateConfig(ctx context.Context, patch []byte, switchVersion bool) ([]string, []string, error) {
+	changed, restartRequired, err := a.patchConfig(ctx, patch, switchVersion)
+	if err != nil {
+		return changed, restartRequired, err
+	}
+	if err := a.syncSecurityGroup(ctx); err != nil {
+		return changed, restartRequired, &api.ApplyError{Err: err}
+	}
+	return changed, restartRequired, nil
+}
+
+// patchConfig 在a.mu内合并补丁并应用到运行中的子系统
+func (a *Agent) patchConfig(ctx context.Context, patch []byte, switchVersion bool) ([]string, []string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.loader == nil {
+		return nil, nil, errors.New("config updates are not supported")
+	}
+
+	newConfig, changed, err := a.loader.Update(a.config, patch)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(changed) == 0 {
+		return nil, nil, nil
 	}
 
-	if status.Message != "Deployment canceled" {
-		logger.Info("V2Ray deployment completed")
-	} else {
-		logger.Info("V2Ray deployment canceled")
+	a.log.Info("Config updated via API", zap.Strings("changed", changed))
+	restartRequired, err := a.applyConfig(ctx, newConfig, changed, switchVersion)
+	if err != nil {
+		return changed, restartRequired, &api.ApplyError{Err: err}
+	}
+
+	return changed, restartRequired, nil
+}
+
+// Reload 重新加载配置文件并应用变化，新配置无效时保留当前配置
+func (a *Agent) Reload(ctx context.Context) error {
+	if err := a.reload(ctx); err != nil {
+		return err
 	}
+	if err := a.syncSecurityGroup(ctx); err != nil {
+		a.log.Error("Failed to sync security group", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// reload 在a.mu内重新加载配置文件并应用变化
+func (a *Agent) reload(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.loader == nil {
+		return errors.New("config reload is not supported")
+	}
+
+	newConfig, changed, err := a.loader.Reload(a.config)
+	if err != nil {
+		a.log.Error("Failed to reload config, keeping current config", zap.Error(err))
+		return err
+	}
+	if len(changed) == 0 {
+		a.log.Info("Config reloaded, nothing changed")
+		return nil
+	}
+
+	a.log.Info("Config reloaded", zap.Strings("changed", changed))
+	restartRequired, err := a.applyConfig(ctx, newConfig, changed, true)
+	if len(restartRequired) > 0 {
+		a.log.Warn("Some config changes require an agent restart", zap.Strings("keys", restartRequired))
+	}
+	if err != nil {
+		a.log.Error("Failed to apply reloaded config", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// applyConfig 将新配置应用到运行中的各个子系统，调用方需持有a.mu
+// changed 为发生变化的配置项，返回需要重启Agent才能生效的配置项；
+// switchVersion 为false时不因 v2ray.version 的变化切换核心版本。
+func (a *Agent) applyConfig(ctx context.Context, cfg *config.Config, changed []string, switchVersion bool) ([]string, error) {
+	var restartRequired []string
+	reconfigureV2Ray := false
+	upgradeV2Ray := false
+	restartAPI := false
+	restartDecoy := false
+	syncFirewall := false
+	replaceFirewall := false
+
+	for _, key := range changed {
+		switch {
+		case key == "v2ray.version":
+			// 异步切换版本，避免下载时阻塞配置更新
+			upgradeV2Ray = switchVersion
+		case strings.HasPrefix(key, "v2ray.install."):
+			// 安装来源在下次安装或升级时生效
+		case key == "v2ray.service_manager" || key == "backend":
+			// 代理核心和服务管理器在启动时创建
+			restartRequired = append(restartRequired, key)
+		case key == "v2ray.geodata.interval":
+			a.scheduler.SetGeoDataInterval(cfg.V2Ray.GeoData.Interval.Std())
+		case strings.HasPrefix(key, "v2ray.geodata."):
+			// 下载地址在下次更新时生效
+		case strings.HasPrefix(key, "v2ray.rotation."):
+			// 下次检查凭据时生效
+		case key == "v2ray.firewall.backend":
+			replaceFirewall = true
+			syncFirewall = true
+		case strings.HasPrefix(key, "v2ray.firewall."):
+			syncFirewall = true
+		case strings.HasPrefix(key, "v2ray.decoy."):
+			// 回落目标写在入站配置中，同时更新核心配置
+			restartDecoy = true
+			reconfigureV2Ray = true
+		case key == "v2ray.health_check.interval":
+			a.scheduler.SetHealthInterval(cfg.V2Ray.HealthCheck.Interval.Std())
+			reconfigureV2Ray = true
+		case strings.HasPrefix(key, "v2ray."):
+			// 入站的端口跳跃范围和端口轮换设置同时影响重定向规则
+			reconfigureV2Ray = true
+			syncFirewall = true
+		case key == "api.token":
+			// 每个请求按当前配置校验token，无需重启API服务
+		case strings.HasPrefix(key, "api."):
+			restartAPI = true
+		case key == "checks.traffic_interval":
+			a.scheduler.SetTrafficInterval(cfg.Checks.TrafficInterval.Std())
+		case key == "checks.idle_timeout":
+			a.stats.SetIdleTimeout(cfg.Checks.IdleTimeout.Std())
+		case key == "log.level" && a.logLevel != nil:
+			a.logLevel.SetLevel(logger.ParseLevel(cfg.Log.Level))
+		default:
+			// 日志轮转参数在启动时确定，需要重启Agent
+			restartRequired = append(restartRequired, key)
+		}
+	}
+
+	a.config = cfg
+	a.log.Info("Config applied",
+		zap.Strings("changed", changed),
+		zap.Strings("restart_required", restartRequired))
+
+	// 异步重启API服务器，避免在处理API请求时等待自身连接关闭
+	if restartAPI {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.restartAPIServer(cfg.API.Address, cfg.API.Port)
+		}()
+	}
+
+	if restartDecoy {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.restartDecoy(cfg.V2Ray.Decoy)
+		}()
+	}
+
+	if upgradeV2Ray {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.ensureV2RayVersion(a.ctx)
+		}()
+	}
+
+	// 代理核心配置协调：重新生成配置，有变化时重启服务
+	if reconfigureV2Ray {
+		monitorConfig, err := v2ray.ApplyClashSecret(a.store, a.backend, cfg.V2Ray)
+		if err != nil {
+			return restartRequired, err
+		}
+		a.stats.SetConfig(monitorConfig)
+		a.health.SetConfig(monitorConfig)
+		if err := a.reconfigure(ctx, cfg.V2Ray); err != nil {
+			return restartRequired, err
+		}
+	}
+
+	// 更换防火墙时先删除原防火墙中的规则
+	if replaceFirewall {
+		if err := a.firewall.Cleanup(ctx); err != nil {
+			a.log.Error("Failed to clean up firewall rules", zap.Error(err))
+		}
+		fw, err := firewall.New(cfg.V2Ray.Firewall.Backend,
+			firewall.WithLogger(a.log.Named("firewall")),
+			firewall.WithCommandRunner(a.runner))
+		if err != nil {
+			return restartRequired, err
+		}
+		a.firewall = fw
+	}
+	if syncFirewall {
+		if err := a.syncFirewall(ctx, cfg.V2Ray); err != nil {
+			return restartRequired, err
+		}
+	}
+
+	return restartRequired, nil
 }
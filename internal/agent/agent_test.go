@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/aws"
+	"go.uber.org/zap"
+)
+
+// baseConfig 测试使用的配置文件，不使用防火墙以免执行系统命令
+const baseConfig = "version: 2\nv2ray:\n  uuid: " + testUUID + "\n  firewall:\n    backend: none\n"
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+		check   func(t *testing.T, ta *testAgent)
+	}{
+		{
+			name:    "unchanged",
+			content: baseConfig,
+			check: func(t *testing.T, ta *testAgent) {
+				if ta.Config().Log.Level != "info" {
+					t.Errorf("log.level = %q, want info", ta.Config().Log.Level)
+				}
+			},
+		},
+		{
+			name:    "log level",
+			content: baseConfig + "log:\n  level: debug\n",
+			check: func(t *testing.T, ta *testAgent) {
+				if ta.Config().Log.Level != "debug" {
+					t.Errorf("log.level = %q, want debug", ta.Config().Log.Level)
+				}
+				if ta.level.Level() != zap.DebugLevel {
+					t.Errorf("logger level = %v, want debug", ta.level.Level())
+				}
+			},
+		},
+		{
+			name:    "check intervals",
+			content: baseConfig + "checks:\n  traffic_interval: 1m\n  idle_timeout: 2h\n",
+			check: func(t *testing.T, ta *testAgent) {
+				checks := ta.Config().Checks
+				if checks.TrafficInterval.Std() != time.Minute || checks.IdleTimeout.Std() != 2*time.Hour {
+					t.Errorf("checks = %+v, want 1m and 2h", checks)
+				}
+				select {
+				case interval := <-ta.scheduler.intervalChan:
+					if interval != time.Minute {
+						t.Errorf("scheduler traffic interval = %v, want 1m", interval)
+					}
+				default:
+					t.Error("scheduler traffic interval was not updated")
+				}
+			},
+		},
+		{
+			name:    "restart required",
+			content: baseConfig + "log:\n  max_size: 50\n",
+			check: func(t *testing.T, ta *testAgent) {
+				if ta.Config().Log.MaxSize != 50 {
+					t.Errorf("log.max_size = %d, want 50 applied to config", ta.Config().Log.MaxSize)
+				}
+			},
+		},
+		{
+			name:    "invalid config keeps current",
+			content: baseConfig + "log:\n  level: loud\nchecks:\n  idle_timeout: 2h\n",
+			wantErr: true,
+			check: func(t *testing.T, ta *testAgent) {
+				if ta.Config().Log.Level != "info" || ta.Config().Checks.IdleTimeout.Std() != 30*time.Minute {
+					t.Errorf("config changed after invalid reload: %+v", ta.Config())
+				}
+				if ta.level.Level() != zap.InfoLevel {
+					t.Errorf("logger level = %v, want info", ta.level.Level())
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ta := newTestAgent(t, baseConfig)
+			writeFile(t, ta.path, tt.content)
+			if err := ta.Reload(context.Background()); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, want error %v", err, tt.wantErr)
+			}
+			tt.check(t, ta)
+
+			// 以上配置项都不需要重新生成代理核心配置
+			if calls := ta.services.Calls(); len(calls) != 0 {
+				t.Errorf("service calls = %v, want none", calls)
+			}
+			if lines := ta.runner.Lines(); len(lines) != 0 {
+				t.Errorf("runner calls = %v, want none", lines)
+			}
+		})
+	}
+}
+
+func TestApplyConfigRestartRequired(t *testing.T) {
+	t.Parallel()
+
+	ta := newTestAgent(t, baseConfig)
+	cfg := *ta.Config()
+	cfg.Backend = "xray"
+	cfg.V2Ray.ServiceManager = "openrc"
+	cfg.Log.MaxAge = 7
+	changed := []string{"backend", "log.max_age", "v2ray.service_manager"}
+
+	ta.mu.Lock()
+	restartRequired, err := ta.applyConfig(context.Background(), &cfg, changed, true)
+	ta.mu.Unlock()
+	if err != nil {
+		t.Fatalf("applyConfig() error = %v", err)
+	}
+	if len(restartRequired) != len(changed) {
+		t.Errorf("applyConfig() restart required = %v, want %v", restartRequired, changed)
+	}
+	if ta.Config() != &cfg {
+		t.Error("applyConfig() did not replace the current config")
+	}
+}
+
+func TestSyncFirewallSecurityGroupPlan(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		want    *securityGroupPlan
+	}{
+		{
+			name:    "no security group",
+			content: baseConfig,
+		},
+		{
+			name:    "security group",
+			content: baseConfig + "    security_group: sg-0123456789abcdef0\n",
+			want:    &securityGroupPlan{groupID: "sg-0123456789abcdef0", ranges: []aws.PortRange{{From: 10086, To: 10086}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ta := newTestAgent(t, tt.content)
+			// 重定向规则在a.mu内更新，安全组只记录待同步的端口
+			ta.mu.Lock()
+			err := ta.syncFirewall(context.Background(), ta.config.V2Ray)
+			plan := ta.sgPlan
+			ta.mu.Unlock()
+			if err != nil {
+				t.Fatalf("syncFirewall() error = %v", err)
+			}
+			if !reflect.DeepEqual(plan, tt.want) {
+				t.Fatalf("security group plan = %+v, want %+v", plan, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			// 没有待同步的端口时不调用AWS
+			if err := ta.syncSecurityGroup(context.Background()); err != nil {
+				t.Errorf("syncSecurityGroup() error = %v", err)
+			}
+		})
+	}
+}
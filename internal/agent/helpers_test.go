@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/aws"
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+	"go.uber.org/zap"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// fakeService 记录调用的服务管理器
+type fakeService struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *fakeService) record(call string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+	return nil
+}
+
+func (s *fakeService) Kind() string                      { return "fake" }
+func (s *fakeService) Start(ctx context.Context) error   { return s.record("start") }
+func (s *fakeService) Stop(ctx context.Context) error    { return s.record("stop") }
+func (s *fakeService) Restart(ctx context.Context) error { return s.record("restart") }
+func (s *fakeService) Enable(ctx context.Context) error  { return s.record("enable") }
+
+func (s *fakeService) Status(ctx context.Context) (*service.Status, error) {
+	s.record("status")
+	return &service.Status{}, nil
+}
+
+// Calls 返回所有调用
+func (s *fakeService) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// testAgent 使用临时配置文件、假命令执行器和假服务管理器的Agent
+type testAgent struct {
+	*Agent
+	path     string
+	runner   *commandtest.FakeRunner
+	services *fakeService
+	level    zap.AtomicLevel
+}
+
+// newTestAgent 从content创建配置文件并创建Agent，不访问AWS和系统服务
+// Agent未启动，只用于测试配置的加载和应用。
+func newTestAgent(t *testing.T, content string) *testAgent {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, content)
+	loader := config.NewLoader(path, config.WithLookupEnv(func(string) (string, bool) { return "", false }))
+	cfg, _, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ta := &testAgent{
+		path:     path,
+		runner:   commandtest.NewFakeRunner(),
+		services: &fakeService{},
+		level:    zap.NewAtomicLevelAt(zap.InfoLevel),
+	}
+	ta.Agent, err = NewAgent(context.Background(), cfg,
+		WithLogLevel(ta.level),
+		WithConfigLoader(loader),
+		WithEC2Client(&aws.EC2Client{}),
+		WithCommandRunner(ta.runner),
+		WithServiceManager(ta.services),
+		WithStateStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ta
+}
+
+// writeFile 写入文件，失败时终止测试
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
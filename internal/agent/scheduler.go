@@ -1,100 +1,402 @@
 package agent
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/yuhai94/anywhere_agent/internal/aws"
 	"github.com/yuhai94/anywhere_agent/internal/config"
-	"github.com/yuhai94/anywhere_agent/internal/logger"
 	"github.com/yuhai94/anywhere_agent/internal/v2ray"
 	"go.uber.org/zap"
 )
 
+// GeoDataUpdater 更新geo数据文件，由Agent实现以便与部署和版本切换串行执行
+type GeoDataUpdater interface {
+	UpdateGeoData(ctx context.Context) error
+}
+
+// CredentialRotator 轮换到期的用户凭据，由Agent实现以便同时更新核心配置
+type CredentialRotator interface {
+	RotateDueCredentials(ctx context.Context) error
+}
+
+// PortRotator 轮换到期的入站端口，由Agent实现以便同时更新核心配置和防火墙
+type PortRotator interface {
+	RotateDuePorts(ctx context.Context) error
+}
+
+// ClientAccessChecker 检查用户的有效期和允许的时间段，由Agent实现以便同时更新核心配置
+type ClientAccessChecker interface {
+	CheckClientAccess(ctx context.Context) error
+}
+
+// UserTrafficCollector 收集每个用户的流量，由Agent实现以便使用当前配置
+type UserTrafficCollector interface {
+	CollectUserTraffic(ctx context.Context) error
+}
+
+// rotationCheckInterval 检查用户凭据和入站端口是否到期的间隔，轮换间隔和重叠期按此精度生效
+const rotationCheckInterval = time.Minute
+
+// accessCheckInterval 检查用户访问状态的间隔，expires_at 和 windows 按此精度生效
+const accessCheckInterval = time.Minute
+
 // Scheduler 调度器，定期执行任务
 type Scheduler struct {
-	config     *config.Config
-	ec2Client  *aws.EC2Client
-	stats      *v2ray.TrafficMonitor
-	deployChan chan *v2ray.DeployStatus
-	stopChan   chan struct{}
-	isRunning  bool
+	config       *config.Config
+	ec2Client    *aws.EC2Client
+	stats        *v2ray.TrafficMonitor
+	health       *v2ray.HealthMonitor
+	geoData      GeoDataUpdater
+	credentials  CredentialRotator
+	ports        PortRotator
+	access       ClientAccessChecker
+	traffic      UserTrafficCollector
+	deployChan   chan *v2ray.DeployStatus
+	intervalChan chan time.Duration
+	healthChan   chan time.Duration
+	geoDataChan  chan time.Duration
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+	isRunning    bool
+	log          *zap.Logger
+}
+
+// SchedulerOption 调度器可选参数
+type SchedulerOption func(*Scheduler)
+
+// WithSchedulerLogger 设置调度器使用的日志实例
+func WithSchedulerLogger(log *zap.Logger) SchedulerOption {
+	return func(s *Scheduler) {
+		s.log = log
+	}
+}
+
+// WithHealthMonitor 设置出站健康检查，按 v2ray.health_check.interval 定期执行
+func WithHealthMonitor(health *v2ray.HealthMonitor) SchedulerOption {
+	return func(s *Scheduler) {
+		s.health = health
+	}
+}
+
+// WithGeoDataUpdater 设置geo数据文件更新，按 v2ray.geodata.interval 定期执行
+func WithGeoDataUpdater(updater GeoDataUpdater) SchedulerOption {
+	return func(s *Scheduler) {
+		s.geoData = updater
+	}
+}
+
+// WithCredentialRotator 设置用户凭据轮换，按 v2ray.rotation 定期检查
+func WithCredentialRotator(rotator CredentialRotator) SchedulerOption {
+	return func(s *Scheduler) {
+		s.credentials = rotator
+	}
+}
+
+// WithPortRotator 设置入站端口轮换，按各入站的 port_rotation 定期检查
+func WithPortRotator(rotator PortRotator) SchedulerOption {
+	return func(s *Scheduler) {
+		s.ports = rotator
+	}
+}
+
+// WithClientAccessChecker 设置用户访问状态检查，按各用户的 expires_at 和 windows 启用或删除用户
+func WithClientAccessChecker(checker ClientAccessChecker) SchedulerOption {
+	return func(s *Scheduler) {
+		s.access = checker
+	}
+}
+
+// WithUserTrafficCollector 设置用户流量收集，随实例删除检查按 checks.traffic_interval 执行
+func WithUserTrafficCollector(collector UserTrafficCollector) SchedulerOption {
+	return func(s *Scheduler) {
+		s.traffic = collector
+	}
 }
 
 // NewScheduler 创建新的调度器
-func NewScheduler(cfg *config.Config, ec2Client *aws.EC2Client, stats *v2ray.TrafficMonitor, deployChan chan *v2ray.DeployStatus) *Scheduler {
-	return &Scheduler{
-		config:     cfg,
-		ec2Client:  ec2Client,
-		stats:      stats,
-		deployChan: deployChan,
-		stopChan:   make(chan struct{}),
-		isRunning:  false,
+func NewScheduler(cfg *config.Config, ec2Client *aws.EC2Client, stats *v2ray.TrafficMonitor, deployChan chan *v2ray.DeployStatus, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		config:       cfg,
+		ec2Client:    ec2Client,
+		stats:        stats,
+		deployChan:   deployChan,
+		intervalChan: make(chan time.Duration, 1),
+		healthChan:   make(chan time.Duration, 1),
+		geoDataChan:  make(chan time.Duration, 1),
+		isRunning:    false,
+		log:          zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-// Start 启动调度器
-func (s *Scheduler) Start() {
+// Start 启动调度器，ctx取消或调用Stop时停止所有任务
+func (s *Scheduler) Start(ctx context.Context) {
 	if s.isRunning {
 		return
 	}
 
 	s.isRunning = true
-	logger.Info("Starting scheduler...")
+	s.log.Info("Starting scheduler...")
+
+	ctx, s.cancel = context.WithCancel(ctx)
 
 	// 启动实例删除检查协程
-	go s.instanceDeleteLoop()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.instanceDeleteLoop(ctx)
+	}()
+
+	// 启动出站健康检查协程
+	if s.health != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.healthCheckLoop(ctx)
+		}()
+	}
+
+	// 启动geo数据文件更新协程
+	if s.geoData != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.geoDataLoop(ctx)
+		}()
+	}
+
+	// 启动用户凭据轮换协程
+	if s.credentials != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.credentialLoop(ctx)
+		}()
+	}
+
+	// 启动入站端口轮换协程
+	if s.ports != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.portLoop(ctx)
+		}()
+	}
+
+	// 启动用户访问状态检查协程
+	if s.access != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.accessLoop(ctx)
+		}()
+	}
 }
 
-// Stop 停止调度器
+// Stop 停止调度器并等待正在执行的任务退出
 func (s *Scheduler) Stop() {
 	if !s.isRunning {
 		return
 	}
 
-	logger.Info("Stopping scheduler...")
-	close(s.stopChan)
+	s.log.Info("Stopping scheduler...")
+	s.cancel()
+	s.wg.Wait()
 	s.isRunning = false
 }
 
+// SetTrafficInterval 更新实例删除检查间隔，下一个周期开始生效
+func (s *Scheduler) SetTrafficInterval(interval time.Duration) {
+	// 丢弃尚未被处理的旧值，只保留最新间隔
+	select {
+	case <-s.intervalChan:
+	default:
+	}
+	s.intervalChan <- interval
+}
+
+// SetHealthInterval 更新出站健康检查间隔，下一个周期开始生效
+func (s *Scheduler) SetHealthInterval(interval time.Duration) {
+	select {
+	case <-s.healthChan:
+	default:
+	}
+	s.healthChan <- interval
+}
+
+// SetGeoDataInterval 更新geo数据文件更新间隔，0 表示停止更新
+func (s *Scheduler) SetGeoDataInterval(interval time.Duration) {
+	select {
+	case <-s.geoDataChan:
+	default:
+	}
+	s.geoDataChan <- interval
+}
+
 // instanceDeleteLoop 实例删除检查循环
-func (s *Scheduler) instanceDeleteLoop() {
+func (s *Scheduler) instanceDeleteLoop(ctx context.Context) {
 	// 从配置获取实例删除检查间隔
-	checkInterval := s.config.Checks.TrafficInterval
-	logger.Info("Setting instance delete check interval", zap.Int("minutes", checkInterval))
+	checkInterval := s.config.Checks.TrafficInterval.Std()
+	s.log.Info("Setting instance delete check interval", zap.Duration("interval", checkInterval))
 
-	ticker := time.NewTicker(time.Duration(checkInterval) * time.Minute)
+	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
+			if s.traffic != nil {
+				if err := s.traffic.CollectUserTraffic(ctx); err != nil {
+					s.log.Warn("Failed to collect user traffic", zap.Error(err))
+				}
+			}
+
 			// 检查是否空闲
-			idle, err := s.stats.IsIdle()
+			idle, err := s.stats.IsIdle(ctx)
 			if err != nil {
-				logger.Error("Failed to check if instance is idle", zap.Error(err))
+				s.log.Error("Failed to check if instance is idle", zap.Error(err))
 				continue
 			}
 
 			if idle {
-				logger.Info("Instance is idle for 30 minutes, terminating...")
+				s.log.Info("Instance is idle, terminating...")
 
 				// 获取实例ID
-				instanceID, err := aws.GetInstanceID()
+				instanceID, err := aws.GetInstanceID(ctx)
 				if err != nil {
-					logger.Error("Failed to get instance ID", zap.Error(err))
+					s.log.Error("Failed to get instance ID", zap.Error(err))
 					continue
 				}
 
 				// 终止实例
-				if err := s.ec2Client.TerminateInstance(instanceID); err != nil {
-					logger.Error("Failed to terminate instance", zap.Error(err))
+				if err := s.ec2Client.TerminateInstance(ctx, instanceID); err != nil {
+					s.log.Error("Failed to terminate instance", zap.Error(err))
 					continue
 				}
 
-				logger.Info("Instance terminated successfully", logger.String("instance_id", instanceID))
+				s.log.Info("Instance terminated successfully", zap.String("instance_id", instanceID))
+			}
+
+		case interval := <-s.intervalChan:
+			s.log.Info("Updating instance delete check interval", zap.Duration("interval", interval))
+			ticker.Reset(interval)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// healthCheckLoop 出站健康检查循环
+func (s *Scheduler) healthCheckLoop(ctx context.Context) {
+	interval := s.config.V2Ray.HealthCheck.Interval.Std()
+	s.log.Info("Setting outbound health check interval", zap.Duration("interval", interval))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.health.CheckAll(ctx)
+
+		case interval := <-s.healthChan:
+			s.log.Info("Updating outbound health check interval", zap.Duration("interval", interval))
+			ticker.Reset(interval)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// geoDataLoop geo数据文件更新循环，启动时的首次更新在部署完成后由Agent执行
+func (s *Scheduler) geoDataLoop(ctx context.Context) {
+	interval := s.config.V2Ray.GeoData.Interval.Std()
+	s.log.Info("Setting geo data update interval", zap.Duration("interval", interval))
+
+	// 间隔为0时停止计时器，不再更新
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	resetTicker := func(interval time.Duration) {
+		ticker.Stop()
+		if interval > 0 {
+			ticker.Reset(interval)
+		}
+	}
+	resetTicker(interval)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.geoData.UpdateGeoData(ctx); err != nil {
+				s.log.Error("Failed to update geo data", zap.Error(err))
+			}
+
+		case interval := <-s.geoDataChan:
+			s.log.Info("Updating geo data update interval", zap.Duration("interval", interval))
+			resetTicker(interval)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// credentialLoop 用户凭据轮换循环，轮换到期的凭据并清理重叠期已过的旧凭据
+func (s *Scheduler) credentialLoop(ctx context.Context) {
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.credentials.RotateDueCredentials(ctx); err != nil {
+				s.log.Error("Failed to rotate credentials", zap.Error(err))
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// portLoop 入站端口轮换循环，轮换到期的端口并清理重叠期已过的旧端口重定向
+func (s *Scheduler) portLoop(ctx context.Context) {
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.ports.RotateDuePorts(ctx); err != nil {
+				s.log.Error("Failed to rotate ports", zap.Error(err))
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// accessLoop 用户访问状态检查循环，删除已过期的用户，并按允许的时间段启用或停用用户
+func (s *Scheduler) accessLoop(ctx context.Context) {
+	ticker := time.NewTicker(accessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.access.CheckClientAccess(ctx); err != nil {
+				s.log.Error("Failed to check client access", zap.Error(err))
 			}
 
-		case <-s.stopChan:
+		case <-ctx.Done():
 			return
 		}
 	}
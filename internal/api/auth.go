@@ -0,0 +1,78 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+	"go.uber.org/zap"
+)
+
+// apiTokenStateKey 自动生成的API token在状态文件中的键
+const apiTokenStateKey = "api.token"
+
+// LoadAPIToken 返回状态文件中的API token，首次调用时生成并保存
+// generated 表示本次调用生成了新的token。
+func LoadAPIToken(store *state.Store) (token string, generated bool, err error) {
+	err = store.Update(apiTokenStateKey, &token, func() (bool, error) {
+		if token != "" {
+			return false, nil
+		}
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return false, fmt.Errorf("failed to generate API token: %w", err)
+		}
+		token = hex.EncodeToString(raw)
+		generated = true
+		return true, nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return token, generated, nil
+}
+
+// apiToken 返回访问 /api 需要的token：已配置 api.token 时使用配置，否则使用状态文件中自动生成的token
+func (s *APIServer) apiToken() (string, error) {
+	if token := s.configs.Config().API.Token; token != "" {
+		return token, nil
+	}
+	if s.store == nil {
+		return "", errors.New("api.token is not configured and no state store is available")
+	}
+	token, generated, err := LoadAPIToken(s.store)
+	if err != nil {
+		return "", err
+	}
+	if generated {
+		s.log.Info("Generated API token, set api.token or read it from the state file",
+			zap.String("state_file", s.store.Path()),
+			zap.String("key", apiTokenStateKey))
+	}
+	return token, nil
+}
+
+// requireToken 要求请求携带 Authorization: Bearer <token>，token不匹配时返回401
+func (s *APIServer) requireToken(c *gin.Context) {
+	expected, err := s.apiToken()
+	if err != nil {
+		// 无法确定token时拒绝所有请求
+		s.log.Error("Failed to resolve API token", zap.Error(err))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "API token is unavailable"})
+		return
+	}
+
+	provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="api"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	c.Next()
+}
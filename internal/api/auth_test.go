@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig()
+	cfg.API.Token = "0123456789abcdef0123456789abcdef"
+	s, _ := newTestServer(t, cfg, WithStateStore(newTestStore(t)))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/api/config", want: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/api/config", token: "wrong", want: http.StatusUnauthorized},
+		{name: "prefix of token", method: http.MethodGet, path: "/api/config", token: cfg.API.Token[:16], want: http.StatusUnauthorized},
+		{name: "upgrade without token", method: http.MethodPost, path: "/api/v2ray/upgrade", want: http.StatusUnauthorized},
+		{name: "patch config without token", method: http.MethodPatch, path: "/api/config", want: http.StatusUnauthorized},
+		{name: "rotate without token", method: http.MethodPost, path: "/api/clients/alice/rotate", want: http.StatusUnauthorized},
+		{name: "good token", method: http.MethodGet, path: "/api/config", token: cfg.API.Token, want: http.StatusOK},
+		{name: "health", method: http.MethodGet, path: "/health", want: http.StatusOK},
+		{name: "subscription", method: http.MethodGet, path: "/sub/unknown", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := serve(s, tt.method, tt.path, tt.token)
+			if w.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+
+	// 配置返回的token已脱敏
+	w := serve(s, http.MethodGet, "/api/config", cfg.API.Token)
+	if strings.Contains(w.Body.String(), cfg.API.Token) {
+		t.Errorf("GET /api/config returned the API token")
+	}
+}
+
+func TestGeneratedAPIToken(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	s, configs := newTestServer(t, testConfig(), WithStateStore(store))
+
+	token, generated, err := LoadAPIToken(store)
+	if err != nil || !generated || len(token) < 32 {
+		t.Fatalf("LoadAPIToken() = %q, %v, %v, want a new token", token, generated, err)
+	}
+	if again, generated, _ := LoadAPIToken(store); again != token || generated {
+		t.Errorf("LoadAPIToken() generated a second token")
+	}
+	if w := serve(s, http.MethodGet, "/api/config", token); w.Code != http.StatusOK {
+		t.Errorf("GET /api/config with generated token = %d, want 200", w.Code)
+	}
+
+	// 配置 api.token 后只接受配置的token，无需重启
+	cfg := testConfig()
+	cfg.API.Token = "0123456789abcdef0123456789abcdef"
+	configs.set(cfg)
+	if w := serve(s, http.MethodGet, "/api/config", token); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/config with generated token = %d, want 401 once api.token is set", w.Code)
+	}
+	if w := serve(s, http.MethodGet, "/api/config", cfg.API.Token); w.Code != http.StatusOK {
+		t.Errorf("GET /api/config with configured token = %d, want 200", w.Code)
+	}
+}
+
+func TestRequireTokenWithoutStore(t *testing.T) {
+	t.Parallel()
+
+	// 没有配置token也无法生成时拒绝所有请求
+	s, _ := newTestServer(t, testConfig())
+	if w := serve(s, http.MethodGet, "/api/config", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /api/config = %d, want 503", w.Code)
+	}
+	if err := s.Start(t.Context()); err == nil || !strings.Contains(err.Error(), "failed to resolve API token") {
+		t.Errorf("Start() error = %v, want token error", err)
+	}
+}
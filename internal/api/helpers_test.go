@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// fakeConfigs 内存中的 ConfigManager，设置了 loader 时 UpdateConfig 合并补丁并写回配置文件
+type fakeConfigs struct {
+	mu     sync.Mutex
+	cfg    *config.Config
+	loader *config.Loader
+}
+
+func (f *fakeConfigs) Config() *config.Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cfg
+}
+
+func (f *fakeConfigs) UpdateConfig(ctx context.Context, patch []byte) ([]string, []string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.loader == nil {
+		return nil, nil, nil
+	}
+	cfg, changed, err := f.loader.Update(f.cfg, patch)
+	if err != nil {
+		return nil, nil, err
+	}
+	f.cfg = cfg
+	return changed, nil, nil
+}
+
+// set 替换当前配置
+func (f *fakeConfigs) set(cfg *config.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cfg = cfg
+}
+
+// testConfig 返回设置了UUID的默认配置
+func testConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.V2Ray.UUID = testUUID
+	return &cfg
+}
+
+// newTestStore 返回临时目录中的状态存储
+func newTestStore(t *testing.T) *state.Store {
+	t.Helper()
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// newTestServer 返回使用假命令执行器的API服务器，不访问系统服务
+func newTestServer(t *testing.T, cfg *config.Config, opts ...Option) (*APIServer, *fakeConfigs) {
+	t.Helper()
+	configs := &fakeConfigs{cfg: cfg}
+	opts = append([]Option{WithCommandRunner(commandtest.NewFakeRunner())}, opts...)
+	return NewAPIServer(cfg, nil, nil, configs, opts...), configs
+}
+
+// serve 向服务器的路由发送请求，token 非空时携带Bearer token
+func serve(s *APIServer, method, path, token string) *httptest.ResponseRecorder {
+	return serveBody(s, method, path, token, "")
+}
+
+// serveBody 与 serve 相同，同时发送请求体
+func serveBody(s *APIServer, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, req)
+	return w
+}
@@ -2,103 +2,694 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"github.com/yuhai94/anywhere_agent/internal/aws"
+	"github.com/yuhai94/anywhere_agent/internal/command"
 	"github.com/yuhai94/anywhere_agent/internal/config"
-	"github.com/yuhai94/anywhere_agent/internal/logger"
+	"github.com/yuhai94/anywhere_agent/internal/events"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"github.com/yuhai94/anywhere_agent/internal/state"
 	"github.com/yuhai94/anywhere_agent/internal/v2ray"
 	"go.uber.org/zap"
 )
 
+// versionPattern V2Ray版本号格式
+var versionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+$`)
+
+// qrCodeSize 分享链接二维码的边长（像素）
+const qrCodeSize = 320
+
+// ConfigManager 管理运行中的配置，负责配置的查询、更新和应用
+type ConfigManager interface {
+	// Config 返回当前生效的配置
+	Config() *config.Config
+	// UpdateConfig 合并配置补丁并应用，返回变化的配置项和需要重启Agent才能生效的配置项
+	UpdateConfig(ctx context.Context, patch []byte) (changed []string, restartRequired []string, err error)
+}
+
+// V2RayManager 管理V2Ray版本
+type V2RayManager interface {
+	// UpgradeV2Ray 切换V2Ray版本，失败时自动回滚；version 为空时使用 v2ray.version
+	UpgradeV2Ray(ctx context.Context, version string) (*v2ray.UpgradeResult, error)
+}
+
+// CredentialManager 管理用户凭据
+type CredentialManager interface {
+	// RotateCredentials 为用户生成新的UUID并应用，旧UUID在 v2ray.rotation.overlap 内继续有效
+	RotateCredentials(ctx context.Context, email string) (*v2ray.RotationResult, error)
+}
+
+// PortManager 管理入站端口
+type PortManager interface {
+	// RotatePort 将入站换到轮换范围内的随机端口并应用，旧端口在 port_rotation.overlap 内继续可用
+	RotatePort(ctx context.Context, tag string) (*v2ray.PortRotationResult, error)
+}
+
+// ApplyError 配置已保存但应用到运行中的子系统失败
+type ApplyError struct {
+	Err error
+}
+
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("config saved but failed to apply: %v", e.Err)
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
 // APIServer API服务器
 type APIServer struct {
-	config     *config.Config
+	mu         sync.Mutex // 保护监听地址、server和公网地址缓存，监听地址可在运行时变更
+	configs    ConfigManager
 	address    string
 	port       int
 	v2rayStats *v2ray.TrafficMonitor
+	health     *v2ray.HealthMonitor // 为空时状态中不包含出站健康检查结果
 	deployChan chan *v2ray.DeployStatus
+	runner     command.Runner
+	backend    v2ray.ProxyBackend
+	services   service.ServiceManager
+	store      *state.Store      // 为空时不支持查询REALITY参数
+	publicIP   string            // 缓存的实例公网IPv4，未配置 v2ray.public_address 时用于分享链接
+	versions   V2RayManager      // 为空时不支持版本管理
+	rotator    CredentialManager // 为空时不支持凭据轮换
+	ports      PortManager       // 为空时不支持端口轮换
+	events     *events.Bus       // 为空时不支持查询事件
+	log        *zap.Logger
 	server     *http.Server // 保存HTTP服务器实例
 }
 
+// Option API服务器可选参数
+type Option func(*APIServer)
+
+// WithLogger 设置API服务器使用的日志实例
+func WithLogger(log *zap.Logger) Option {
+	return func(s *APIServer) {
+		s.log = log
+	}
+}
+
+// WithCommandRunner 设置查询V2Ray状态时使用的命令执行器
+func WithCommandRunner(runner command.Runner) Option {
+	return func(s *APIServer) {
+		s.runner = runner
+	}
+}
+
+// WithProxyBackend 设置查询状态的代理核心，默认为V2Ray
+func WithProxyBackend(backend v2ray.ProxyBackend) Option {
+	return func(s *APIServer) {
+		s.backend = backend
+	}
+}
+
+// WithServiceManager 设置查询代理核心服务状态时使用的服务管理器
+func WithServiceManager(svc service.ServiceManager) Option {
+	return func(s *APIServer) {
+		s.services = svc
+	}
+}
+
+// WithStateStore 设置状态存储，用于读取REALITY密钥
+func WithStateStore(store *state.Store) Option {
+	return func(s *APIServer) {
+		s.store = store
+	}
+}
+
+// WithHealthMonitor 设置出站健康检查，结果包含在 GET /api/status 中
+func WithHealthMonitor(health *v2ray.HealthMonitor) Option {
+	return func(s *APIServer) {
+		s.health = health
+	}
+}
+
+// WithV2RayManager 设置V2Ray版本管理，启用 POST /api/v2ray/upgrade
+func WithV2RayManager(manager V2RayManager) Option {
+	return func(s *APIServer) {
+		s.versions = manager
+	}
+}
+
+// WithCredentialManager 设置凭据管理，启用 POST /api/clients/:email/rotate
+func WithCredentialManager(manager CredentialManager) Option {
+	return func(s *APIServer) {
+		s.rotator = manager
+	}
+}
+
+// WithPortManager 设置端口管理，启用 POST /api/inbounds/:tag/rotate-port
+func WithPortManager(manager PortManager) Option {
+	return func(s *APIServer) {
+		s.ports = manager
+	}
+}
+
+// WithEventBus 设置事件总线，启用 GET /api/events
+func WithEventBus(bus *events.Bus) Option {
+	return func(s *APIServer) {
+		s.events = bus
+	}
+}
+
 // NewAPIServer 创建新的API服务器
-func NewAPIServer(cfg *config.Config, deployChan chan *v2ray.DeployStatus, v2rayStats *v2ray.TrafficMonitor) *APIServer {
-	return &APIServer{
-		config:     cfg,
+func NewAPIServer(cfg *config.Config, deployChan chan *v2ray.DeployStatus, v2rayStats *v2ray.TrafficMonitor, configs ConfigManager, opts ...Option) *APIServer {
+	s := &APIServer{
+		configs:    configs,
 		address:    cfg.API.Address,
 		port:       cfg.API.Port,
 		v2rayStats: v2rayStats,
 		deployChan: deployChan,
+		log:        zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.runner == nil {
+		s.runner = command.NewExecRunner(s.log.Named("exec"))
+	}
+	if s.backend == nil {
+		// 配置已校验，核心名称有效
+		s.backend, _ = v2ray.NewBackend(cfg.Backend)
+	}
+	if s.services == nil {
+		// 自动检测不会失败
+		s.services, _ = service.New(service.KindAuto, s.backend.ServiceSpec(),
+			service.WithLogger(s.log.Named("service")),
+			service.WithCommandRunner(s.runner))
+	}
+	return s
+}
+
+// Start 启动API服务器，请求的context派生自ctx，ctx取消时进行中的请求随之取消
+func (s *APIServer) Start(ctx context.Context) error {
+	// 提前解析token，未配置 api.token 时在启动时生成，而不是等到第一个请求
+	if _, err := s.apiToken(); err != nil {
+		return fmt.Errorf("failed to resolve API token: %w", err)
+	}
+	r := s.routes()
+
+	// 启动HTTP服务器
+	s.mu.Lock()
+	addr := fmt.Sprintf("%s:%d", s.address, s.port)
+	s.log.Info("API server starting",
+		zap.String("address", addr),
+		zap.String("protocol", "HTTP"))
+
+	// 创建HTTP服务器实例
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     r,
+		BaseContext: func(net.Listener) context.Context { return ctx },
 	}
+	s.server = server
+	s.mu.Unlock()
+
+	// 使用ListenAndServe启动服务器
+	return server.ListenAndServe()
 }
 
-// Start 启动API服务器
-func (s *APIServer) Start() error {
+// routes 创建注册了所有端点的Gin引擎
+func (s *APIServer) routes() *gin.Engine {
 	// 创建Gin引擎
 	gin.SetMode(gin.ReleaseMode) // 生产模式
 	r := gin.Default()
 
-	// API路由组 - 移除JWT认证中间件
-	api := r.Group("/api")
+	// API路由组，以Bearer token认证
+	api := r.Group("/api", s.requireToken)
 
 	// 简化后的API端点：同时返回状态和配置
 	api.GET("/status", s.handleStatusAndConfig)
 
-	// 健康检查端点（无需认证）
-	r.GET("/health", s.handleHealth)
+	// 配置查询与更新
+	api.GET("/config", s.handleGetConfig)
+	api.PATCH("/config", s.handlePatchConfig)
 
-	// 启动HTTP服务器
-	addr := fmt.Sprintf("%s:%d", s.address, s.port)
-	logger.Info("API server starting",
-		zap.String("address", addr),
-		zap.String("protocol", "HTTP"))
+	// V2Ray版本管理
+	api.POST("/v2ray/upgrade", s.handleUpgradeV2Ray)
 
-	// 创建HTTP服务器实例
-	s.server = &http.Server{
-		Addr:    addr,
-		Handler: r,
-	}
+	// REALITY客户端参数
+	api.GET("/reality", s.handleReality)
 
-	// 使用ListenAndServe启动服务器
-	return s.server.ListenAndServe()
+	// 用户列表及访问状态
+	api.GET("/clients", s.handleListClients)
+
+	// 客户端分享链接
+	api.GET("/clients/:email/link", s.handleClientLink)
+	api.GET("/clients/:email/qr.png", s.handleClientQRCode)
+	api.POST("/clients/:email/rotate", s.handleRotateCredentials)
+
+	// 入站端口轮换
+	api.POST("/inbounds/:tag/rotate-port", s.handleRotatePort)
+
+	// 事件
+	api.GET("/events", s.handleEvents)
+
+	// 健康检查端点（无需认证）
+	r.GET("/health", s.handleHealth)
+
+	// 订阅，以用户的订阅token认证
+	r.GET("/sub/:token", s.handleSubscription)
+	return r
 }
 
-// Stop 停止API服务器
-func (s *APIServer) Stop() error {
-	if s.server == nil {
+// Stop 优雅停止API服务器，等待进行中的请求结束直到ctx到期
+func (s *APIServer) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.server = nil
+	s.mu.Unlock()
+
+	if server == nil {
 		return nil
 	}
 
-	logger.Info("Stopping API server...")
-	// 优雅关闭服务器，允许10秒超时
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	s.log.Info("Stopping API server...")
+	return server.Shutdown(ctx)
+}
 
-	return s.server.Shutdown(ctx)
+// SetListenAddress 更新监听地址，下次Start时生效
+func (s *APIServer) SetListenAddress(address string, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.address = address
+	s.port = port
 }
 
 // handleStatusAndConfig 同时处理状态和配置查询请求
 func (s *APIServer) handleStatusAndConfig(c *gin.Context) {
-	// 获取V2Ray状态
-	status, err := v2ray.GetV2RayStatus()
+	// 获取代理核心状态
+	status, err := v2ray.GetStatus(c.Request.Context(), s.log, s.runner, s.backend, s.services)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get v2ray status: %v", err)})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get %s status: %v", s.backend.Name(), err)})
 		return
 	}
 
+	// UUID是客户端的凭据，与 GET /api/config 一样只返回脱敏后的值
+	cfg := config.Redact(s.configs.Config())
+	v2rayConfig := cfg.V2Ray
+
 	// 返回合并的响应
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"status": status,
 		"config": map[string]interface{}{
-			"port":       s.config.V2Ray.Port,
-			"uuid":       s.config.V2Ray.UUID,
-			"access_log": s.config.V2Ray.AccessLog,
+			"backend":    cfg.Backend,
+			"port":       v2rayConfig.Port,
+			"uuid":       v2rayConfig.UUID,
+			"access_log": v2rayConfig.AccessLog,
 		},
+	}
+	if s.health != nil {
+		response["outbounds"] = s.health.Results()
+	}
+	if s.store != nil && s.backend.AssetDir() != "" {
+		geoData, err := v2ray.GetGeoDataStatus(s.store)
+		if err != nil {
+			s.log.Warn("Failed to read geo data status", zap.Error(err))
+		} else if geoData != nil {
+			response["geodata"] = geoData
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleGetConfig 返回当前生效的配置，敏感字段已脱敏
+func (s *APIServer) handleGetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Redact(s.configs.Config()))
+}
+
+// handlePatchConfig 合并配置补丁，校验并持久化后应用到运行中的子系统
+func (s *APIServer) handlePatchConfig(c *gin.Context) {
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to read request body: %v", err)})
+		return
+	}
+
+	changed, restartRequired, err := s.configs.UpdateConfig(c.Request.Context(), patch)
+	if changed == nil {
+		changed = []string{}
+	}
+	if restartRequired == nil {
+		restartRequired = []string{}
+	}
+	if err != nil {
+		var applyErr *ApplyError
+		if errors.As(err, &applyErr) {
+			// 配置已保存，但应用到子系统失败
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":            err.Error(),
+				"changed":          changed,
+				"restart_required": restartRequired,
+			})
+			return
+		}
+		s.log.Warn("Rejected config patch", zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changed":          changed,
+		"restart_required": restartRequired,
+		"config":           config.Redact(s.configs.Config()),
 	})
 }
 
+// handleUpgradeV2Ray 切换V2Ray版本，?version= 为空时使用 v2ray.version
+func (s *APIServer) handleUpgradeV2Ray(c *gin.Context) {
+	if s.versions == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "V2Ray version management is not supported"})
+		return
+	}
+
+	version := c.Query("version")
+	if version != "" && version != "latest" && !versionPattern.MatchString(version) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid version %q", version)})
+		return
+	}
+
+	// 客户端断开时不中止升级，避免停在替换二进制和回滚之间
+	ctx := context.WithoutCancel(c.Request.Context())
+	result, err := s.versions.UpgradeV2Ray(ctx, version)
+	if err != nil {
+		s.log.Error("Failed to upgrade V2Ray", zap.String("version", version), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":  err.Error(),
+			"result": result,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// handleReality 返回REALITY入站的客户端参数，?check=true 时同时检查目标站点是否可达
+func (s *APIServer) handleReality(c *gin.Context) {
+	if s.store == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "REALITY is not supported"})
+		return
+	}
+
+	cfg := s.configs.Config()
+	params, err := v2ray.RealityClientParams(s.store, cfg.V2Ray)
+	if err != nil {
+		s.log.Error("Failed to load REALITY keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	inbounds := make([]gin.H, 0, len(params))
+	for _, param := range params {
+		inbound := gin.H{"params": param}
+		if c.Query("check") == "true" {
+			err := v2ray.CheckRealityDest(c.Request.Context(), config.RealityConfig{
+				Dest:        param.Dest,
+				ServerNames: param.ServerNames,
+			})
+			inbound["dest_reachable"] = err == nil
+			if err != nil {
+				inbound["dest_error"] = err.Error()
+			}
+		}
+		inbounds = append(inbounds, inbound)
+	}
+	c.JSON(http.StatusOK, gin.H{"inbounds": inbounds})
+}
+
+// handleListClients 返回所有用户及其访问状态，?status= 只返回 active、upcoming 或 expired 的用户
+func (s *APIServer) handleListClients(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", config.AccessActive, config.AccessUpcoming, config.AccessExpired:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("status %q must be one of active, upcoming, expired", status)})
+		return
+	}
+
+	clients := make([]v2ray.ClientStatus, 0)
+	for _, client := range v2ray.ClientStatuses(s.configs.Config().V2Ray, time.Now()) {
+		if status == "" || client.Status == status {
+			clients = append(clients, client)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"clients": clients})
+}
+
+// handleClientLink 返回用户在各入站上的分享链接，?tag= 只返回指定入站
+func (s *APIServer) handleClientLink(c *gin.Context) {
+	email := c.Param("email")
+	links, ok := s.clientLinks(c)
+	if !ok {
+		return
+	}
+
+	token, err := v2ray.SubscriptionToken(s.store, email)
+	if err != nil {
+		s.log.Error("Failed to get subscription token", zap.String("email", email), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"email": email, "links": links, "subscription": "/sub/" + token})
+}
+
+// handleRotateCredentials 立即轮换用户的UUID，返回新的分享链接和订阅地址
+// 订阅地址不变，客户端更新订阅即可获得新的UUID。
+func (s *APIServer) handleRotateCredentials(c *gin.Context) {
+	if s.rotator == nil || s.store == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "credential rotation is not supported"})
+		return
+	}
+
+	email := c.Param("email")
+	result, err := s.rotator.RotateCredentials(c.Request.Context(), email)
+	if err != nil {
+		if errors.Is(err, v2ray.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("client %q not found", email)})
+			return
+		}
+		s.log.Error("Failed to rotate credentials", zap.String("email", email), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+
+	links, ok := s.clientLinks(c)
+	if !ok {
+		return
+	}
+	token, err := v2ray.SubscriptionToken(s.store, email)
+	if err != nil {
+		s.log.Error("Failed to get subscription token", zap.String("email", email), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": result, "links": links, "subscription": "/sub/" + token})
+}
+
+// handleRotatePort 立即轮换入站的监听端口，客户端更新订阅后使用新端口
+func (s *APIServer) handleRotatePort(c *gin.Context) {
+	if s.ports == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "port rotation is not supported"})
+		return
+	}
+
+	tag := c.Param("tag")
+	result, err := s.ports.RotatePort(c.Request.Context(), tag)
+	if err != nil {
+		if errors.Is(err, v2ray.ErrInboundNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("inbound %q not found or has no port_rotation.range", tag)})
+			return
+		}
+		s.log.Error("Failed to rotate port", zap.String("tag", tag), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// handleEvents 返回序号大于 ?since= 的事件，?type= 只返回指定类型
+func (s *APIServer) handleEvents(c *gin.Context) {
+	if s.events == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "events are not supported"})
+		return
+	}
+
+	var since int64
+	if value := c.Query("since"); value != "" {
+		var err error
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || since < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid since %q", value)})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"events": s.events.Since(since, c.Query("type"))})
+}
+
+// handleClientQRCode 以PNG二维码返回分享链接，?tag= 指定入站，默认为第一个入站
+func (s *APIServer) handleClientQRCode(c *gin.Context) {
+	links, ok := s.clientLinks(c)
+	if !ok {
+		return
+	}
+
+	png, err := qrcode.Encode(links[0].URL, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to render QR code: %v", err)})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// clientLinks 生成请求中用户的分享链接，失败时已写入错误响应
+func (s *APIServer) clientLinks(c *gin.Context) ([]v2ray.ShareLink, bool) {
+	if s.store == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "share links are not supported"})
+		return nil, false
+	}
+
+	cfg, err := s.clientConfig()
+	if err != nil {
+		s.log.Error("Failed to load rotated credentials and ports", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	email := c.Param("email")
+	user, ok := v2ray.FindUser(cfg, email)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("client %q not found", email)})
+		return nil, false
+	}
+
+	address, err := s.publicAddress(c.Request.Context(), cfg)
+	if err != nil {
+		s.log.Error("Failed to get public address", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get public address, set v2ray.public_address: %v", err)})
+		return nil, false
+	}
+
+	links, err := v2ray.ShareLinks(s.store, cfg, address, user)
+	if err != nil {
+		s.log.Error("Failed to build share links", zap.String("email", email), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if tag := c.Query("tag"); tag != "" {
+		for _, link := range links {
+			if link.Tag == tag {
+				return []v2ray.ShareLink{link}, true
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("inbound %q not found", tag)})
+		return nil, false
+	}
+	return links, true
+}
+
+// handleSubscription 按 ?format= 返回用户的订阅：base64（默认）、clash、sing-box
+// token无效时返回404，不区分token不存在和用户已删除。
+func (s *APIServer) handleSubscription(c *gin.Context) {
+	if s.store == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "subscriptions are not supported"})
+		return
+	}
+
+	cfg, err := s.clientConfig()
+	if err != nil {
+		s.log.Error("Failed to load rotated credentials and ports", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to look up subscription"})
+		return
+	}
+	user, ok, err := v2ray.FindSubscriptionUser(s.store, cfg, c.Param("token"))
+	if err != nil {
+		s.log.Error("Failed to look up subscription token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to look up subscription"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+
+	format := c.DefaultQuery("format", v2ray.SubscriptionBase64)
+	switch format {
+	case v2ray.SubscriptionBase64, v2ray.SubscriptionClash, v2ray.SubscriptionSingBox:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("format %q must be one of base64, clash, sing-box", format)})
+		return
+	}
+
+	address, err := s.publicAddress(c.Request.Context(), cfg)
+	if err != nil {
+		s.log.Error("Failed to get public address", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get public address"})
+		return
+	}
+
+	data, contentType, err := v2ray.RenderSubscription(s.store, cfg, address, user, format)
+	if err != nil {
+		s.log.Error("Failed to render subscription", zap.String("email", user.Email), zap.String("format", format), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render subscription"})
+		return
+	}
+
+	// 用量为定期收集的累计流量，核心不支持按用户统计时为0
+	traffic, err := v2ray.GetUserTraffic(s.store, user.Email)
+	if err != nil {
+		s.log.Warn("Failed to read user traffic", zap.String("email", user.Email), zap.Error(err))
+	}
+	info := v2ray.SubscriptionInfo{Upload: traffic.Upload, Download: traffic.Download, Expire: user.ExpiresAt}
+	c.Header("subscription-userinfo", info.Header())
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// clientConfig 返回填入轮换后的UUID和端口的V2Ray配置，用于生成分享链接和订阅
+func (s *APIServer) clientConfig() (config.V2RayConfig, error) {
+	cfg, err := v2ray.ApplyCredentials(s.store, s.configs.Config().V2Ray)
+	if err != nil {
+		return cfg, err
+	}
+	return v2ray.ApplyPorts(s.store, cfg)
+}
+
+// publicAddress 返回客户端连接的地址，未配置 v2ray.public_address 时查询并缓存实例的公网IPv4
+func (s *APIServer) publicAddress(ctx context.Context, cfg config.V2RayConfig) (string, error) {
+	if cfg.PublicAddress != "" {
+		return cfg.PublicAddress, nil
+	}
+
+	s.mu.Lock()
+	address := s.publicIP
+	s.mu.Unlock()
+	if address != "" {
+		return address, nil
+	}
+
+	address, err := aws.GetPublicIPv4(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.publicIP = address
+	s.mu.Unlock()
+	return address, nil
+}
+
 // handleHealth 处理健康检查请求
 func (s *APIServer) handleHealth(c *gin.Context) {
 	// 返回健康状态
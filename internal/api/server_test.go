@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/v2ray"
+)
+
+func TestSubscriptionUserInfo(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig()
+	cfg.V2Ray.PublicAddress = "proxy.example.com"
+	cfg.V2Ray.Clients = []config.ClientConfig{{Email: "alice@example.com", UUID: "0b5a8a2e-7f1c-4c55-9a0e-3b0f6f1e2d4c"}}
+	store := newTestStore(t)
+	s, _ := newTestServer(t, cfg, WithStateStore(store))
+
+	token, err := v2ray.SubscriptionToken(store, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(s, http.MethodGet, "/sub/"+token, ""); w.Header().Get("subscription-userinfo") != "upload=0; download=0; total=0" {
+		t.Errorf("subscription-userinfo before collection = %q, want zero usage", w.Header().Get("subscription-userinfo"))
+	}
+
+	// 收集的用户流量出现在订阅的用量中
+	backend, _ := v2ray.NewBackend(v2ray.BackendV2Ray)
+	runner := commandtest.NewFakeRunner().On(backend.BinaryPath()+" api stats -json -reset -s "+cfg.V2Ray.StatsAPI,
+		`{"stat":[{"name":"user>>>alice@example.com>>>traffic>>>uplink","value":"1024"},{"name":"user>>>alice@example.com>>>traffic>>>downlink","value":"4096"}]}`, nil)
+	if err := v2ray.CollectUserTraffic(context.Background(), runner, backend, store, cfg.V2Ray); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(s, http.MethodGet, "/sub/"+token, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /sub/:token = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("subscription-userinfo"); got != "upload=1024; download=4096; total=0" {
+		t.Errorf("subscription-userinfo = %q, want collected usage", got)
+	}
+}
+
+func TestPatchConfig(t *testing.T) {
+	t.Parallel()
+
+	const token = "0123456789abcdef0123456789abcdef"
+	original := "version: 2\n# agent settings\nv2ray:\n  uuid: " + testUUID + "\n  port: 10086 # listen port\napi:\n  token: " + token + "\n"
+	tests := []struct {
+		name    string
+		patch   string
+		want    int
+		changed []string
+	}{
+		{name: "valid patch", patch: `{"v2ray": {"port": 20000}, "log": {"level": "debug"}}`, want: http.StatusOK, changed: []string{"log.level", "v2ray.port"}},
+		{name: "no changes", patch: `{"v2ray": {"port": 10086}}`, want: http.StatusOK, changed: []string{}},
+		{name: "unknown key", patch: `{"v2ray": {"prot": 20000}}`, want: http.StatusBadRequest},
+		{name: "invalid value", patch: `{"v2ray": {"port": 0}}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+				t.Fatal(err)
+			}
+			loader := config.NewLoader(path, config.WithLookupEnv(func(string) (string, bool) { return "", false }))
+			cfg, _, err := loader.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, configs := newTestServer(t, cfg)
+			configs.loader = loader
+
+			w := serveBody(s, http.MethodPatch, "/api/config", token, tt.patch)
+			if w.Code != tt.want {
+				t.Fatalf("PATCH /api/config = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != http.StatusOK {
+				if string(data) != original {
+					t.Errorf("config file changed after rejected patch:\n%s", data)
+				}
+				return
+			}
+
+			var response struct {
+				Changed []string `json:"changed"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(response.Changed, ",") != strings.Join(tt.changed, ",") {
+				t.Errorf("changed = %v, want %v", response.Changed, tt.changed)
+			}
+			// 响应中的配置已脱敏
+			if strings.Contains(w.Body.String(), testUUID) || strings.Contains(w.Body.String(), token) {
+				t.Errorf("response contains secrets: %s", w.Body)
+			}
+			if !strings.Contains(string(data), "# agent settings") || !strings.Contains(string(data), "# listen port") {
+				t.Errorf("config file lost comments:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestStatusRedactsUUID(t *testing.T) {
+	t.Parallel()
+
+	const token = "0123456789abcdef0123456789abcdef"
+	cfg := testConfig()
+	cfg.API.Token = token
+	s, _ := newTestServer(t, cfg)
+
+	w := serve(s, http.MethodGet, "/api/status", token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Config struct {
+			UUID string `json:"uuid"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Config.UUID == "" || strings.Contains(w.Body.String(), testUUID) {
+		t.Errorf("GET /api/status config.uuid = %q, want redacted", resp.Config.UUID)
+	}
+}
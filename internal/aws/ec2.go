@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -16,6 +17,8 @@ import (
 const (
 	// metadataBaseURL EC2元数据服务基础URL
 	metadataBaseURL = "http://169.254.169.254/latest"
+	// metadataTimeout 单次元数据请求超时时间，非EC2环境下避免长时间阻塞
+	metadataTimeout = 5 * time.Second
 )
 
 // EC2Client AWS EC2客户端
@@ -24,15 +27,15 @@ type EC2Client struct {
 }
 
 // NewEC2Client 创建新的EC2客户端
-func NewEC2Client() (*EC2Client, error) {
+func NewEC2Client(ctx context.Context) (*EC2Client, error) {
 	// 获取当前实例所在region
-	region, err := GetRegion()
+	region, err := GetRegion(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get region: %w", err)
 	}
 
 	// 加载AWS配置，自动使用EC2实例角色
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
+	cfg, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(region),
 	)
 	if err != nil {
@@ -48,11 +51,11 @@ func NewEC2Client() (*EC2Client, error) {
 }
 
 // getMetadataToken 获取EC2元数据token
-func getMetadataToken() (string, error) {
+func getMetadataToken(ctx context.Context) (string, error) {
 	// 创建http客户端
-	client := &http.Client{}
+	client := &http.Client{Timeout: metadataTimeout}
 	// 创建PUT请求
-	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/api/token", metadataBaseURL), nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/api/token", metadataBaseURL), nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create token request: %w", err)
 	}
@@ -85,18 +88,18 @@ func getMetadataToken() (string, error) {
 }
 
 // getMetadataWithToken 使用token获取EC2元数据
-func getMetadataWithToken(path string) (string, error) {
+func getMetadataWithToken(ctx context.Context, path string) (string, error) {
 	// 获取token
-	token, err := getMetadataToken()
+	token, err := getMetadataToken(ctx)
 	if err != nil {
 		return "", err
 	}
 
 	// 创建http客户端
-	client := &http.Client{}
+	client := &http.Client{Timeout: metadataTimeout}
 	// 创建GET请求
 	url := fmt.Sprintf("%s/meta-data/%s", metadataBaseURL, path)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create metadata request: %w", err)
 	}
@@ -129,7 +132,7 @@ func getMetadataWithToken(path string) (string, error) {
 }
 
 // GetInstanceID 获取当前实例ID
-func GetInstanceID() (string, error) {
+func GetInstanceID(ctx context.Context) (string, error) {
 	// 尝试从环境变量获取实例ID（用于测试）
 	instanceID := os.Getenv("EC2_INSTANCE_ID")
 	if instanceID != "" {
@@ -137,11 +140,11 @@ func GetInstanceID() (string, error) {
 	}
 
 	// 从EC2实例元数据获取实例ID，使用token认证
-	return getMetadataWithToken("instance-id")
+	return getMetadataWithToken(ctx, "instance-id")
 }
 
 // GetRegion 获取当前实例所在region
-func GetRegion() (string, error) {
+func GetRegion(ctx context.Context) (string, error) {
 	// 尝试从环境变量获取region（用于测试）
 	region := os.Getenv("AWS_REGION")
 	if region != "" {
@@ -149,13 +152,25 @@ func GetRegion() (string, error) {
 	}
 
 	// 从EC2实例元数据获取region，使用token认证
-	return getMetadataWithToken("placement/region")
+	return getMetadataWithToken(ctx, "placement/region")
+}
+
+// GetPublicIPv4 获取当前实例的公网IPv4地址，实例没有公网地址时返回错误
+func GetPublicIPv4(ctx context.Context) (string, error) {
+	// 尝试从环境变量获取公网地址（用于测试）
+	address := os.Getenv("EC2_PUBLIC_IPV4")
+	if address != "" {
+		return address, nil
+	}
+
+	// 从EC2实例元数据获取公网地址，使用token认证
+	return getMetadataWithToken(ctx, "public-ipv4")
 }
 
 // TerminateInstance 终止当前实例
-func (ec *EC2Client) TerminateInstance(instanceID string) error {
+func (ec *EC2Client) TerminateInstance(ctx context.Context, instanceID string) error {
 	// 调用AWS EC2 API终止实例
-	_, err := ec.client.TerminateInstances(context.TODO(), &ec2.TerminateInstancesInput{
+	_, err := ec.client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
 		InstanceIds: []string{instanceID},
 	})
 	if err != nil {
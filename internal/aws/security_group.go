@@ -0,0 +1,147 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// securityGroupRuleDescription Agent添加的安全组规则的描述前缀，完整描述为 "anywhere-agent:<实例ID>"
+const securityGroupRuleDescription = "anywhere-agent"
+
+// ruleDescription 返回实例添加的规则的描述，多个实例共用安全组时各自只撤销自己的规则
+func ruleDescription(instanceID string) string {
+	return securityGroupRuleDescription + ":" + instanceID
+}
+
+// PortRange 安全组放行的端口范围，同时放行TCP和UDP
+type PortRange struct {
+	From int
+	To   int
+}
+
+// securityGroupRule 安全组入站规则的比较键
+type securityGroupRule struct {
+	protocol string
+	from     int32
+	to       int32
+	cidr     string
+}
+
+// SyncSecurityGroupPorts 使安全组中本实例添加的入站规则与 ports 一致，返回添加和撤销的规则数
+// 每个端口范围对 0.0.0.0/0 和 ::/0 放行TCP和UDP；已有相同的规则（手动或其他实例添加）时不重复添加，
+// 只撤销描述为 anywhere-agent:<instanceID> 的规则，不影响手动添加和其他实例的规则。
+func (ec *EC2Client) SyncSecurityGroupPorts(ctx context.Context, groupID string, instanceID string, ports []PortRange) (int, int, error) {
+	var rules []types.SecurityGroupRule
+	paginator := ec2.NewDescribeSecurityGroupRulesPaginator(ec.client, &ec2.DescribeSecurityGroupRulesInput{
+		Filters: []types.Filter{{Name: aws.String("group-id"), Values: []string{groupID}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to describe security group rules: %w", err)
+		}
+		rules = append(rules, page.SecurityGroupRules...)
+	}
+
+	description := ruleDescription(instanceID)
+	missing, stale := planSecurityGroupRules(rules, description, ports)
+	added := 0
+	for _, key := range missing {
+		ok, err := ec.authorizeIngress(ctx, groupID, key, description)
+		if err != nil {
+			return added, 0, err
+		}
+		if ok {
+			added++
+		}
+	}
+
+	if len(stale) > 0 {
+		_, err := ec.client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:              aws.String(groupID),
+			SecurityGroupRuleIds: stale,
+		})
+		if err != nil {
+			return added, 0, fmt.Errorf("failed to revoke security group rules: %w", err)
+		}
+	}
+	return added, len(stale), nil
+}
+
+// planSecurityGroupRules 比较安全组的现有规则和需要放行的端口
+// 返回安全组中还没有的规则，以及描述为 description（本实例添加）但不再需要的规则ID。
+func planSecurityGroupRules(rules []types.SecurityGroupRule, description string, ports []PortRange) ([]securityGroupRule, []string) {
+	existing := make(map[securityGroupRule]bool)
+	owned := make(map[securityGroupRule]string) // 规则 -> 规则ID
+	for _, rule := range rules {
+		if aws.ToBool(rule.IsEgress) {
+			continue
+		}
+		cidr := aws.ToString(rule.CidrIpv4)
+		if cidr == "" {
+			cidr = aws.ToString(rule.CidrIpv6)
+		}
+		key := securityGroupRule{aws.ToString(rule.IpProtocol), aws.ToInt32(rule.FromPort), aws.ToInt32(rule.ToPort), cidr}
+		existing[key] = true
+		if aws.ToString(rule.Description) == description {
+			owned[key] = aws.ToString(rule.SecurityGroupRuleId)
+		}
+	}
+
+	var missing []securityGroupRule
+	desired := make(map[securityGroupRule]bool)
+	for _, r := range ports {
+		for _, protocol := range []string{"tcp", "udp"} {
+			for _, cidr := range []string{"0.0.0.0/0", "::/0"} {
+				key := securityGroupRule{protocol, int32(r.From), int32(r.To), cidr}
+				desired[key] = true
+				if !existing[key] {
+					missing = append(missing, key)
+				}
+			}
+		}
+	}
+
+	var stale []string
+	for key, id := range owned {
+		if !desired[key] {
+			stale = append(stale, id)
+		}
+	}
+	sort.Strings(stale)
+	return missing, stale
+}
+
+// authorizeIngress 添加一条入站规则，已存在相同规则时返回false
+func (ec *EC2Client) authorizeIngress(ctx context.Context, groupID string, rule securityGroupRule, description string) (bool, error) {
+	permission := types.IpPermission{
+		IpProtocol: aws.String(rule.protocol),
+		FromPort:   aws.Int32(rule.from),
+		ToPort:     aws.Int32(rule.to),
+	}
+	if rule.cidr == "::/0" {
+		permission.Ipv6Ranges = []types.Ipv6Range{{CidrIpv6: aws.String(rule.cidr), Description: aws.String(description)}}
+	} else {
+		permission.IpRanges = []types.IpRange{{CidrIp: aws.String(rule.cidr), Description: aws.String(description)}}
+	}
+
+	_, err := ec.client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: []types.IpPermission{permission},
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidPermission.Duplicate" {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to authorize %s %d-%d from %s: %w", rule.protocol, rule.from, rule.to, rule.cidr, err)
+	}
+	return true, nil
+}
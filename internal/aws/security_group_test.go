@@ -0,0 +1,55 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ingress 返回端口为port的IPv4入站规则
+func ingress(id string, protocol string, port int32, description string) types.SecurityGroupRule {
+	return types.SecurityGroupRule{
+		SecurityGroupRuleId: aws.String(id),
+		IsEgress:            aws.Bool(false),
+		IpProtocol:          aws.String(protocol),
+		FromPort:            aws.Int32(port),
+		ToPort:              aws.Int32(port),
+		CidrIpv4:            aws.String("0.0.0.0/0"),
+		Description:         aws.String(description),
+	}
+}
+
+func TestPlanSecurityGroupRules(t *testing.T) {
+	t.Parallel()
+
+	own := ruleDescription("i-0123456789abcdef0")
+	other := ruleDescription("i-0fedcba9876543210")
+	rules := []types.SecurityGroupRule{
+		ingress("sgr-own-443", "tcp", 443, own),       // 仍需要
+		ingress("sgr-own-8443", "tcp", 8443, own),     // 本实例不再需要
+		ingress("sgr-other-9443", "tcp", 9443, other), // 其他实例的规则
+		ingress("sgr-legacy-7443", "tcp", 7443, "anywhere-agent"),
+		ingress("sgr-manual-443", "udp", 443, "manual"), // 手动添加，与需要的规则相同
+	}
+	egress := ingress("sgr-egress", "tcp", 8443, own)
+	egress.IsEgress = aws.Bool(true)
+	rules = append(rules, egress)
+
+	missing, stale := planSecurityGroupRules(rules, own, []PortRange{{From: 443, To: 443}})
+	if len(stale) != 1 || stale[0] != "sgr-own-8443" {
+		t.Errorf("stale = %v, want only this instance's unneeded rule", stale)
+	}
+	want := map[securityGroupRule]bool{
+		{"tcp", 443, 443, "::/0"}: true,
+		{"udp", 443, 443, "::/0"}: true,
+	}
+	if len(missing) != len(want) {
+		t.Fatalf("missing = %v, want %v", missing, want)
+	}
+	for _, key := range missing {
+		if !want[key] {
+			t.Errorf("rule %v already exists but is planned to be added", key)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Runner 执行外部命令，便于在测试中替换为脚本化的实现
+type Runner interface {
+	// Run 执行命令，返回合并后的标准输出和标准错误
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner 使用 os/exec 执行命令，ctx取消时终止子进程
+type ExecRunner struct {
+	log *zap.Logger
+}
+
+// NewExecRunner 创建执行真实命令的Runner，log用于记录命令和输出（debug级别）
+func NewExecRunner(log *zap.Logger) *ExecRunner {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return &ExecRunner{log: log}
+}
+
+// Run 执行命令并返回合并输出
+func (r *ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	r.log.Debug("Executing command", zap.String("command", cmd.String()))
+	output, err := cmd.CombinedOutput()
+	r.log.Debug("Command output",
+		zap.String("command", cmd.String()),
+		zap.String("output", string(output)),
+		zap.Error(err))
+	return output, err
+}
+
+// Line 将命令名和参数拼接成一行，用于日志和匹配
+func Line(name string, args ...string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
+
+// ExitCode 返回命令的退出码，err 不是退出码错误时返回false
+func ExitCode(err error) (int, bool) {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true
+	}
+	return 0, false
+}
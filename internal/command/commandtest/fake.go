@@ -0,0 +1,119 @@
+// Package commandtest 提供用于测试的脚本化 command.Runner 实现
+package commandtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+)
+
+// Call 一次命令调用记录
+type Call struct {
+	Name string
+	Args []string
+}
+
+// Line 返回调用的完整命令行
+func (c Call) Line() string {
+	return command.Line(c.Name, c.Args...)
+}
+
+// Response 预设的命令执行结果
+type Response struct {
+	Output string
+	Err    error
+}
+
+// ExitError 模拟命令以非零状态退出
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode 返回退出码，与 *exec.ExitError 行为一致
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// FakeRunner 按命令行返回预设结果并记录所有调用
+// 同一命令行设置多个结果时按顺序返回，最后一个结果会被重复使用；
+// 未设置的命令返回 Default。
+type FakeRunner struct {
+	mu        sync.Mutex
+	responses map[string][]Response
+	calls     []Call
+
+	// Default 未预设命令的返回结果，默认为退出码127（命令不存在）
+	Default Response
+}
+
+// NewFakeRunner 创建脚本化的Runner
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		responses: make(map[string][]Response),
+		Default:   Response{Err: &ExitError{Code: 127}},
+	}
+}
+
+// On 为命令行（如 "systemctl start v2ray"）追加一个返回结果
+func (f *FakeRunner) On(line string, output string, err error) *FakeRunner {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[line] = append(f.responses[line], Response{Output: output, Err: err})
+	return f
+}
+
+// Run 记录调用并返回预设结果，ctx已取消时返回ctx.Err()
+func (f *FakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, Call{Name: name, Args: append([]string(nil), args...)})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	line := command.Line(name, args...)
+	queue := f.responses[line]
+	if len(queue) == 0 {
+		return []byte(f.Default.Output), f.Default.Err
+	}
+	response := queue[0]
+	if len(queue) > 1 {
+		f.responses[line] = queue[1:]
+	}
+	return []byte(response.Output), response.Err
+}
+
+// Calls 返回所有调用记录
+func (f *FakeRunner) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// Lines 返回所有调用的命令行
+func (f *FakeRunner) Lines() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := make([]string, len(f.calls))
+	for i, call := range f.calls {
+		lines[i] = call.Line()
+	}
+	return lines
+}
+
+// Called 检查命令行是否被调用过
+func (f *FakeRunner) Called(line string) bool {
+	for _, called := range f.Lines() {
+		if called == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 用户的访问状态
+const (
+	AccessActive   = "active"   // 可以使用
+	AccessUpcoming = "upcoming" // 不在允许的时间段内，下个时间段开始时启用
+	AccessExpired  = "expired"  // 已过 expires_at，不再启用
+)
+
+// AccessWindow 每天允许使用的时间段，end 不晚于 start 时跨越午夜
+type AccessWindow struct {
+	Days  []string `yaml:"days,omitempty" json:"days,omitempty"` // mon, tue, wed, thu, fri, sat, sun，为空时每天；跨越午夜时指开始的那天
+	Start string   `yaml:"start" json:"start"`                   // HH:MM
+	End   string   `yaml:"end" json:"end"`                       // HH:MM，24:00 表示当天结束
+}
+
+// weekdays days 中使用的星期名称
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseClock 解析 HH:MM，返回从零点开始的分钟数；allowEnd 为true时允许 24:00
+func parseClock(value string, allowEnd bool) (int, error) {
+	hour, minute, ok := strings.Cut(value, ":")
+	h, hErr := strconv.Atoi(hour)
+	m, mErr := strconv.Atoi(minute)
+	if !ok || len(hour) != 2 || len(minute) != 2 || hErr != nil || mErr != nil || m < 0 || m > 59 || h < 0 {
+		return 0, fmt.Errorf("time %q must be HH:MM", value)
+	}
+	if h > 23 && !(allowEnd && h == 24 && m == 0) {
+		return 0, fmt.Errorf("time %q must be HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// location 返回 windows 使用的时区，未配置或无法加载时为服务器本地时区
+func (c ClientConfig) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// AccessAt 返回用户在 now 的访问状态，以及状态下次变化的时间，零值表示不再变化
+func (c ClientConfig) AccessAt(now time.Time) (string, time.Time) {
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt) {
+		return AccessExpired, time.Time{}
+	}
+
+	status, next := AccessActive, time.Time{}
+	if len(c.Windows) > 0 {
+		open, change := c.windowAt(now)
+		if !open {
+			status = AccessUpcoming
+		}
+		next = change
+	}
+	if !c.ExpiresAt.IsZero() && (next.IsZero() || c.ExpiresAt.Before(next)) {
+		next = c.ExpiresAt
+	}
+	return status, next
+}
+
+// windowAt 返回 now 是否在允许的时间段内，以及下次开放或关闭的时间
+// 相邻或重叠的时间段视为一个连续的时间段。
+func (c ClientConfig) windowAt(now time.Time) (bool, time.Time) {
+	type span struct{ start, end time.Time }
+	var spans []span
+	local := now.In(c.location())
+	year, month, day := local.Date()
+	// 前一天开始的时间段可能跨越午夜；每个时间段每周至少出现一次
+	for offset := -1; offset <= 8; offset++ {
+		date := time.Date(year, month, day+offset, 0, 0, 0, 0, local.Location())
+		for _, window := range c.Windows {
+			if !window.onDay(date.Weekday()) {
+				continue
+			}
+			start, startErr := parseClock(window.Start, false)
+			end, endErr := parseClock(window.End, true)
+			if startErr != nil || endErr != nil {
+				continue
+			}
+			if end <= start {
+				end += 24 * 60
+			}
+			spans = append(spans, span{
+				start: time.Date(year, month, day+offset, 0, start, 0, 0, local.Location()),
+				end:   time.Date(year, month, day+offset, 0, end, 0, 0, local.Location()),
+			})
+		}
+	}
+
+	var closesAt time.Time
+	for _, s := range spans {
+		if !now.Before(s.start) && now.Before(s.end) && s.end.After(closesAt) {
+			closesAt = s.end
+		}
+	}
+	if !closesAt.IsZero() {
+		// 延伸到与当前时间段相连的时间段结束
+		for extended := true; extended; {
+			extended = false
+			for _, s := range spans {
+				if !closesAt.Before(s.start) && closesAt.Before(s.end) {
+					closesAt, extended = s.end, true
+				}
+			}
+		}
+		// 一直延伸到计算范围的末尾说明时间段覆盖整周，不会关闭
+		for _, s := range spans {
+			if s.end.After(closesAt) {
+				return true, closesAt
+			}
+		}
+		return true, time.Time{}
+	}
+
+	var opensAt time.Time
+	for _, s := range spans {
+		if s.start.After(now) && (opensAt.IsZero() || s.start.Before(opensAt)) {
+			opensAt = s.start
+		}
+	}
+	return false, opensAt
+}
+
+// onDay 时间段是否在星期 weekday 开始
+func (w AccessWindow) onDay(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if weekdays[day] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// validateClientAccess 验证用户的时间段和时区
+func validateClientAccess(problems *ValidationError, key string, client ClientConfig) {
+	if client.Timezone != "" {
+		if len(client.Windows) == 0 {
+			problems.addf("%s.timezone requires windows", key)
+		} else if _, err := time.LoadLocation(client.Timezone); err != nil {
+			problems.addf("%s.timezone %q is not a valid time zone", key, client.Timezone)
+		}
+	}
+	for i, window := range client.Windows {
+		windowKey := fmt.Sprintf("%s.windows[%d]", key, i)
+		start, startErr := parseClock(window.Start, false)
+		if startErr != nil {
+			problems.addf("%s.start: %v", windowKey, startErr)
+		}
+		end, endErr := parseClock(window.End, true)
+		if endErr != nil {
+			problems.addf("%s.end: %v", windowKey, endErr)
+		}
+		if startErr == nil && endErr == nil && start == end {
+			problems.addf("%s.start and end must differ", windowKey)
+		}
+		for j, day := range window.Days {
+			if _, ok := weekdays[day]; !ok {
+				problems.addf("%s.days[%d] %q must be one of mon, tue, wed, thu, fri, sat, sun", windowKey, j, day)
+			}
+		}
+	}
+}
@@ -3,14 +3,20 @@ package config
 import (
 	"flag"
 	"fmt"
-	"os"
+	"io"
+	"strings"
 )
 
-// CLIConfig 存储命令行参数
-var CLIConfig struct {
-	ConfigFile string
-	LogDir     string
-	Version    bool
+// CLI 存储命令行参数
+type CLI struct {
+	ConfigFile  string
+	LogDir      string
+	StateFile   string // 保存REALITY密钥等自动生成数据的文件
+	WatchConfig bool
+	Version     bool
+	Overrides   []string // --set key=value，按出现顺序覆盖配置项
+	Command     string   // 子命令，如 "config show"，为空时运行Agent
+	ShowSources bool     // config show --sources
 }
 
 // GetVersion 返回版本信息
@@ -18,24 +24,81 @@ func GetVersion() string {
 	return "v1.0.0"
 }
 
-// InitCLI 初始化命令行参数
-func InitCLI() error {
+// overrideFlag 可重复的 --set 参数
+type overrideFlag []string
+
+func (o *overrideFlag) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *overrideFlag) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+// registerCommonFlags 注册Agent和子命令共用的参数
+func (c *CLI) registerCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "Config file path")
+	fs.StringVar(&c.LogDir, "log-dir", c.LogDir, "Log directory")
+	fs.Var((*overrideFlag)(&c.Overrides), "set", "Override a config key, e.g. --set v2ray.port=443 (repeatable)")
+}
+
+// ParseCLI 解析命令行参数，args 不包含程序名
+func ParseCLI(name string, args []string, output io.Writer) (*CLI, error) {
+	cli := &CLI{
+		ConfigFile: "./config.yaml",
+		LogDir:     "/var/log/aw_agent/",
+		StateFile:  "/var/lib/aw_agent/state.json",
+	}
+
 	// 使用标准库flag解析命令行参数
-	flag.StringVar(&CLIConfig.ConfigFile, "config", "./config.yaml", "Config file path")
-	flag.StringVar(&CLIConfig.LogDir, "log-dir", "/var/log/aw_agent/", "Log directory")
-	flag.BoolVar(&CLIConfig.Version, "version", false, "Show version information")
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	cli.registerCommonFlags(fs)
+	fs.StringVar(&cli.StateFile, "state-file", cli.StateFile, "State file for generated keys")
+	fs.BoolVar(&cli.WatchConfig, "watch-config", false, "Reload config automatically when the config file changes")
+	fs.BoolVar(&cli.Version, "version", false, "Show version information")
 
 	// 自定义help信息
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Anywhere Agent is a tool for deploying and managing V2Ray on EC2 instances.\n")
-		fmt.Fprintf(os.Stderr, "It automatically checks and deploys V2Ray, monitors traffic, and manages EC2 instances.\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Flags:\n")
-		flag.PrintDefaults()
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Anywhere Agent is a tool for deploying and managing V2Ray on EC2 instances.\n")
+		fmt.Fprintf(output, "It automatically checks and deploys V2Ray, monitors traffic, and manages EC2 instances.\n\n")
+		fmt.Fprintf(output, "Usage: %s [flags]\n", name)
+		fmt.Fprintf(output, "       %s [flags] config show [--sources]\n\n", name)
+		fmt.Fprintf(output, "Every config key can also be set with an AW_* environment variable,\n")
+		fmt.Fprintf(output, "e.g. AW_V2RAY_PORT=443. Precedence: defaults < config file < env < --set.\n\n")
+		fmt.Fprintf(output, "Flags:\n")
+		fs.PrintDefaults()
 	}
 
 	// 解析命令行参数
-	flag.Parse()
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
 
-	return nil
+	// 解析子命令
+	rest := fs.Args()
+	if len(rest) == 0 {
+		return cli, nil
+	}
+	if len(rest) < 2 || rest[0] != "config" || rest[1] != "show" {
+		fs.Usage()
+		return nil, fmt.Errorf("unknown command: %s", strings.Join(rest, " "))
+	}
+
+	cli.Command = "config show"
+	showFlags := flag.NewFlagSet(name+" config show", flag.ContinueOnError)
+	showFlags.SetOutput(output)
+	cli.registerCommonFlags(showFlags)
+	showFlags.BoolVar(&cli.ShowSources, "sources", false, "Show which layer each value came from")
+	if err := showFlags.Parse(rest[2:]); err != nil {
+		return nil, err
+	}
+
+	return cli, nil
+}
+
+// Loader 返回按命令行参数加载配置的Loader，opts 追加在命令行参数之后
+func (c *CLI) Loader(opts ...LoaderOption) *Loader {
+	return NewLoader(c.ConfigFile, append([]LoaderOption{WithOverrides(c.Overrides)}, opts...)...)
 }
@@ -1,123 +1,639 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 // Config 存储所有配置项
 type Config struct {
-	V2Ray  V2RayConfig  `yaml:"v2ray"`
-	API    APIConfig    `yaml:"api"`
-	Checks ChecksConfig `yaml:"checks"`
-	Log    LogConfig    `yaml:"log"`
+	Version int `yaml:"version" json:"version"`
+	// Backend 代理核心：v2ray, xray, sing-box，v2ray 段的配置由所选核心共用
+	Backend string       `yaml:"backend" json:"backend"`
+	V2Ray   V2RayConfig  `yaml:"v2ray" json:"v2ray"`
+	API     APIConfig    `yaml:"api" json:"api"`
+	Checks  ChecksConfig `yaml:"checks" json:"checks"`
+	Log     LogConfig    `yaml:"log" json:"log"`
 }
 
 // V2RayConfig V2Ray相关配置
 type V2RayConfig struct {
-	Port      int    `yaml:"port"`
-	UUID      string `yaml:"uuid"`
-	AccessLog string `yaml:"access_log"`
+	Port      int            `yaml:"port" json:"port"`
+	UUID      string         `yaml:"uuid" json:"uuid"`
+	AccessLog string         `yaml:"access_log" json:"access_log"`
+	Clients   []ClientConfig `yaml:"clients,omitempty" json:"clients,omitempty"`
+	// Rotation 用户UUID轮换，轮换后的UUID保存在状态文件中，覆盖此处配置的UUID
+	Rotation RotationConfig `yaml:"rotation" json:"rotation"`
+	// Inbounds 入站列表，为空时在 port 上提供单个VMess入站
+	Inbounds []InboundConfig `yaml:"inbounds,omitempty" json:"inbounds,omitempty"`
+	// Outbounds 额外的出站，由 routing.rules 和 clients 的 outbound 按tag引用；内置 direct 和 block 出站
+	Outbounds   []OutboundConfig  `yaml:"outbounds,omitempty" json:"outbounds,omitempty"`
+	Routing     RoutingConfig     `yaml:"routing" json:"routing"`
+	DNS         DNSConfig         `yaml:"dns" json:"dns"`
+	HealthCheck HealthCheckConfig `yaml:"health_check" json:"health_check"`
+	Decoy       DecoyConfig       `yaml:"decoy" json:"decoy"`
+	Firewall    FirewallConfig    `yaml:"firewall" json:"firewall"`
+	// PublicAddress 分享链接中客户端连接的地址（域名或IP），为空时使用EC2实例的公网IPv4
+	PublicAddress string `yaml:"public_address" json:"public_address"`
+	// ClashAPI sing-box的Clash API监听地址，用于流量统计，仅 backend 为 sing-box 时使用
+	ClashAPI string `yaml:"clash_api" json:"clash_api"`
+	// ClashSecret Clash API的访问密钥，由Agent生成并保存在状态文件中，不出现在配置文件和API中
+	ClashSecret string `yaml:"-" json:"-"`
+	// StatsAPI V2Ray/Xray统计API的监听地址，用于按用户统计流量，为空时不统计
+	StatsAPI string `yaml:"stats_api" json:"stats_api"`
+	// ServiceManager V2Ray服务管理方式：auto, systemd, openrc, supervisor
+	ServiceManager string `yaml:"service_manager" json:"service_manager"`
+	// Version 安装的核心版本，如 5.16.1，为空或 latest 时安装最新版本
+	Version string        `yaml:"version" json:"version"`
+	Install InstallConfig `yaml:"install" json:"install"`
+	GeoData GeoDataConfig `yaml:"geodata" json:"geodata"`
+}
+
+// InstallConfig 核心安装来源
+type InstallConfig struct {
+	ReleaseURL string `yaml:"release_url" json:"release_url"` // 发布地址或镜像，为空时使用所选核心的GitHub发布地址
+	Proxy      string `yaml:"proxy" json:"proxy"`             // 下载使用的HTTP代理，为空时使用 HTTPS_PROXY 等环境变量
+	Archive    string `yaml:"archive" json:"archive"`         // 本地发布包路径，设置后不再下载
+	SHA256     string `yaml:"sha256" json:"sha256"`           // 发布包的SHA256，为空时使用 .dgst 文件校验
+	SkipVerify bool   `yaml:"skip_verify" json:"skip_verify"` // 没有可用的摘要时仍然安装，默认拒绝安装未校验的发布包
+}
+
+// GeoDataConfig V2Ray/Xray的geoip.dat、geosite.dat定期更新，sing-box使用自行更新的远程规则集
+// 每个文件的下载地址依次尝试，后面的作为镜像；每个地址须提供 {url}.sha256sum 校验文件，下载使用 install.proxy。
+type GeoDataConfig struct {
+	Interval Duration `yaml:"interval" json:"interval"` // 检查更新的间隔，0 表示不更新
+	GeoIP    []string `yaml:"geoip" json:"geoip"`
+	Geosite  []string `yaml:"geosite" json:"geosite"`
+}
+
+// ClientConfig V2Ray客户端配置，UUID之外的额外用户
+type ClientConfig struct {
+	Email string `yaml:"email" json:"email"`
+	UUID  string `yaml:"uuid" json:"uuid"`
+	// Outbound 该用户未匹配任何路由规则时使用的出站tag，为空时使用 direct
+	Outbound string `yaml:"outbound,omitempty" json:"outbound,omitempty"`
+	// ExpiresAt 过期时间（RFC 3339，如 2024-06-01T00:00:00+08:00），到期后从核心中删除，为空时不过期
+	ExpiresAt time.Time `yaml:"expires_at,omitempty" json:"expires_at,omitempty"`
+	// Windows 每天允许使用的时间段，时间段外从核心中删除，为空时不限制
+	Windows []AccessWindow `yaml:"windows,omitempty" json:"windows,omitempty"`
+	// Timezone windows 使用的时区，如 Asia/Shanghai，为空时使用服务器本地时区
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+// RotationConfig 用户凭据轮换
+type RotationConfig struct {
+	Interval Duration `yaml:"interval" json:"interval"` // 定期轮换所有用户的间隔，0 表示只通过API轮换
+	Overlap  Duration `yaml:"overlap" json:"overlap"`   // 轮换后旧UUID继续有效的时间，0 表示立即失效
+}
+
+// InboundConfig 入站配置，所有用户（uuid 及 clients）均可使用每个入站
+// Trojan、Hysteria2 使用用户的UUID作为密码，TUIC 的UUID和密码均为用户的UUID，
+// Shadowsocks 使用入站自身的 method 和 password。
+type InboundConfig struct {
+	Tag      string        `yaml:"tag" json:"tag"`
+	Protocol string        `yaml:"protocol" json:"protocol"` // vmess, vless, trojan, shadowsocks, hysteria2, tuic
+	Port     int           `yaml:"port" json:"port"`
+	Security string        `yaml:"security,omitempty" json:"security,omitempty"` // none, tls, reality
+	TLS      TLSConfig     `yaml:"tls,omitempty" json:"tls,omitempty"`
+	Reality  RealityConfig `yaml:"reality,omitempty" json:"reality,omitempty"`
+	Flow     string        `yaml:"flow,omitempty" json:"flow,omitempty"`         // VLESS流控，如 xtls-rprx-vision
+	Method   string        `yaml:"method,omitempty" json:"method,omitempty"`     // Shadowsocks加密方式
+	Password string        `yaml:"password,omitempty" json:"password,omitempty"` // Shadowsocks密码
+	// PortRange 端口跳跃范围，如 20000-20100，范围内的端口由Agent通过防火墙重定向到监听端口
+	PortRange    string             `yaml:"port_range,omitempty" json:"port_range,omitempty"`
+	PortRotation PortRotationConfig `yaml:"port_rotation,omitempty" json:"port_rotation,omitempty"`
+}
+
+// TLSConfig 入站TLS证书
+type TLSConfig struct {
+	CertFile   string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+}
+
+// RealityConfig VLESS入站的REALITY设置
+// 未配置 private_key 和 short_ids 时由Agent生成，并保存在状态文件中，重启后保持不变。
+type RealityConfig struct {
+	Dest        string   `yaml:"dest,omitempty" json:"dest,omitempty"`                 // 转发未认证连接的目标站点，如 www.microsoft.com:443
+	ServerNames []string `yaml:"server_names,omitempty" json:"server_names,omitempty"` // 客户端可使用的SNI，需为目标站点证书中的域名
+	Fingerprint string   `yaml:"fingerprint,omitempty" json:"fingerprint,omitempty"`   // 客户端uTLS指纹，默认 chrome
+	PrivateKey  string   `yaml:"private_key,omitempty" json:"private_key,omitempty"`   // x25519私钥（base64url）
+	ShortIDs    []string `yaml:"short_ids,omitempty" json:"short_ids,omitempty"`       // 十六进制，最长16个字符
+}
+
+// EffectiveInbounds 返回实际生效的入站列表，未配置 inbounds 时为 port 上的VMess入站
+func (c V2RayConfig) EffectiveInbounds() []InboundConfig {
+	if len(c.Inbounds) > 0 {
+		return c.Inbounds
+	}
+	return []InboundConfig{{Tag: "vmess", Protocol: "vmess", Port: c.Port}}
+}
+
+// Users 返回所有用户，第一个为 uuid 对应的默认用户（email为空）
+func (c V2RayConfig) Users() []ClientConfig {
+	users := []ClientConfig{{UUID: c.UUID}}
+	return append(users, c.Clients...)
 }
 
 // APIConfig API服务相关配置
 type APIConfig struct {
-	Address string `yaml:"address"`
-	Port    int    `yaml:"port"`
+	Address string `yaml:"address" json:"address"`
+	Port    int    `yaml:"port" json:"port"`
+	// Token 访问 /api 的Bearer token，为空时自动生成并保存在状态文件中
+	Token string `yaml:"token" json:"token"`
 }
 
 // ChecksConfig 检查相关配置
 type ChecksConfig struct {
-	TrafficInterval int `yaml:"traffic_interval"`
-	IdleTimeout     int `yaml:"idle_timeout"`
+	TrafficInterval Duration `yaml:"traffic_interval" json:"traffic_interval"`
+	IdleTimeout     Duration `yaml:"idle_timeout" json:"idle_timeout"`
 }
 
 // LogConfig 日志相关配置
 type LogConfig struct {
-	Level      string `yaml:"level"`
-	MaxSize    int    `yaml:"max_size"`
-	MaxBackups int    `yaml:"max_backups"`
-	MaxAge     int    `yaml:"max_age"`
+	Level      string `yaml:"level" json:"level"`
+	MaxSize    int    `yaml:"max_size" json:"max_size"`
+	MaxBackups int    `yaml:"max_backups" json:"max_backups"`
+	MaxAge     int    `yaml:"max_age" json:"max_age"`
+}
+
+// Loader 按 默认值 -> 配置文件 -> AW_*环境变量 -> --set参数 的顺序加载配置
+type Loader struct {
+	path      string
+	overrides []string
+	lookupEnv func(key string) (string, bool)
+	validate  func(*Config) error
+}
+
+// LoaderOption Loader可选参数
+type LoaderOption func(*Loader)
+
+// WithOverrides 设置 --set key=value 覆盖项
+func WithOverrides(overrides []string) LoaderOption {
+	return func(l *Loader) {
+		l.overrides = overrides
+	}
+}
+
+// WithLookupEnv 设置环境变量查询函数，默认使用 os.LookupEnv
+func WithLookupEnv(lookupEnv func(key string) (string, bool)) LoaderOption {
+	return func(l *Loader) {
+		l.lookupEnv = lookupEnv
+	}
 }
 
-// AppConfig 全局配置实例
-var AppConfig Config
+// WithValidator 设置额外的配置校验，在内置校验之后执行，问题一并汇总返回
+// 用于校验依赖其他包的规则，如所选代理核心是否支持配置中的功能。
+func WithValidator(validate func(*Config) error) LoaderOption {
+	return func(l *Loader) {
+		l.validate = validate
+	}
+}
 
-// LoadConfig 加载配置文件
-func LoadConfig() error {
+// NewLoader 创建配置加载器
+func NewLoader(path string, opts ...LoaderOption) *Loader {
+	l := &Loader{
+		path:      path,
+		lookupEnv: os.LookupEnv,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Path 返回配置文件路径
+func (l *Loader) Path() string {
+	return l.path
+}
+
+// Load 加载配置，返回生效配置及每个配置项的来源（default/file/env/flag）
+func (l *Loader) Load() (*Config, map[string]string, error) {
+	configData, err := readConfigFile(l.path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 合并各层配置并验证
+	return l.resolve(configData, false)
+}
+
+// Reload 重新读取配置文件，返回新配置和相对当前配置发生变化的配置项
+// 环境变量和--set参数仍然覆盖配置文件。新配置校验失败时返回错误，调用方应继续使用当前配置。
+func (l *Loader) Reload(cur *Config) (*Config, []string, error) {
+	configData, err := readConfigFile(l.path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg, _, err := l.resolve(configData, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, Diff(cur, cfg), nil
+}
+
+// readConfigFile 读取配置文件
+func readConfigFile(path string) ([]byte, error) {
 	// 检查配置文件是否存在
-	if _, err := os.Stat(CLIConfig.ConfigFile); os.IsNotExist(err) {
-		return fmt.Errorf("config file not found: %s", CLIConfig.ConfigFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("config file not found: %s", path)
 	}
 
-	// 读取配置文件
-	configData, err := os.ReadFile(CLIConfig.ConfigFile)
+	configData, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// 解析YAML配置
-	if err := yaml.Unmarshal(configData, &AppConfig); err != nil {
-		return fmt.Errorf("failed to unmarshal config: %w", err)
+	return configData, nil
+}
+
+// parseDocument 解析YAML文档并迁移到当前版本结构
+func parseDocument(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config root must be a mapping")
 	}
 
-	// 验证配置完整性
-	if err := validateConfig(); err != nil {
-		return err
+	if _, err := migrate(&doc); err != nil {
+		return nil, err
 	}
 
-	// 创建日志目录（如果不存在）
-	if err := os.MkdirAll(CLIConfig.LogDir, 0755); err != nil {
-		return fmt.Errorf("failed to create log directory %s: %w", CLIConfig.LogDir, err)
+	return &doc, nil
+}
+
+// decodeDocument 将文档解析到带默认值的配置中并验证
+// 类型错误不中断解析，与校验问题一起汇总返回；strict 为true时拒绝未知配置项。
+// validate 不为空时在内置校验之后执行。
+func decodeDocument(doc *yaml.Node, strict bool, validate func(*Config) error) (*Config, error) {
+	cfg := DefaultConfig()
+
+	var err error
+	if strict {
+		var buf bytes.Buffer
+		if err := yaml.NewEncoder(&buf).Encode(doc); err != nil {
+			return nil, fmt.Errorf("failed to encode config: %w", err)
+		}
+		decoder := yaml.NewDecoder(&buf)
+		decoder.KnownFields(true)
+		err = decoder.Decode(&cfg)
+	} else {
+		err = doc.Decode(&cfg)
 	}
 
-	return nil
+	var typeErrors []string
+	if err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		}
+		typeErrors = typeErr.Errors
+	}
+
+	problems := &ValidationError{Problems: typeErrors}
+	if err := problems.merge(validateConfig(&cfg)); err != nil {
+		return nil, err
+	}
+	if validate != nil {
+		if err := problems.merge(validate(&cfg)); err != nil {
+			return nil, err
+		}
+	}
+	if len(problems.Problems) > 0 {
+		return nil, problems
+	}
+
+	return &cfg, nil
+}
+
+// uuidPattern UUID格式
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// logLevels 支持的日志级别
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+// versionPattern V2Ray版本号格式
+var versionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+$`)
+
+// sha256Pattern SHA256摘要格式
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// defaultUserEmail 状态文件和流量统计中表示 uuid 对应的默认用户，不能用作 clients 的email
+const defaultUserEmail = "default"
+
+// minAPITokenLength api.token 的最小长度
+const minAPITokenLength = 16
+
+// backends 支持的代理核心
+var backends = map[string]bool{"v2ray": true, "xray": true, "sing-box": true}
+
+// inboundProtocols 支持的入站协议
+var inboundProtocols = map[string]bool{
+	"vmess":       true,
+	"vless":       true,
+	"trojan":      true,
+	"shadowsocks": true,
+	"hysteria2":   true,
+	"tuic":        true,
+}
+
+// tlsProtocols 必须使用TLS的入站协议
+var tlsProtocols = map[string]bool{"trojan": true, "hysteria2": true, "tuic": true}
+
+// inboundSecurities 支持的入站传输安全
+var inboundSecurities = map[string]bool{"": true, "none": true, "tls": true, "reality": true}
+
+// realityFingerprints REALITY客户端支持的uTLS指纹
+var realityFingerprints = map[string]bool{
+	"":           true,
+	"chrome":     true,
+	"firefox":    true,
+	"safari":     true,
+	"ios":        true,
+	"android":    true,
+	"edge":       true,
+	"360":        true,
+	"qq":         true,
+	"random":     true,
+	"randomized": true,
+}
+
+// shortIDPattern REALITY short ID，偶数个十六进制字符
+var shortIDPattern = regexp.MustCompile(`^([0-9a-f]{2}){0,8}$`)
+
+// shadowsocksMethods 支持的Shadowsocks加密方式，2022系列是否可用取决于所选核心
+var shadowsocksMethods = map[string]bool{
+	"aes-128-gcm":                   true,
+	"aes-256-gcm":                   true,
+	"chacha20-poly1305":             true,
+	"2022-blake3-aes-128-gcm":       true,
+	"2022-blake3-aes-256-gcm":       true,
+	"2022-blake3-chacha20-poly1305": true,
 }
 
-// validateConfig 验证配置完整性
-func validateConfig() error {
+// vlessFlows 支持的VLESS流控，是否可用取决于所选核心
+var vlessFlows = map[string]bool{"": true, "xtls-rprx-vision": true}
+
+// serviceManagers 支持的V2Ray服务管理方式
+var serviceManagers = map[string]bool{"auto": true, "systemd": true, "openrc": true, "supervisor": true}
+
+// validateConfig 验证配置，返回包含所有问题的 *ValidationError
+func validateConfig(cfg *Config) error {
+	problems := &ValidationError{}
+
+	if !backends[cfg.Backend] {
+		problems.addf("backend %q must be one of v2ray, xray, sing-box", cfg.Backend)
+	}
+
 	// 验证V2Ray配置
-	if AppConfig.V2Ray.Port == 0 {
-		return fmt.Errorf("v2ray.port is required")
+	validatePort(problems, "v2ray.port", cfg.V2Ray.Port)
+	validateUUID(problems, "v2ray.uuid", cfg.V2Ray.UUID)
+	if cfg.V2Ray.AccessLog == "" {
+		problems.addf("v2ray.access_log is required")
+	}
+	emails := make(map[string]bool)
+	for i, client := range cfg.V2Ray.Clients {
+		if client.Email == "" {
+			problems.addf("v2ray.clients[%d].email is required", i)
+		} else if emails[client.Email] {
+			problems.addf("v2ray.clients[%d].email %q is duplicated", i, client.Email)
+		} else if client.Email == defaultUserEmail {
+			problems.addf("v2ray.clients[%d].email %q is reserved for the default user", i, client.Email)
+		}
+		emails[client.Email] = true
+		validateUUID(problems, fmt.Sprintf("v2ray.clients[%d].uuid", i), client.UUID)
+		validateClientAccess(problems, fmt.Sprintf("v2ray.clients[%d]", i), client)
+	}
+	if cfg.V2Ray.Rotation.Interval < 0 {
+		problems.addf("v2ray.rotation.interval must not be negative")
+	}
+	if cfg.V2Ray.Rotation.Overlap < 0 {
+		problems.addf("v2ray.rotation.overlap must not be negative")
+	}
+	validateInbounds(problems, cfg.V2Ray.Inbounds)
+	validateRouting(problems, cfg.V2Ray)
+	validateDNS(problems, cfg.V2Ray.DNS)
+	validateDecoy(problems, cfg.V2Ray)
+	validatePorts(problems, cfg)
+	if address := cfg.V2Ray.PublicAddress; address != "" && (strings.ContainsAny(address, "/@?# ") ||
+		(strings.Contains(address, ":") && net.ParseIP(address) == nil)) {
+		problems.addf("v2ray.public_address %q must be a host name or IP address without port", address)
+	}
+	if cfg.V2Ray.ClashAPI != "" {
+		if _, port, err := net.SplitHostPort(cfg.V2Ray.ClashAPI); err != nil || port == "" {
+			problems.addf("v2ray.clash_api %q must be host:port", cfg.V2Ray.ClashAPI)
+		}
+	}
+	if cfg.V2Ray.StatsAPI != "" {
+		if host, port, err := net.SplitHostPort(cfg.V2Ray.StatsAPI); err != nil || net.ParseIP(host) == nil || port == "" {
+			problems.addf("v2ray.stats_api %q must be ip:port", cfg.V2Ray.StatsAPI)
+		}
+	}
+	if !serviceManagers[cfg.V2Ray.ServiceManager] {
+		problems.addf("v2ray.service_manager %q must be one of auto, systemd, openrc, supervisor", cfg.V2Ray.ServiceManager)
+	}
+	if cfg.V2Ray.Version != "" && cfg.V2Ray.Version != "latest" && !versionPattern.MatchString(cfg.V2Ray.Version) {
+		problems.addf("v2ray.version %q must be latest or a version like 5.16.1", cfg.V2Ray.Version)
+	}
+	if cfg.V2Ray.Install.ReleaseURL != "" {
+		validateURL(problems, "v2ray.install.release_url", cfg.V2Ray.Install.ReleaseURL, "http", "https")
+	}
+	if cfg.V2Ray.Install.Proxy != "" {
+		validateURL(problems, "v2ray.install.proxy", cfg.V2Ray.Install.Proxy, "http", "https", "socks5")
 	}
-	if AppConfig.V2Ray.UUID == "" {
-		return fmt.Errorf("v2ray.uuid is required")
+	if cfg.V2Ray.Install.SHA256 != "" && !sha256Pattern.MatchString(cfg.V2Ray.Install.SHA256) {
+		problems.addf("v2ray.install.sha256 must be a hex encoded SHA256 digest")
 	}
-	if AppConfig.V2Ray.AccessLog == "" {
-		return fmt.Errorf("v2ray.access_log is required")
+	if cfg.V2Ray.GeoData.Interval < 0 {
+		problems.addf("v2ray.geodata.interval must not be negative")
 	}
+	validateGeoDataURLs(problems, "v2ray.geodata.geoip", cfg.V2Ray.GeoData.GeoIP, cfg.V2Ray.GeoData.Interval > 0)
+	validateGeoDataURLs(problems, "v2ray.geodata.geosite", cfg.V2Ray.GeoData.Geosite, cfg.V2Ray.GeoData.Interval > 0)
 
 	// 验证API配置
-	if AppConfig.API.Address == "" {
-		return fmt.Errorf("api.address is required")
+	if cfg.API.Address == "" {
+		problems.addf("api.address is required")
+	} else if net.ParseIP(cfg.API.Address) == nil {
+		problems.addf("api.address %q is not a valid IP address", cfg.API.Address)
 	}
-	if AppConfig.API.Port == 0 {
-		return fmt.Errorf("api.port is required")
+	validatePort(problems, "api.port", cfg.API.Port)
+	if cfg.API.Token != "" && len(cfg.API.Token) < minAPITokenLength {
+		problems.addf("api.token must be at least %d characters", minAPITokenLength)
 	}
 
 	// 验证Checks配置
-	if AppConfig.Checks.TrafficInterval == 0 {
-		return fmt.Errorf("checks.traffic_interval is required")
+	if cfg.Checks.TrafficInterval <= 0 {
+		problems.addf("checks.traffic_interval must be positive")
 	}
-	if AppConfig.Checks.IdleTimeout == 0 {
-		return fmt.Errorf("checks.idle_timeout is required")
+	if cfg.Checks.IdleTimeout <= 0 {
+		problems.addf("checks.idle_timeout must be positive")
 	}
 
-	// 验证Log配置
-	if AppConfig.Log.Level == "" {
-		return fmt.Errorf("log.level is required")
+	// 验证Log配置，轮转参数为0表示使用lumberjack默认值/不限制
+	if !logLevels[cfg.Log.Level] {
+		problems.addf("log.level %q must be one of debug, info, warn, error", cfg.Log.Level)
 	}
-	if AppConfig.Log.MaxSize == 0 {
-		return fmt.Errorf("log.max_size is required")
+	if cfg.Log.MaxSize < 0 {
+		problems.addf("log.max_size must not be negative")
 	}
-	if AppConfig.Log.MaxBackups == 0 {
-		return fmt.Errorf("log.max_backups is required")
+	if cfg.Log.MaxBackups < 0 {
+		problems.addf("log.max_backups must not be negative")
 	}
-	if AppConfig.Log.MaxAge == 0 {
-		return fmt.Errorf("log.max_age is required")
+	if cfg.Log.MaxAge < 0 {
+		problems.addf("log.max_age must not be negative")
 	}
 
+	if len(problems.Problems) > 0 {
+		return problems
+	}
 	return nil
 }
+
+// validateInbounds 验证入站列表，核心相关的限制由 WithValidator 设置的校验负责
+func validateInbounds(problems *ValidationError, inbounds []InboundConfig) {
+	tags := make(map[string]bool)
+	ports := make(map[int]bool)
+	for i, inbound := range inbounds {
+		key := fmt.Sprintf("v2ray.inbounds[%d]", i)
+		if inbound.Tag == "" {
+			problems.addf("%s.tag is required", key)
+		} else if tags[inbound.Tag] {
+			problems.addf("%s.tag %q is duplicated", key, inbound.Tag)
+		}
+		tags[inbound.Tag] = true
+		validatePort(problems, key+".port", inbound.Port)
+		if ports[inbound.Port] {
+			problems.addf("%s.port %d is used by another inbound", key, inbound.Port)
+		}
+		ports[inbound.Port] = true
+
+		if !inboundProtocols[inbound.Protocol] {
+			problems.addf("%s.protocol %q must be one of vmess, vless, trojan, shadowsocks, hysteria2, tuic", key, inbound.Protocol)
+		}
+		if !inboundSecurities[inbound.Security] {
+			problems.addf("%s.security %q must be none, tls or reality", key, inbound.Security)
+		}
+		switch inbound.Security {
+		case "tls":
+			if inbound.TLS.CertFile == "" || inbound.TLS.KeyFile == "" {
+				problems.addf("%s.tls.cert_file and %s.tls.key_file are required with security tls", key, key)
+			}
+		case "reality":
+			validateReality(problems, key, inbound)
+		}
+		if inbound.Security != "tls" && tlsProtocols[inbound.Protocol] {
+			problems.addf("%s: %s requires security tls", key, inbound.Protocol)
+		}
+
+		if !vlessFlows[inbound.Flow] {
+			problems.addf("%s.flow %q must be xtls-rprx-vision", key, inbound.Flow)
+		} else if inbound.Flow != "" && (inbound.Protocol != "vless" || (inbound.Security != "tls" && inbound.Security != "reality")) {
+			problems.addf("%s.flow is only supported by vless with security tls or reality", key)
+		}
+
+		if inbound.Protocol == "shadowsocks" {
+			if !shadowsocksMethods[inbound.Method] {
+				problems.addf("%s.method %q is not a supported shadowsocks method", key, inbound.Method)
+			}
+			if inbound.Password == "" {
+				problems.addf("%s.password is required for shadowsocks", key)
+			}
+		}
+	}
+}
+
+// validateReality 验证REALITY设置，dest是否可达在部署时检查
+func validateReality(problems *ValidationError, key string, inbound InboundConfig) {
+	reality := inbound.Reality
+	if inbound.Protocol != "vless" {
+		problems.addf("%s: security reality is only supported by vless", key)
+	}
+	if reality.Dest == "" {
+		problems.addf("%s.reality.dest is required with security reality", key)
+	} else if host, port, err := net.SplitHostPort(reality.Dest); err != nil || host == "" || port == "" {
+		problems.addf("%s.reality.dest %q must be host:port", key, reality.Dest)
+	}
+	if len(reality.ServerNames) == 0 {
+		problems.addf("%s.reality.server_names is required with security reality", key)
+	}
+	for i, name := range reality.ServerNames {
+		if name == "" {
+			problems.addf("%s.reality.server_names[%d] must not be empty", key, i)
+		}
+	}
+	if !realityFingerprints[reality.Fingerprint] {
+		problems.addf("%s.reality.fingerprint %q is not a supported fingerprint", key, reality.Fingerprint)
+	}
+	if reality.PrivateKey != "" {
+		if raw, err := base64.RawURLEncoding.DecodeString(reality.PrivateKey); err != nil || len(raw) != 32 {
+			problems.addf("%s.reality.private_key must be a base64url encoded x25519 key", key)
+		}
+	}
+	for i, id := range reality.ShortIDs {
+		if !shortIDPattern.MatchString(id) {
+			problems.addf("%s.reality.short_ids[%d] %q must be up to 16 hex characters of even length", key, i, id)
+		}
+	}
+}
+
+// validatePort 检查端口范围
+func validatePort(problems *ValidationError, key string, port int) {
+	if port < 1 || port > 65535 {
+		problems.addf("%s %d must be between 1 and 65535", key, port)
+	}
+}
+
+// validateGeoDataURLs 验证geo数据文件的下载地址，启用更新时至少需要一个
+func validateGeoDataURLs(problems *ValidationError, key string, urls []string, required bool) {
+	if required && len(urls) == 0 {
+		problems.addf("%s requires at least one URL when v2ray.geodata.interval is set", key)
+	}
+	for i, u := range urls {
+		validateURL(problems, fmt.Sprintf("%s[%d]", key, i), u, "http", "https")
+	}
+}
+
+// validateURL 验证URL格式及协议
+func validateURL(problems *ValidationError, key string, value string, schemes ...string) {
+	if value == "" {
+		problems.addf("%s is required", key)
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		problems.addf("%s %q is not a valid URL", key, value)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	problems.addf("%s %q must use one of %s", key, value, strings.Join(schemes, ", "))
+}
+
+// validateUUID 检查UUID格式
+func validateUUID(problems *ValidationError, key string, uuid string) {
+	if uuid == "" {
+		problems.addf("%s is required", key)
+	} else if !uuidPattern.MatchString(uuid) {
+		problems.addf("%s is not a valid UUID", key) // 不输出UUID本身，错误会写入日志和API响应
+	}
+}
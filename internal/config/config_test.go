@@ -0,0 +1,195 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// writeConfig 在临时目录中写入配置文件，返回文件路径
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// envMap 返回从map查询环境变量的函数，代替 os.LookupEnv
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoaderLayers(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "version: 2\nv2ray:\n  uuid: "+testUUID+"\n  port: 20000\n")
+	tests := []struct {
+		name      string
+		env       map[string]string
+		overrides []string
+		port      int
+		source    string
+	}{
+		{name: "file", port: 20000, source: SourceFile},
+		{name: "env", env: map[string]string{"AW_V2RAY_PORT": "20001"}, port: 20001, source: SourceEnv},
+		{name: "flag", env: map[string]string{"AW_V2RAY_PORT": "20001"}, overrides: []string{"v2ray.port=20002"}, port: 20002, source: SourceFlag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			loader := NewLoader(path, WithLookupEnv(envMap(tt.env)), WithOverrides(tt.overrides))
+			cfg, sources, err := loader.Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.V2Ray.Port != tt.port {
+				t.Errorf("v2ray.port = %d, want %d", cfg.V2Ray.Port, tt.port)
+			}
+			if sources["v2ray.port"] != tt.source {
+				t.Errorf("source of v2ray.port = %q, want %q", sources["v2ray.port"], tt.source)
+			}
+			if sources["api.port"] != SourceDefault {
+				t.Errorf("source of api.port = %q, want %q", sources["api.port"], SourceDefault)
+			}
+		})
+	}
+}
+
+func TestLoaderAggregatesProblems(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "version: 2\nv2ray:\n  uuid: not-a-uuid\n  port: 70000\n")
+	_, _, err := NewLoader(path, WithLookupEnv(envMap(nil))).Load()
+	if err == nil {
+		t.Fatal("Load() succeeded, want validation error")
+	}
+	for _, want := range []string{"v2ray.uuid is not a valid UUID", "v2ray.port"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "not-a-uuid") {
+		t.Errorf("error %q contains the UUID value", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	oldCfg := DefaultConfig()
+	newCfg := DefaultConfig()
+	newCfg.V2Ray.Port = 20000
+	newCfg.Log.Level = "debug"
+	newCfg.V2Ray.Clients = []ClientConfig{{Email: "alice@example.com", UUID: testUUID}}
+
+	changed := Diff(&oldCfg, &newCfg)
+	want := []string{"log.level", "v2ray.clients", "v2ray.port"}
+	if strings.Join(changed, ",") != strings.Join(want, ",") {
+		t.Errorf("Diff() = %v, want %v", changed, want)
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "version: 2\nv2ray:\n  uuid: "+testUUID+"\n")
+	_, _, err := NewLoader(path, WithLookupEnv(envMap(map[string]string{"AW_API_TOKEN": "short"}))).Load()
+	if err == nil || !strings.Contains(err.Error(), "api.token must be at least") {
+		t.Fatalf("Load() error = %v, want api.token length error", err)
+	}
+
+	token := "0123456789abcdef0123456789abcdef"
+	cfg, _, err := NewLoader(path, WithLookupEnv(envMap(map[string]string{"AW_API_TOKEN": token}))).Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.API.Token != token {
+		t.Errorf("api.token = %q, want value from AW_API_TOKEN", cfg.API.Token)
+	}
+	if redacted := Redact(cfg).API.Token; strings.Contains(redacted, token[4:]) {
+		t.Errorf("Redact() api.token = %q, want redacted", redacted)
+	}
+}
+
+func TestValidateStatsAPIAndDefaultEmail(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "version: 2\nv2ray:\n  uuid: "+testUUID+"\n  stats_api: localhost:10085\n  clients:\n    - email: default\n      uuid: "+testUUID+"\n")
+	_, _, err := NewLoader(path, WithLookupEnv(envMap(nil))).Load()
+	if err == nil {
+		t.Fatal("Load() succeeded, want validation error")
+	}
+	for _, want := range []string{"v2ray.stats_api", `email "default" is reserved`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestValidatePortRangesAgainstLocalPorts(t *testing.T) {
+	t.Parallel()
+
+	base := "version: 2\nv2ray:\n  uuid: " + testUUID + "\n" +
+		"  inbounds:\n" +
+		"    - tag: vmess\n      protocol: vmess\n      port: 443\n      port_range: 10080-10090\n" +
+		"      port_rotation:\n        range: 21000-22000\n" +
+		"  outbounds:\n    - tag: upstream\n      protocol: socks\n      address: 192.0.2.1\n      port: 1080\n"
+	tests := []struct {
+		name  string
+		extra string
+		want  []string
+		clean []string
+	}{
+		{
+			name: "v2ray",
+			want: []string{
+				"v2ray.inbounds[0].port_rotation.range includes api.port 21994",
+				"v2ray.inbounds[0].port_range includes v2ray.stats_api 10085",
+			},
+			clean: []string{"v2ray.decoy.listen", "v2ray.clash_api", "v2ray.health_check.port"},
+		},
+		{
+			name:  "decoy and health check",
+			extra: "  decoy:\n    enabled: true\n    listen: 127.0.0.1:21500\n  health_check:\n    port: 10089\n",
+			want: []string{
+				"v2ray.inbounds[0].port_rotation.range includes v2ray.decoy.listen 21500",
+				"v2ray.inbounds[0].port_range includes v2ray.health_check.port 10089",
+			},
+		},
+		{
+			name:  "sing-box",
+			extra: "backend: sing-box\n",
+			want:  []string{"includes api.port 21994"},
+			clean: []string{"v2ray.stats_api", "v2ray.health_check.port"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, _, err := NewLoader(writeConfig(t, base+tt.extra), WithLookupEnv(envMap(nil))).Load()
+			if err == nil {
+				t.Fatal("Load() succeeded, want port conflicts")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+			for _, key := range tt.clean {
+				if strings.Contains(err.Error(), key) {
+					t.Errorf("error %q mentions %s", err, key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"net"
+	"strconv"
+)
+
+// DecoyConfig 伪装站点，VLESS/Trojan的TLS入站收到的非代理流量回落到该站点
+// root 和 proxy 都为空时使用内置的默认页面。
+type DecoyConfig struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Listen  string `yaml:"listen" json:"listen"` // 本地监听地址，即入站回落的目标
+	Root    string `yaml:"root" json:"root"`     // 静态站点目录
+	Proxy   string `yaml:"proxy" json:"proxy"`   // 反向代理的目标站点，如 https://example.com
+	Title   string `yaml:"title" json:"title"`   // 默认页面的标题
+}
+
+// AcceptsFallback 入站是否支持将非代理流量回落到伪装站点
+func (i InboundConfig) AcceptsFallback() bool {
+	return (i.Protocol == "vless" || i.Protocol == "trojan") && i.Security == "tls"
+}
+
+// validateDecoy 验证伪装站点设置，站点目录是否存在在启动时检查
+func validateDecoy(problems *ValidationError, cfg V2RayConfig) {
+	decoy := cfg.Decoy
+	if decoy.Listen != "" || decoy.Enabled {
+		host, port, err := net.SplitHostPort(decoy.Listen)
+		if n, convErr := strconv.Atoi(port); err != nil || convErr != nil || n < 1 || n > 65535 {
+			problems.addf("v2ray.decoy.listen %q must be host:port", decoy.Listen)
+		} else if host != "" && net.ParseIP(host) == nil {
+			problems.addf("v2ray.decoy.listen %q must use an IP address", decoy.Listen)
+		} else {
+			for _, inbound := range cfg.EffectiveInbounds() {
+				if inbound.Port == n {
+					problems.addf("v2ray.decoy.listen port %d is used by inbound %s", n, inbound.Tag)
+				}
+			}
+		}
+	}
+	if decoy.Root != "" && decoy.Proxy != "" {
+		problems.addf("v2ray.decoy.root and v2ray.decoy.proxy are mutually exclusive")
+	}
+	if decoy.Proxy != "" {
+		validateURL(problems, "v2ray.decoy.proxy", decoy.Proxy, "http", "https")
+	}
+	if !decoy.Enabled {
+		return
+	}
+	for _, inbound := range cfg.EffectiveInbounds() {
+		if inbound.AcceptsFallback() {
+			return
+		}
+	}
+	problems.addf("v2ray.decoy.enabled requires a vless or trojan inbound with security tls")
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// DNSConfig 代理核心内置DNS设置，未配置 servers 时解析目标域名使用系统DNS
+type DNSConfig struct {
+	Servers []DNSServer `yaml:"servers,omitempty" json:"servers,omitempty"`
+	Hosts   []DNSHost   `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	// QueryStrategy 查询的地址类型：UseIP, UseIPv4, UseIPv6，为空时为 UseIP
+	QueryStrategy string `yaml:"query_strategy" json:"query_strategy"`
+	// DomainStrategy 路由的域名策略：AsIs, IPIfNonMatch, IPOnDemand，为空时按路由规则自动选择
+	DomainStrategy string `yaml:"domain_strategy" json:"domain_strategy"`
+}
+
+// DNSServer DNS服务器，domains 不为空时只用于解析匹配的域名
+// address 为 8.8.8.8、8.8.8.8:53、tcp://、tls://（DoT）、https://（DoH）或 localhost。
+type DNSServer struct {
+	Address string   `yaml:"address" json:"address"`
+	Domains []string `yaml:"domains,omitempty" json:"domains,omitempty"` // 写法与路由规则的 domain 一致
+}
+
+// DNSHost 静态解析，domain 的写法与路由规则的 domain 一致
+type DNSHost struct {
+	Domain string   `yaml:"domain" json:"domain"`
+	IP     []string `yaml:"ip" json:"ip"`
+}
+
+// dnsQueryStrategies 支持的查询策略
+var dnsQueryStrategies = map[string]bool{"": true, "UseIP": true, "UseIPv4": true, "UseIPv6": true}
+
+// routingDomainStrategies 支持的路由域名策略
+var routingDomainStrategies = map[string]bool{"": true, "AsIs": true, "IPIfNonMatch": true, "IPOnDemand": true}
+
+// dnsSchemes 支持的DNS服务器地址协议，核心相关的限制由 WithValidator 设置的校验负责
+var dnsSchemes = map[string]bool{"tcp": true, "tls": true, "https": true}
+
+// validateDNS 验证DNS设置
+func validateDNS(problems *ValidationError, dns DNSConfig) {
+	for i, server := range dns.Servers {
+		key := fmt.Sprintf("v2ray.dns.servers[%d]", i)
+		if err := validateDNSAddress(server.Address); err != nil {
+			problems.addf("%s.address: %v", key, err)
+		}
+		for j, domain := range server.Domains {
+			if err := validateDomainMatcher(domain); err != nil {
+				problems.addf("%s.domains[%d]: %v", key, j, err)
+			}
+		}
+	}
+	for i, host := range dns.Hosts {
+		key := fmt.Sprintf("v2ray.dns.hosts[%d]", i)
+		if err := validateDomainMatcher(host.Domain); err != nil {
+			problems.addf("%s.domain: %v", key, err)
+		}
+		if len(host.IP) == 0 {
+			problems.addf("%s.ip is required", key)
+		}
+		for j, ip := range host.IP {
+			if net.ParseIP(ip) == nil {
+				problems.addf("%s.ip[%d] %q is not a valid IP address", key, j, ip)
+			}
+		}
+	}
+	if !dnsQueryStrategies[dns.QueryStrategy] {
+		problems.addf("v2ray.dns.query_strategy %q must be one of UseIP, UseIPv4, UseIPv6", dns.QueryStrategy)
+	}
+	if !routingDomainStrategies[dns.DomainStrategy] {
+		problems.addf("v2ray.dns.domain_strategy %q must be one of AsIs, IPIfNonMatch, IPOnDemand", dns.DomainStrategy)
+	}
+}
+
+// validateDNSAddress 验证DNS服务器地址
+func validateDNSAddress(address string) error {
+	if address == "" {
+		return errors.New("address is required")
+	}
+	if address == "localhost" {
+		return nil
+	}
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+	if host, port, err := net.SplitHostPort(address); err == nil && net.ParseIP(host) != nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port in %q", address)
+		}
+		return nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%q must be an IP, localhost or a tcp://, tls:// or https:// URL", address)
+	}
+	if !dnsSchemes[u.Scheme] {
+		return fmt.Errorf("%q must use one of tcp, tls, https", address)
+	}
+	if u.Scheme != "https" && u.Path != "" {
+		return fmt.Errorf("%q must not have a path", address)
+	}
+	return nil
+}
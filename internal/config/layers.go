@@ -0,0 +1,207 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置来源，按优先级从低到高排列，后面的层覆盖前面的层
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// envPrefix 环境变量覆盖配置项时使用的前缀
+const envPrefix = "AW_"
+
+// EnvName 返回配置项对应的环境变量名，如 v2ray.port -> AW_V2RAY_PORT
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Keys 按结构体定义顺序返回所有配置项
+func Keys() []string {
+	var keys []string
+	collectKeys("", reflect.TypeOf(Config{}), &keys)
+	return keys
+}
+
+// collectKeys 递归收集结构体字段对应的配置项
+func collectKeys(prefix string, t reflect.Type, keys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := yamlName(field)
+		if name == "" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			collectKeys(name, field.Type, keys)
+			continue
+		}
+		*keys = append(*keys, name)
+	}
+}
+
+// resolve 依次合并 默认值 -> 配置文件 -> AW_*环境变量 -> --set参数，返回生效配置及每个配置项的来源
+// strict 为true时拒绝配置文件中的未知配置项。
+func (l *Loader) resolve(data []byte, strict bool) (*Config, map[string]string, error) {
+	doc, err := parseDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	root := doc.Content[0]
+
+	// 记录每个配置项的来源
+	sources := make(map[string]string)
+	for _, key := range Keys() {
+		sources[key] = SourceDefault
+	}
+	for _, key := range leafKeys("", root) {
+		if _, ok := sources[key]; ok {
+			sources[key] = SourceFile
+		}
+	}
+
+	// 环境变量覆盖
+	for _, key := range Keys() {
+		value, ok := l.lookupEnv(EnvName(key))
+		if !ok {
+			continue
+		}
+		if err := setValue(root, key, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid value for %s: %w", EnvName(key), err)
+		}
+		sources[key] = SourceEnv
+	}
+
+	// 命令行 --set 覆盖
+	for _, override := range l.overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid --set %q, expected key=value", override)
+		}
+		key = strings.TrimSpace(key)
+		if _, known := sources[key]; !known {
+			return nil, nil, fmt.Errorf("invalid --set %q: unknown config key %q", override, key)
+		}
+		if err := setValue(root, key, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid value for --set %s: %w", key, err)
+		}
+		sources[key] = SourceFlag
+	}
+
+	cfg, err := decodeDocument(doc, strict, l.validate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, sources, nil
+}
+
+// leafKeys 返回映射节点中所有叶子配置项的点分路径
+func leafKeys(prefix string, mapping *yaml.Node) []string {
+	var keys []string
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i].Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		value := mapping.Content[i+1]
+		if value.Kind == yaml.MappingNode {
+			keys = append(keys, leafKeys(key, value)...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// setValue 将字符串值按YAML解析后写入文档中点分路径对应的位置
+// 列表类配置项（如 v2ray.clients）可以使用JSON数组表示。
+func setValue(root *yaml.Node, key string, value string) error {
+	var valueDoc yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &valueDoc); err != nil {
+		return err
+	}
+	valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: ""}
+	if len(valueDoc.Content) > 0 {
+		valueNode = valueDoc.Content[0]
+	}
+
+	// 由点分路径构造嵌套映射后合并到文档
+	parts := strings.Split(key, ".")
+	patch := valueNode
+	for i := len(parts) - 1; i >= 0; i-- {
+		patch = &yaml.Node{
+			Kind:    yaml.MappingNode,
+			Tag:     "!!map",
+			Content: []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: parts[i]}, patch},
+		}
+	}
+	mergeNode(root, patch)
+	return nil
+}
+
+// Show 输出生效配置，withSources 为true时同时输出每个配置项的来源
+// 敏感字段已脱敏。
+func (l *Loader) Show(w io.Writer, withSources bool) error {
+	cfg, sources, err := l.Load()
+	if err != nil {
+		return err
+	}
+	redacted := Redact(cfg)
+
+	if !withSources {
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(redacted)
+	}
+
+	values := flatten(redacted)
+	for _, key := range Keys() {
+		source := sources[key]
+		switch source {
+		case SourceFile:
+			source = fmt.Sprintf("%s (%s)", source, l.path)
+		case SourceEnv:
+			source = fmt.Sprintf("%s (%s)", source, EnvName(key))
+		case SourceFlag:
+			source = fmt.Sprintf("%s (--set %s)", source, key)
+		}
+		fmt.Fprintf(w, "%-26s %-40s %s\n", key, formatValue(values[key]), source)
+	}
+	return nil
+}
+
+// formatValue 将配置值格式化为单行文本
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "[]"
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	if reflect.ValueOf(value).Kind() == reflect.Slice {
+		data, err := json.Marshal(value)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(value)
+}
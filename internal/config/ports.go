@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// 主机防火墙类型
+const (
+	FirewallAuto     = "auto"
+	FirewallNftables = "nftables"
+	FirewallIPTables = "iptables"
+	FirewallNone     = "none"
+)
+
+// PortRotationConfig 入站监听端口轮换，新端口从 range 中随机选择
+// 轮换后的端口保存在状态文件中，覆盖入站的 port；旧端口在 overlap 内重定向到新端口。
+type PortRotationConfig struct {
+	Range    string   `yaml:"range,omitempty" json:"range,omitempty"`       // 可选端口范围，如 30000-40000，为空时不轮换
+	Interval Duration `yaml:"interval,omitempty" json:"interval,omitempty"` // 定期轮换的间隔，0 表示只通过API轮换
+	Overlap  Duration `yaml:"overlap,omitempty" json:"overlap,omitempty"`   // 旧端口继续可用的时间，0 表示立即关闭
+}
+
+// FirewallConfig 端口跳跃和端口轮换使用的防火墙
+type FirewallConfig struct {
+	// Backend 重定向规则使用的主机防火墙：auto, nftables, iptables, none
+	Backend string `yaml:"backend" json:"backend"`
+	// SecurityGroup 同步放行入站端口的EC2安全组ID，为空时不修改安全组
+	SecurityGroup string `yaml:"security_group" json:"security_group"`
+}
+
+// firewallBackends 支持的主机防火墙
+var firewallBackends = map[string]bool{FirewallAuto: true, FirewallNftables: true, FirewallIPTables: true, FirewallNone: true}
+
+// securityGroupPattern EC2安全组ID格式
+var securityGroupPattern = regexp.MustCompile(`^sg-[0-9a-f]{8,17}$`)
+
+// ParsePortRange 解析 from-to 形式的单个端口范围，范围至少包含两个端口
+func ParsePortRange(value string) (PortRange, error) {
+	ranges, err := ParsePorts(value)
+	if err != nil {
+		return PortRange{}, err
+	}
+	if len(ranges) != 1 || ranges[0].From == ranges[0].To {
+		return PortRange{}, fmt.Errorf("port range %q must be from-to", value)
+	}
+	return ranges[0], nil
+}
+
+// Contains 端口是否在范围内
+func (r PortRange) Contains(port int) bool {
+	return port >= r.From && port <= r.To
+}
+
+// Overlaps 两个范围是否有重叠
+func (r PortRange) Overlaps(other PortRange) bool {
+	return r.From <= other.To && other.From <= r.To
+}
+
+// String 返回 from-to 形式
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
+
+// validatePorts 验证端口跳跃、端口轮换和防火墙设置
+// 跳跃范围内的端口都会被重定向，因此不能包含其他入站的端口，也不能与轮换范围重叠；
+// 两种范围都不能包含Agent和核心在本机监听的端口（API、伪装站点、健康检查、统计API）。
+func validatePorts(problems *ValidationError, root *Config) {
+	cfg := root.V2Ray
+	if !firewallBackends[cfg.Firewall.Backend] {
+		problems.addf("v2ray.firewall.backend %q must be one of auto, nftables, iptables, none", cfg.Firewall.Backend)
+	}
+	if group := cfg.Firewall.SecurityGroup; group != "" && !securityGroupPattern.MatchString(group) {
+		problems.addf("v2ray.firewall.security_group %q must be a security group ID like sg-0123456789abcdef0", group)
+	}
+
+	type namedRange struct {
+		key string
+		PortRange
+	}
+	var hopping, rotation []namedRange
+	for i, inbound := range cfg.Inbounds {
+		key := fmt.Sprintf("v2ray.inbounds[%d]", i)
+		if inbound.PortRange != "" {
+			r, err := ParsePortRange(inbound.PortRange)
+			if err != nil {
+				problems.addf("%s.port_range: %v", key, err)
+			} else {
+				hopping = append(hopping, namedRange{key + ".port_range", r})
+			}
+		}
+
+		rotate := inbound.PortRotation
+		if rotate.Interval < 0 {
+			problems.addf("%s.port_rotation.interval must not be negative", key)
+		}
+		if rotate.Overlap < 0 {
+			problems.addf("%s.port_rotation.overlap must not be negative", key)
+		}
+		if rotate.Range == "" {
+			if rotate.Interval > 0 {
+				problems.addf("%s.port_rotation.range is required with port_rotation.interval", key)
+			}
+			continue
+		}
+		r, err := ParsePortRange(rotate.Range)
+		if err != nil {
+			problems.addf("%s.port_rotation.range: %v", key, err)
+		} else {
+			rotation = append(rotation, namedRange{key + ".port_rotation.range", r})
+		}
+	}
+
+	for i, h := range hopping {
+		for _, inbound := range cfg.Inbounds {
+			if h.Contains(inbound.Port) {
+				problems.addf("%s includes port %d of inbound %s", h.key, inbound.Port, inbound.Tag)
+			}
+		}
+		for _, other := range hopping[i+1:] {
+			if h.Overlaps(other.PortRange) {
+				problems.addf("%s overlaps %s", h.key, other.key)
+			}
+		}
+		for _, r := range rotation {
+			if h.Overlaps(r.PortRange) {
+				problems.addf("%s overlaps %s", h.key, r.key)
+			}
+		}
+	}
+
+	local := localPorts(root)
+	for _, r := range append(hopping, rotation...) {
+		for _, l := range local {
+			if !r.Overlaps(l.PortRange) {
+				continue
+			}
+			if l.From == l.To {
+				problems.addf("%s includes %s %d", r.key, l.key, l.From)
+			} else {
+				problems.addf("%s overlaps %s range %s", r.key, l.key, l.PortRange)
+			}
+		}
+	}
+}
+
+// localPort 本机服务监听的端口范围，key 为对应的配置项
+type localPort struct {
+	key string
+	PortRange
+}
+
+// localPorts 返回Agent和所选核心在本机监听的端口，格式错误的地址由各自的校验报告
+func localPorts(cfg *Config) []localPort {
+	ports := []localPort{{"api.port", PortRange{From: cfg.API.Port, To: cfg.API.Port}}}
+	addAddress := func(key string, address string) {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return
+		}
+		if n, err := strconv.Atoi(port); err == nil {
+			ports = append(ports, localPort{key, PortRange{From: n, To: n}})
+		}
+	}
+	if cfg.V2Ray.Decoy.Enabled {
+		addAddress("v2ray.decoy.listen", cfg.V2Ray.Decoy.Listen)
+	}
+	if cfg.Backend == "sing-box" {
+		addAddress("v2ray.clash_api", cfg.V2Ray.ClashAPI)
+		return ports
+	}
+
+	// V2Ray/Xray 为每个上游出站在 health_check.port 起连续的端口上监听SOCKS入站
+	addAddress("v2ray.stats_api", cfg.V2Ray.StatsAPI)
+	upstreams := 0
+	for _, outbound := range cfg.V2Ray.Outbounds {
+		if outbound.IsUpstream() {
+			upstreams++
+		}
+	}
+	if upstreams > 0 {
+		check := cfg.V2Ray.HealthCheck.Port
+		ports = append(ports, localPort{"v2ray.health_check.port", PortRange{From: check, To: check + upstreams - 1}})
+	}
+	return ports
+}
@@ -0,0 +1,343 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 内置出站
+const (
+	OutboundDirect = "direct" // 直接连接，未匹配任何规则时使用
+	OutboundBlock  = "block"  // 丢弃连接
+)
+
+// OutboundConfig 额外的出站，tag 供路由规则和用户的 outbound 引用
+// socks、http、vmess、vless 经 address:port 上的上游服务器转发，wireguard 的对端为 address:port。
+type OutboundConfig struct {
+	Tag        string          `yaml:"tag" json:"tag"`
+	Protocol   string          `yaml:"protocol" json:"protocol"` // freedom, blackhole, socks, http, vmess, vless, wireguard
+	Address    string          `yaml:"address,omitempty" json:"address,omitempty"`
+	Port       int             `yaml:"port,omitempty" json:"port,omitempty"`
+	Username   string          `yaml:"username,omitempty" json:"username,omitempty"`       // socks、http认证
+	Password   string          `yaml:"password,omitempty" json:"password,omitempty"`       // socks、http认证
+	UUID       string          `yaml:"uuid,omitempty" json:"uuid,omitempty"`               // vmess、vless
+	Flow       string          `yaml:"flow,omitempty" json:"flow,omitempty"`               // vless流控，需要 security tls
+	Security   string          `yaml:"security,omitempty" json:"security,omitempty"`       // none, tls（http、vmess、vless）
+	ServerName string          `yaml:"server_name,omitempty" json:"server_name,omitempty"` // TLS的SNI，默认为 address
+	WireGuard  WireGuardConfig `yaml:"wireguard,omitempty" json:"wireguard,omitempty"`
+}
+
+// WireGuardConfig WireGuard出站的密钥和隧道地址，密钥为base64编码
+type WireGuardConfig struct {
+	PrivateKey   string   `yaml:"private_key,omitempty" json:"private_key,omitempty"`       // 本端私钥
+	PublicKey    string   `yaml:"public_key,omitempty" json:"public_key,omitempty"`         // 对端公钥
+	PreSharedKey string   `yaml:"pre_shared_key,omitempty" json:"pre_shared_key,omitempty"` // 可选
+	LocalAddress []string `yaml:"local_address,omitempty" json:"local_address,omitempty"`   // 隧道内本端地址，如 10.0.0.2/32
+	MTU          int      `yaml:"mtu,omitempty" json:"mtu,omitempty"`                       // 默认1420
+}
+
+// HealthCheckConfig 额外出站的健康检查，定期经每个出站请求 url
+// V2Ray/Xray 为每个出站在 127.0.0.1 上开放一个SOCKS端口用于检查，从 port 开始依次分配；
+// sing-box 通过Clash API检查，不占用端口。
+type HealthCheckConfig struct {
+	URL      string   `yaml:"url" json:"url"`
+	Interval Duration `yaml:"interval" json:"interval"`
+	Timeout  Duration `yaml:"timeout" json:"timeout"`
+	Port     int      `yaml:"port" json:"port"`
+}
+
+// RoutingConfig 路由设置
+// 内置的拦截规则在前，rules 按顺序在后，第一条匹配的规则决定出站，都不匹配时使用 direct。
+type RoutingConfig struct {
+	BlockPrivate    bool          `yaml:"block_private" json:"block_private"`       // 拦截私有和保留地址
+	BlockPorts      []int         `yaml:"block_ports" json:"block_ports"`           // 拦截的目标端口，默认拦截SMTP的25端口
+	BlockBitTorrent bool          `yaml:"block_bittorrent" json:"block_bittorrent"` // 按流量探测拦截BitTorrent
+	Rules           []RoutingRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+// RoutingRule 路由规则，同一规则中的多个条件需同时满足，同一条件中的多个值满足其一即可
+// domain 与V2Ray/Xray的写法一致：domain:、full:、keyword:、regexp:、geosite:，无前缀时为关键字匹配。
+// ip 为IP、CIDR或 geoip:，port 如 "25,465,1000-2000"，protocol 为流量探测到的协议，user 为 clients 中的email。
+type RoutingRule struct {
+	Domain   []string `yaml:"domain,omitempty" json:"domain,omitempty"`
+	IP       []string `yaml:"ip,omitempty" json:"ip,omitempty"`
+	Port     string   `yaml:"port,omitempty" json:"port,omitempty"`
+	Protocol []string `yaml:"protocol,omitempty" json:"protocol,omitempty"` // http, tls, quic, bittorrent
+	User     []string `yaml:"user,omitempty" json:"user,omitempty"`
+	Outbound string   `yaml:"outbound" json:"outbound"`
+}
+
+// PortRange 端口范围，单个端口时 From 与 To 相同
+type PortRange struct {
+	From int
+	To   int
+}
+
+// outboundProtocols 支持的额外出站协议，值表示是否经上游服务器转发
+var outboundProtocols = map[string]bool{
+	"freedom":   false,
+	"blackhole": false,
+	"socks":     true,
+	"http":      true,
+	"vmess":     true,
+	"vless":     true,
+	"wireguard": true,
+}
+
+// outboundTLSProtocols 可以使用 security tls 的上游出站协议
+var outboundTLSProtocols = map[string]bool{"http": true, "vmess": true, "vless": true}
+
+// routingProtocols 路由规则支持的探测协议
+var routingProtocols = map[string]bool{"http": true, "tls": true, "quic": true, "bittorrent": true}
+
+// geoNamePattern geoip/geosite 的分类名，如 cn、private、category-ads-all
+var geoNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// ParsePorts 解析 "25,465,1000-2000" 形式的端口列表
+func ParsePorts(value string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(to); err != nil {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+		ranges = append(ranges, PortRange{From: start, To: end})
+	}
+	return ranges, nil
+}
+
+// IsUpstream 出站是否经上游服务器转发，只有这类出站需要健康检查
+func (o OutboundConfig) IsUpstream() bool {
+	return outboundProtocols[o.Protocol]
+}
+
+// validateRouting 验证额外出站、路由规则和用户的出站映射，引用的出站和用户必须存在
+func validateRouting(problems *ValidationError, cfg V2RayConfig) {
+	tags := map[string]bool{OutboundDirect: true, OutboundBlock: true}
+	for i, outbound := range cfg.Outbounds {
+		key := fmt.Sprintf("v2ray.outbounds[%d]", i)
+		if outbound.Tag == "" {
+			problems.addf("%s.tag is required", key)
+		} else if tags[outbound.Tag] {
+			problems.addf("%s.tag %q is duplicated or reserved", key, outbound.Tag)
+		}
+		tags[outbound.Tag] = true
+		if _, ok := outboundProtocols[outbound.Protocol]; !ok {
+			problems.addf("%s.protocol %q must be one of freedom, blackhole, socks, http, vmess, vless, wireguard", key, outbound.Protocol)
+			continue
+		}
+		if outbound.IsUpstream() {
+			validateUpstream(problems, key, outbound)
+		}
+	}
+
+	emails := make(map[string]bool)
+	for i, client := range cfg.Clients {
+		emails[client.Email] = true
+		if client.Outbound != "" && !tags[client.Outbound] {
+			problems.addf("v2ray.clients[%d].outbound %q is not direct, block or a tag in v2ray.outbounds", i, client.Outbound)
+		}
+	}
+
+	routing := cfg.Routing
+	for i, port := range routing.BlockPorts {
+		validatePort(problems, fmt.Sprintf("v2ray.routing.block_ports[%d]", i), port)
+	}
+
+	for i, rule := range routing.Rules {
+		key := fmt.Sprintf("v2ray.routing.rules[%d]", i)
+		if len(rule.Domain) == 0 && len(rule.IP) == 0 && rule.Port == "" && len(rule.Protocol) == 0 && len(rule.User) == 0 {
+			problems.addf("%s must have at least one of domain, ip, port, protocol, user", key)
+		}
+		for j, domain := range rule.Domain {
+			if err := validateDomainMatcher(domain); err != nil {
+				problems.addf("%s.domain[%d]: %v", key, j, err)
+			}
+		}
+		for j, ip := range rule.IP {
+			if err := validateIPMatcher(ip); err != nil {
+				problems.addf("%s.ip[%d]: %v", key, j, err)
+			}
+		}
+		if rule.Port != "" {
+			if _, err := ParsePorts(rule.Port); err != nil {
+				problems.addf("%s.port: %v", key, err)
+			}
+		}
+		for j, protocol := range rule.Protocol {
+			if !routingProtocols[protocol] {
+				problems.addf("%s.protocol[%d] %q must be one of http, tls, quic, bittorrent", key, j, protocol)
+			}
+		}
+		for j, user := range rule.User {
+			if !emails[user] {
+				problems.addf("%s.user[%d] %q is not an email in v2ray.clients", key, j, user)
+			}
+		}
+		if rule.Outbound == "" {
+			problems.addf("%s.outbound is required", key)
+		} else if !tags[rule.Outbound] {
+			problems.addf("%s.outbound %q is not direct, block or a tag in v2ray.outbounds", key, rule.Outbound)
+		}
+	}
+
+	validateHealthCheck(problems, cfg)
+}
+
+// validateUpstream 验证经上游服务器转发的出站
+func validateUpstream(problems *ValidationError, key string, outbound OutboundConfig) {
+	if outbound.Address == "" {
+		problems.addf("%s.address is required for %s", key, outbound.Protocol)
+	} else if strings.ContainsAny(outbound.Address, "/@?# ") ||
+		(strings.Contains(outbound.Address, ":") && net.ParseIP(outbound.Address) == nil) {
+		problems.addf("%s.address %q must be a host name or IP address without port", key, outbound.Address)
+	}
+	validatePort(problems, key+".port", outbound.Port)
+
+	switch outbound.Protocol {
+	case "vmess", "vless":
+		validateUUID(problems, key+".uuid", outbound.UUID)
+	case "wireguard":
+		validateWireGuard(problems, key+".wireguard", outbound.WireGuard)
+	}
+
+	switch outbound.Security {
+	case "", "none":
+	case "tls":
+		if !outboundTLSProtocols[outbound.Protocol] {
+			problems.addf("%s.security tls is only supported by http, vmess and vless", key)
+		}
+	default:
+		problems.addf("%s.security %q must be none or tls", key, outbound.Security)
+	}
+	if !vlessFlows[outbound.Flow] {
+		problems.addf("%s.flow %q must be xtls-rprx-vision", key, outbound.Flow)
+	} else if outbound.Flow != "" && (outbound.Protocol != "vless" || outbound.Security != "tls") {
+		problems.addf("%s.flow is only supported by vless with security tls", key)
+	}
+}
+
+// validateWireGuard 验证WireGuard出站的密钥和隧道地址
+func validateWireGuard(problems *ValidationError, key string, wg WireGuardConfig) {
+	validateKey := func(name string, value string, required bool) {
+		if value == "" {
+			if required {
+				problems.addf("%s.%s is required", key, name)
+			}
+			return
+		}
+		if raw, err := base64.StdEncoding.DecodeString(value); err != nil || len(raw) != 32 {
+			problems.addf("%s.%s must be a base64 encoded 32 byte key", key, name)
+		}
+	}
+	validateKey("private_key", wg.PrivateKey, true)
+	validateKey("public_key", wg.PublicKey, true)
+	validateKey("pre_shared_key", wg.PreSharedKey, false)
+
+	if len(wg.LocalAddress) == 0 {
+		problems.addf("%s.local_address is required", key)
+	}
+	for i, address := range wg.LocalAddress {
+		if net.ParseIP(address) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			problems.addf("%s.local_address[%d] %q is not an IP or CIDR", key, i, address)
+		}
+	}
+	if wg.MTU != 0 && (wg.MTU < 1280 || wg.MTU > 65535) {
+		problems.addf("%s.mtu %d must be between 1280 and 65535", key, wg.MTU)
+	}
+}
+
+// validateHealthCheck 验证出站健康检查，V2Ray/Xray的检查端口不能与入站端口冲突
+func validateHealthCheck(problems *ValidationError, cfg V2RayConfig) {
+	check := cfg.HealthCheck
+	validateURL(problems, "v2ray.health_check.url", check.URL, "http", "https")
+	if check.Interval <= 0 {
+		problems.addf("v2ray.health_check.interval must be positive")
+	}
+	if check.Timeout <= 0 {
+		problems.addf("v2ray.health_check.timeout must be positive")
+	}
+	validatePort(problems, "v2ray.health_check.port", check.Port)
+
+	upstreams := 0
+	for _, outbound := range cfg.Outbounds {
+		if outbound.IsUpstream() {
+			upstreams++
+		}
+	}
+	if upstreams == 0 {
+		return
+	}
+	last := check.Port + upstreams - 1
+	if last > 65535 {
+		problems.addf("v2ray.health_check.port %d leaves no room for %d outbounds", check.Port, upstreams)
+	}
+	for _, inbound := range cfg.EffectiveInbounds() {
+		if inbound.Port >= check.Port && inbound.Port <= last {
+			problems.addf("v2ray.health_check.port range %d-%d overlaps inbound %s port %d", check.Port, last, inbound.Tag, inbound.Port)
+		}
+	}
+}
+
+// validateDomainMatcher 验证域名匹配条件
+func validateDomainMatcher(value string) error {
+	kind, pattern, ok := strings.Cut(value, ":")
+	if !ok {
+		kind, pattern = "keyword", value
+	}
+	if pattern == "" {
+		return fmt.Errorf("empty domain matcher %q", value)
+	}
+	switch kind {
+	case "domain", "full", "keyword":
+		if strings.ContainsAny(pattern, " /") {
+			return fmt.Errorf("invalid domain %q", pattern)
+		}
+	case "regexp":
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid regexp %q: %v", pattern, err)
+		}
+	case "geosite":
+		if !geoNamePattern.MatchString(pattern) {
+			return fmt.Errorf("invalid geosite category %q", pattern)
+		}
+	default:
+		return fmt.Errorf("unknown domain matcher %q, use domain:, full:, keyword:, regexp: or geosite:", kind)
+	}
+	return nil
+}
+
+// validateIPMatcher 验证IP匹配条件
+func validateIPMatcher(value string) error {
+	if name, ok := strings.CutPrefix(value, "geoip:"); ok {
+		if !geoNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid geoip category %q", name)
+		}
+		return nil
+	}
+	if net.ParseIP(value) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(value); err != nil {
+		return fmt.Errorf("%q is not an IP, CIDR or geoip: matcher", value)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion 当前配置文件结构版本
+// 版本1：未声明version，checks下的时间间隔为整数秒；
+// 版本2：时间间隔使用Go duration字符串（如 "5m"、"30m"）。
+const CurrentVersion = 2
+
+// Duration 配置中的时间间隔，YAML/JSON中使用Go duration字符串表示
+type Duration time.Duration
+
+// Std 转换为time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// String 返回duration字符串
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// UnmarshalYAML 解析duration字符串
+// 返回 *yaml.TypeError 以便解码器继续处理其余字段并汇总所有错误。
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode || value.Tag == "!!int" {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: duration must be a string such as \"5m\", got %q", value.Line, value.Value)}}
+	}
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: invalid duration %q", value.Line, value.Value)}}
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML 输出duration字符串
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// MarshalJSON 输出duration字符串
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + d.String() + `"`), nil
+}
+
+// DefaultConfig 返回带默认值的配置，配置文件中未出现的配置项使用这些值
+// v2ray.uuid 没有默认值，必须在配置文件中指定。
+func DefaultConfig() Config {
+	return Config{
+		Version: CurrentVersion,
+		Backend: "v2ray",
+		V2Ray: V2RayConfig{
+			Port:           10086,
+			AccessLog:      "/var/log/v2ray/access.log",
+			ClashAPI:       "127.0.0.1:9090",
+			StatsAPI:       "127.0.0.1:10085",
+			ServiceManager: "auto",
+			Version:        "latest",
+			Rotation: RotationConfig{
+				Overlap: Duration(24 * time.Hour),
+			},
+			Routing: RoutingConfig{
+				BlockPrivate: true,
+				BlockPorts:   []int{25},
+			},
+			HealthCheck: HealthCheckConfig{
+				URL:      "https://www.gstatic.com/generate_204",
+				Interval: Duration(5 * time.Minute),
+				Timeout:  Duration(10 * time.Second),
+				Port:     10800,
+			},
+			Firewall: FirewallConfig{
+				Backend: FirewallAuto,
+			},
+			Decoy: DecoyConfig{
+				Listen: "127.0.0.1:8080",
+				Title:  "Welcome",
+			},
+			GeoData: GeoDataConfig{
+				Interval: Duration(24 * time.Hour),
+				GeoIP:    []string{"https://github.com/v2fly/geoip/releases/latest/download/geoip.dat"},
+				Geosite:  []string{"https://github.com/v2fly/domain-list-community/releases/latest/download/dlc.dat"},
+			},
+		},
+		API: APIConfig{
+			Address: "127.0.0.1",
+			Port:    21994,
+		},
+		Checks: ChecksConfig{
+			TrafficInterval: Duration(5 * time.Minute),
+			IdleTimeout:     Duration(30 * time.Minute),
+		},
+		Log: LogConfig{
+			Level:      "info",
+			MaxSize:    100,
+			MaxBackups: 7,
+			MaxAge:     7,
+		},
+	}
+}
+
+// ValidationError 配置校验错误，包含所有发现的问题
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(e.Problems, "; "))
+}
+
+// addf 记录一个校验问题
+func (e *ValidationError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// merge 合并另一个校验结果中的问题，err 不是 *ValidationError 时原样返回
+func (e *ValidationError) merge(err error) error {
+	if err == nil {
+		return nil
+	}
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	e.Problems = append(e.Problems, validationErr.Problems...)
+	return nil
+}
+
+// migrate 将旧版本配置文档迁移到当前版本，返回迁移前的版本号
+func migrate(doc *yaml.Node) (int, error) {
+	root := doc.Content[0]
+
+	version := 1
+	if node := mappingValue(root, "version"); node != nil {
+		if err := node.Decode(&version); err != nil {
+			return 0, fmt.Errorf("invalid config version %q", node.Value)
+		}
+	}
+	if version > CurrentVersion {
+		return version, fmt.Errorf("unsupported config version %d (max %d)", version, CurrentVersion)
+	}
+
+	if version < 2 {
+		migrateV1(root)
+	}
+
+	setMappingValue(root, "version", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(CurrentVersion)})
+	return version, nil
+}
+
+// migrateV1 将版本1中以整数秒表示的时间间隔转换为duration字符串
+func migrateV1(root *yaml.Node) {
+	checks := mappingValue(root, "checks")
+	if checks == nil || checks.Kind != yaml.MappingNode {
+		return
+	}
+	for _, key := range []string{"traffic_interval", "idle_timeout"} {
+		node := mappingValue(checks, key)
+		if node == nil || node.Tag != "!!int" {
+			continue
+		}
+		node.Tag = "!!str"
+		node.Value = node.Value + "s"
+	}
+}
+
+// mappingValue 查找映射节点中指定键的值节点
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue 设置映射节点中指定键的值，键不存在时插入到最前面
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value.LineComment = mapping.Content[i+1].LineComment
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	mapping.Content = append([]*yaml.Node{keyNode, value}, mapping.Content...)
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// encodeDocument 按写回配置文件时的格式序列化文档
+func encodeDocument(t *testing.T, doc *yaml.Node) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		t.Fatal(err)
+	}
+	encoder.Close()
+	return buf.Bytes()
+}
+
+// readTestdata 读取testdata中的文件
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "v1 integer seconds", input: "v1.yaml", want: "v1_migrated.yaml"},
+		{name: "v2 unchanged", input: "v2.yaml", want: "v2.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc, err := parseDocument(readTestdata(t, tt.input))
+			if err != nil {
+				t.Fatalf("parseDocument() error = %v", err)
+			}
+			got := encodeDocument(t, doc)
+			if want := readTestdata(t, tt.want); !bytes.Equal(got, want) {
+				t.Errorf("migrated %s:\n%s\nwant %s:\n%s", tt.input, got, tt.want, want)
+			}
+		})
+	}
+}
+
+func TestLoadV1(t *testing.T) {
+	t.Parallel()
+
+	cfg, _, err := NewLoader(filepath.Join("testdata", "v1.yaml"), WithLookupEnv(envMap(nil))).Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Version != CurrentVersion {
+		t.Errorf("version = %d, want %d", cfg.Version, CurrentVersion)
+	}
+	if cfg.Checks.TrafficInterval.Std() != 5*time.Minute || cfg.Checks.IdleTimeout.Std() != 30*time.Minute {
+		t.Errorf("checks = %v, %v, want 5m0s, 30m0s", cfg.Checks.TrafficInterval, cfg.Checks.IdleTimeout)
+	}
+}
+
+func TestMigrateUnsupportedVersion(t *testing.T) {
+	t.Parallel()
+
+	if _, err := parseDocument([]byte("version: 3\n")); err == nil {
+		t.Error("parseDocument() succeeded for version 3, want error")
+	}
+	if _, err := parseDocument([]byte("version: two\n")); err == nil {
+		t.Error("parseDocument() succeeded for non-integer version, want error")
+	}
+}
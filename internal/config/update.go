@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue 敏感字段脱敏后的占位值
+const redactedValue = "******"
+
+// Redact 返回隐藏敏感字段（UUID、密码、私钥）后的配置副本
+func Redact(cfg *Config) *Config {
+	redacted := *cfg
+	redacted.V2Ray.UUID = redactSecret(cfg.V2Ray.UUID)
+	redacted.V2Ray.Clients = make([]ClientConfig, len(cfg.V2Ray.Clients))
+	for i, client := range cfg.V2Ray.Clients {
+		client.UUID = redactSecret(client.UUID)
+		redacted.V2Ray.Clients[i] = client
+	}
+	redacted.V2Ray.Inbounds = make([]InboundConfig, len(cfg.V2Ray.Inbounds))
+	for i, inbound := range cfg.V2Ray.Inbounds {
+		if inbound.Password != "" {
+			inbound.Password = redactSecret(inbound.Password)
+		}
+		if inbound.Reality.PrivateKey != "" {
+			inbound.Reality.PrivateKey = redactSecret(inbound.Reality.PrivateKey)
+		}
+		redacted.V2Ray.Inbounds[i] = inbound
+	}
+	redacted.V2Ray.Outbounds = make([]OutboundConfig, len(cfg.V2Ray.Outbounds))
+	for i, outbound := range cfg.V2Ray.Outbounds {
+		if outbound.Password != "" {
+			outbound.Password = redactSecret(outbound.Password)
+		}
+		if outbound.UUID != "" {
+			outbound.UUID = redactSecret(outbound.UUID)
+		}
+		if outbound.WireGuard.PrivateKey != "" {
+			outbound.WireGuard.PrivateKey = redactSecret(outbound.WireGuard.PrivateKey)
+		}
+		if outbound.WireGuard.PreSharedKey != "" {
+			outbound.WireGuard.PreSharedKey = redactSecret(outbound.WireGuard.PreSharedKey)
+		}
+		redacted.V2Ray.Outbounds[i] = outbound
+	}
+	redacted.V2Ray.Install.Proxy = redactURL(cfg.V2Ray.Install.Proxy)
+	if cfg.API.Token != "" {
+		redacted.API.Token = redactSecret(cfg.API.Token)
+	}
+	return &redacted
+}
+
+// redactURL 隐藏URL中的密码
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+	return u.String()
+}
+
+// redactSecret 仅保留密钥前4位
+func redactSecret(secret string) string {
+	if len(secret) <= 4 {
+		return redactedValue
+	}
+	return secret[:4] + redactedValue
+}
+
+// Diff 比较两份配置，返回值发生变化的配置项（如 v2ray.port）
+func Diff(oldCfg, newCfg *Config) []string {
+	oldValues := flatten(oldCfg)
+	newValues := flatten(newCfg)
+
+	var changed []string
+	for key, newValue := range newValues {
+		if !reflect.DeepEqual(oldValues[key], newValue) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// flatten 将配置展开为 点分路径 -> 值 的映射，列表作为整体比较
+func flatten(cfg *Config) map[string]interface{} {
+	values := make(map[string]interface{})
+	flattenValue("", reflect.ValueOf(cfg).Elem(), values)
+	return values
+}
+
+// flattenValue 递归展开结构体字段，字段名取自yaml标签
+func flattenValue(prefix string, v reflect.Value, values map[string]interface{}) {
+	// 空列表与未配置视为相同
+	if v.Kind() == reflect.Slice && v.Len() == 0 {
+		values[prefix] = nil
+		return
+	}
+	if v.Kind() != reflect.Struct {
+		values[prefix] = v.Interface()
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := yamlName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		flattenValue(name, v.Field(i), values)
+	}
+}
+
+// yamlName 获取结构体字段的yaml键名
+func yamlName(field reflect.StructField) string {
+	tag := field.Tag.Get("yaml")
+	if tag == "-" {
+		return ""
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// Update 将YAML/JSON格式的补丁合并到配置文件中
+// 合并后的配置经过 validateConfig 校验后才会原子写回文件，文件中的注释尽量保留。
+// 被环境变量或--set参数覆盖的配置项写入文件后不会改变生效值。
+// 返回新配置以及发生变化的配置项。
+func (l *Loader) Update(cur *Config, patch []byte) (*Config, []string, error) {
+	// 解析补丁
+	var patchDoc yaml.Node
+	if err := yaml.Unmarshal(patch, &patchDoc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config patch: %w", err)
+	}
+	if len(patchDoc.Content) == 0 || patchDoc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("config patch must be an object")
+	}
+
+	// 读取现有配置文件，保留注释，旧版本结构一并迁移
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	doc, err := parseDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 合并补丁
+	mergeNode(doc.Content[0], patchDoc.Content[0])
+
+	// 序列化合并后的文档
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	encoder.Close()
+
+	// 解析并校验新配置，拒绝未知配置项；环境变量和--set参数仍然优先
+	newCfg, _, err := l.resolve(buf.Bytes(), true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := Diff(cur, newCfg)
+	if len(changed) == 0 {
+		return newCfg, nil, nil
+	}
+
+	// 原子写回配置文件
+	if err := writeFileAtomic(l.path, buf.Bytes()); err != nil {
+		return nil, nil, err
+	}
+
+	return newCfg, changed, nil
+}
+
+// mergeNode 将补丁映射节点合并到目标映射节点
+func mergeNode(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		resetStyle(value)
+
+		found := false
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value != key.Value {
+				continue
+			}
+			found = true
+			target := dst.Content[j+1]
+			if target.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+				mergeNode(target, value)
+				break
+			}
+			// 替换值节点，保留原有注释
+			value.HeadComment = target.HeadComment
+			value.LineComment = target.LineComment
+			value.FootComment = target.FootComment
+			dst.Content[j+1] = value
+			break
+		}
+
+		if !found {
+			resetStyle(key)
+			dst.Content = append(dst.Content, key, value)
+		}
+	}
+}
+
+// resetStyle 清除JSON补丁带来的flow/引号样式，使写回的文件保持块格式
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，避免写入中途失败损坏配置
+func writeFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp config file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp config file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp config file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to chmod temp config file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce 文件变化后的防抖时间，编辑器保存时通常会触发多个事件
+const watchDebounce = 500 * time.Millisecond
+
+// Watch 监听配置文件变化，文件被修改或替换后调用onChange，直到ctx取消
+// 监听的是配置文件所在目录，以兼容编辑器通过重命名替换文件的保存方式。
+func Watch(ctx context.Context, path string, onChange func()) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path: %w", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(absPath)); err != nil {
+		return fmt.Errorf("failed to watch config directory: %w", err)
+	}
+
+	// 防抖定时器，初始不触发
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != absPath {
+				continue
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+				debounce.Reset(watchDebounce)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("config watcher error: %w", err)
+
+		case <-debounce.C:
+			onChange()
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
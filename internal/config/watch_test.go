@@ -0,0 +1,126 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitChange 等待onChange被调用，超时返回false
+func waitChange(changes <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-changes:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "version: 2\n")
+	dir := filepath.Dir(path)
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan struct{}, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, path, func() { changes <- struct{}{} })
+	}()
+	// 等待监听建立
+	time.Sleep(100 * time.Millisecond)
+
+	// 其他文件的变化不触发重载
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("x: 1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if waitChange(changes, 2*watchDebounce) {
+		t.Error("onChange called for another file")
+	}
+
+	// 连续写入只触发一次
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(path, []byte(strings.Repeat("#\n", i)+"version: 2\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !waitChange(changes, 5*time.Second) {
+		t.Fatal("onChange not called after write")
+	}
+	if waitChange(changes, 2*watchDebounce) {
+		t.Error("onChange called more than once for consecutive writes")
+	}
+
+	// 编辑器通过重命名替换文件
+	tmp := filepath.Join(dir, "config.yaml.swp")
+	if err := os.WriteFile(tmp, []byte("version: 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	if !waitChange(changes, 5*time.Second) {
+		t.Fatal("onChange not called after rename")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch() did not return after cancel")
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, "version: 2\nv2ray:\n  uuid: "+testUUID+"\n")
+	loader := NewLoader(path, WithLookupEnv(envMap(map[string]string{"AW_LOG_LEVEL": "warn"})))
+	cur, _, err := loader.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		changed []string
+		wantErr string
+	}{
+		{name: "unchanged", content: "version: 2\nv2ray:\n  uuid: " + testUUID + "\n"},
+		{name: "changed", content: "version: 2\nv2ray:\n  uuid: " + testUUID + "\n  port: 20000\nchecks:\n  idle_timeout: 1h\n", changed: []string{"checks.idle_timeout", "v2ray.port"}},
+		// 环境变量仍然覆盖配置文件
+		{name: "overridden", content: "version: 2\nv2ray:\n  uuid: " + testUUID + "\nlog:\n  level: debug\n"},
+		{name: "invalid", content: "version: 2\nv2ray:\n  uuid: not-a-uuid\n", wantErr: "v2ray.uuid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 子测试共用配置文件，不并行执行
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, changed, err := loader.Reload(cur)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reload() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Reload() error = %v", err)
+			}
+			if strings.Join(changed, ",") != strings.Join(tt.changed, ",") {
+				t.Errorf("Reload() changed = %v, want %v", changed, tt.changed)
+			}
+			if cfg.Log.Level != "warn" {
+				t.Errorf("log.level = %q, want AW_LOG_LEVEL override", cfg.Log.Level)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Package decoy 伪装站点，代理入站收到的非代理流量回落到这里，主动探测只能看到普通网站
+package decoy
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"go.uber.org/zap"
+)
+
+// indexTemplate 未配置 root 和 proxy 时使用的默认页面
+//
+//go:embed assets/index.html.tmpl
+var indexTemplate string
+
+// defaultPage 解析后的默认页面模板
+var defaultPage = template.Must(template.New("index").Parse(indexTemplate))
+
+// Server 伪装站点的HTTP服务器，监听 v2ray.decoy.listen
+type Server struct {
+	mu     sync.Mutex // 保护配置和server，配置可在运行时变更
+	cfg    config.DecoyConfig
+	server *http.Server
+	log    *zap.Logger
+}
+
+// Option 伪装站点可选参数
+type Option func(*Server)
+
+// WithLogger 设置伪装站点使用的日志实例
+func WithLogger(log *zap.Logger) Option {
+	return func(s *Server) {
+		s.log = log
+	}
+}
+
+// NewServer 创建伪装站点服务器
+func NewServer(cfg config.DecoyConfig, opts ...Option) *Server {
+	s := &Server{cfg: cfg, log: zap.NewNop()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetConfig 更新站点配置，下次Start时生效
+func (s *Server) SetConfig(cfg config.DecoyConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// Start 启动伪装站点，阻塞直到Stop，站点内容无效时返回错误
+// 回落的连接是已解除TLS的明文流量，协商了h2的连接以明文HTTP/2到达，因此同时接受两种协议。
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	cfg := s.cfg
+	handler, err := newHandler(cfg)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		Protocols:         protocols,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	s.server = server
+	s.mu.Unlock()
+
+	s.log.Info("Decoy site starting",
+		zap.String("address", cfg.Listen),
+		zap.String("root", cfg.Root),
+		zap.String("proxy", cfg.Proxy))
+	return server.ListenAndServe()
+}
+
+// Stop 优雅停止伪装站点，等待进行中的请求结束直到ctx到期
+func (s *Server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	server := s.server
+	s.server = nil
+	s.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	s.log.Info("Stopping decoy site...")
+	return server.Shutdown(ctx)
+}
+
+// newHandler 按配置返回站点内容：反向代理、静态目录或默认页面
+func newHandler(cfg config.DecoyConfig) (http.Handler, error) {
+	switch {
+	case cfg.Proxy != "":
+		target, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decoy proxy %q: %w", cfg.Proxy, err)
+		}
+		// 使用目标站点的Host，虚拟主机和HTTPS站点才能正常响应
+		return &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(target)
+			},
+		}, nil
+
+	case cfg.Root != "":
+		info, err := os.Stat(cfg.Root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open decoy root: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("decoy root %s is not a directory", cfg.Root)
+		}
+		return http.FileServer(http.Dir(cfg.Root)), nil
+
+	default:
+		var buf bytes.Buffer
+		data := struct {
+			Title string
+			Year  int
+		}{Title: cfg.Title, Year: time.Now().Year()}
+		if err := defaultPage.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render decoy page: %w", err)
+		}
+		page := buf.Bytes()
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" && r.URL.Path != "/index.html" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(page)
+		}), nil
+	}
+}
@@ -0,0 +1,97 @@
+// Package events 进程内事件总线，记录凭据轮换等状态变化
+// 最近的事件保存在内存中，可通过API按序号增量查询，Agent重启后清空。
+package events
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 事件类型
+const (
+	CredentialsRotated  = "credentials_rotated"
+	PortRotated         = "port_rotated"
+	ClientAccessChanged = "client_access_changed"
+)
+
+// defaultCapacity 默认保留的最近事件数
+const defaultCapacity = 1000
+
+// Event 单个事件
+type Event struct {
+	ID      int64                  `json:"id"` // 递增序号，从1开始
+	Type    string                 `json:"type"`
+	Time    time.Time              `json:"time"`
+	Subject string                 `json:"subject,omitempty"` // 事件对象，如用户email
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Bus 事件总线，只保留最近的 capacity 个事件
+type Bus struct {
+	mu       sync.Mutex
+	events   []Event
+	nextID   int64
+	capacity int
+	log      *zap.Logger
+}
+
+// Option 事件总线可选参数
+type Option func(*Bus)
+
+// WithLogger 设置事件总线使用的日志实例，每个事件记录一条日志
+func WithLogger(log *zap.Logger) Option {
+	return func(b *Bus) {
+		b.log = log
+	}
+}
+
+// WithCapacity 设置保留的最近事件数
+func WithCapacity(capacity int) Option {
+	return func(b *Bus) {
+		b.capacity = capacity
+	}
+}
+
+// NewBus 创建事件总线
+func NewBus(opts ...Option) *Bus {
+	b := &Bus{nextID: 1, capacity: defaultCapacity, log: zap.NewNop()}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Publish 发布事件并返回，超出容量时丢弃最早的事件
+func (b *Bus) Publish(eventType string, subject string, data map[string]interface{}) Event {
+	b.mu.Lock()
+	event := Event{ID: b.nextID, Type: eventType, Time: time.Now(), Subject: subject, Data: data}
+	b.nextID++
+	b.events = append(b.events, event)
+	if len(b.events) > b.capacity {
+		b.events = append([]Event(nil), b.events[len(b.events)-b.capacity:]...)
+	}
+	b.mu.Unlock()
+
+	b.log.Info("Event published",
+		zap.Int64("id", event.ID),
+		zap.String("type", event.Type),
+		zap.String("subject", event.Subject),
+		zap.Any("data", event.Data))
+	return event
+}
+
+// Since 返回序号大于 id 的事件，eventType 不为空时只返回该类型
+func (b *Bus) Since(id int64, eventType string) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := []Event{}
+	for _, event := range b.events {
+		if event.ID > id && (eventType == "" || event.Type == eventType) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
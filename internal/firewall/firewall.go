@@ -0,0 +1,111 @@
+// Package firewall 管理端口跳跃和端口轮换使用的重定向规则，支持nftables和iptables
+// Agent的规则放在独立的表或链中，每次整体替换，停止时整体删除，不影响其他规则。
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"go.uber.org/zap"
+)
+
+// Redirect 将 From-To 范围内的TCP和UDP端口重定向到本机的 Target 端口
+type Redirect struct {
+	From   int `json:"from"`
+	To     int `json:"to"`
+	Target int `json:"target"`
+}
+
+// Firewall 管理Agent的重定向规则
+type Firewall interface {
+	// Kind 返回防火墙类型，如 nftables
+	Kind() string
+	// Apply 以 redirects 替换Agent的全部重定向规则，redirects 为空时删除规则
+	Apply(ctx context.Context, redirects []Redirect) error
+	// Cleanup 删除Agent的全部规则，规则不存在时直接返回
+	Cleanup(ctx context.Context) error
+}
+
+// options 创建防火墙的可选参数
+type options struct {
+	log    *zap.Logger
+	runner command.Runner
+}
+
+// Option 防火墙可选参数
+type Option func(*options)
+
+// WithLogger 设置防火墙使用的日志实例
+func WithLogger(log *zap.Logger) Option {
+	return func(o *options) {
+		o.log = log
+	}
+}
+
+// WithCommandRunner 设置执行 nft/iptables 的命令执行器
+func WithCommandRunner(runner command.Runner) Option {
+	return func(o *options) {
+		o.runner = runner
+	}
+}
+
+// New 按类型创建防火墙，kind 为 auto 时自动检测
+func New(kind string, opts ...Option) (Firewall, error) {
+	o := &options{log: zap.NewNop()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.runner == nil {
+		o.runner = command.NewExecRunner(o.log.Named("exec"))
+	}
+
+	if kind == config.FirewallAuto || kind == "" {
+		kind = Detect()
+		o.log.Info("Detected firewall", zap.String("kind", kind))
+	}
+
+	switch kind {
+	case config.FirewallNftables:
+		return &nftables{runner: o.runner, log: o.log}, nil
+	case config.FirewallIPTables:
+		return newIPTables(o.runner, o.log), nil
+	case config.FirewallNone:
+		return none{log: o.log}, nil
+	}
+	return nil, fmt.Errorf("unknown firewall %q", kind)
+}
+
+// Detect 检测当前系统可用的防火墙，优先使用nftables，都不可用时为 none
+func Detect() string {
+	if _, err := exec.LookPath("nft"); err == nil {
+		return config.FirewallNftables
+	}
+	if _, err := exec.LookPath("iptables"); err == nil {
+		return config.FirewallIPTables
+	}
+	return config.FirewallNone
+}
+
+// none 不管理重定向规则，端口跳跃不可用
+type none struct {
+	log *zap.Logger
+}
+
+func (none) Kind() string {
+	return config.FirewallNone
+}
+
+// Apply 有重定向规则时返回错误，避免端口跳跃静默失效
+func (n none) Apply(ctx context.Context, redirects []Redirect) error {
+	if len(redirects) > 0 {
+		return fmt.Errorf("no firewall available for %d port redirects, install nftables or iptables", len(redirects))
+	}
+	return nil
+}
+
+func (none) Cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,207 @@
+package firewall
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"go.uber.org/zap"
+)
+
+// scriptRunner 在 nft -f 执行时记录规则文件的内容，文件在Apply返回前即被删除
+type scriptRunner struct {
+	*commandtest.FakeRunner
+	mu      sync.Mutex
+	scripts []string
+}
+
+func (r *scriptRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if name == "nft" && len(args) == 2 && args[0] == "-f" {
+		data, err := os.ReadFile(args[1])
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		r.scripts = append(r.scripts, string(data))
+		r.mu.Unlock()
+	}
+	return r.FakeRunner.Run(ctx, name, args...)
+}
+
+func TestNftablesApply(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		redirects []Redirect
+		want      string
+	}{
+		{
+			name:      "port range and single port",
+			redirects: []Redirect{{From: 20000, To: 20100, Target: 443}, {From: 8443, To: 8443, Target: 443}},
+			want: "table inet aw_agent\n" +
+				"delete table inet aw_agent\n" +
+				"table inet aw_agent {\n" +
+				"\tchain prerouting {\n" +
+				"\t\ttype nat hook prerouting priority dstnat; policy accept;\n" +
+				"\t\tmeta l4proto { tcp, udp } th dport 20000-20100 redirect to :443\n" +
+				"\t\tmeta l4proto { tcp, udp } th dport 8443 redirect to :443\n" +
+				"\t}\n" +
+				"}\n",
+		},
+		{
+			name: "no redirects deletes the table",
+			want: "table inet aw_agent\ndelete table inet aw_agent\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fake := commandtest.NewFakeRunner()
+			fake.Default = commandtest.Response{}
+			runner := &scriptRunner{FakeRunner: fake}
+			fw, err := New(config.FirewallNftables, WithCommandRunner(runner))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fw.Apply(context.Background(), tt.redirects); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if len(runner.scripts) != 1 {
+				t.Fatalf("nft -f called %d times, want 1", len(runner.scripts))
+			}
+			if runner.scripts[0] != tt.want {
+				t.Errorf("nft script =\n%s\nwant\n%s", runner.scripts[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestNftablesApplyError(t *testing.T) {
+	t.Parallel()
+
+	fake := commandtest.NewFakeRunner()
+	fake.Default = commandtest.Response{Output: "Error: Could not process rule\n", Err: &commandtest.ExitError{Code: 1}}
+	fw, err := New(config.FirewallNftables, WithCommandRunner(&scriptRunner{FakeRunner: fake}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fw.Apply(context.Background(), []Redirect{{From: 20000, To: 20100, Target: 443}})
+	if err == nil || !strings.Contains(err.Error(), "Could not process rule") {
+		t.Errorf("Apply() error = %v, want nft output", err)
+	}
+}
+
+func TestIPTablesApply(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		redirects []Redirect
+		jumped    bool // PREROUTING中已有跳转
+		want      []string
+	}{
+		{
+			name:      "port range and single port",
+			redirects: []Redirect{{From: 20000, To: 20100, Target: 443}, {From: 8443, To: 8443, Target: 443}},
+			want: []string{
+				"iptables -t nat -N AW_AGENT",
+				"iptables -t nat -F AW_AGENT",
+				"iptables -t nat -A AW_AGENT -p tcp --dport 20000:20100 -j REDIRECT --to-ports 443",
+				"iptables -t nat -A AW_AGENT -p udp --dport 20000:20100 -j REDIRECT --to-ports 443",
+				"iptables -t nat -A AW_AGENT -p tcp --dport 8443 -j REDIRECT --to-ports 443",
+				"iptables -t nat -A AW_AGENT -p udp --dport 8443 -j REDIRECT --to-ports 443",
+				"iptables -t nat -C PREROUTING -j AW_AGENT",
+				"iptables -t nat -A PREROUTING -j AW_AGENT",
+			},
+		},
+		{
+			name:      "existing jump is not added again",
+			redirects: []Redirect{{From: 20000, To: 20100, Target: 443}},
+			jumped:    true,
+			want: []string{
+				"iptables -t nat -N AW_AGENT",
+				"iptables -t nat -F AW_AGENT",
+				"iptables -t nat -A AW_AGENT -p tcp --dport 20000:20100 -j REDIRECT --to-ports 443",
+				"iptables -t nat -A AW_AGENT -p udp --dport 20000:20100 -j REDIRECT --to-ports 443",
+				"iptables -t nat -C PREROUTING -j AW_AGENT",
+			},
+		},
+		{
+			name: "no redirects cleans up",
+			want: []string{
+				"iptables -t nat -D PREROUTING -j AW_AGENT",
+				"iptables -t nat -F AW_AGENT",
+				"iptables -t nat -X AW_AGENT",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			runner := commandtest.NewFakeRunner()
+			runner.Default = commandtest.Response{}
+			// 跳转不存在时 -C 和 -D 以退出码1失败
+			if !tt.jumped {
+				runner.On("iptables -t nat -C PREROUTING -j AW_AGENT", "", &commandtest.ExitError{Code: 1})
+				runner.On("iptables -t nat -D PREROUTING -j AW_AGENT", "", &commandtest.ExitError{Code: 1})
+			}
+			fw := &iptables{commands: []string{"iptables"}, runner: runner, log: zap.NewNop()}
+			if err := fw.Apply(context.Background(), tt.redirects); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if got := runner.Lines(); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Apply() ran\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestIPTablesCleanupDuplicateJumps(t *testing.T) {
+	t.Parallel()
+
+	// 跳转被重复添加了两次，第三次删除失败时停止
+	runner := commandtest.NewFakeRunner().
+		On("iptables -t nat -D PREROUTING -j AW_AGENT", "", nil).
+		On("iptables -t nat -D PREROUTING -j AW_AGENT", "", nil).
+		On("iptables -t nat -D PREROUTING -j AW_AGENT", "iptables: Bad rule (does a matching rule exist in that chain?).", &commandtest.ExitError{Code: 1})
+	fw := &iptables{commands: []string{"iptables", "ip6tables"}, runner: runner, log: zap.NewNop()}
+	if err := fw.Cleanup(context.Background()); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	want := []string{
+		"iptables -t nat -D PREROUTING -j AW_AGENT",
+		"iptables -t nat -D PREROUTING -j AW_AGENT",
+		"iptables -t nat -D PREROUTING -j AW_AGENT",
+		"iptables -t nat -F AW_AGENT",
+		"iptables -t nat -X AW_AGENT",
+		"ip6tables -t nat -D PREROUTING -j AW_AGENT",
+		"ip6tables -t nat -F AW_AGENT",
+		"ip6tables -t nat -X AW_AGENT",
+	}
+	if got := runner.Lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Cleanup() ran\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNoneApply(t *testing.T) {
+	t.Parallel()
+
+	fw, err := New(config.FirewallNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Apply(context.Background(), nil); err != nil {
+		t.Errorf("Apply() without redirects error = %v", err)
+	}
+	if err := fw.Apply(context.Background(), []Redirect{{From: 20000, To: 20100, Target: 443}}); err == nil {
+		t.Error("Apply() with redirects succeeded, want error")
+	}
+}
@@ -0,0 +1,100 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"go.uber.org/zap"
+)
+
+// iptablesChain Agent在nat表中使用的自定义链，由PREROUTING跳转
+const iptablesChain = "AW_AGENT"
+
+// iptables 在nat表的 AW_AGENT 链中管理重定向规则，存在ip6tables时同时管理IPv6
+type iptables struct {
+	commands []string // iptables，以及存在时的 ip6tables
+	runner   command.Runner
+	log      *zap.Logger
+}
+
+// newIPTables 创建iptables防火墙
+func newIPTables(runner command.Runner, log *zap.Logger) *iptables {
+	commands := []string{"iptables"}
+	if _, err := exec.LookPath("ip6tables"); err == nil {
+		commands = append(commands, "ip6tables")
+	}
+	return &iptables{commands: commands, runner: runner, log: log}
+}
+
+func (t *iptables) Kind() string {
+	return config.FirewallIPTables
+}
+
+// Apply 清空 AW_AGENT 链后重新添加规则
+// iptables 无法原子替换整条链，清空和添加之间的短暂时间内重定向不生效。
+func (t *iptables) Apply(ctx context.Context, redirects []Redirect) error {
+	if len(redirects) == 0 {
+		return t.Cleanup(ctx)
+	}
+
+	for _, name := range t.commands {
+		// 链已存在时创建失败，忽略错误
+		t.runner.Run(ctx, name, "-t", "nat", "-N", iptablesChain)
+		if err := t.run(ctx, name, "-t", "nat", "-F", iptablesChain); err != nil {
+			return err
+		}
+		for _, r := range redirects {
+			for _, protocol := range []string{"tcp", "udp"} {
+				if err := t.run(ctx, name, "-t", "nat", "-A", iptablesChain,
+					"-p", protocol, "--dport", iptablesPorts(r),
+					"-j", "REDIRECT", "--to-ports", strconv.Itoa(r.Target)); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := t.runner.Run(ctx, name, "-t", "nat", "-C", "PREROUTING", "-j", iptablesChain); err != nil {
+			if err := t.run(ctx, name, "-t", "nat", "-A", "PREROUTING", "-j", iptablesChain); err != nil {
+				return err
+			}
+		}
+	}
+	t.log.Info("Port redirects applied", zap.String("firewall", t.Kind()), zap.Int("redirects", len(redirects)))
+	return nil
+}
+
+// Cleanup 删除PREROUTING中的跳转和 AW_AGENT 链，规则不存在时的错误被忽略
+func (t *iptables) Cleanup(ctx context.Context) error {
+	for _, name := range t.commands {
+		// 重复添加的跳转需要逐条删除
+		for {
+			if _, err := t.runner.Run(ctx, name, "-t", "nat", "-D", "PREROUTING", "-j", iptablesChain); err != nil {
+				break
+			}
+		}
+		t.runner.Run(ctx, name, "-t", "nat", "-F", iptablesChain)
+		t.runner.Run(ctx, name, "-t", "nat", "-X", iptablesChain)
+	}
+	return ctx.Err()
+}
+
+// run 执行命令，失败时返回包含输出的错误
+func (t *iptables) run(ctx context.Context, name string, args ...string) error {
+	output, err := t.runner.Run(ctx, name, args...)
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %w: %s", command.Line(name, args...), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// iptablesPorts 返回iptables的端口写法：单个端口或 from:to
+func iptablesPorts(r Redirect) string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return fmt.Sprintf("%d:%d", r.From, r.To)
+}
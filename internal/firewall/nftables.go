@@ -0,0 +1,82 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"go.uber.org/zap"
+)
+
+// nftTable Agent使用的nftables表，同时处理IPv4和IPv6
+const nftTable = "aw_agent"
+
+// nftables 使用独立的 inet aw_agent 表，通过 nft -f 原子替换整张表
+type nftables struct {
+	runner command.Runner
+	log    *zap.Logger
+}
+
+func (n *nftables) Kind() string {
+	return config.FirewallNftables
+}
+
+// Apply 重新创建 aw_agent 表；先声明再删除，表不存在时也不会报错
+func (n *nftables) Apply(ctx context.Context, redirects []Redirect) error {
+	var script strings.Builder
+	fmt.Fprintf(&script, "table inet %s\n", nftTable)
+	fmt.Fprintf(&script, "delete table inet %s\n", nftTable)
+	if len(redirects) > 0 {
+		fmt.Fprintf(&script, "table inet %s {\n", nftTable)
+		script.WriteString("\tchain prerouting {\n")
+		script.WriteString("\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+		for _, r := range redirects {
+			fmt.Fprintf(&script, "\t\tmeta l4proto { tcp, udp } th dport %s redirect to :%d\n", nftPorts(r), r.Target)
+		}
+		script.WriteString("\t}\n}\n")
+	}
+
+	if err := n.run(ctx, script.String()); err != nil {
+		return fmt.Errorf("failed to apply nftables rules: %w", err)
+	}
+	n.log.Info("Port redirects applied", zap.String("firewall", n.Kind()), zap.Int("redirects", len(redirects)))
+	return nil
+}
+
+// Cleanup 删除 aw_agent 表
+func (n *nftables) Cleanup(ctx context.Context) error {
+	return n.Apply(ctx, nil)
+}
+
+// run 将规则写入临时文件并由 nft -f 加载，整个文件作为一个事务生效
+func (n *nftables) run(ctx context.Context, script string) error {
+	file, err := os.CreateTemp("", "aw_agent-*.nft")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(script)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	output, err := n.runner.Run(ctx, "nft", "-f", file.Name())
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// nftPorts 返回nftables的端口写法：单个端口或 from-to
+func nftPorts(r Redirect) string {
+	if r.From == r.To {
+		return fmt.Sprint(r.From)
+	}
+	return fmt.Sprintf("%d-%d", r.From, r.To)
+}
@@ -2,18 +2,24 @@ package logger
 
 import (
 	"os"
-	"time"
+	"path/filepath"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// Logger 全局zap logger实例
-var Logger *zap.Logger
+// Options 日志配置
+type Options struct {
+	Dir        string // 日志目录，为空时只输出到控制台
+	Level      string // 日志级别：debug, info, warn, error
+	MaxSize    int    // 单日志文件最大大小（MB）
+	MaxBackups int    // 保留日志文件数量
+	MaxAge     int    // 日志文件最大保留天数
+}
 
-// Init 初始化日志系统
-func Init(logDir, logLevel string, maxSize, maxBackups, maxAge int) {
+// New 创建日志实例，返回的AtomicLevel可用于运行时调整日志级别
+func New(opts Options) (*zap.Logger, zap.AtomicLevel, error) {
 	// 配置日志格式
 	encoderConfig := zapcore.EncoderConfig{
 		TimeKey:        "time",
@@ -30,102 +36,44 @@ func Init(logDir, logLevel string, maxSize, maxBackups, maxAge int) {
 	}
 
 	// 配置日志级别
-	level := zap.InfoLevel
-	switch logLevel {
-	case "debug":
-		level = zap.DebugLevel
-	case "warn":
-		level = zap.WarnLevel
-	case "error":
-		level = zap.ErrorLevel
-	}
-
-	// 创建日志目录（如果不存在）
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		zap.NewNop().Error("Failed to create log directory", zap.Error(err))
-	}
-
-	// 配置日志输出到文件
-	logFilePath := logDir + "/agent.log"
-	fileWriter := zapcore.AddSync(&lumberjack.Logger{
-		Filename:   logFilePath,
-		MaxSize:    maxSize, // MB
-		MaxBackups: maxBackups,
-		MaxAge:     maxAge, // 天
-		Compress:   true,
-	})
+	level := zap.NewAtomicLevelAt(ParseLevel(opts.Level))
 
 	// 配置同时输出到控制台
-	consoleWriter := zapcore.AddSync(os.Stdout)
+	cores := []zapcore.Core{
+		zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(os.Stdout), level),
+	}
 
-	// 创建zap核心
-	core := zapcore.NewTee(
-		zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), fileWriter, level),
-		zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), consoleWriter, level),
-	)
+	if opts.Dir != "" {
+		// 创建日志目录（如果不存在）
+		if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+			return nil, level, err
+		}
+
+		// 配置日志输出到文件
+		fileWriter := zapcore.AddSync(&lumberjack.Logger{
+			Filename:   filepath.Join(opts.Dir, "agent.log"),
+			MaxSize:    opts.MaxSize, // MB
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     opts.MaxAge, // 天
+			Compress:   true,
+		})
+		cores = append(cores, zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), fileWriter, level))
+	}
 
 	// 创建logger实例
-	Logger = zap.New(core, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
+	log := zap.New(zapcore.NewTee(cores...), zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel))
+	return log, level, nil
 }
 
-// Sync 刷新日志
-func Sync() {
-	if Logger != nil {
-		Logger.Sync()
+// ParseLevel 将配置中的日志级别转换为zap级别，未知级别按info处理
+func ParseLevel(logLevel string) zapcore.Level {
+	switch logLevel {
+	case "debug":
+		return zap.DebugLevel
+	case "warn":
+		return zap.WarnLevel
+	case "error":
+		return zap.ErrorLevel
 	}
-}
-
-// Debug 调试日志
-func Debug(msg string, fields ...zap.Field) {
-	Logger.Debug(msg, fields...)
-}
-
-// Info 信息日志
-func Info(msg string, fields ...zap.Field) {
-	Logger.Info(msg, fields...)
-}
-
-// Warn 警告日志
-func Warn(msg string, fields ...zap.Field) {
-	Logger.Warn(msg, fields...)
-}
-
-// Error 错误日志
-func Error(msg string, fields ...zap.Field) {
-	Logger.Error(msg, fields...)
-}
-
-// Fatal 致命错误日志
-func Fatal(msg string, fields ...zap.Field) {
-	Logger.Fatal(msg, fields...)
-}
-
-// String 创建字符串类型的zap.Field
-func String(key string, value string) zap.Field {
-	return zap.String(key, value)
-}
-
-// Int 创建整数类型的zap.Field
-func Int(key string, value int) zap.Field {
-	return zap.Int(key, value)
-}
-
-// Int64 创建int64类型的zap.Field
-func Int64(key string, value int64) zap.Field {
-	return zap.Int64(key, value)
-}
-
-// Bool 创建布尔类型的zap.Field
-func Bool(key string, value bool) zap.Field {
-	return zap.Bool(key, value)
-}
-
-// Time 创建时间类型的zap.Field
-func Time(key string, value time.Time) zap.Field {
-	return zap.Time(key, value)
-}
-
-// Error 创建错误类型的zap.Field
-func ErrorField(key string, value error) zap.Field {
-	return zap.Error(value)
+	return zap.InfoLevel
 }
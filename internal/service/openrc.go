@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"go.uber.org/zap"
+)
+
+// rc-service status 的退出码
+const (
+	openRCStopped = 3
+	openRCCrashed = 32
+)
+
+// openRC 通过 rc-service 和 rc-update 控制OpenRC服务
+type openRC struct {
+	name    string
+	pidFile string
+	runner  command.Runner
+	log     *zap.Logger
+}
+
+func newOpenRC(spec Spec, runner command.Runner, log *zap.Logger) *openRC {
+	pidFile := spec.PIDFile
+	if pidFile == "" {
+		pidFile = "/run/" + spec.Name + ".pid"
+	}
+	return &openRC{name: spec.Name, pidFile: pidFile, runner: runner, log: log}
+}
+
+// Kind 返回 openrc
+func (o *openRC) Kind() string {
+	return KindOpenRC
+}
+
+// rcService 执行 rc-service <name> <action>
+func (o *openRC) rcService(ctx context.Context, action string) error {
+	output, err := o.runner.Run(ctx, "rc-service", o.name, action)
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w: %s", action, o.name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Start 启动服务
+func (o *openRC) Start(ctx context.Context) error {
+	return o.rcService(ctx, "start")
+}
+
+// Stop 停止服务
+func (o *openRC) Stop(ctx context.Context) error {
+	return o.rcService(ctx, "stop")
+}
+
+// Restart 重启服务
+func (o *openRC) Restart(ctx context.Context) error {
+	return o.rcService(ctx, "restart")
+}
+
+// Enable 将服务加入default运行级别
+func (o *openRC) Enable(ctx context.Context) error {
+	output, err := o.runner.Run(ctx, "rc-update", "add", o.name, "default")
+	if err != nil {
+		return fmt.Errorf("failed to enable %s: %w: %s", o.name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Status 按 rc-service status 的退出码判断状态，PID和启动时间取自PID文件
+func (o *openRC) Status(ctx context.Context) (*Status, error) {
+	_, err := o.runner.Run(ctx, "rc-service", o.name, "status")
+	status := &Status{Manager: KindOpenRC}
+	if err != nil {
+		code, ok := command.ExitCode(err)
+		if !ok {
+			return nil, fmt.Errorf("failed to get %s status: %w", o.name, err)
+		}
+		switch code {
+		case openRCStopped:
+			status.State = "stopped"
+		case openRCCrashed:
+			status.State = "crashed"
+		default:
+			status.State = "unknown"
+		}
+		return status, nil
+	}
+
+	status.Active = true
+	status.State = "started"
+	if data, err := os.ReadFile(o.pidFile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			status.PID = pid
+		}
+		// PID文件在服务启动时写入，其修改时间即启动时间
+		if info, err := os.Stat(o.pidFile); err == nil {
+			status.setStartedAt(info.ModTime())
+		}
+	} else {
+		o.log.Debug("Failed to read pid file", zap.String("path", o.pidFile), zap.Error(err))
+	}
+	return status, nil
+}
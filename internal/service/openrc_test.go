@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+)
+
+// newTestOpenRC 返回使用runner执行命令的OpenRC服务，PID文件在临时目录中
+func newTestOpenRC(t *testing.T, runner *commandtest.FakeRunner) (ServiceManager, string) {
+	t.Helper()
+	pidFile := filepath.Join(t.TempDir(), "v2ray.pid")
+	svc, err := New(KindOpenRC, Spec{Name: "v2ray", PIDFile: pidFile}, WithCommandRunner(runner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, pidFile
+}
+
+func TestOpenRCCommands(t *testing.T) {
+	t.Parallel()
+
+	runner := commandtest.NewFakeRunner()
+	runner.Default = commandtest.Response{}
+	svc, _ := newTestOpenRC(t, runner)
+	ctx := context.Background()
+
+	for _, step := range []struct {
+		call func(context.Context) error
+		line string
+	}{
+		{svc.Enable, "rc-update add v2ray default"},
+		{svc.Start, "rc-service v2ray start"},
+		{svc.Restart, "rc-service v2ray restart"},
+		{svc.Stop, "rc-service v2ray stop"},
+	} {
+		if err := step.call(ctx); err != nil {
+			t.Errorf("%s: error = %v", step.line, err)
+		}
+		if !runner.Called(step.line) {
+			t.Errorf("runner calls = %v, want %q", runner.Lines(), step.line)
+		}
+	}
+}
+
+func TestOpenRCStartFailure(t *testing.T) {
+	t.Parallel()
+
+	runner := commandtest.NewFakeRunner()
+	runner.On("rc-service v2ray start", " * v2ray: unable to start\n", &commandtest.ExitError{Code: 1})
+	svc, _ := newTestOpenRC(t, runner)
+
+	err := svc.Start(context.Background())
+	if err == nil || err.Error() != "failed to start v2ray: exit status 1: * v2ray: unable to start" {
+		t.Errorf("Start() error = %v", err)
+	}
+}
+
+func TestOpenRCStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		err     error
+		active  bool
+		state   string
+		pid     int
+		wantErr bool
+	}{
+		{name: "started", active: true, state: "started", pid: 1234},
+		{name: "stopped", err: &commandtest.ExitError{Code: openRCStopped}, state: "stopped"},
+		{name: "crashed", err: &commandtest.ExitError{Code: openRCCrashed}, state: "crashed"},
+		{name: "other exit code", err: &commandtest.ExitError{Code: 1}, state: "unknown"},
+		{name: "not executed", err: errors.New("rc-service not found"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			runner := commandtest.NewFakeRunner()
+			runner.On("rc-service v2ray status", "", tt.err)
+			svc, pidFile := newTestOpenRC(t, runner)
+			if err := os.WriteFile(pidFile, []byte("1234\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := svc.Status(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Status() = %+v, want error", status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Status() error = %v", err)
+			}
+			if status.Active != tt.active || status.State != tt.state || status.PID != tt.pid || status.Manager != KindOpenRC {
+				t.Errorf("Status() = %+v, want active=%v state=%s pid=%d", status, tt.active, tt.state, tt.pid)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// maxLineLength 单行输出的最大长度，超过时按该长度拆分
+const maxLineLength = 4096
+
+// lineWriter 将子进程输出按行写入日志
+type lineWriter struct {
+	mu     sync.Mutex
+	log    *zap.Logger
+	stream string
+	buf    []byte
+}
+
+func newLineWriter(log *zap.Logger, stream string) *lineWriter {
+	return &lineWriter{log: log, stream: stream}
+}
+
+// Write 缓存输出并记录其中完整的行
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			if len(w.buf) >= maxLineLength {
+				w.emit(w.buf[:maxLineLength])
+				w.buf = w.buf[maxLineLength:]
+				continue
+			}
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush 记录缓存中剩余的不完整行
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+}
+
+// emit 记录一行输出，忽略空行
+func (w *lineWriter) emit(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return
+	}
+	w.log.Info(string(line), zap.String("stream", w.stream))
+}
@@ -0,0 +1,11 @@
+package service
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcAttr Agent异常退出时由内核向子进程发送SIGTERM，避免遗留孤儿进程
+func setProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package service
+
+import "os/exec"
+
+// setProcAttr 非Linux系统不支持 Pdeathsig
+func setProcAttr(cmd *exec.Cmd) {}
@@ -0,0 +1,136 @@
+// Package service 管理V2Ray等后台服务的启停，支持systemd、OpenRC和Agent内置的进程守护
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"go.uber.org/zap"
+)
+
+// 服务管理方式
+const (
+	KindAuto       = "auto"
+	KindSystemd    = "systemd"
+	KindOpenRC     = "openrc"
+	KindSupervisor = "supervisor"
+)
+
+// ServiceManager 控制单个服务的运行状态
+type ServiceManager interface {
+	// Kind 返回服务管理方式，如 systemd
+	Kind() string
+	// Start 启动服务，服务已在运行时直接返回
+	Start(ctx context.Context) error
+	// Stop 停止服务
+	Stop(ctx context.Context) error
+	// Restart 重启服务，服务未运行时启动服务
+	Restart(ctx context.Context) error
+	// Enable 设置服务开机自启
+	Enable(ctx context.Context) error
+	// Status 查询服务当前状态
+	Status(ctx context.Context) (*Status, error)
+}
+
+// Status 服务运行状态
+type Status struct {
+	Manager       string    `json:"manager"`
+	Active        bool      `json:"active"`
+	State         string    `json:"state"`
+	PID           int       `json:"pid,omitempty"`
+	StartedAt     time.Time `json:"started_at,omitzero"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	ExitCode      int       `json:"exit_code"` // 最近一次退出的退出码
+
+	Restarts       int    `json:"restarts"`                   // 服务被自动重启的次数
+	LastExitReason string `json:"last_exit_reason,omitempty"` // 最近一次退出的原因
+	CrashLoop      bool   `json:"crash_loop,omitempty"`       // 是否处于崩溃循环（仅supervisor）
+}
+
+// setStartedAt 记录启动时间并计算运行时长
+func (s *Status) setStartedAt(startedAt time.Time) {
+	if startedAt.IsZero() {
+		return
+	}
+	s.StartedAt = startedAt
+	s.UptimeSeconds = int64(time.Since(startedAt) / time.Second)
+}
+
+// Spec 描述被管理的服务
+type Spec struct {
+	Name    string   // 服务名，systemd单元为 Name.service
+	Command []string // supervisor方式下启动的命令及参数
+	Env     []string // supervisor方式下额外的环境变量，如 KEY=value
+	PIDFile string   // OpenRC方式下的PID文件，为空时使用 /run/Name.pid
+}
+
+// options 创建服务管理器的可选参数
+type options struct {
+	log    *zap.Logger
+	runner command.Runner
+}
+
+// Option 服务管理器可选参数
+type Option func(*options)
+
+// WithLogger 设置服务管理器使用的日志实例
+func WithLogger(log *zap.Logger) Option {
+	return func(o *options) {
+		o.log = log
+	}
+}
+
+// WithCommandRunner 设置OpenRC方式下执行 rc-service/rc-update 的命令执行器
+func WithCommandRunner(runner command.Runner) Option {
+	return func(o *options) {
+		o.runner = runner
+	}
+}
+
+// New 按管理方式创建服务管理器，kind 为 auto 时自动检测
+func New(kind string, spec Spec, opts ...Option) (ServiceManager, error) {
+	o := &options{log: zap.NewNop()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.runner == nil {
+		o.runner = command.NewExecRunner(o.log.Named("exec"))
+	}
+
+	if kind == KindAuto || kind == "" {
+		kind = Detect()
+		o.log.Info("Detected service manager", zap.String("kind", kind))
+	}
+
+	switch kind {
+	case KindSystemd:
+		return newSystemd(spec, o.log), nil
+	case KindOpenRC:
+		return newOpenRC(spec, o.runner, o.log), nil
+	case KindSupervisor:
+		if len(spec.Command) == 0 {
+			return nil, fmt.Errorf("supervisor service %s has no command", spec.Name)
+		}
+		return newSupervisor(spec, o.log), nil
+	}
+	return nil, fmt.Errorf("unknown service manager %q", kind)
+}
+
+// Detect 检测当前系统可用的服务管理方式
+// systemd 作为init运行时使用systemd，存在OpenRC时使用OpenRC，否则由Agent自行守护进程。
+func Detect() string {
+	if info, err := os.Stat("/run/systemd/system"); err == nil && info.IsDir() {
+		return KindSystemd
+	}
+	if _, err := os.Stat("/run/openrc"); err == nil {
+		return KindOpenRC
+	}
+	if _, err := exec.LookPath("rc-service"); err == nil {
+		return KindOpenRC
+	}
+	return KindSupervisor
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"go.uber.org/zap"
+)
+
+// 进程守护参数
+const (
+	supervisorMinBackoff  = time.Second
+	supervisorMaxBackoff  = time.Minute
+	supervisorStableAfter = time.Minute      // 进程运行超过该时间后退出，重启退避从头计算
+	supervisorStopTimeout = 10 * time.Second // 发送SIGTERM后等待进程退出的时间，超时后SIGKILL
+	supervisorWaitDelay   = 5 * time.Second  // 进程退出后等待输出读取完成的时间
+
+	// 连续 supervisorCrashLoopCount 次在 supervisorStableAfter 内退出视为崩溃循环，
+	// 此后每 supervisorCrashLoopBackoff 才尝试重启一次，直到进程稳定运行。
+	supervisorCrashLoopCount   = 5
+	supervisorCrashLoopBackoff = 5 * time.Minute
+)
+
+// supervisor 由Agent以子进程方式运行服务，进程退出后按指数退避重启
+// 子进程的标准输出和标准错误逐行写入Agent日志。
+type supervisor struct {
+	spec Spec
+	log  *zap.Logger
+
+	mu         sync.Mutex
+	stop       chan struct{} // 非nil表示守护循环正在运行，关闭后守护循环停止子进程并退出
+	kill       chan struct{} // 关闭后不再等待子进程响应SIGTERM，直接强制结束
+	done       chan struct{} // 守护循环退出时关闭，此时 stop、kill、done 才被清空
+	stopping   bool          // stop 已关闭，守护循环尚未退出
+	pid        int
+	startedAt  time.Time
+	exitCode   int
+	exitReason string
+	state      string
+	restarts   int  // 自Start以来的自动重启次数
+	quickExits int  // 连续在稳定时间内退出的次数
+	crashLoop  bool // 是否处于崩溃循环
+}
+
+func newSupervisor(spec Spec, log *zap.Logger) *supervisor {
+	return &supervisor{spec: spec, log: log, state: "stopped"}
+}
+
+// Kind 返回 supervisor
+func (s *supervisor) Kind() string {
+	return KindSupervisor
+}
+
+// Start 启动子进程及守护循环，首次启动失败时直接返回错误
+func (s *supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		if s.stopping {
+			return fmt.Errorf("failed to start %s: previous process is still stopping", s.spec.Name)
+		}
+		return nil
+	}
+	s.restarts = 0
+	s.quickExits = 0
+	s.crashLoop = false
+	cmd, err := s.spawn()
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", s.spec.Name, err)
+	}
+
+	s.stop = make(chan struct{})
+	s.kill = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(cmd, s.stop, s.kill, s.done)
+	return nil
+}
+
+// Stop 停止守护循环，向子进程转发SIGTERM并等待其退出
+// ctx到期时强制结束子进程并返回错误，子进程退出前 Start 会返回错误，不会启动第二个进程。
+func (s *supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	stop, kill, done := s.stop, s.kill, s.done
+	if stop != nil && !s.stopping {
+		s.stopping = true
+		close(stop)
+	}
+	s.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	if s.done == done {
+		select {
+		case <-kill:
+		default:
+			close(kill)
+		}
+	}
+	s.mu.Unlock()
+	return ctx.Err()
+}
+
+// Restart 停止后重新启动子进程
+func (s *supervisor) Restart(ctx context.Context) error {
+	if err := s.Stop(ctx); err != nil {
+		return err
+	}
+	return s.Start(ctx)
+}
+
+// Enable 子进程随Agent启动，无需额外设置
+func (s *supervisor) Enable(ctx context.Context) error {
+	s.log.Debug("Supervised service starts with the agent, nothing to enable", zap.String("name", s.spec.Name))
+	return nil
+}
+
+// Status 返回子进程状态、重启次数和最近一次退出原因
+func (s *supervisor) Status(ctx context.Context) (*Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := &Status{
+		Manager:        KindSupervisor,
+		Active:         s.stop != nil && s.pid != 0,
+		State:          s.state,
+		PID:            s.pid,
+		ExitCode:       s.exitCode,
+		Restarts:       s.restarts,
+		LastExitReason: s.exitReason,
+		CrashLoop:      s.crashLoop,
+	}
+	if status.Active {
+		status.setStartedAt(s.startedAt)
+	}
+	return status, nil
+}
+
+// spawn 启动子进程，调用方需持有 s.mu
+func (s *supervisor) spawn() (*exec.Cmd, error) {
+	output := s.log.Named(s.spec.Name)
+	cmd := exec.Command(s.spec.Command[0], s.spec.Command[1:]...)
+	cmd.Stdout = newLineWriter(output, "stdout")
+	cmd.Stderr = newLineWriter(output, "stderr")
+	cmd.WaitDelay = supervisorWaitDelay
+	if len(s.spec.Env) > 0 {
+		cmd.Env = append(os.Environ(), s.spec.Env...)
+	}
+	setProcAttr(cmd)
+
+	if err := cmd.Start(); err != nil {
+		s.state = "failed"
+		s.exitReason = err.Error()
+		return nil, err
+	}
+
+	s.pid = cmd.Process.Pid
+	s.startedAt = time.Now()
+	s.state = "running"
+	s.log.Info("Supervised process started",
+		zap.String("name", s.spec.Name),
+		zap.Int("pid", s.pid))
+	return cmd, nil
+}
+
+// run 等待子进程退出并按退避时间重启，直到 stop 被关闭
+func (s *supervisor) run(cmd *exec.Cmd, stop, kill <-chan struct{}, done chan struct{}) {
+	defer func() {
+		s.mu.Lock()
+		if s.done == done {
+			s.stop, s.kill, s.done, s.stopping = nil, nil, nil, false
+		}
+		s.mu.Unlock()
+		close(done)
+	}()
+
+	backoff := supervisorMinBackoff
+	for {
+		if cmd != nil {
+			exited := make(chan error, 1)
+			go func() {
+				exited <- cmd.Wait()
+			}()
+
+			select {
+			case err := <-exited:
+				s.exited(cmd, err, "exited")
+			case <-stop:
+				s.terminate(cmd, exited, kill)
+				return
+			}
+		}
+
+		// 进程稳定运行过则重置退避，连续快速退出时进入崩溃循环
+		s.mu.Lock()
+		if s.quickExits == 0 {
+			backoff = supervisorMinBackoff
+		}
+		delay := backoff
+		if s.crashLoop {
+			delay = supervisorCrashLoopBackoff
+		}
+		s.mu.Unlock()
+
+		s.log.Warn("Restarting supervised process",
+			zap.String("name", s.spec.Name),
+			zap.Duration("backoff", delay))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			s.mu.Lock()
+			s.state = "stopped"
+			s.mu.Unlock()
+			return
+		}
+		backoff = min(backoff*2, supervisorMaxBackoff)
+
+		var err error
+		s.mu.Lock()
+		s.restarts++
+		cmd, err = s.spawn()
+		if err != nil {
+			s.quickExits++
+			s.checkCrashLoop()
+		}
+		s.mu.Unlock()
+		if err != nil {
+			s.log.Error("Failed to restart supervised process", zap.String("name", s.spec.Name), zap.Error(err))
+		}
+	}
+}
+
+// terminate 转发SIGTERM，超时或 kill 被关闭时强制结束子进程
+func (s *supervisor) terminate(cmd *exec.Cmd, exited <-chan error, kill <-chan struct{}) {
+	s.log.Info("Stopping supervised process",
+		zap.String("name", s.spec.Name),
+		zap.Int("pid", cmd.Process.Pid))
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		s.log.Warn("Failed to send SIGTERM", zap.Error(err))
+	}
+
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(supervisorStopTimeout):
+		s.log.Warn("Supervised process did not exit in time, killing", zap.String("name", s.spec.Name))
+		_ = cmd.Process.Kill()
+		err = <-exited
+	case <-kill:
+		s.log.Warn("Stop timed out, killing supervised process", zap.String("name", s.spec.Name))
+		_ = cmd.Process.Kill()
+		err = <-exited
+	}
+	s.exited(cmd, err, "stopped")
+}
+
+// exited 记录子进程退出状态及退出原因
+func (s *supervisor) exited(cmd *exec.Cmd, err error, state string) {
+	flushOutput(cmd)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uptime := time.Since(s.startedAt)
+	s.exitCode = 0
+	if code, ok := command.ExitCode(err); ok {
+		s.exitCode = code
+	}
+	s.exitReason = exitReason(cmd, err)
+	s.pid = 0
+	s.state = state
+
+	if state != "exited" {
+		return
+	}
+	s.log.Warn("Supervised process exited",
+		zap.String("name", s.spec.Name),
+		zap.String("reason", s.exitReason),
+		zap.Int("exit_code", s.exitCode),
+		zap.Duration("uptime", uptime))
+
+	if uptime >= supervisorStableAfter {
+		if s.crashLoop {
+			s.log.Info("Supervised process recovered from crash loop", zap.String("name", s.spec.Name))
+		}
+		s.quickExits = 0
+		s.crashLoop = false
+		return
+	}
+	s.quickExits++
+	s.checkCrashLoop()
+	if s.crashLoop {
+		s.state = "crash-loop"
+	}
+}
+
+// checkCrashLoop 连续快速退出次数达到阈值时进入崩溃循环，调用方需持有 s.mu
+func (s *supervisor) checkCrashLoop() {
+	if s.crashLoop || s.quickExits < supervisorCrashLoopCount {
+		return
+	}
+	s.crashLoop = true
+	s.state = "crash-loop"
+	s.log.Error("Supervised process is crash looping, slowing down restarts",
+		zap.String("name", s.spec.Name),
+		zap.Int("quick_exits", s.quickExits),
+		zap.String("last_exit_reason", s.exitReason),
+		zap.Duration("retry_interval", supervisorCrashLoopBackoff))
+}
+
+// exitReason 返回可读的退出原因，如 "exit status 1" 或 "signal: killed"
+func exitReason(cmd *exec.Cmd, err error) string {
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.String()
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return "unknown"
+}
+
+// flushOutput 输出子进程最后一行不以换行结尾的输出
+func flushOutput(cmd *exec.Cmd) {
+	for _, w := range []interface{}{cmd.Stdout, cmd.Stderr} {
+		if lw, ok := w.(*lineWriter); ok {
+			lw.Flush()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestSupervisor 返回运行command的守护服务
+func newTestSupervisor(t *testing.T, command ...string) ServiceManager {
+	t.Helper()
+	svc, err := New(KindSupervisor, Spec{Name: "test", Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// 超时后子进程被强制结束
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		if svc.Stop(ctx) != nil {
+			waitStopped(t, svc)
+		}
+	})
+	return svc
+}
+
+// waitStopped 等待守护循环退出，即 Stop 不再需要等待
+func waitStopped(t *testing.T, svc ServiceManager) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := svc.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v, want the process gone", err)
+	}
+}
+
+func TestSupervisorStopStart(t *testing.T) {
+	t.Parallel()
+
+	svc := newTestSupervisor(t, "sleep", "60")
+	ctx := context.Background()
+	if err := svc.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	status, _ := svc.Status(ctx)
+	if !status.Active || status.PID == 0 {
+		t.Fatalf("Status() = %+v, want active", status)
+	}
+
+	waitStopped(t, svc)
+	status, _ = svc.Status(ctx)
+	if status.Active || status.PID != 0 || status.State != "stopped" {
+		t.Errorf("Status() after Stop = %+v, want stopped", status)
+	}
+
+	if err := svc.Start(ctx); err != nil {
+		t.Fatalf("Start() after Stop error = %v", err)
+	}
+	if status, _ := svc.Status(ctx); !status.Active {
+		t.Errorf("Status() after restart = %+v, want active", status)
+	}
+}
+
+func TestSupervisorStopTimeoutKills(t *testing.T) {
+	t.Parallel()
+
+	// 忽略的信号在exec后仍然被忽略，sleep不会响应SIGTERM
+	svc := newTestSupervisor(t, "sh", "-c", `trap "" TERM; exec sleep 60`)
+	if err := svc.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	// 等待sh设置好信号处理
+	time.Sleep(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := svc.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop() error = %v, want deadline exceeded", err)
+	}
+
+	// 超时后子进程被强制结束，无需等待 supervisorStopTimeout
+	waitStopped(t, svc)
+	status, _ := svc.Status(context.Background())
+	if status.Active || status.PID != 0 {
+		t.Errorf("Status() = %+v, want process gone", status)
+	}
+	if err := svc.Start(context.Background()); err != nil {
+		t.Errorf("Start() after killed process error = %v", err)
+	}
+}
+
+func TestSupervisorStartWhileStopping(t *testing.T) {
+	t.Parallel()
+
+	s := newSupervisor(Spec{Name: "test", Command: []string{"sleep", "60"}}, zap.NewNop())
+	s.stop = make(chan struct{})
+	s.stopping = true
+
+	if err := s.Start(context.Background()); err == nil {
+		t.Fatal("Start() succeeded while the previous process is stopping")
+	}
+	if s.pid != 0 {
+		t.Errorf("pid = %d, want no new process", s.pid)
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	"go.uber.org/zap"
+)
+
+// systemd 通过D-Bus控制systemd单元
+type systemd struct {
+	unit string
+	log  *zap.Logger
+}
+
+func newSystemd(spec Spec, log *zap.Logger) *systemd {
+	return &systemd{unit: spec.Name + ".service", log: log}
+}
+
+// Kind 返回 systemd
+func (s *systemd) Kind() string {
+	return KindSystemd
+}
+
+// connect 建立到systemd的D-Bus连接，每次操作单独连接，避免长连接在dbus重启后失效
+func (s *systemd) connect(ctx context.Context) (*dbus.Conn, error) {
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	return conn, nil
+}
+
+// runJob 提交单元任务并等待完成
+func (s *systemd) runJob(ctx context.Context, action string, submit func(*dbus.Conn, chan<- string) (int, error)) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.log.Debug("Submitting systemd job", zap.String("unit", s.unit), zap.String("action", action))
+	result := make(chan string, 1)
+	if _, err := submit(conn, result); err != nil {
+		return fmt.Errorf("failed to %s %s: %w", action, s.unit, err)
+	}
+
+	select {
+	case done := <-result:
+		if done != "done" {
+			return fmt.Errorf("failed to %s %s: job %s", action, s.unit, done)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start 启动单元
+func (s *systemd) Start(ctx context.Context) error {
+	return s.runJob(ctx, "start", func(conn *dbus.Conn, ch chan<- string) (int, error) {
+		return conn.StartUnitContext(ctx, s.unit, "replace", ch)
+	})
+}
+
+// Stop 停止单元
+func (s *systemd) Stop(ctx context.Context) error {
+	return s.runJob(ctx, "stop", func(conn *dbus.Conn, ch chan<- string) (int, error) {
+		return conn.StopUnitContext(ctx, s.unit, "replace", ch)
+	})
+}
+
+// Restart 重启单元
+func (s *systemd) Restart(ctx context.Context) error {
+	return s.runJob(ctx, "restart", func(conn *dbus.Conn, ch chan<- string) (int, error) {
+		return conn.RestartUnitContext(ctx, s.unit, "replace", ch)
+	})
+}
+
+// Enable 启用单元并重新加载systemd配置
+func (s *systemd) Enable(ctx context.Context) error {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, _, err := conn.EnableUnitFilesContext(ctx, []string{s.unit}, false, true); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", s.unit, err)
+	}
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+	return nil
+}
+
+// Status 读取单元的ActiveState以及主进程PID、启动时间和退出码
+func (s *systemd) Status(ctx context.Context) (*Status, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	unitProps, err := conn.GetUnitPropertiesContext(ctx, s.unit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s properties: %w", s.unit, err)
+	}
+	serviceProps, err := conn.GetUnitTypePropertiesContext(ctx, s.unit, "Service")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s service properties: %w", s.unit, err)
+	}
+
+	activeState, _ := unitProps["ActiveState"].(string)
+	subState, _ := unitProps["SubState"].(string)
+	status := &Status{
+		Manager: KindSystemd,
+		Active:  activeState == "active",
+		State:   fmt.Sprintf("%s (%s)", activeState, subState),
+	}
+	if pid, ok := serviceProps["MainPID"].(uint32); ok {
+		status.PID = int(pid)
+	}
+	if code, ok := serviceProps["ExecMainStatus"].(int32); ok {
+		status.ExitCode = int(code)
+	}
+	if restarts, ok := serviceProps["NRestarts"].(uint32); ok {
+		status.Restarts = int(restarts)
+	}
+	// Result 为 success、exit-code、signal、core-dump 等
+	if result, ok := serviceProps["Result"].(string); ok && result != "success" {
+		status.LastExitReason = result
+	}
+	// 时间戳为微秒，0表示未启动
+	if usec, ok := serviceProps["ExecMainStartTimestamp"].(uint64); ok && usec > 0 && status.Active {
+		status.setStartedAt(time.UnixMicro(int64(usec)))
+	}
+	return status, nil
+}
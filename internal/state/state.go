@@ -0,0 +1,144 @@
+// Package state 持久化Agent自动生成的数据，如REALITY密钥
+// 这些数据不属于用户配置，但需要在Agent重启后保持不变，以JSON形式保存在单个文件中。
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultPath 默认的状态文件路径
+const DefaultPath = "/var/lib/aw_agent/state.json"
+
+// Store JSON状态存储，按键保存任意可序列化的值，每次修改后原子写回文件
+type Store struct {
+	mu   sync.Mutex
+	path string
+	data map[string]json.RawMessage
+}
+
+// Open 打开状态文件，文件不存在时返回空存储，首次写入时创建
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: make(map[string]json.RawMessage)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Path 返回状态文件路径
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get 读取key对应的值到v，key不存在时返回false
+func (s *Store) Get(key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.data[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("failed to decode state %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Set 保存key对应的值并写回文件
+func (s *Store) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode state %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = raw
+	return s.save()
+}
+
+// Update 读取key对应的值到v后调用fn修改v，fn返回true时保存v并写回文件
+// 读取、修改和写回期间持有存储的锁，与其他读写互斥，fn中不能再调用Store的方法。
+// key不存在时v保持调用方传入的初值；fn返回错误时不保存。
+func (s *Store) Update(key string, v interface{}, fn func() (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if raw, ok := s.data[key]; ok {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("failed to decode state %s: %w", key, err)
+		}
+	}
+	changed, err := fn()
+	if err != nil || !changed {
+		return err
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode state %s: %w", key, err)
+	}
+	s.data[key] = raw
+	if err := s.save(); err != nil {
+		return fmt.Errorf("failed to save state %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete 删除key并写回文件
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	delete(s.data, key)
+	return s.save()
+}
+
+// save 原子写回状态文件，文件包含密钥，只允许所有者读写
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := store.Set("tokens", map[string]string{"alice": "t1"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("state file = %v, %v, want mode 0600", info, err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	var tokens map[string]string
+	if ok, err := reopened.Get("tokens", &tokens); !ok || err != nil || tokens["alice"] != "t1" {
+		t.Errorf("Get() = %v, %v, %v, want saved tokens", ok, err, tokens)
+	}
+	if err := reopened.Delete("tokens"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if ok, _ := reopened.Get("tokens", &tokens); ok {
+		t.Errorf("Get() after Delete found the key")
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	t.Parallel()
+
+	store, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发的读取-修改-写回不会丢失更新
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var count int
+			if err := store.Update("count", &count, func() (bool, error) {
+				count++
+				return true, nil
+			}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var count int
+	if _, err := store.Get("count", &count); err != nil || count != 50 {
+		t.Errorf("count = %d, %v, want 50", count, err)
+	}
+
+	// 未修改或出错时不保存
+	failed := errors.New("failed")
+	for _, result := range []struct {
+		changed bool
+		err     error
+	}{{false, nil}, {true, failed}} {
+		err := store.Update("count", &count, func() (bool, error) {
+			count = 0
+			return result.changed, result.err
+		})
+		if err != result.err {
+			t.Errorf("Update() error = %v, want %v", err, result.err)
+		}
+	}
+	if _, err := store.Get("count", &count); err != nil || count != 50 {
+		t.Errorf("count = %d, %v, want unchanged 50", count, err)
+	}
+}
@@ -0,0 +1,100 @@
+package v2ray
+
+import (
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+// accessStateKey 上次检查时用户的访问状态在状态文件中的键，值为 email -> 状态
+const accessStateKey = "access"
+
+// ClientStatus 用户当前的访问状态
+type ClientStatus struct {
+	Email      string                `json:"email"`
+	Status     string                `json:"status"` // active, upcoming, expired
+	ExpiresAt  *time.Time            `json:"expires_at,omitempty"`
+	Windows    []config.AccessWindow `json:"windows,omitempty"`
+	Timezone   string                `json:"timezone,omitempty"`
+	NextChange *time.Time            `json:"next_change,omitempty"` // 状态下次变化的时间
+}
+
+// AccessTransition 用户访问状态的变化
+type AccessTransition struct {
+	Email      string    `json:"email"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	NextChange time.Time `json:"next_change"`
+}
+
+// ClientStatuses 返回所有用户在 now 的访问状态，第一个为 uuid 对应的默认用户
+func ClientStatuses(cfg config.V2RayConfig, now time.Time) []ClientStatus {
+	users := cfg.Users()
+	statuses := make([]ClientStatus, 0, len(users))
+	for _, user := range users {
+		status, next := user.AccessAt(now)
+		client := ClientStatus{
+			Email:    credentialKey(user.Email),
+			Status:   status,
+			Windows:  user.Windows,
+			Timezone: user.Timezone,
+		}
+		if !user.ExpiresAt.IsZero() {
+			expiresAt := user.ExpiresAt
+			client.ExpiresAt = &expiresAt
+		}
+		if !next.IsZero() {
+			client.NextChange = &next
+		}
+		statuses = append(statuses, client)
+	}
+	return statuses
+}
+
+// activeClients 返回只保留在 now 可以使用的用户的副本，用于生成核心配置
+func activeClients(cfg config.V2RayConfig, now time.Time) config.V2RayConfig {
+	var clients []config.ClientConfig
+	for _, client := range cfg.Clients {
+		if status, _ := client.AccessAt(now); status == config.AccessActive {
+			clients = append(clients, client)
+		}
+	}
+	cfg.Clients = clients
+	return cfg
+}
+
+// CheckClientAccess 比较用户当前与上次检查时的访问状态，返回发生的变化
+// 首次检查到的用户只记录状态，已删除用户的记录随之删除。有变化时需要重新生成核心配置。
+func CheckClientAccess(store *state.Store, cfg config.V2RayConfig) ([]AccessTransition, error) {
+	now := time.Now()
+	var transitions []AccessTransition
+	// 在store的锁内比较和保存，并发检查不会重复报告同一变化
+	previous := make(map[string]string)
+	err := store.Update(accessStateKey, &previous, func() (bool, error) {
+		current := make(map[string]string, len(cfg.Clients))
+		dirty := len(previous) != len(cfg.Clients)
+		for _, client := range cfg.Clients {
+			status, next := client.AccessAt(now)
+			current[client.Email] = status
+			from, ok := previous[client.Email]
+			if ok && from == status {
+				continue
+			}
+			dirty = true
+			if ok {
+				transitions = append(transitions, AccessTransition{Email: client.Email, From: from, To: status, NextChange: next})
+			}
+		}
+		if !dirty {
+			return false, nil
+		}
+		// 保存当前状态，已删除用户的记录随之删除
+		previous = current
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transitions, nil
+}
@@ -0,0 +1,54 @@
+package v2ray
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+func TestCheckClientAccess(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	cfg := testV2RayConfig(t)
+	cfg.Clients = []config.ClientConfig{
+		{Email: "alice@example.com", UUID: "0b5a8a2e-7f1c-4c55-9a0e-3b0f6f1e2d4c"},
+		{Email: "bob@example.com", UUID: "5d2f0c1e-8b3a-4e6f-9c7d-1a2b3c4d5e6f"},
+	}
+
+	// 首次检查只记录状态
+	transitions, err := CheckClientAccess(store, cfg)
+	if err != nil || len(transitions) != 0 {
+		t.Fatalf("CheckClientAccess() = %v, %v, want no transitions on first check", transitions, err)
+	}
+
+	// 用户过期后报告一次变化
+	cfg.Clients[0].ExpiresAt = time.Now().Add(-time.Minute)
+	transitions, err = CheckClientAccess(store, cfg)
+	if err != nil || len(transitions) != 1 {
+		t.Fatalf("CheckClientAccess() = %v, %v, want one transition", transitions, err)
+	}
+	if got := transitions[0]; got.Email != "alice@example.com" || got.From != config.AccessActive || got.To != config.AccessExpired {
+		t.Errorf("transition = %+v, want alice active -> expired", got)
+	}
+	if transitions, _ := CheckClientAccess(store, cfg); len(transitions) != 0 {
+		t.Errorf("CheckClientAccess() = %v, want the transition reported once", transitions)
+	}
+
+	// 已删除用户的记录随之删除
+	cfg.Clients = cfg.Clients[1:]
+	if _, err := CheckClientAccess(store, cfg); err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]string
+	if _, err := store.Get(accessStateKey, &saved); err != nil || len(saved) != 1 || saved["bob@example.com"] != config.AccessActive {
+		t.Errorf("saved access = %v, %v, want only bob", saved, err)
+	}
+
+	// 核心配置只包含当前可用的用户
+	cfg.Clients = append(cfg.Clients, config.ClientConfig{Email: "carol@example.com", UUID: testUUID, ExpiresAt: time.Now().Add(-time.Hour)})
+	if active := activeClients(cfg, time.Now()); len(active.Clients) != 1 || active.Clients[0].Email != "bob@example.com" {
+		t.Errorf("activeClients() = %v, want only bob", active.Clients)
+	}
+}
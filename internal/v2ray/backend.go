@@ -0,0 +1,96 @@
+// Package v2ray 部署和管理代理核心（V2Ray、Xray、sing-box），各核心通过 ProxyBackend 接入
+package v2ray
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"go.uber.org/zap"
+)
+
+// 支持的代理核心
+const (
+	BackendV2Ray   = "v2ray"
+	BackendXray    = "xray"
+	BackendSingBox = "sing-box"
+)
+
+// ProxyBackend 代理核心，负责安装、生成配置、校验、服务描述和流量统计
+// 部署、状态查询、版本切换和空闲检测等流程只通过该接口与具体核心交互，
+// 所有核心共用 v2ray 配置段中的入站和用户模型。
+type ProxyBackend interface {
+	// Name 返回核心名称，如 xray
+	Name() string
+	// BinaryPath 返回核心二进制的安装路径
+	BinaryPath() string
+	// ConfigPath 返回核心配置文件路径
+	ConfigPath() string
+	// LogDir 返回核心日志目录，写入配置时确保存在
+	LogDir() string
+	// AssetDir 返回 geoip.dat、geosite.dat 所在目录，不使用geo数据文件的核心返回空
+	AssetDir() string
+	// ServiceSpec 返回核心的服务描述
+	ServiceSpec() service.Spec
+	// Validate 检查配置中使用的功能是否被该核心支持，返回 *config.ValidationError
+	Validate(cfg config.V2RayConfig) error
+	// RenderConfig 按共用配置模型生成核心的配置文件内容
+	RenderConfig(cfg config.V2RayConfig) ([]byte, error)
+	// Install 获取并校验发布包，安装二进制、数据文件和服务文件
+	Install(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig, serviceKind string) error
+	// StageBinary 获取并校验发布包，只将二进制解压到dst，用于版本切换
+	StageBinary(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig, dst string) error
+	// Version 执行指定二进制获取版本号，失败时返回 "unknown"
+	Version(ctx context.Context, log *zap.Logger, runner command.Runner, binary string) string
+	// TestConfig 使用指定二进制校验配置文件
+	TestConfig(ctx context.Context, runner command.Runner, binary string, configPath string) error
+	// Stats 返回最近的流量活动
+	Stats(ctx context.Context, cfg config.V2RayConfig) (*TrafficStats, error)
+	// UserTraffic 返回上次查询以来每个用户的流量并清零计数，不支持时返回 ErrUserTrafficUnsupported
+	UserTraffic(ctx context.Context, runner command.Runner, cfg config.V2RayConfig) (map[string]UserTraffic, error)
+	// CheckOutbound 经指定的上游出站请求 v2ray.health_check.url，返回耗时，检查流量不计入 Stats
+	CheckOutbound(ctx context.Context, cfg config.V2RayConfig, tag string) (time.Duration, error)
+}
+
+// backends 已注册的代理核心，每次调用返回新的实例，不同Agent之间不共享状态
+var backends = map[string]func() ProxyBackend{
+	BackendV2Ray:   func() ProxyBackend { return v2rayCore.clone() },
+	BackendXray:    func() ProxyBackend { return xrayCore.clone() },
+	BackendSingBox: newSingBox,
+}
+
+// NewBackend 按名称返回代理核心，名称为空时使用V2Ray
+func NewBackend(name string) (ProxyBackend, error) {
+	if name == "" {
+		name = BackendV2Ray
+	}
+	newBackend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown proxy backend %q", name)
+	}
+	return newBackend(), nil
+}
+
+// Backends 返回所有支持的代理核心名称
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateConfig 检查所选核心是否支持配置中的功能，用作 config.WithValidator
+// 未知的核心名称由配置本身的校验报告，这里不再重复。
+func ValidateConfig(cfg *config.Config) error {
+	backend, err := NewBackend(cfg.Backend)
+	if err != nil {
+		return nil
+	}
+	return backend.Validate(cfg.V2Ray)
+}
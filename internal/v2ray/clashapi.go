@@ -0,0 +1,120 @@
+package v2ray
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+// clashAPITimeout 查询Clash API的超时时间
+const clashAPITimeout = 5 * time.Second
+
+// clashConnections Clash API /connections 的响应，只包含统计需要的字段
+type clashConnections struct {
+	DownloadTotal int64             `json:"downloadTotal"`
+	UploadTotal   int64             `json:"uploadTotal"`
+	Connections   []json.RawMessage `json:"connections"`
+}
+
+// clashSecretStateKey Clash API访问密钥在状态文件中的键
+const clashSecretStateKey = "clash_api.secret"
+
+// ApplyClashSecret 返回填入Clash API访问密钥的配置副本，只有sing-box使用。
+// 密钥首次使用时随机生成并保存在状态文件中，不随UUID轮换变化，避免统计和延迟测试使用与核心不同的密钥。
+func ApplyClashSecret(store *state.Store, backend ProxyBackend, cfg config.V2RayConfig) (config.V2RayConfig, error) {
+	if store == nil || backend.Name() != BackendSingBox || cfg.ClashSecret != "" {
+		return cfg, nil
+	}
+	var secret string
+	err := store.Update(clashSecretStateKey, &secret, func() (bool, error) {
+		if secret != "" {
+			return false, nil
+		}
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return false, fmt.Errorf("failed to generate clash api secret: %w", err)
+		}
+		secret = hex.EncodeToString(b)
+		return true, nil
+	})
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ClashSecret = secret
+	return cfg, nil
+}
+
+// queryClashConnections 查询当前连接和累计流量
+func queryClashConnections(ctx context.Context, address string, secret string) (*clashConnections, error) {
+	ctx, cancel := context.WithTimeout(ctx, clashAPITimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+"/connections", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from clash api", resp.Status)
+	}
+	var connections clashConnections
+	if err := json.NewDecoder(resp.Body).Decode(&connections); err != nil {
+		return nil, fmt.Errorf("failed to decode clash api response: %w", err)
+	}
+	return &connections, nil
+}
+
+// clashDelay Clash API /proxies/{name}/delay 的响应，失败时只有 message
+type clashDelay struct {
+	Delay   int64  `json:"delay"`
+	Message string `json:"message"`
+}
+
+// queryClashDelay 让sing-box经指定出站请求检查URL，返回延迟
+func queryClashDelay(ctx context.Context, address string, secret string, tag string, check config.HealthCheckConfig) (time.Duration, error) {
+	timeout := check.Timeout.Std()
+	ctx, cancel := context.WithTimeout(ctx, timeout+clashAPITimeout)
+	defer cancel()
+
+	query := url.Values{
+		"url":     {check.URL},
+		"timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)},
+	}
+	endpoint := "http://" + address + "/proxies/" + url.PathEscape(tag) + "/delay?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var delay clashDelay
+	if err := json.NewDecoder(resp.Body).Decode(&delay); err != nil {
+		return 0, fmt.Errorf("failed to decode clash api response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("delay test failed with status %s: %s", resp.Status, delay.Message)
+	}
+	return time.Duration(delay.Delay) * time.Millisecond, nil
+}
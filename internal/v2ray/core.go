@@ -0,0 +1,310 @@
+package v2ray
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"go.uber.org/zap"
+)
+
+// coreBackend V2Ray和Xray共用的实现，两者的发布包结构、配置格式和运行方式基本一致
+type coreBackend struct {
+	name          string
+	title         string // 用于服务描述，如 "Xray"
+	documentation string
+	binaryPath    string
+	configPath    string
+	assetDir      string // geoip.dat、geosite.dat
+	assetEnv      string // 指定geo文件目录的环境变量
+	logDir        string // error.log 所在目录
+	releaseURL    string // 默认发布地址
+	assetPrefix   string // 发布包文件名前缀，如 "Xray-linux-"
+	archiveBinary string // 发布包中的二进制文件名
+
+	versionPattern *regexp.Regexp
+	versionArgs    [][]string // 依次尝试的获取版本参数
+	testArgs       [][]string // 依次尝试的配置校验参数，末尾追加配置文件路径
+	statsArgs      []string   // 查询并清零用户流量计数的参数，末尾追加统计API地址
+
+	vision          bool // 是否支持VLESS的 xtls-rprx-vision 流控
+	shadowsocks2022 bool // 是否支持 2022-blake3-* 加密方式
+	reality         bool // 是否支持REALITY
+	wireguard       bool // 是否支持WireGuard出站
+}
+
+// clone 返回核心描述的副本
+func (b *coreBackend) clone() *coreBackend {
+	c := *b
+	return &c
+}
+
+// v2rayCore V2Ray（v2fly）核心
+var v2rayCore = &coreBackend{
+	name:          BackendV2Ray,
+	title:         "V2Ray",
+	documentation: "https://www.v2fly.org/",
+	binaryPath:    "/usr/local/bin/v2ray",
+	configPath:    "/usr/local/etc/v2ray/config.json",
+	assetDir:      "/usr/local/share/v2ray",
+	assetEnv:      "V2RAY_LOCATION_ASSET",
+	logDir:        "/var/log/v2ray",
+	releaseURL:    "https://github.com/v2fly/v2ray-core/releases",
+	assetPrefix:   "v2ray-linux-",
+	archiveBinary: "v2ray",
+	// 如 "V2Ray 5.16.1 (V2Fly, a community-driven edition of V2Ray.) ..."
+	versionPattern: regexp.MustCompile(`V2Ray\s+v?(\S+)`),
+	// v5使用子命令，v4使用 -version/-test 参数
+	versionArgs: [][]string{{"version"}, {"-version"}},
+	testArgs:    [][]string{{"test", "-config"}, {"-test", "-config"}},
+	statsArgs:   []string{"api", "stats", "-json", "-reset", "-s"},
+}
+
+// xrayCore Xray核心
+var xrayCore = &coreBackend{
+	name:          BackendXray,
+	title:         "Xray",
+	documentation: "https://xtls.github.io/",
+	binaryPath:    "/usr/local/bin/xray",
+	configPath:    "/usr/local/etc/xray/config.json",
+	assetDir:      "/usr/local/share/xray",
+	assetEnv:      "XRAY_LOCATION_ASSET",
+	logDir:        "/var/log/xray",
+	releaseURL:    "https://github.com/XTLS/Xray-core/releases",
+	assetPrefix:   "Xray-linux-",
+	archiveBinary: "xray",
+	// 如 "Xray 1.8.24 (Xray, Penetrates Everything.) ..."
+	versionPattern:  regexp.MustCompile(`Xray\s+v?(\S+)`),
+	versionArgs:     [][]string{{"version"}},
+	testArgs:        [][]string{{"run", "-test", "-config"}},
+	statsArgs:       []string{"api", "statsquery", "-pattern", "user>>>", "-reset", "-s"},
+	vision:          true,
+	shadowsocks2022: true,
+	reality:         true,
+	wireguard:       true,
+}
+
+// Name 返回核心名称
+func (b *coreBackend) Name() string {
+	return b.name
+}
+
+// BinaryPath 返回二进制安装路径
+func (b *coreBackend) BinaryPath() string {
+	return b.binaryPath
+}
+
+// ConfigPath 返回配置文件路径
+func (b *coreBackend) ConfigPath() string {
+	return b.configPath
+}
+
+// LogDir 返回 error.log 所在目录
+func (b *coreBackend) LogDir() string {
+	return b.logDir
+}
+
+// AssetDir 返回geo数据文件目录
+func (b *coreBackend) AssetDir() string {
+	return b.assetDir
+}
+
+// ServiceSpec 返回服务描述，supervisor方式下直接运行核心二进制
+func (b *coreBackend) ServiceSpec() service.Spec {
+	return service.Spec{
+		Name:    b.name,
+		Command: []string{b.binaryPath, "run", "-config", b.configPath},
+		Env:     []string{b.assetEnv + "=" + b.assetDir},
+	}
+}
+
+// Validate 检查配置中的入站是否使用了核心不支持的功能
+func (b *coreBackend) Validate(cfg config.V2RayConfig) error {
+	problems := &config.ValidationError{}
+	unsupported := func(key string, value string, alternative string) {
+		problems.Problems = append(problems.Problems,
+			fmt.Sprintf("%s %q is not supported by backend %s, use backend %s", key, value, b.name, alternative))
+	}
+	for i, inbound := range cfg.Inbounds {
+		key := fmt.Sprintf("v2ray.inbounds[%d]", i)
+		switch inbound.Protocol {
+		case "hysteria2", "tuic":
+			unsupported(key+".protocol", inbound.Protocol, BackendSingBox)
+		}
+		if inbound.Security == "reality" && !b.reality {
+			unsupported(key+".security", inbound.Security, BackendXray)
+		}
+		if inbound.Flow != "" && !b.vision {
+			unsupported(key+".flow", inbound.Flow, BackendXray)
+		}
+		if strings.HasPrefix(inbound.Method, "2022-") && !b.shadowsocks2022 {
+			unsupported(key+".method", inbound.Method, BackendXray)
+		}
+	}
+	for i, server := range cfg.DNS.Servers {
+		if strings.HasPrefix(server.Address, "tls://") {
+			unsupported(fmt.Sprintf("v2ray.dns.servers[%d].address", i), server.Address, BackendSingBox)
+		}
+	}
+	for i, outbound := range cfg.Outbounds {
+		key := fmt.Sprintf("v2ray.outbounds[%d]", i)
+		if outbound.Protocol == "wireguard" && !b.wireguard {
+			unsupported(key+".protocol", outbound.Protocol, BackendXray)
+		}
+		if outbound.Flow != "" && !b.vision {
+			unsupported(key+".flow", outbound.Flow, BackendXray)
+		}
+	}
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// RenderConfig 生成V2Ray/Xray的JSON配置
+func (b *coreBackend) RenderConfig(cfg config.V2RayConfig) ([]byte, error) {
+	return renderCoreConfig(cfg, filepath.Join(b.logDir, "error.log"))
+}
+
+// Install 安装二进制、geo文件和服务文件
+// 发布包来自 v2ray.install.archive 指定的本地文件，或从发布地址下载。
+// serviceKind 决定安装systemd单元还是OpenRC脚本，supervisor方式不需要服务文件。
+func (b *coreBackend) Install(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig, serviceKind string) error {
+	archive, cleanup, err := b.fetch(ctx, log, cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open %s archive: %w", b.name, err)
+	}
+	defer reader.Close()
+
+	// 二进制
+	if err := installZipEntry(reader, b.archiveBinary, b.binaryPath, 0755); err != nil {
+		return err
+	}
+	log.Info("Proxy binary installed", zap.String("path", b.binaryPath))
+
+	// geo数据文件
+	for _, name := range geoFiles {
+		path := filepath.Join(b.assetDir, name)
+		if err := installZipEntry(reader, name, path, 0644); err != nil {
+			return err
+		}
+		log.Info("Geo file installed", zap.String("path", path))
+	}
+
+	return installServiceFiles(log, serviceUnit{
+		Spec:          b.ServiceSpec(),
+		Description:   b.title + " Service",
+		Documentation: b.documentation,
+		LogDir:        b.logDir,
+	}, serviceKind)
+}
+
+// StageBinary 只将发布包中的二进制解压到dst
+func (b *coreBackend) StageBinary(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig, dst string) error {
+	archive, cleanup, err := b.fetch(ctx, log, cfg)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open %s archive: %w", b.name, err)
+	}
+	defer reader.Close()
+	return installZipEntry(reader, b.archiveBinary, dst, 0755)
+}
+
+// fetch 获取并校验当前平台的发布包
+func (b *coreBackend) fetch(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig) (string, func(), error) {
+	arch, err := releaseArch()
+	if err != nil {
+		return "", func() {}, err
+	}
+	base := cfg.Install.ReleaseURL
+	if base == "" {
+		base = b.releaseURL
+	}
+	archiveURL := releaseURL(base, cfg.Version, b.assetPrefix+arch+".zip")
+	archive, expected, cleanup, err := fetchRelease(ctx, log, cfg, archiveURL, ".dgst")
+	if err != nil {
+		return "", func() {}, err
+	}
+	if err := verifyRelease(log, archive, expected, cfg.Install.SkipVerify); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return archive, cleanup, nil
+}
+
+// Version 执行二进制获取版本号，失败时返回 "unknown"
+func (b *coreBackend) Version(ctx context.Context, log *zap.Logger, runner command.Runner, binary string) string {
+	for _, args := range b.versionArgs {
+		output, err := runner.Run(ctx, binary, args...)
+		if err != nil {
+			log.Debug("Failed to get proxy version", zap.Strings("args", args), zap.Error(err))
+			continue
+		}
+		if match := b.versionPattern.FindSubmatch(output); match != nil {
+			return string(match[1])
+		}
+	}
+	log.Warn("Failed to detect proxy version", zap.String("path", binary))
+	return "unknown"
+}
+
+// TestConfig 使用指定二进制校验配置文件，依次尝试各版本的参数格式
+func (b *coreBackend) TestConfig(ctx context.Context, runner command.Runner, binary string, configPath string) error {
+	var firstErr error
+	var firstOutput []byte
+	for _, args := range b.testArgs {
+		output, err := runner.Run(ctx, binary, append(append([]string{}, args...), configPath)...)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr, firstOutput = err, output
+		}
+	}
+	return fmt.Errorf("%w: %s", firstErr, strings.TrimSpace(string(firstOutput)))
+}
+
+// Stats 以访问日志的修改时间作为最后活动时间
+func (b *coreBackend) Stats(ctx context.Context, cfg config.V2RayConfig) (*TrafficStats, error) {
+	return accessLogStats(cfg.AccessLog)
+}
+
+// UserTraffic 通过核心的 api 子命令查询统计API，未配置 v2ray.stats_api 时不支持
+func (b *coreBackend) UserTraffic(ctx context.Context, runner command.Runner, cfg config.V2RayConfig) (map[string]UserTraffic, error) {
+	if cfg.StatsAPI == "" {
+		return nil, ErrUserTrafficUnsupported
+	}
+	output, err := runner.Run(ctx, b.binaryPath, append(append([]string{}, b.statsArgs...), cfg.StatsAPI)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s stats: %w: %s", b.name, err, strings.TrimSpace(string(output)))
+	}
+	return parseUserTraffic(output)
+}
+
+// CheckOutbound 经出站对应的本地SOCKS入站请求检查URL
+func (b *coreBackend) CheckOutbound(ctx context.Context, cfg config.V2RayConfig, tag string) (time.Duration, error) {
+	for _, probe := range healthProbes(cfg) {
+		if probe.Outbound == tag {
+			return checkViaSOCKS(ctx, probe.Port, cfg.HealthCheck)
+		}
+	}
+	return 0, fmt.Errorf("outbound %q is not an upstream outbound", tag)
+}
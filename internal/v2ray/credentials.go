@@ -0,0 +1,239 @@
+package v2ray
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+// credentialsStateKey 轮换后的用户凭据在状态文件中的键，值为 email -> Credential，默认用户使用 DefaultUserEmail
+const credentialsStateKey = "credentials"
+
+// ErrUserNotFound 轮换凭据的用户不存在
+var ErrUserNotFound = errors.New("user not found")
+
+// Credential 用户轮换后的凭据，覆盖配置中的UUID
+// Base 为轮换时配置中的UUID，配置中的UUID此后被修改时以配置为准，轮换结果不再生效。
+type Credential struct {
+	UUID      string               `json:"uuid"`
+	Base      string               `json:"base"`
+	RotatedAt time.Time            `json:"rotated_at"`
+	Previous  []PreviousCredential `json:"previous,omitempty"`
+}
+
+// PreviousCredential 轮换前的UUID，到期前仍可使用
+type PreviousCredential struct {
+	UUID      string    `json:"uuid"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RotationResult 单个用户的轮换结果，不包含凭据本身
+type RotationResult struct {
+	Email             string    `json:"email"`
+	RotatedAt         time.Time `json:"rotated_at"`
+	PreviousExpiresAt time.Time `json:"previous_expires_at"` // 旧UUID失效的时间
+}
+
+// credentialKey 返回用户在状态文件中的键
+func credentialKey(email string) string {
+	if email == "" {
+		return DefaultUserEmail
+	}
+	return email
+}
+
+// loadCredentials 读取所有用户轮换后的凭据
+func loadCredentials(store *state.Store) (map[string]Credential, error) {
+	credentials := make(map[string]Credential)
+	if _, err := store.Get(credentialsStateKey, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// ApplyCredentials 返回用轮换后的UUID替换配置中UUID的副本，用于生成分享链接和订阅
+func ApplyCredentials(store *state.Store, cfg config.V2RayConfig) (config.V2RayConfig, error) {
+	credentials, err := loadCredentials(store)
+	if err != nil {
+		return cfg, err
+	}
+	return applyCredentials(cfg, credentials, time.Time{}), nil
+}
+
+// resolveCredentials 返回供生成核心配置使用的副本，重叠期内的旧UUID作为额外用户加入
+func resolveCredentials(store *state.Store, cfg config.V2RayConfig) (config.V2RayConfig, error) {
+	if store == nil {
+		return cfg, nil
+	}
+	credentials, err := loadCredentials(store)
+	if err != nil {
+		return cfg, err
+	}
+	return applyCredentials(cfg, credentials, time.Now()), nil
+}
+
+// applyCredentials 替换为轮换后的UUID；now 不为零时追加在now仍有效的旧UUID
+// 旧UUID的email为 "<email>#previous<n>"，核心要求同一入站中的email不重复；出站映射与原用户相同。
+func applyCredentials(cfg config.V2RayConfig, credentials map[string]Credential, now time.Time) config.V2RayConfig {
+	var previous []config.ClientConfig
+	resolve := func(user config.ClientConfig) config.ClientConfig {
+		credential, ok := credentials[credentialKey(user.Email)]
+		if !ok || credential.Base != user.UUID {
+			return user
+		}
+		if !now.IsZero() {
+			for i, old := range unexpiredCredentials(credential.Previous, now) {
+				previous = append(previous, config.ClientConfig{
+					Email:    fmt.Sprintf("%s#previous%d", credentialKey(user.Email), i+1),
+					UUID:     old.UUID,
+					Outbound: user.Outbound,
+				})
+			}
+		}
+		user.UUID = credential.UUID
+		return user
+	}
+
+	cfg.UUID = resolve(config.ClientConfig{UUID: cfg.UUID}).UUID
+	clients := make([]config.ClientConfig, len(cfg.Clients))
+	for i, client := range cfg.Clients {
+		clients[i] = resolve(client)
+	}
+	cfg.Clients = append(clients, previous...)
+	return cfg
+}
+
+// RotateCredentials 为用户生成新的UUID，当前UUID在 overlap 内继续有效
+// email 为 DefaultUserEmail 时轮换 uuid 对应的默认用户。
+func RotateCredentials(store *state.Store, cfg config.V2RayConfig, email string, overlap time.Duration) (*RotationResult, error) {
+	user, ok := FindUser(cfg, email)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, email)
+	}
+
+	// 在store的锁内读取和保存，与其他轮换互斥
+	key := credentialKey(user.Email)
+	credentials := make(map[string]Credential)
+	var credential Credential
+	err := store.Update(credentialsStateKey, &credentials, func() (bool, error) {
+		var err error
+		credential, err = rotateCredential(credentials[key], user.UUID, overlap, time.Now())
+		if err != nil {
+			return false, err
+		}
+		credentials[key] = credential
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rotationResult(key, credential, overlap), nil
+}
+
+// RotateDueCredentials 轮换距上次轮换已超过 interval 的用户，并清理过期的旧UUID
+// 返回轮换结果，以及核心中的用户是否需要更新。interval 为0时只清理；
+// 从未轮换过的用户从首次检查时开始计时，已删除用户的记录随之删除。
+func RotateDueCredentials(store *state.Store, cfg config.V2RayConfig, interval time.Duration, overlap time.Duration) ([]RotationResult, bool, error) {
+	now := time.Now()
+	var results []RotationResult
+	changed := false
+	// 在store的锁内读取和保存，与其他轮换互斥
+	credentials := make(map[string]Credential)
+	err := store.Update(credentialsStateKey, &credentials, func() (bool, error) {
+		dirty := false
+		users := make(map[string]bool)
+		for _, user := range cfg.Users() {
+			key := credentialKey(user.Email)
+			users[key] = true
+			credential, ok := credentials[key]
+
+			switch {
+			case !ok || credential.Base != user.UUID:
+				// 未轮换过或配置中的UUID已修改，轮换结果本就不生效，只重新开始计时
+				if interval > 0 || ok {
+					credentials[key] = Credential{UUID: user.UUID, Base: user.UUID, RotatedAt: now}
+					dirty = true
+				}
+			case interval > 0 && now.Sub(credential.RotatedAt) >= interval:
+				rotated, err := rotateCredential(credential, user.UUID, overlap, now)
+				if err != nil {
+					return false, err
+				}
+				credentials[key] = rotated
+				results = append(results, *rotationResult(key, rotated, overlap))
+				changed = true
+			default:
+				if kept := unexpiredCredentials(credential.Previous, now); len(kept) != len(credential.Previous) {
+					credential.Previous = kept
+					credentials[key] = credential
+					changed = true
+				}
+			}
+		}
+		for key := range credentials {
+			if !users[key] {
+				delete(credentials, key)
+				dirty = true
+			}
+		}
+		return changed || dirty, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return results, changed, nil
+}
+
+// rotateCredential 生成新的UUID，当前UUID加入旧UUID列表并同时清理已过期的旧UUID
+func rotateCredential(credential Credential, base string, overlap time.Duration, now time.Time) (Credential, error) {
+	current := base
+	var previous []PreviousCredential
+	if credential.Base == base && credential.UUID != "" {
+		current = credential.UUID
+		previous = unexpiredCredentials(credential.Previous, now)
+	}
+	if overlap > 0 {
+		previous = append(previous, PreviousCredential{UUID: current, ExpiresAt: now.Add(overlap)})
+	}
+
+	uuid, err := newUUID()
+	if err != nil {
+		return Credential{}, err
+	}
+	return Credential{UUID: uuid, Base: base, RotatedAt: now, Previous: previous}, nil
+}
+
+// rotationResult 返回轮换结果
+func rotationResult(email string, credential Credential, overlap time.Duration) *RotationResult {
+	return &RotationResult{
+		Email:             email,
+		RotatedAt:         credential.RotatedAt,
+		PreviousExpiresAt: credential.RotatedAt.Add(overlap),
+	}
+}
+
+// unexpiredCredentials 返回在now仍有效的旧UUID
+func unexpiredCredentials(previous []PreviousCredential, now time.Time) []PreviousCredential {
+	var kept []PreviousCredential
+	for _, old := range previous {
+		if now.Before(old.ExpiresAt) {
+			kept = append(kept, old)
+		}
+	}
+	return kept
+}
+
+// newUUID 生成随机（第4版）UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate uuid: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
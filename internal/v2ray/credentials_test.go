@@ -0,0 +1,72 @@
+package v2ray
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotateCredentialsConcurrent(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	cfg := testV2RayConfig(t)
+
+	// 并发轮换不会丢失任何一次轮换，每次轮换前的UUID都在重叠期内有效
+	const rotations = 10
+	var wg sync.WaitGroup
+	for range rotations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := RotateCredentials(store, cfg, DefaultUserEmail, time.Hour); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	credentials, err := loadCredentials(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := credentials[DefaultUserEmail]
+	if len(credential.Previous) != rotations || credential.Previous[0].UUID != testUUID {
+		t.Fatalf("previous credentials = %d, want %d starting with the configured UUID", len(credential.Previous), rotations)
+	}
+
+	applied, err := ApplyCredentials(store, cfg)
+	if err != nil || applied.UUID != credential.UUID || applied.UUID == testUUID {
+		t.Errorf("ApplyCredentials() uuid changed = %v, %v, want the rotated UUID", applied.UUID != testUUID, err)
+	}
+	resolved, err := resolveCredentials(store, cfg)
+	if err != nil || len(resolved.Clients) != rotations {
+		t.Errorf("resolveCredentials() clients = %d, %v, want %d previous UUIDs", len(resolved.Clients), err, rotations)
+	}
+}
+
+func TestRotateDueCredentials(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	cfg := testV2RayConfig(t)
+
+	// 首次检查只开始计时
+	results, changed, err := RotateDueCredentials(store, cfg, time.Hour, time.Hour)
+	if err != nil || changed || len(results) != 0 {
+		t.Fatalf("RotateDueCredentials() = %v, %v, %v, want only timer started", results, changed, err)
+	}
+
+	// 到期后轮换
+	results, changed, err = RotateDueCredentials(store, cfg, time.Nanosecond, time.Hour)
+	if err != nil || !changed || len(results) != 1 || results[0].Email != DefaultUserEmail {
+		t.Fatalf("RotateDueCredentials() = %v, %v, %v, want default user rotated", results, changed, err)
+	}
+
+	// 配置中的UUID修改后轮换结果不再生效
+	cfg.UUID = "0b5a8a2e-7f1c-4c55-9a0e-3b0f6f1e2d4c"
+	applied, err := ApplyCredentials(store, cfg)
+	if err != nil || applied.UUID != cfg.UUID {
+		t.Errorf("ApplyCredentials() = %s, %v, want the configured UUID", applied.UUID, err)
+	}
+}
@@ -0,0 +1,52 @@
+package v2ray
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+// fallbackSection V2Ray/Xray VLESS/Trojan入站的回落目标，不匹配代理协议的流量转发到dest
+type fallbackSection struct {
+	Dest string `json:"dest"`
+}
+
+// singBoxFallback sing-box Trojan入站的回落目标
+type singBoxFallback struct {
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+}
+
+// decoyAddress 返回入站回落到伪装站点的地址，未启用伪装站点或入站不支持回落时返回false
+// 伪装站点监听所有地址时经本机回环地址连接。
+func decoyAddress(cfg config.V2RayConfig, inbound config.InboundConfig) (string, int, bool) {
+	if !cfg.Decoy.Enabled || !inbound.AcceptsFallback() {
+		return "", 0, false
+	}
+	// listen 已校验为 host:port
+	host, port, _ := net.SplitHostPort(cfg.Decoy.Listen)
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	n, _ := strconv.Atoi(port)
+	return host, n, true
+}
+
+// renderFallbacks 生成V2Ray/Xray入站的回落配置
+func renderFallbacks(cfg config.V2RayConfig, inbound config.InboundConfig) []fallbackSection {
+	host, port, ok := decoyAddress(cfg, inbound)
+	if !ok {
+		return nil
+	}
+	return []fallbackSection{{Dest: net.JoinHostPort(host, strconv.Itoa(port))}}
+}
+
+// renderSingBoxFallback 生成sing-box入站的回落配置，sing-box只有Trojan入站支持回落
+func renderSingBoxFallback(cfg config.V2RayConfig, inbound config.InboundConfig) *singBoxFallback {
+	host, port, ok := decoyAddress(cfg, inbound)
+	if !ok || inbound.Protocol != "trojan" {
+		return nil
+	}
+	return &singBoxFallback{Server: host, ServerPort: port}
+}
@@ -1,299 +1,186 @@
 package v2ray
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/yuhai94/anywhere_agent/internal/logger"
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"github.com/yuhai94/anywhere_agent/internal/state"
 	"go.uber.org/zap"
 )
 
-// DeployStatus V2Ray部署状态
+// DeployStatus 代理核心部署状态
 type DeployStatus struct {
-	Installed bool   `json:"installed"`
-	Running   bool   `json:"running"`
-	Version   string `json:"version"`
-	Progress  int    `json:"progress"`
-	Message   string `json:"message"`
+	Backend         string `json:"backend"`
+	Installed       bool   `json:"installed"`
+	Running         bool   `json:"running"`
+	Version         string `json:"version"`
+	PreviousVersion string `json:"previous_version,omitempty"` // 升级前保留的版本，可用于回滚
+	Progress        int    `json:"progress"`
+	Message         string `json:"message"`
+
+	Service *service.Status `json:"service,omitempty"`
+	Process *ProcessInfo    `json:"process,omitempty"`
 }
 
-// CheckV2Ray 检查V2Ray是否已安装
-func CheckV2Ray() (bool, string, error) {
-	// 1. 使用ps命令检查v2ray进程是否存在
-	psCmd := exec.Command("bash", "-c", "ps aux | grep -v grep | grep v2ray")
-	logger.Debug("Executing command", zap.String("command", psCmd.String()))
-	psOutput, err := psCmd.CombinedOutput()
-	logger.Debug("ps command output",
-		zap.String("output", string(psOutput)),
-		zap.Error(err))
-	if err != nil {
-		// 检查是否存在v2ray进程
-		if strings.Contains(err.Error(), "exit status 1") {
-			// grep没有找到匹配，即没有v2ray进程
-			logger.Info("No V2Ray process found")
+// CheckInstalled 按二进制路径检查代理核心是否已安装，返回是否已安装及版本
+// 是否正在运行由 IsRunning 单独检查。
+func CheckInstalled(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend) (bool, string, error) {
+	binary := backend.BinaryPath()
+	if _, err := os.Stat(binary); err != nil {
+		if os.IsNotExist(err) {
+			log.Info("Proxy binary not found", zap.String("path", binary))
 			return false, "", nil
 		}
-		return false, "", fmt.Errorf("failed to check v2ray process: %w", err)
-	}
-	logger.Info("V2Ray process found")
-
-	// 2. 如果有进程，尝试获取版本信息
-	var version string
-	versionCmd := exec.Command("v2ray", "--version")
-	logger.Debug("Executing command", zap.String("command", versionCmd.String()))
-	versionOutput, err := versionCmd.CombinedOutput()
-	logger.Debug("v2ray version command output",
-		zap.String("output", string(versionOutput)),
-		zap.Error(err))
-	if err == nil {
-		version = strings.TrimSpace(string(versionOutput))
-		logger.Info("V2Ray version detected", zap.String("version", version))
-	} else {
-		version = "unknown"
-		logger.Warn("Failed to get V2Ray version", zap.Error(err))
+		return false, "", fmt.Errorf("failed to check %s binary: %w", backend.Name(), err)
 	}
 
-	// 3. 检查是否正在运行
-	isRunning := IsV2RayRunning()
-	logger.Info("V2Ray status check completed",
-		zap.Bool("running", isRunning),
+	version := backend.Version(ctx, log, runner, binary)
+	log.Info("Proxy installed",
+		zap.String("path", binary),
 		zap.String("version", version))
-
-	return isRunning, version, nil
+	return true, version, nil
 }
 
-// DeployV2Ray 部署V2Ray
-func DeployV2Ray(port int, uuid string, accessLogPath string) (*DeployStatus, error) {
-	// 创建一个默认的stopChan，不支持取消
-	// 这个版本保留向后兼容，实际使用中应该调用带stopChan参数的版本
-	stopChan := make(chan struct{})
-	return DeployV2RayWithContext(port, uuid, accessLogPath, stopChan)
-}
-
-// DeployV2RayWithContext 部署V2Ray，支持通过stopChan取消部署
-func DeployV2RayWithContext(port int, uuid string, accessLogPath string, stopChan <-chan struct{}) (*DeployStatus, error) {
-	logger.Info("Starting V2Ray deployment",
-		zap.Int("port", port),
-		zap.String("uuid", uuid[:8]+"..."), // 只显示UUID前8位
-		zap.String("access_log", accessLogPath))
+// Deploy 部署代理核心，ctx取消时中止部署并终止正在执行的命令
+// 部署被取消时返回当前进度和 ctx.Err()。
+// REALITY入站的密钥从store读取，首次部署时生成并保存。
+func Deploy(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend, svc service.ServiceManager, store *state.Store, cfg config.V2RayConfig) (*DeployStatus, error) {
+	log.Info("Starting proxy deployment",
+		zap.String("backend", backend.Name()),
+		zap.Int("port", cfg.Port),
+		zap.String("uuid", cfg.UUID[:8]+"..."), // 只显示UUID前8位
+		zap.Int("clients", len(cfg.Clients)),
+		zap.Int("inbounds", len(cfg.EffectiveInbounds())),
+		zap.String("access_log", cfg.AccessLog))
 
 	status := &DeployStatus{
+		Backend:  backend.Name(),
 		Progress: 0,
-		Message:  "Starting V2Ray deployment",
+		Message:  "Starting deployment",
 	}
 
-	// 检查是否已收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
+	// canceled 检查部署是否已被取消
+	canceled := func() bool {
+		if ctx.Err() == nil {
+			return false
+		}
+		log.Info("Proxy deployment canceled", zap.Error(ctx.Err()))
 		status.Message = "Deployment canceled"
-		return status, nil
-	default:
-		// 继续执行
+		return true
 	}
 
 	// 检查是否已安装
-	installed, version, err := CheckV2Ray()
+	installed, version, err := CheckInstalled(ctx, log, runner, backend)
+	if canceled() {
+		return status, ctx.Err()
+	}
 	if err != nil {
-		logger.Error("Failed to check V2Ray installation", zap.Error(err))
+		log.Error("Failed to check proxy installation", zap.Error(err))
 		return status, err
 	}
 
-	// 检查是否已收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
-		status.Message = "Deployment canceled"
-		return status, nil
-	default:
-		// 继续执行
-	}
-
 	if installed {
 		status.Installed = true
 		status.Version = version
-		status.Progress = 100
-		status.Message = "V2Ray already installed"
-		logger.Info("V2Ray already installed", zap.String("version", version))
-		return status, nil
-	}
-
-	// 1. 直接执行V2Ray安装脚本
-	status.Progress = 20
-	status.Message = "Downloading and installing V2Ray"
-	logger.Info("Downloading and installing V2Ray")
-
-	// 检查是否已收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
-		status.Message = "Deployment canceled"
-		return status, nil
-	default:
-		// 继续执行
-	}
-
-	// 使用curl直接执行脚本，不保存到本地
-	installCmd := exec.Command("bash", "-c", "curl -L https://github.com/v2fly/fhs-install-v2ray/raw/master/install-release.sh | bash -s -- --force")
-	logger.Debug("Executing command", zap.String("command", installCmd.String()))
-	installCmd.Stdout = os.Stdout
-	installCmd.Stderr = os.Stderr
-
-	// 在goroutine中执行安装命令，支持取消
-	installDone := make(chan error, 1)
-	go func() {
-		installDone <- installCmd.Run()
-	}()
-
-	// 等待安装完成或收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray installation canceled due to stop signal")
-		// 尝试终止安装进程
-		if installCmd.Process != nil {
-			installCmd.Process.Kill()
-			logger.Info("Killed V2Ray installation process")
-		}
-		status.Message = "Installation canceled"
-		return status, nil
-	case err := <-installDone:
-		if err != nil {
-			logger.Error("Failed to install V2Ray", zap.Error(err))
-			return status, fmt.Errorf("failed to install v2ray: %w", err)
+		log.Info("Proxy already installed, skipping installation", zap.String("version", version))
+	} else {
+		// 1. 下载（或读取本地）发布包，校验后安装；ctx取消时中止下载
+		status.Progress = 20
+		status.Message = "Downloading and installing " + backend.Name()
+		log.Info("Downloading and installing proxy", zap.String("version", cfg.Version))
+
+		if err := backend.Install(ctx, log, cfg, svc.Kind()); err != nil {
+			if canceled() {
+				status.Message = "Installation canceled"
+				return status, ctx.Err()
+			}
+			log.Error("Failed to install proxy", zap.Error(err))
+			return status, fmt.Errorf("failed to install %s: %w", backend.Name(), err)
 		}
-	}
-
-	logger.Info("V2Ray installation completed")
-
-	// 检查是否已收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
-		status.Message = "Deployment canceled"
-		return status, nil
-	default:
-		// 继续执行
+		log.Info("Proxy installation completed")
 	}
 
 	status.Progress = 40
-	status.Message = "V2Ray installation completed"
+	status.Message = "Installation completed"
 
-	// 3. 配置V2Ray
+	// 3. 生成配置
+	if canceled() {
+		return status, ctx.Err()
+	}
 	status.Progress = 60
-	status.Message = "Configuring V2Ray"
-	logger.Info("Configuring V2Ray")
+	status.Message = "Configuring " + backend.Name()
+	log.Info("Configuring proxy")
 
-	// 检查是否已收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
-		status.Message = "Deployment canceled"
-		return status, nil
-	default:
-		// 继续执行
+	configChanged, err := configure(ctx, log, runner, backend, store, cfg)
+	if err != nil {
+		log.Error("Failed to configure proxy", zap.Error(err))
+		return status, fmt.Errorf("failed to configure %s: %w", backend.Name(), err)
 	}
+	log.Info("Proxy configuration completed")
+	checkRealityDests(ctx, log, cfg)
 
-	if err := configureV2Ray(port, uuid, accessLogPath); err != nil {
-		logger.Error("Failed to configure V2Ray", zap.Error(err))
-		return status, fmt.Errorf("failed to configure v2ray: %w", err)
+	// 4. 设置开机自启，systemd下同时重新加载服务单元
+	if canceled() {
+		return status, ctx.Err()
 	}
-	logger.Info("V2Ray configuration completed")
-
-	// 检查是否已收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
-		status.Message = "Deployment canceled"
-		return status, nil
-	default:
-		// 继续执行
+	log.Info("Setting proxy to start on boot")
+	if err := svc.Enable(ctx); err != nil {
+		// 忽略开机自启错误，不影响主功能
+		log.Warn("Failed to enable proxy service on boot", zap.Error(err))
+	} else {
+		log.Info("Proxy enabled on boot", zap.String("manager", svc.Kind()))
 	}
 
-	// 4. 启动V2Ray服务
-	status.Progress = 80
-	status.Message = "Starting V2Ray service"
-	logger.Info("Starting V2Ray service")
-
-	// 检查是否已收到停止信号
-	select {
-	case <-stopChan:
-		logger.Info("V2Ray deployment canceled due to stop signal")
-		status.Message = "Deployment canceled"
-		return status, nil
-	default:
-		// 继续执行
+	// 5. 启动服务
+	if canceled() {
+		return status, ctx.Err()
 	}
-
-	startCmd := exec.Command("systemctl", "start", "v2ray")
-	logger.Debug("Executing command", zap.String("command", startCmd.String()))
-	startOutput, startErr := startCmd.CombinedOutput()
-	logger.Debug("systemctl start v2ray output",
-		zap.String("output", string(startOutput)),
-		zap.Error(startErr))
-
-	if startErr != nil {
-		logger.Warn("Failed to start V2Ray with systemctl, trying service command", zap.Error(startErr))
-		// 尝试使用service命令
-		startCmd = exec.Command("service", "v2ray", "start")
-		logger.Debug("Executing command", zap.String("command", startCmd.String()))
-		startOutput, startErr = startCmd.CombinedOutput()
-		logger.Debug("service start v2ray output",
-			zap.String("output", string(startOutput)),
-			zap.Error(startErr))
-		if startErr != nil {
-			logger.Error("Failed to start V2Ray service", zap.Error(startErr))
-			return status, fmt.Errorf("failed to start v2ray: %w", startErr)
-		}
+	status.Progress = 80
+	status.Message = "Starting " + backend.Name() + " service"
+	log.Info("Starting proxy service")
+
+	// 已在运行的核心需要重启才能加载新配置
+	start := svc.Start
+	if configChanged && IsRunning(ctx, log, backend, svc) {
+		log.Info("Proxy config changed while running, restarting")
+		start = svc.Restart
 	}
-	logger.Info("V2Ray service started successfully")
-
-	// 5. 设置开机自启
-	logger.Info("Setting V2Ray to start on boot")
-	enableCmd := exec.Command("systemctl", "enable", "v2ray")
-	logger.Debug("Executing command", zap.String("command", enableCmd.String()))
-	enableOutput, enableErr := enableCmd.CombinedOutput()
-	logger.Debug("systemctl enable v2ray output",
-		zap.String("output", string(enableOutput)),
-		zap.Error(enableErr))
-
-	if enableErr != nil {
-		logger.Warn("Failed to enable V2Ray with systemctl, trying chkconfig", zap.Error(enableErr))
-		// 尝试使用chkconfig
-		enableCmd = exec.Command("chkconfig", "v2ray", "on")
-		logger.Debug("Executing command", zap.String("command", enableCmd.String()))
-		enableOutput, enableErr = enableCmd.CombinedOutput()
-		logger.Debug("chkconfig enable v2ray output",
-			zap.String("output", string(enableOutput)),
-			zap.Error(enableErr))
-		if enableErr != nil {
-			// 忽略开机自启错误，不影响主功能
-			logger.Warn("Failed to enable v2ray service on boot", zap.Error(enableErr))
-		} else {
-			logger.Info("V2Ray enabled on boot with chkconfig")
+	if err := start(ctx); err != nil {
+		if canceled() {
+			return status, ctx.Err()
 		}
-	} else {
-		logger.Info("V2Ray enabled on boot with systemctl")
+		log.Error("Failed to start proxy service", zap.String("manager", svc.Kind()), zap.Error(err))
+		return status, fmt.Errorf("failed to start %s: %w", backend.Name(), err)
 	}
+	log.Info("Proxy service started successfully", zap.String("manager", svc.Kind()))
 
 	// 6. 验证安装
+	if canceled() {
+		return status, ctx.Err()
+	}
 	status.Progress = 100
-	status.Message = "V2Ray deployment completed"
-	logger.Info("Verifying V2Ray installation")
+	status.Message = "Deployment completed"
+	log.Info("Verifying proxy installation")
 
-	installed, version, err = CheckV2Ray()
+	installed, version, err = CheckInstalled(ctx, log, runner, backend)
 	if err != nil {
-		logger.Error("Failed to verify V2Ray installation", zap.Error(err))
+		log.Error("Failed to verify proxy installation", zap.Error(err))
 		return status, err
 	}
 
 	status.Installed = installed
 	status.Version = version
-	status.Running = IsV2RayRunning()
+	status.Running = IsRunning(ctx, log, backend, svc)
 
-	logger.Info("V2Ray deployment completed",
+	log.Info("Proxy deployment completed",
 		zap.Bool("installed", installed),
 		zap.String("version", version),
 		zap.Bool("running", status.Running))
@@ -301,104 +188,138 @@ func DeployV2RayWithContext(port int, uuid string, accessLogPath string, stopCha
 	return status, nil
 }
 
-// configureV2Ray 配置V2Ray
-func configureV2Ray(port int, uuid string, accessLogPath string) error {
-	// V2Ray配置文件路径
-	configPath := "/usr/local/etc/v2ray/config.json"
-	logger.Info("Configuring V2Ray",
+// Reconfigure 按新配置重写代理核心配置文件，配置有变化时重启服务
+// 新配置未通过核心校验时保留原配置文件，不重启服务。
+func Reconfigure(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend, svc service.ServiceManager, store *state.Store, cfg config.V2RayConfig) error {
+	changed, err := configure(ctx, log, runner, backend, store, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure %s: %w", backend.Name(), err)
+	}
+	if !changed {
+		log.Info("Proxy config unchanged, skipping restart")
+		return nil
+	}
+	checkRealityDests(ctx, log, cfg)
+
+	log.Info("Restarting proxy service to apply new config")
+	if err := svc.Restart(ctx); err != nil {
+		log.Error("Failed to restart proxy service", zap.String("manager", svc.Kind()), zap.Error(err))
+		return fmt.Errorf("failed to restart %s: %w", backend.Name(), err)
+	}
+	log.Info("Proxy service restarted successfully")
+
+	return nil
+}
+
+// configure 生成代理核心配置文件，返回配置文件是否发生变化
+// 新配置先写入同目录下的临时文件，经已安装的核心校验后再原子替换原文件。
+func configure(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend, store *state.Store, cfg config.V2RayConfig) (bool, error) {
+	configPath := backend.ConfigPath()
+	log.Info("Configuring proxy",
 		zap.String("config_path", configPath),
-		zap.Int("port", port))
+		zap.Int("port", cfg.Port))
+
+	// 去掉已过期和不在允许时间段内的用户，填入REALITY密钥、轮换后的用户凭据和端口以及Clash API密钥，生成期望的配置内容
+	resolved, err := resolveReality(store, activeClients(cfg, time.Now()))
+	if err != nil {
+		return false, err
+	}
+	resolved, err = resolveCredentials(store, resolved)
+	if err != nil {
+		return false, err
+	}
+	resolved, err = ApplyPorts(store, resolved)
+	if err != nil {
+		return false, err
+	}
+	resolved, err = ApplyClashSecret(store, backend, resolved)
+	if err != nil {
+		return false, err
+	}
+	desired, err := backend.RenderConfig(resolved)
+	if err != nil {
+		return false, fmt.Errorf("failed to render %s config: %w", backend.Name(), err)
+	}
 
 	// 读取现有配置
 	existingConfig, err := os.ReadFile(configPath)
 	if err != nil {
 		// 如果配置文件不存在，创建新的
 		if os.IsNotExist(err) {
-			logger.Info("V2Ray config file not found, creating new one", zap.String("path", configPath))
-			return createNewConfig(configPath, port, uuid, accessLogPath)
+			log.Info("Proxy config file not found, creating new one", zap.String("path", configPath))
+			return true, createNewConfig(ctx, log, runner, backend, desired, filepath.Dir(cfg.AccessLog))
 		}
-		logger.Error("Failed to read V2Ray config file",
+		log.Error("Failed to read proxy config file",
 			zap.String("path", configPath),
 			zap.Error(err))
-		return fmt.Errorf("failed to read v2ray config: %w", err)
+		return false, fmt.Errorf("failed to read %s config: %w", backend.Name(), err)
 	}
 
-	// 检查配置是否已包含我们需要的配置
-	if strings.Contains(string(existingConfig), uuid) && strings.Contains(string(existingConfig), fmt.Sprintf("%d", port)) {
-		logger.Info("V2Ray config already contains required settings, skipping")
-		return nil // 配置已存在，无需修改
+	// 检查现有配置是否与期望一致
+	if bytes.Equal(bytes.TrimSpace(existingConfig), bytes.TrimSpace(desired)) {
+		log.Info("Proxy config already contains required settings, skipping")
+		return false, nil // 配置已存在，无需修改
 	}
 
 	// 创建新配置
-	logger.Info("Existing V2Ray config does not match required settings, creating new config")
-	return createNewConfig(configPath, port, uuid, accessLogPath)
+	log.Info("Existing proxy config does not match required settings, creating new config")
+	return true, createNewConfig(ctx, log, runner, backend, desired, filepath.Dir(cfg.AccessLog))
 }
 
-// createNewConfig 创建新的V2Ray配置文件
-func createNewConfig(configPath string, port int, uuid string, accessLogPath string) error {
-	// V2Ray配置模板
-	configTemplate := fmt.Sprintf(`{
-  "log": {
-    "access": "%s",
-    "error": "/var/log/v2ray/error.log",
-    "loglevel": "info"
-  },
-  "inbounds": [
-    {
-      "port": %d,
-      "protocol": "vmess",
-      "settings": {
-        "clients": [
-          {
-            "id": "%s",
-            "alterId": 0
-          }
-        ]
-      }
-    }
-  ],
-  "outbounds": [
-    {
-      "protocol": "freedom",
-      "tag": "direct",
-      "settings": {}
-    }
-  ]
-}`, accessLogPath, port, uuid)
-
+// createNewConfig 校验并原子写入新的配置文件，并确保核心的日志目录存在
+func createNewConfig(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend, configData []byte, accessLogDir string) error {
+	configPath := backend.ConfigPath()
 	// 确保配置目录存在
 	configDir := filepath.Dir(configPath)
-	logger.Debug("Ensuring config directory exists", zap.String("dir", configDir))
+	log.Debug("Ensuring config directory exists", zap.String("dir", configDir))
 	if err := os.MkdirAll(configDir, 0755); err != nil {
-		logger.Error("Failed to create config directory",
+		log.Error("Failed to create config directory",
 			zap.String("dir", configDir),
 			zap.Error(err))
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
-	logger.Debug("Config directory ensured", zap.String("dir", configDir))
+	log.Debug("Config directory ensured", zap.String("dir", configDir))
 
-	// 写入配置文件
-	logger.Debug("Writing V2Ray config file",
+	// 写入临时文件，保留扩展名以便核心识别配置格式
+	log.Debug("Writing proxy config file",
 		zap.String("path", configPath),
-		zap.Int("config_size", len(configTemplate)))
-	if err := os.WriteFile(configPath, []byte(configTemplate), 0644); err != nil {
-		logger.Error("Failed to write V2Ray config file",
+		zap.Int("config_size", len(configData)))
+	ext := filepath.Ext(configPath)
+	pattern := "." + strings.TrimSuffix(filepath.Base(configPath), ext) + "-*" + ext
+	tmpPath, err := writeTempFile(configDir, pattern, configData, 0644)
+	if err != nil {
+		log.Error("Failed to write proxy config file",
 			zap.String("path", configPath),
 			zap.Error(err))
-		return fmt.Errorf("failed to write v2ray config: %w", err)
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	// 核心已安装时先校验新配置，校验失败时保留原配置文件
+	if _, err := os.Stat(backend.BinaryPath()); err == nil {
+		if err := backend.TestConfig(ctx, runner, backend.BinaryPath(), tmpPath); err != nil {
+			log.Error("Proxy rejected new config, keeping previous config",
+				zap.String("path", configPath),
+				zap.Error(err))
+			return fmt.Errorf("%s rejected new config: %w", backend.Name(), err)
+		}
 	}
-	logger.Info("V2Ray config file written successfully", zap.String("path", configPath))
+
+	if err := os.Rename(tmpPath, configPath); err != nil {
+		return fmt.Errorf("failed to replace config: %w", err)
+	}
+	log.Info("Proxy config file written successfully", zap.String("path", configPath))
 
 	// 确保日志目录存在
-	logDir := "/var/log/v2ray"
-	logger.Debug("Ensuring log directory exists", zap.String("dir", logDir))
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		logger.Error("Failed to create log directory",
-			zap.String("dir", logDir),
-			zap.Error(err))
-		return fmt.Errorf("failed to create log directory: %w", err)
+	for _, logDir := range []string{backend.LogDir(), accessLogDir} {
+		log.Debug("Ensuring log directory exists", zap.String("dir", logDir))
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			log.Error("Failed to create log directory",
+				zap.String("dir", logDir),
+				zap.Error(err))
+			return fmt.Errorf("failed to create log directory: %w", err)
+		}
 	}
-	logger.Debug("Log directory ensured", zap.String("dir", logDir))
 
 	return nil
 }
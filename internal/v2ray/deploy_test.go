@@ -0,0 +1,150 @@
+package v2ray
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"go.uber.org/zap"
+)
+
+// succeedingRunner 返回所有命令都执行成功的Runner
+func succeedingRunner() *commandtest.FakeRunner {
+	runner := commandtest.NewFakeRunner()
+	runner.Default = commandtest.Response{}
+	return runner
+}
+
+func TestReconfigureWritesConfigAndRestarts(t *testing.T) {
+	t.Parallel()
+
+	backend := newTestBackend(t, true)
+	runner := succeedingRunner()
+	svc := &fakeService{}
+	store := newTestStore(t)
+	cfg := testV2RayConfig(t)
+
+	if err := Reconfigure(context.Background(), zap.NewNop(), runner, backend, svc, store, cfg); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	data, err := os.ReadFile(backend.ConfigPath())
+	if err != nil {
+		t.Fatalf("config not written: %v", err)
+	}
+	if !strings.Contains(string(data), testUUID) {
+		t.Errorf("config does not contain the user UUID")
+	}
+	if !svc.called("restart") {
+		t.Errorf("service calls = %v, want restart", svc.Calls())
+	}
+
+	// 新配置在替换前由核心校验，校验的是同目录下的临时文件
+	calls := runner.Calls()
+	if len(calls) != 1 || calls[0].Name != backend.BinaryPath() || calls[0].Args[0] != "test" {
+		t.Fatalf("runner calls = %v, want one config test", runner.Lines())
+	}
+	tested := calls[0].Args[len(calls[0].Args)-1]
+	if filepath.Dir(tested) != filepath.Dir(backend.ConfigPath()) || tested == backend.ConfigPath() || filepath.Ext(tested) != ".json" {
+		t.Errorf("tested config %s, want a .json temp file next to %s", tested, backend.ConfigPath())
+	}
+
+	// 配置未变化时不再重启
+	svc2 := &fakeService{}
+	if err := Reconfigure(context.Background(), zap.NewNop(), runner, backend, svc2, store, cfg); err != nil {
+		t.Fatalf("second Reconfigure() error = %v", err)
+	}
+	if len(svc2.Calls()) != 0 {
+		t.Errorf("service calls = %v, want none for unchanged config", svc2.Calls())
+	}
+}
+
+func TestReconfigureKeepsConfigWhenTestFails(t *testing.T) {
+	t.Parallel()
+
+	backend := newTestBackend(t, true)
+	if err := os.MkdirAll(filepath.Dir(backend.ConfigPath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := []byte(`{"previous": true}`)
+	if err := os.WriteFile(backend.ConfigPath(), previous, 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner := commandtest.NewFakeRunner()
+	runner.Default = commandtest.Response{Output: "invalid config", Err: &commandtest.ExitError{Code: 23}}
+	svc := &fakeService{}
+
+	err := Reconfigure(context.Background(), zap.NewNop(), runner, backend, svc, newTestStore(t), testV2RayConfig(t))
+	if err == nil || !strings.Contains(err.Error(), "invalid config") {
+		t.Fatalf("Reconfigure() error = %v, want config test failure", err)
+	}
+	data, err := os.ReadFile(backend.ConfigPath())
+	if err != nil || string(data) != string(previous) {
+		t.Errorf("config = %q, %v, want previous config kept", data, err)
+	}
+	if len(svc.Calls()) != 0 {
+		t.Errorf("service calls = %v, want no restart", svc.Calls())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(backend.ConfigPath()))
+	if len(entries) != 1 {
+		t.Errorf("config dir has %d entries, want temp file removed", len(entries))
+	}
+}
+
+func TestReconfigureSkipsTestWhenNotInstalled(t *testing.T) {
+	t.Parallel()
+
+	backend := newTestBackend(t, false)
+	runner := commandtest.NewFakeRunner()
+	svc := &fakeService{}
+
+	if err := Reconfigure(context.Background(), zap.NewNop(), runner, backend, svc, newTestStore(t), testV2RayConfig(t)); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	if _, err := os.Stat(backend.ConfigPath()); err != nil {
+		t.Errorf("config not written: %v", err)
+	}
+	if len(runner.Calls()) != 0 {
+		t.Errorf("runner calls = %v, want none without a binary", runner.Lines())
+	}
+}
+
+func TestDeployInstalled(t *testing.T) {
+	t.Parallel()
+
+	backend := newTestBackend(t, true)
+	runner := commandtest.NewFakeRunner()
+	runner.On(backend.BinaryPath()+" version", "V2Ray 5.16.1 (V2Fly, a community-driven edition of V2Ray.)", nil)
+	runner.Default = commandtest.Response{}
+	svc := &fakeService{}
+
+	status, err := Deploy(context.Background(), zap.NewNop(), runner, backend, svc, newTestStore(t), testV2RayConfig(t))
+	if err != nil {
+		t.Fatalf("Deploy() error = %v", err)
+	}
+	if !status.Installed || status.Version != "5.16.1" || status.Progress != 100 {
+		t.Errorf("status = %+v, want installed 5.16.1 at 100%%", status)
+	}
+	if !svc.called("enable") || !svc.called("start") {
+		t.Errorf("service calls = %v, want enable and start", svc.Calls())
+	}
+}
+
+func TestDeployCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	backend := newTestBackend(t, true)
+	svc := &fakeService{}
+
+	_, err := Deploy(ctx, zap.NewNop(), commandtest.NewFakeRunner(), backend, svc, newTestStore(t), testV2RayConfig(t))
+	if err != context.Canceled {
+		t.Fatalf("Deploy() error = %v, want context.Canceled", err)
+	}
+	if len(svc.Calls()) != 0 {
+		t.Errorf("service calls = %v, want none after cancellation", svc.Calls())
+	}
+}
@@ -0,0 +1,150 @@
+package v2ray
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+// singBoxLocalDNS sing-box中使用系统DNS的服务器tag
+const singBoxLocalDNS = "dns-local"
+
+// dnsSection V2Ray/Xray内置DNS配置
+type dnsSection struct {
+	Hosts         map[string][]string `json:"hosts,omitempty"`
+	Servers       []dnsServerSection  `json:"servers,omitempty"`
+	QueryStrategy string              `json:"queryStrategy,omitempty"`
+}
+
+// dnsServerSection V2Ray/Xray DNS服务器，指定了域名的服务器不用于其他域名的查询
+type dnsServerSection struct {
+	Address      string   `json:"address"`
+	Port         int      `json:"port,omitempty"`
+	Domains      []string `json:"domains,omitempty"`
+	SkipFallback bool     `json:"skipFallback,omitempty"`
+}
+
+// renderDNS 生成V2Ray/Xray的DNS配置，未配置DNS时返回nil
+// hosts 中无前缀的域名与路由规则一致按关键字匹配，而核心中无前缀表示完整匹配，需要加上 keyword: 前缀。
+func renderDNS(dns config.DNSConfig) *dnsSection {
+	if len(dns.Servers) == 0 && len(dns.Hosts) == 0 && dns.QueryStrategy == "" {
+		return nil
+	}
+	section := &dnsSection{QueryStrategy: dns.QueryStrategy}
+	for _, host := range dns.Hosts {
+		domain := host.Domain
+		if !strings.Contains(domain, ":") {
+			domain = "keyword:" + domain
+		}
+		if section.Hosts == nil {
+			section.Hosts = make(map[string][]string)
+		}
+		section.Hosts[domain] = append(section.Hosts[domain], host.IP...)
+	}
+	for _, server := range dns.Servers {
+		entry := dnsServerSection{Address: server.Address, Domains: server.Domains, SkipFallback: len(server.Domains) > 0}
+		if host, port, ok := dnsHostPort(server.Address); ok {
+			entry.Address = host
+			entry.Port, _ = strconv.Atoi(port)
+		}
+		section.Servers = append(section.Servers, entry)
+	}
+	return section
+}
+
+// freedomDomainStrategy 配置了DNS服务器时，直连出站使用内置DNS解析目标域名，不再使用系统DNS
+func freedomDomainStrategy(dns config.DNSConfig) string {
+	if len(dns.Servers) == 0 {
+		return ""
+	}
+	if dns.QueryStrategy == "" {
+		return "UseIP"
+	}
+	return dns.QueryStrategy
+}
+
+// singBoxDNS sing-box DNS配置
+type singBoxDNS struct {
+	Servers  []singBoxDNSServer `json:"servers"`
+	Rules    []singBoxDNSRule   `json:"rules,omitempty"`
+	Final    string             `json:"final,omitempty"`
+	Strategy string             `json:"strategy,omitempty"`
+}
+
+// singBoxDNSServer sing-box DNS服务器，域名形式的服务器地址由 address_resolver 解析
+type singBoxDNSServer struct {
+	Tag             string `json:"tag"`
+	Address         string `json:"address"`
+	AddressResolver string `json:"address_resolver,omitempty"`
+}
+
+// singBoxDNSRule sing-box DNS规则，匹配字段与路由规则相同
+type singBoxDNSRule struct {
+	singBoxRouteRule
+	Server string `json:"server"`
+}
+
+// singBoxDNSStrategies 查询策略到sing-box strategy的映射
+var singBoxDNSStrategies = map[string]string{
+	"UseIPv4": "ipv4_only",
+	"UseIPv6": "ipv6_only",
+}
+
+// renderSingBoxDNS 生成sing-box的DNS配置，未配置DNS服务器时返回nil
+// 指定了域名的服务器通过DNS规则使用，其他服务器中的第一个作为默认服务器，没有时使用系统DNS。
+func renderSingBoxDNS(dns config.DNSConfig, ruleSets *singBoxRuleSets) *singBoxDNS {
+	if len(dns.Servers) == 0 {
+		return nil
+	}
+	section := &singBoxDNS{Strategy: singBoxDNSStrategies[dns.QueryStrategy]}
+	needsLocal := false
+	for i, server := range dns.Servers {
+		entry := singBoxDNSServer{Tag: fmt.Sprintf("dns-%d", i), Address: singBoxDNSAddress(server.Address)}
+		if u, err := url.Parse(server.Address); err == nil && u.Host != "" && net.ParseIP(u.Hostname()) == nil {
+			entry.AddressResolver = singBoxLocalDNS
+			needsLocal = true
+		}
+		section.Servers = append(section.Servers, entry)
+
+		if len(server.Domains) > 0 {
+			section.Rules = append(section.Rules, singBoxDNSRule{
+				singBoxRouteRule: ruleSets.domainRule(server.Domains),
+				Server:           entry.Tag,
+			})
+		} else if section.Final == "" {
+			section.Final = entry.Tag
+		}
+	}
+	if section.Final == "" {
+		section.Final = singBoxLocalDNS
+		needsLocal = true
+	}
+	if needsLocal {
+		section.Servers = append(section.Servers, singBoxDNSServer{Tag: singBoxLocalDNS, Address: "local"})
+	}
+	return section
+}
+
+// singBoxDNSAddress 转换为sing-box的服务器地址写法
+func singBoxDNSAddress(address string) string {
+	if address == "localhost" {
+		return "local"
+	}
+	if _, _, ok := dnsHostPort(address); ok {
+		return "udp://" + address
+	}
+	return address
+}
+
+// dnsHostPort 拆分 IP:端口 形式的服务器地址，URL形式的地址返回false
+func dnsHostPort(address string) (string, string, bool) {
+	if strings.Contains(address, "://") {
+		return "", "", false
+	}
+	host, port, err := net.SplitHostPort(address)
+	return host, port, err == nil
+}
@@ -0,0 +1,264 @@
+package v2ray
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+	"go.uber.org/zap"
+)
+
+// geoDataStateKey geo数据文件版本在状态文件中的键
+const geoDataStateKey = "geodata"
+
+// GeoDataFile 已安装的geo数据文件
+type GeoDataFile struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"` // 发布标签，下载地址中没有标签时为SHA256的前12位
+	SHA256    string    `json:"sha256"`
+	Source    string    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GeoDataStatus geo数据文件最近一次检查更新的结果
+type GeoDataStatus struct {
+	CheckedAt time.Time     `json:"checked_at"`
+	Files     []GeoDataFile `json:"files"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// stagedGeoFile 已下载并校验、等待替换的geo数据文件
+type stagedGeoFile struct {
+	tmp  string
+	path string
+}
+
+// GetGeoDataStatus 读取最近一次检查更新的结果，从未检查过时返回nil
+func GetGeoDataStatus(store *state.Store) (*GeoDataStatus, error) {
+	var status GeoDataStatus
+	ok, err := store.Get(geoDataStateKey, &status)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// UpdateGeoData 检查并更新geo数据文件，有文件被替换且核心正在运行时重启服务
+// 每个文件依次尝试配置的下载地址：先下载 .sha256sum，与已安装的文件一致时不再下载；
+// 所有文件下载并校验后才一起替换。不使用geo数据文件的核心直接返回nil。
+func UpdateGeoData(ctx context.Context, log *zap.Logger, backend ProxyBackend, svc service.ServiceManager, store *state.Store, cfg config.V2RayConfig) (*GeoDataStatus, error) {
+	dir := backend.AssetDir()
+	if dir == "" {
+		log.Debug("Proxy backend does not use geo data files, skipping update", zap.String("backend", backend.Name()))
+		return nil, nil
+	}
+
+	recorded := make(map[string]GeoDataFile)
+	previous, err := GetGeoDataStatus(store)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		for _, file := range previous.Files {
+			recorded[file.Name] = file
+		}
+	}
+
+	var staged []stagedGeoFile
+	defer func() {
+		for _, file := range staged {
+			os.Remove(file.tmp)
+		}
+	}()
+
+	status := &GeoDataStatus{CheckedAt: time.Now(), Files: []GeoDataFile{}}
+	var problems []string
+	sources := map[string][]string{"geoip.dat": cfg.GeoData.GeoIP, "geosite.dat": cfg.GeoData.Geosite}
+	for _, name := range geoFiles {
+		path := filepath.Join(dir, name)
+		file, tmp, err := fetchGeoFile(ctx, log, cfg.Install.Proxy, path, sources[name])
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			if old, ok := recorded[name]; ok {
+				status.Files = append(status.Files, old)
+			}
+			continue
+		}
+
+		switch old, ok := recorded[name]; {
+		case tmp != "":
+			file.UpdatedAt = status.CheckedAt
+			staged = append(staged, stagedGeoFile{tmp: tmp, path: path})
+		case ok && old.SHA256 == file.SHA256:
+			file.UpdatedAt = old.UpdatedAt
+		default:
+			// 发布包中自带的文件已是最新
+			if info, err := os.Stat(path); err == nil {
+				file.UpdatedAt = info.ModTime()
+			}
+		}
+		status.Files = append(status.Files, file)
+	}
+
+	// 替换文件
+	replaced := 0
+	for _, file := range staged {
+		if err := os.Rename(file.tmp, file.path); err != nil {
+			problems = append(problems, fmt.Sprintf("failed to replace %s: %v", file.path, err))
+			continue
+		}
+		replaced++
+		log.Info("Geo file updated", zap.String("path", file.path))
+	}
+
+	if len(problems) > 0 {
+		status.Error = strings.Join(problems, "; ")
+	}
+	if err := store.Set(geoDataStateKey, status); err != nil {
+		return status, err
+	}
+
+	// 核心只在启动时加载geo数据文件
+	if replaced > 0 && IsRunning(ctx, log, backend, svc) {
+		log.Info("Restarting proxy service to load updated geo files")
+		if err := svc.Restart(ctx); err != nil {
+			return status, fmt.Errorf("failed to restart %s: %w", backend.Name(), err)
+		}
+	}
+
+	if status.Error != "" {
+		return status, fmt.Errorf("failed to update geo files: %s", status.Error)
+	}
+	if replaced == 0 {
+		log.Info("Geo files are up to date")
+	}
+	return status, nil
+}
+
+// fetchGeoFile 依次尝试下载地址，返回最新文件的信息
+// 最新文件与path处已安装的文件不同时，同时返回已下载并校验的同目录临时文件。
+func fetchGeoFile(ctx context.Context, log *zap.Logger, proxy string, path string, urls []string) (GeoDataFile, string, error) {
+	if len(urls) == 0 {
+		return GeoDataFile{}, "", errors.New("no download URL configured")
+	}
+	installed, err := fileSHA256(path)
+	if err != nil && !os.IsNotExist(err) {
+		return GeoDataFile{}, "", fmt.Errorf("failed to hash installed file: %w", err)
+	}
+
+	var lastErr error
+	for _, source := range urls {
+		file, tmp, err := fetchGeoFileFrom(ctx, proxy, path, source, installed)
+		if err == nil {
+			return file, tmp, nil
+		}
+		if ctx.Err() != nil {
+			return GeoDataFile{}, "", ctx.Err()
+		}
+		log.Warn("Failed to fetch geo file", zap.String("url", source), zap.Error(err))
+		lastErr = err
+	}
+	return GeoDataFile{}, "", lastErr
+}
+
+// fetchGeoFileFrom 从单个下载地址获取geo数据文件，installed 为已安装文件的SHA256
+func fetchGeoFileFrom(ctx context.Context, proxy string, path string, source string, installed string) (GeoDataFile, string, error) {
+	client, err := downloadClient(proxy)
+	if err != nil {
+		return GeoDataFile{}, "", err
+	}
+	// 最新版本的地址会重定向到带发布标签的地址，从中记录版本
+	tag := releaseTag(source)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if tag == "" {
+			tag = releaseTag(req.URL.Path)
+		}
+		return nil
+	}
+
+	sum, err := downloadString(ctx, client, source+".sha256sum")
+	if err != nil {
+		return GeoDataFile{}, "", fmt.Errorf("failed to download checksum: %w", err)
+	}
+	expected, err := parseSHA256Sum(sum)
+	if err != nil {
+		return GeoDataFile{}, "", err
+	}
+	file := GeoDataFile{Name: filepath.Base(path), Version: tag, SHA256: expected, Source: source}
+	if file.Version == "" {
+		file.Version = expected[:12]
+	}
+	if expected == installed {
+		return file, "", nil
+	}
+
+	// 下载到同目录临时文件，替换时只需重命名
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return GeoDataFile{}, "", err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return GeoDataFile{}, "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	hash := sha256.New()
+	err = download(ctx, client, source, io.MultiWriter(tmp, hash))
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return GeoDataFile{}, "", fmt.Errorf("failed to download: %w", err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		os.Remove(tmp.Name())
+		return GeoDataFile{}, "", fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return file, tmp.Name(), nil
+}
+
+// parseSHA256Sum 读取 sha256sum 格式的校验文件，第一行形如 "<hex>  geoip.dat"
+func parseSHA256Sum(data string) (string, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return "", errors.New("empty checksum file")
+	}
+	digest := strings.ToLower(fields[0])
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed SHA256 digest %q", fields[0])
+	}
+	return digest, nil
+}
+
+// releaseTag 从GitHub发布文件地址 .../releases/download/{tag}/{file} 中取出发布标签
+func releaseTag(u string) string {
+	_, rest, ok := strings.Cut(u, "/releases/download/")
+	if !ok {
+		return ""
+	}
+	tag, _, _ := strings.Cut(rest, "/")
+	return tag
+}
@@ -0,0 +1,153 @@
+package v2ray
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// geoFile 返回geo数据文件内容及其 .sha256sum 文件内容
+func geoFile(content string) ([]byte, []byte) {
+	sum := sha256.Sum256([]byte(content))
+	return []byte(content), []byte(hex.EncodeToString(sum[:]) + "  " + "geo.dat\n")
+}
+
+func TestUpdateGeoData(t *testing.T) {
+	t.Parallel()
+
+	geoip, geoipSum := geoFile("geoip data")
+	geosite, geositeSum := geoFile("geosite data")
+	good := map[string][]byte{
+		"/releases/download/202406010000/geoip.dat":           geoip,
+		"/releases/download/202406010000/geoip.dat.sha256sum": geoipSum,
+		"/dlc.dat":           geosite,
+		"/dlc.dat.sha256sum": geositeSum,
+	}
+	_, badSum := geoFile("something else")
+
+	tests := []struct {
+		name    string
+		mirror  map[string][]byte // 为空时只配置主地址
+		primary map[string][]byte
+		wantErr string
+		// noFetch 为true时不应下载数据文件本身
+		noFetch bool
+	}{
+		{name: "good checksum", primary: good},
+		{name: "bad checksum", primary: map[string][]byte{
+			"/releases/download/202406010000/geoip.dat":           geoip,
+			"/releases/download/202406010000/geoip.dat.sha256sum": badSum,
+			"/dlc.dat":           geosite,
+			"/dlc.dat.sha256sum": geositeSum,
+		}, wantErr: "checksum mismatch"},
+		{name: "missing checksum", primary: map[string][]byte{
+			"/releases/download/202406010000/geoip.dat": geoip,
+			"/dlc.dat":           geosite,
+			"/dlc.dat.sha256sum": geositeSum,
+		}, wantErr: "failed to download checksum", noFetch: true},
+		{name: "mirror fallback", primary: map[string][]byte{}, mirror: good},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			primary := newReleaseServer(t, tt.primary)
+			cfg := testV2RayConfig(t)
+			cfg.GeoData.GeoIP = []string{primary.URL + "/releases/download/202406010000/geoip.dat"}
+			cfg.GeoData.Geosite = []string{primary.URL + "/dlc.dat"}
+			var mirror *releaseServer
+			if tt.mirror != nil {
+				mirror = newReleaseServer(t, tt.mirror)
+				cfg.GeoData.GeoIP = append(cfg.GeoData.GeoIP, mirror.URL+"/releases/download/202406010000/geoip.dat")
+				cfg.GeoData.Geosite = append(cfg.GeoData.Geosite, mirror.URL+"/dlc.dat")
+			}
+			backend := newTestBackend(t, false)
+			store := newTestStore(t)
+			svc := &fakeService{}
+
+			status, err := UpdateGeoData(context.Background(), zap.NewNop(), backend, svc, store, cfg)
+			geoipPath := filepath.Join(backend.AssetDir(), "geoip.dat")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("UpdateGeoData() error = %v, want %q", err, tt.wantErr)
+				}
+				if _, err := os.Stat(geoipPath); !os.IsNotExist(err) {
+					t.Errorf("geoip.dat installed despite failed verification")
+				}
+				if tt.noFetch && primary.requested("/releases/download/202406010000/geoip.dat") {
+					t.Errorf("geoip.dat downloaded without a checksum")
+				}
+				// 失败的文件不影响其他文件，临时文件被清理
+				if data, _ := os.ReadFile(filepath.Join(backend.AssetDir(), "geosite.dat")); string(data) != "geosite data" {
+					t.Errorf("geosite.dat = %q, want updated", data)
+				}
+				entries, _ := os.ReadDir(backend.AssetDir())
+				for _, entry := range entries {
+					if strings.HasPrefix(entry.Name(), ".") {
+						t.Errorf("temp file %s left behind", entry.Name())
+					}
+				}
+				if saved, _ := GetGeoDataStatus(store); saved == nil || !strings.Contains(saved.Error, tt.wantErr) {
+					t.Errorf("saved status = %+v, want error recorded", saved)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdateGeoData() error = %v", err)
+			}
+			if data, _ := os.ReadFile(geoipPath); string(data) != "geoip data" {
+				t.Errorf("geoip.dat = %q, want downloaded content", data)
+			}
+			if len(status.Files) != 2 || status.Files[0].Version != "202406010000" || status.Files[1].Version != status.Files[1].SHA256[:12] {
+				t.Errorf("status files = %+v, want release tag and digest versions", status.Files)
+			}
+			if mirror != nil && !strings.HasPrefix(status.Files[0].Source, mirror.URL) {
+				t.Errorf("geoip source = %s, want the mirror", status.Files[0].Source)
+			}
+			if svc.called("restart") {
+				t.Errorf("service restarted while the core was not running")
+			}
+		})
+	}
+}
+
+func TestUpdateGeoDataUpToDate(t *testing.T) {
+	t.Parallel()
+
+	geoip, geoipSum := geoFile("geoip data")
+	geosite, geositeSum := geoFile("geosite data")
+	srv := newReleaseServer(t, map[string][]byte{
+		"/geoip.dat": geoip, "/geoip.dat.sha256sum": geoipSum,
+		"/dlc.dat": geosite, "/dlc.dat.sha256sum": geositeSum,
+	})
+	cfg := testV2RayConfig(t)
+	cfg.GeoData.GeoIP = []string{srv.URL + "/geoip.dat"}
+	cfg.GeoData.Geosite = []string{srv.URL + "/dlc.dat"}
+	backend := newTestBackend(t, false)
+	store := newTestStore(t)
+
+	// 已安装的文件与校验文件一致时只下载校验文件
+	if err := os.MkdirAll(backend.AssetDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"geoip.dat": geoip, "geosite.dat": geosite} {
+		if err := os.WriteFile(filepath.Join(backend.AssetDir(), name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := UpdateGeoData(context.Background(), zap.NewNop(), backend, &fakeService{}, store, cfg); err != nil {
+		t.Fatalf("UpdateGeoData() error = %v", err)
+	}
+	if srv.requested("/geoip.dat") || srv.requested("/dlc.dat") {
+		t.Errorf("up-to-date files downloaded again")
+	}
+	if !srv.requested("/geoip.dat.sha256sum") {
+		t.Errorf("checksum not checked")
+	}
+}
@@ -0,0 +1,161 @@
+package v2ray
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"go.uber.org/zap"
+)
+
+// OutboundHealth 上游出站最近一次健康检查的结果
+type OutboundHealth struct {
+	Tag       string    `json:"tag"`
+	Protocol  string    `json:"protocol"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMS int64     `json:"latency_ms,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthMonitor 出站健康检查，定期经每个上游出站请求 v2ray.health_check.url
+type HealthMonitor struct {
+	mu      sync.RWMutex
+	backend ProxyBackend
+	cfg     config.V2RayConfig
+	results map[string]OutboundHealth
+	log     *zap.Logger
+}
+
+// HealthMonitorOption 出站健康检查可选参数
+type HealthMonitorOption func(*HealthMonitor)
+
+// WithHealthLogger 设置出站健康检查使用的日志实例
+func WithHealthLogger(log *zap.Logger) HealthMonitorOption {
+	return func(m *HealthMonitor) {
+		m.log = log
+	}
+}
+
+// NewHealthMonitor 创建出站健康检查
+func NewHealthMonitor(backend ProxyBackend, cfg config.V2RayConfig, opts ...HealthMonitorOption) *HealthMonitor {
+	m := &HealthMonitor{
+		backend: backend,
+		cfg:     cfg,
+		results: make(map[string]OutboundHealth),
+		log:     zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// SetConfig 更新检查使用的配置，已删除或修改过的出站的结果随之清除
+func (m *HealthMonitor) SetConfig(cfg config.V2RayConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := make(map[string]config.OutboundConfig, len(cfg.Outbounds))
+	for _, outbound := range cfg.Outbounds {
+		current[outbound.Tag] = outbound
+	}
+	for _, outbound := range m.cfg.Outbounds {
+		if updated, ok := current[outbound.Tag]; !ok || !reflect.DeepEqual(updated, outbound) {
+			delete(m.results, outbound.Tag)
+		}
+	}
+	m.cfg = cfg
+}
+
+// CheckAll 依次检查所有上游出站，状态变化时记录日志
+func (m *HealthMonitor) CheckAll(ctx context.Context) {
+	m.mu.RLock()
+	cfg := m.cfg
+	m.mu.RUnlock()
+
+	for _, outbound := range cfg.Outbounds {
+		if !outbound.IsUpstream() {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		result := OutboundHealth{Tag: outbound.Tag, Protocol: outbound.Protocol, CheckedAt: time.Now()}
+		latency, err := m.backend.CheckOutbound(ctx, cfg, outbound.Tag)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Healthy = true
+			result.LatencyMS = latency.Milliseconds()
+		}
+
+		m.mu.Lock()
+		previous, checked := m.results[outbound.Tag]
+		m.results[outbound.Tag] = result
+		m.mu.Unlock()
+
+		switch {
+		case !result.Healthy && (!checked || previous.Healthy):
+			m.log.Warn("Outbound health check failed", zap.String("outbound", outbound.Tag), zap.Error(err))
+		case result.Healthy && checked && !previous.Healthy:
+			m.log.Info("Outbound recovered", zap.String("outbound", outbound.Tag), zap.Duration("latency", latency))
+		default:
+			m.log.Debug("Outbound health checked",
+				zap.String("outbound", outbound.Tag),
+				zap.Bool("healthy", result.Healthy),
+				zap.Duration("latency", latency))
+		}
+	}
+}
+
+// Results 按 outbounds 的顺序返回已检查过的上游出站的结果
+func (m *HealthMonitor) Results() []OutboundHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := []OutboundHealth{}
+	for _, outbound := range m.cfg.Outbounds {
+		if result, ok := m.results[outbound.Tag]; ok {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// checkViaSOCKS 经本地SOCKS入站请求检查URL，返回收到响应头的耗时
+// 不跟随重定向，4xx/5xx 响应视为失败。
+func checkViaSOCKS(ctx context.Context, port int, check config.HealthCheckConfig) (time.Duration, error) {
+	proxy := &url.URL{Scheme: "socks5", Host: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxy), DisableKeepAlives: true},
+		Timeout:   check.Timeout.Std(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return latency, nil
+}
@@ -0,0 +1,101 @@
+package v2ray
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// fakeService 记录调用的 service.ServiceManager
+type fakeService struct {
+	mu        sync.Mutex
+	calls     []string
+	status    service.Status
+	statusErr error
+}
+
+func (s *fakeService) record(call string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+	return nil
+}
+
+func (s *fakeService) Kind() string                      { return "fake" }
+func (s *fakeService) Start(ctx context.Context) error   { return s.record("start") }
+func (s *fakeService) Stop(ctx context.Context) error    { return s.record("stop") }
+func (s *fakeService) Restart(ctx context.Context) error { return s.record("restart") }
+func (s *fakeService) Enable(ctx context.Context) error  { return s.record("enable") }
+
+func (s *fakeService) Status(ctx context.Context) (*service.Status, error) {
+	s.record("status")
+	if s.statusErr != nil {
+		return nil, s.statusErr
+	}
+	status := s.status
+	return &status, nil
+}
+
+// Calls 返回所有调用
+func (s *fakeService) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// called 检查调用中是否包含call
+func (s *fakeService) called(call string) bool {
+	for _, c := range s.Calls() {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestBackend 返回所有路径都在临时目录中的V2Ray核心，installed 为true时创建二进制文件
+func newTestBackend(t *testing.T, installed bool) *coreBackend {
+	t.Helper()
+	dir := t.TempDir()
+	backend := *v2rayCore
+	backend.binaryPath = filepath.Join(dir, "bin", "v2ray")
+	backend.configPath = filepath.Join(dir, "etc", "config.json")
+	backend.assetDir = filepath.Join(dir, "share")
+	backend.logDir = filepath.Join(dir, "log")
+	if installed {
+		if err := os.MkdirAll(filepath.Dir(backend.binaryPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(backend.binaryPath, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &backend
+}
+
+// newTestStore 返回临时目录中的状态存储
+func newTestStore(t *testing.T) *state.Store {
+	t.Helper()
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// testV2RayConfig 返回带默认值的最小V2Ray配置，访问日志在临时目录中
+func testV2RayConfig(t *testing.T) config.V2RayConfig {
+	t.Helper()
+	cfg := config.DefaultConfig().V2Ray
+	cfg.UUID = testUUID
+	cfg.AccessLog = filepath.Join(t.TempDir(), "access.log")
+	return cfg
+}
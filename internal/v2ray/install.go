@@ -0,0 +1,396 @@
+package v2ray
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"go.uber.org/zap"
+)
+
+// serviceTemplates 服务文件模板
+//
+//go:embed assets/service.systemd.tmpl assets/service.openrc.tmpl
+var serviceTemplates embed.FS
+
+// serviceTemplate 解析后的服务文件模板
+var serviceTemplate = template.Must(template.New("").
+	Funcs(template.FuncMap{"join": strings.Join}).
+	ParseFS(serviceTemplates, "assets/*.tmpl"))
+
+// serviceUnit 渲染服务文件使用的数据
+type serviceUnit struct {
+	Spec          service.Spec
+	Description   string
+	Documentation string
+	LogDir        string // OpenRC方式下的输出日志目录
+}
+
+// geoFiles 发布包中需要安装的geo数据文件
+var geoFiles = []string{"geoip.dat", "geosite.dat"}
+
+// releaseArchs GOARCH 到发布包平台名的映射
+var releaseArchs = map[string]string{
+	"amd64":   "64",
+	"386":     "32",
+	"arm64":   "arm64-v8a",
+	"arm":     "arm32-v7a",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// releaseArch 返回当前平台在发布包文件名中的名称，如 v2ray-linux-64.zip 中的 64
+func releaseArch() (string, error) {
+	arch, ok := releaseArchs[runtime.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+	}
+	return arch, nil
+}
+
+// releaseURL 返回发布包下载地址，version 为空或 latest 时下载最新版本
+func releaseURL(base string, version string, asset string) string {
+	base = strings.TrimSuffix(base, "/")
+	if version == "" || version == "latest" {
+		return fmt.Sprintf("%s/latest/download/%s", base, asset)
+	}
+	return fmt.Sprintf("%s/download/v%s/%s", base, strings.TrimPrefix(version, "v"), asset)
+}
+
+// latestVersion 通过 {base}/latest 的重定向地址获取最新版本号，用于发布包文件名包含版本号的核心
+// GitHub会将 /releases/latest 重定向到 /releases/tag/v{version}，镜像需保持相同行为。
+func latestVersion(ctx context.Context, proxy string, base string) (string, error) {
+	client, err := downloadClient(proxy)
+	if err != nil {
+		return "", err
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	latestURL := strings.TrimSuffix(base, "/") + "/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, latestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve latest release: %w", err)
+	}
+	resp.Body.Close()
+
+	_, tag, ok := strings.Cut(resp.Header.Get("Location"), "/tag/")
+	if !ok || tag == "" {
+		return "", fmt.Errorf("failed to resolve latest release: %s did not redirect to a release tag", latestURL)
+	}
+	return strings.TrimPrefix(tag, "v"), nil
+}
+
+// verifyRelease 校验发布包的SHA256，expected 为空（没有可用的摘要）时只有 skipVerify 为true才允许安装
+func verifyRelease(log *zap.Logger, archive string, expected string, skipVerify bool) error {
+	actual, err := fileSHA256(archive)
+	if err != nil {
+		return fmt.Errorf("failed to hash release archive: %w", err)
+	}
+	if expected == "" {
+		if !skipVerify {
+			return fmt.Errorf("no SHA256 digest available for release archive, set v2ray.install.sha256 or v2ray.install.skip_verify")
+		}
+		log.Warn("No SHA256 digest available for release archive, skipping verification as configured",
+			zap.String("path", archive),
+			zap.String("sha256", actual))
+		return nil
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("release archive checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	log.Info("Release archive checksum verified", zap.String("sha256", actual))
+	return nil
+}
+
+// fetchRelease 返回发布包路径及期望的SHA256，下载的临时文件由cleanup删除
+// 配置了 v2ray.install.archive 时使用本地发布包，否则从archiveURL下载；
+// 未配置 v2ray.install.sha256 时读取发布包同名、扩展名为digestExt的摘要文件（.dgst 或 .sha256sum）；
+// 配置了 v2ray.install.skip_verify 时摘要下载失败不中止，由 verifyRelease 记录警告。
+func fetchRelease(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig, archiveURL string, digestExt string) (string, string, func(), error) {
+	install := cfg.Install
+	noop := func() {}
+
+	// 本地发布包，摘要取自配置或同目录下的摘要文件
+	if install.Archive != "" {
+		log.Info("Installing from local archive", zap.String("path", install.Archive))
+		expected := install.SHA256
+		if expected == "" {
+			data, err := os.ReadFile(install.Archive + digestExt)
+			if err == nil {
+				if expected, err = parseReleaseDigest(digestExt, string(data)); err != nil {
+					return "", "", noop, err
+				}
+			} else if !os.IsNotExist(err) {
+				return "", "", noop, fmt.Errorf("failed to read archive digest: %w", err)
+			}
+		}
+		return install.Archive, expected, noop, nil
+	}
+
+	client, err := downloadClient(install.Proxy)
+	if err != nil {
+		return "", "", noop, err
+	}
+
+	// 下载摘要
+	expected := install.SHA256
+	if expected == "" {
+		digestURL := archiveURL + digestExt
+		digest, err := downloadString(ctx, client, digestURL)
+		if err == nil {
+			expected, err = parseReleaseDigest(digestExt, digest)
+		}
+		if err != nil {
+			if !install.SkipVerify || ctx.Err() != nil {
+				return "", "", noop, fmt.Errorf("failed to download release digest: %w", err)
+			}
+			log.Warn("Failed to download release digest", zap.String("url", digestURL), zap.Error(err))
+		}
+	}
+
+	// 下载发布包到临时文件
+	log.Info("Downloading release", zap.String("url", archiveURL))
+	file, err := os.CreateTemp("", "release-*"+path.Ext(archiveURL))
+	if err != nil {
+		return "", "", noop, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	cleanup := func() {
+		os.Remove(file.Name())
+	}
+	defer file.Close()
+
+	if err := download(ctx, client, archiveURL, file); err != nil {
+		cleanup()
+		return "", "", noop, fmt.Errorf("failed to download release: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		cleanup()
+		return "", "", noop, fmt.Errorf("failed to write release archive: %w", err)
+	}
+	return file.Name(), expected, cleanup, nil
+}
+
+// downloadClient 创建下载使用的HTTP客户端，未设置代理时使用 HTTPS_PROXY 等环境变量
+func downloadClient(proxy string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// download 下载url到w，ctx取消时中止
+func download(ctx context.Context, client *http.Client, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// downloadString 下载小文件内容
+func downloadString(ctx context.Context, client *http.Client, url string) (string, error) {
+	var sb strings.Builder
+	if err := download(ctx, client, url, &sb); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// parseReleaseDigest 按摘要文件的扩展名解析SHA256
+func parseReleaseDigest(ext string, data string) (string, error) {
+	if ext == ".dgst" {
+		return parseDigest(data)
+	}
+	return parseSHA256Sum(data)
+}
+
+// parseDigest 从 .dgst 文件中读取SHA256，文件每行形如 "SHA2-256= <hex>"
+func parseDigest(data string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(name) {
+		case "SHA2-256", "SHA256":
+			digest := strings.TrimSpace(value)
+			if len(digest) != sha256.Size*2 {
+				return "", fmt.Errorf("malformed SHA256 digest %q", digest)
+			}
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("no SHA256 digest found in dgst file")
+}
+
+// fileSHA256 计算文件的SHA256
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// installServiceFiles 按服务管理方式安装systemd单元或OpenRC脚本，supervisor方式不需要服务文件
+func installServiceFiles(log *zap.Logger, unit serviceUnit, serviceKind string) error {
+	var name, path string
+	var mode os.FileMode
+	switch serviceKind {
+	case service.KindSystemd:
+		name, path, mode = "service.systemd.tmpl", "/etc/systemd/system/"+unit.Spec.Name+".service", 0644
+	case service.KindOpenRC:
+		name, path, mode = "service.openrc.tmpl", "/etc/init.d/"+unit.Spec.Name, 0755
+	default:
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := serviceTemplate.ExecuteTemplate(&buf, name, unit); err != nil {
+		return fmt.Errorf("failed to render %s service file: %w", serviceKind, err)
+	}
+	if err := writeFileAtomic(path, buf.Bytes(), mode); err != nil {
+		return fmt.Errorf("failed to install %s service file: %w", serviceKind, err)
+	}
+	log.Info("Service file installed", zap.String("manager", serviceKind), zap.String("path", path))
+	return nil
+}
+
+// installZipEntry 将发布包中的文件原子写入目标路径
+func installZipEntry(reader *zip.ReadCloser, name string, dst string, mode os.FileMode) error {
+	entry, err := reader.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to find %s in release archive: %w", name, err)
+	}
+	defer entry.Close()
+
+	data, err := io.ReadAll(entry)
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if err := writeFileAtomic(dst, data, mode); err != nil {
+		return fmt.Errorf("failed to install %s: %w", name, err)
+	}
+	return nil
+}
+
+// installTarGzEntry 将tar.gz发布包中文件名为name的文件原子写入目标路径，发布包内的目录层级不限
+func installTarGzEntry(archive string, name string, dst string, mode os.FileMode) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open release archive: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to open release archive: %w", err)
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("failed to find %s in release archive", name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read release archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg || path.Base(header.Name) != name {
+			continue
+		}
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", name, err)
+		}
+		if err := writeFileAtomic(dst, data, mode); err != nil {
+			return fmt.Errorf("failed to install %s: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// writeFileAtomic 写入同目录临时文件后重命名，运行中的二进制也可以直接替换
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := writeTempFile(dir, "."+filepath.Base(path)+".*", data, mode)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// writeTempFile 将data写入dir中按pattern命名的临时文件并同步到磁盘，返回文件路径
+func writeTempFile(dir, pattern string, data []byte, mode os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,289 @@
+package v2ray
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// releaseServer 提供发布包和摘要文件的本地HTTP服务，files 为路径到内容的映射
+type releaseServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+func newReleaseServer(t *testing.T, files map[string][]byte) *releaseServer {
+	t.Helper()
+	s := &releaseServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.Path)
+		s.mu.Unlock()
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requested 检查路径是否被请求过
+func (s *releaseServer) requested(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.requests {
+		if r == path {
+			return true
+		}
+	}
+	return false
+}
+
+// testArchive 返回包含files的zip发布包及其SHA256
+func testArchive(t *testing.T, files map[string]string) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:])
+}
+
+// dgst 返回V2Ray发布的 .dgst 文件内容
+func dgst(sha256 string) []byte {
+	return []byte("MD5= d41d8cd98f00b204e9800998ecf8427e\nSHA2-256= " + sha256 + "\n")
+}
+
+func TestStageBinaryVerifiesChecksum(t *testing.T) {
+	t.Parallel()
+
+	arch, err := releaseArch()
+	if err != nil {
+		t.Skip(err)
+	}
+	archive, digest := testArchive(t, map[string]string{"v2ray": "binary"})
+	assetPath := "/download/v5.16.1/v2ray-linux-" + arch + ".zip"
+	badDigest := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name       string
+		files      map[string][]byte
+		sha256     string
+		skipVerify bool
+		wantErr    string
+	}{
+		{name: "good dgst", files: map[string][]byte{assetPath: archive, assetPath + ".dgst": dgst(digest)}},
+		{name: "configured sha256", files: map[string][]byte{assetPath: archive, assetPath + ".dgst": dgst(badDigest)}, sha256: digest},
+		{name: "bad dgst", files: map[string][]byte{assetPath: archive, assetPath + ".dgst": dgst(badDigest)}, wantErr: "checksum mismatch"},
+		{name: "bad configured sha256", files: map[string][]byte{assetPath: archive}, sha256: badDigest, skipVerify: true, wantErr: "checksum mismatch"},
+		{name: "malformed dgst", files: map[string][]byte{assetPath: archive, assetPath + ".dgst": []byte("SHA2-256= abc\n")}, wantErr: "malformed SHA256 digest"},
+		{name: "missing dgst", files: map[string][]byte{assetPath: archive}, wantErr: "failed to download release digest"},
+		{name: "missing dgst, skip verify", files: map[string][]byte{assetPath: archive}, skipVerify: true},
+		{name: "missing archive", files: map[string][]byte{assetPath + ".dgst": dgst(digest)}, wantErr: "failed to download release"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// 发布地址指向本地镜像，不访问默认的GitHub地址
+			srv := newReleaseServer(t, tt.files)
+			cfg := testV2RayConfig(t)
+			cfg.Version = "5.16.1"
+			cfg.Install.ReleaseURL = srv.URL
+			cfg.Install.SHA256 = tt.sha256
+			cfg.Install.SkipVerify = tt.skipVerify
+			dst := filepath.Join(t.TempDir(), "v2ray")
+
+			err := newTestBackend(t, false).StageBinary(context.Background(), zap.NewNop(), cfg, dst)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("StageBinary() error = %v, want %q", err, tt.wantErr)
+				}
+				if _, err := os.Stat(dst); !os.IsNotExist(err) {
+					t.Errorf("binary installed despite failed verification")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StageBinary() error = %v", err)
+			}
+			if data, _ := os.ReadFile(dst); string(data) != "binary" {
+				t.Errorf("staged binary = %q, want archive content", data)
+			}
+			if !srv.requested(assetPath) {
+				t.Errorf("archive not downloaded from the configured release URL")
+			}
+		})
+	}
+}
+
+func TestStageBinaryLocalArchive(t *testing.T) {
+	t.Parallel()
+
+	archive, digest := testArchive(t, map[string]string{"v2ray": "binary"})
+	tests := []struct {
+		name       string
+		dgst       []byte
+		skipVerify bool
+		wantErr    string
+	}{
+		{name: "dgst", dgst: dgst(digest)},
+		{name: "bad dgst", dgst: dgst(strings.Repeat("0", 64)), wantErr: "checksum mismatch"},
+		{name: "no dgst", wantErr: "no SHA256 digest available"},
+		{name: "no dgst, skip verify", skipVerify: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "v2ray-linux-64.zip")
+			if err := os.WriteFile(path, archive, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.dgst != nil {
+				if err := os.WriteFile(path+".dgst", tt.dgst, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := testV2RayConfig(t)
+			cfg.Install.Archive = path
+			cfg.Install.SkipVerify = tt.skipVerify
+			dst := filepath.Join(dir, "v2ray")
+
+			err := newTestBackend(t, false).StageBinary(context.Background(), zap.NewNop(), cfg, dst)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("StageBinary() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StageBinary() error = %v", err)
+			}
+			if data, _ := os.ReadFile(dst); string(data) != "binary" {
+				t.Errorf("staged binary = %q, want archive content", data)
+			}
+		})
+	}
+}
+
+// testTarGz 返回包含单个文件的tar.gz发布包及其SHA256
+func testTarGz(t *testing.T, name string, content string) ([]byte, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:])
+}
+
+func TestSingBoxStageBinaryVerifiesChecksum(t *testing.T) {
+	t.Parallel()
+
+	arch, ok := singBoxArchs[runtime.GOARCH]
+	if !ok {
+		t.Skipf("unsupported architecture %s", runtime.GOARCH)
+	}
+	asset := "sing-box-1.10.1-linux-" + arch + ".tar.gz"
+	archive, digest := testTarGz(t, "sing-box-1.10.1-linux-"+arch+"/sing-box", "binary")
+	assetPath := "/download/v1.10.1/" + asset
+	sum := func(digest string) []byte { return []byte(digest + "  " + asset + "\n") }
+
+	tests := []struct {
+		name       string
+		files      map[string][]byte
+		skipVerify bool
+		wantErr    string
+	}{
+		{name: "good sha256sum", files: map[string][]byte{assetPath: archive, assetPath + ".sha256sum": sum(digest)}},
+		{name: "bad sha256sum", files: map[string][]byte{assetPath: archive, assetPath + ".sha256sum": sum(strings.Repeat("0", 64))}, wantErr: "checksum mismatch"},
+		{name: "missing sha256sum", files: map[string][]byte{assetPath: archive}, wantErr: "failed to download release digest"},
+		{name: "missing sha256sum, skip verify", files: map[string][]byte{assetPath: archive}, skipVerify: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := newReleaseServer(t, tt.files)
+			cfg := testV2RayConfig(t)
+			cfg.Version = "1.10.1"
+			cfg.Install.ReleaseURL = srv.URL
+			cfg.Install.SkipVerify = tt.skipVerify
+			dst := filepath.Join(t.TempDir(), "sing-box")
+
+			backend, err := NewBackend(BackendSingBox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = backend.StageBinary(context.Background(), zap.NewNop(), cfg, dst)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("StageBinary() error = %v, want %q", err, tt.wantErr)
+				}
+				if _, err := os.Stat(dst); !os.IsNotExist(err) {
+					t.Errorf("binary installed despite failed verification")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StageBinary() error = %v", err)
+			}
+			if data, _ := os.ReadFile(dst); string(data) != "binary" {
+				t.Errorf("staged binary = %q, want archive content", data)
+			}
+		})
+	}
+}
+
+func TestNewBackendReturnsSeparateInstances(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range Backends() {
+		a, err := NewBackend(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := NewBackend(name)
+		if a == b {
+			t.Errorf("NewBackend(%q) returned a shared instance", name)
+		}
+	}
+}
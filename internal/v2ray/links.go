@@ -0,0 +1,196 @@
+package v2ray
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+// DefaultUserEmail 查询分享链接时表示 uuid 对应的默认用户
+const DefaultUserEmail = "default"
+
+// ShareLink 单个入站的客户端分享链接
+type ShareLink struct {
+	Tag      string `json:"tag"`
+	Protocol string `json:"protocol"`
+	URL      string `json:"url"`
+}
+
+// vmessLink vmess:// 链接中base64编码的JSON（v2rayN格式）
+type vmessLink struct {
+	Version  string `json:"v"`
+	Remark   string `json:"ps"`
+	Address  string `json:"add"`
+	Port     string `json:"port"`
+	ID       string `json:"id"`
+	AlterID  string `json:"aid"`
+	Security string `json:"scy"`
+	Network  string `json:"net"`
+	Type     string `json:"type"`
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	TLS      string `json:"tls"`
+	SNI      string `json:"sni,omitempty"`
+}
+
+// FindUser 按email查找用户，DefaultUserEmail 表示 uuid 对应的默认用户
+func FindUser(cfg config.V2RayConfig, email string) (config.ClientConfig, bool) {
+	for _, client := range cfg.Clients {
+		if client.Email == email {
+			return client, true
+		}
+	}
+	if email == DefaultUserEmail {
+		return config.ClientConfig{UUID: cfg.UUID}, true
+	}
+	return config.ClientConfig{}, false
+}
+
+// ShareLinks 生成用户在每个入站上的分享链接，address 为客户端连接的公网地址
+// REALITY入站的公钥和short ID从store读取。
+func ShareLinks(store *state.Store, cfg config.V2RayConfig, address string, user config.ClientConfig) ([]ShareLink, error) {
+	realityByTag, err := realityParamsByTag(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]ShareLink, 0, len(cfg.EffectiveInbounds()))
+	for _, inbound := range cfg.EffectiveInbounds() {
+		link, err := shareLink(inbound, realityByTag[inbound.Tag], address, user)
+		if err != nil {
+			return nil, fmt.Errorf("inbound %s: %w", inbound.Tag, err)
+		}
+		links = append(links, ShareLink{Tag: inbound.Tag, Protocol: inbound.Protocol, URL: link})
+	}
+	return links, nil
+}
+
+// shareLink 按入站协议生成标准分享URI
+func shareLink(inbound config.InboundConfig, reality RealityParams, address string, user config.ClientConfig) (string, error) {
+	remark := clientRemark(inbound, user)
+	host := net.JoinHostPort(address, strconv.Itoa(inbound.Port))
+
+	// userinfo@host:port?query#remark 形式的链接
+	uri := func(scheme string, userinfo *url.Userinfo, query url.Values) string {
+		u := url.URL{
+			Scheme:   scheme,
+			User:     userinfo,
+			Host:     host,
+			RawQuery: query.Encode(),
+			Fragment: remark,
+		}
+		return u.String()
+	}
+
+	switch inbound.Protocol {
+	case "vmess":
+		link := vmessLink{
+			Version:  "2",
+			Remark:   remark,
+			Address:  address,
+			Port:     strconv.Itoa(inbound.Port),
+			ID:       user.UUID,
+			AlterID:  "0",
+			Security: "auto",
+			Network:  "tcp",
+			Type:     "none",
+		}
+		if inbound.Security == "tls" {
+			link.TLS = "tls"
+			link.SNI = inbound.TLS.ServerName
+		}
+		data, err := json.Marshal(link)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode vmess link: %w", err)
+		}
+		return "vmess://" + base64.StdEncoding.EncodeToString(data), nil
+
+	case "vless":
+		query := url.Values{"encryption": {"none"}, "type": {"tcp"}}
+		query.Set("security", securityOrNone(inbound.Security))
+		if inbound.Flow != "" {
+			query.Set("flow", inbound.Flow)
+		}
+		switch inbound.Security {
+		case "tls":
+			setNonEmpty(query, "sni", inbound.TLS.ServerName)
+		case "reality":
+			query.Set("sni", reality.ServerName)
+			query.Set("fp", reality.Fingerprint)
+			query.Set("pbk", reality.PublicKey)
+			setNonEmpty(query, "sid", reality.ShortID)
+		}
+		return uri("vless", url.User(user.UUID), query), nil
+
+	case "trojan":
+		query := url.Values{"security": {"tls"}, "type": {"tcp"}}
+		setNonEmpty(query, "sni", inbound.TLS.ServerName)
+		return uri("trojan", url.User(user.UUID), query), nil
+
+	case "shadowsocks":
+		// SIP002：2022系列的密钥为base64，直接百分号编码；其他方式使用base64url编码的 method:password
+		userinfo := base64.RawURLEncoding.EncodeToString([]byte(inbound.Method + ":" + inbound.Password))
+		if strings.HasPrefix(inbound.Method, "2022-") {
+			userinfo = url.QueryEscape(inbound.Method) + ":" + url.QueryEscape(inbound.Password)
+		}
+		return "ss://" + userinfo + "@" + host + "#" + url.PathEscape(remark), nil
+
+	case "hysteria2":
+		query := url.Values{}
+		setNonEmpty(query, "sni", inbound.TLS.ServerName)
+		if inbound.PortRange != "" {
+			// 端口跳跃：主机部分为 host:port,from-to
+			host += "," + inbound.PortRange
+		}
+		return uri("hysteria2", url.User(user.UUID), query), nil
+
+	case "tuic":
+		query := url.Values{"congestion_control": {"bbr"}, "alpn": {"h3"}}
+		setNonEmpty(query, "sni", inbound.TLS.ServerName)
+		return uri("tuic", url.UserPassword(user.UUID, user.UUID), query), nil
+	}
+	return "", fmt.Errorf("share links are not supported for protocol %s", inbound.Protocol)
+}
+
+// realityParamsByTag 返回按入站tag索引的REALITY客户端参数
+func realityParamsByTag(store *state.Store, cfg config.V2RayConfig) (map[string]RealityParams, error) {
+	reality, err := RealityClientParams(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	byTag := make(map[string]RealityParams, len(reality))
+	for _, params := range reality {
+		byTag[params.Tag] = params
+	}
+	return byTag, nil
+}
+
+// clientRemark 返回客户端中显示的节点名称，如 alice@example.com-vless
+func clientRemark(inbound config.InboundConfig, user config.ClientConfig) string {
+	if user.Email == "" {
+		return inbound.Tag
+	}
+	return user.Email + "-" + inbound.Tag
+}
+
+// securityOrNone 未配置传输安全时返回 none
+func securityOrNone(security string) string {
+	if security == "" {
+		return "none"
+	}
+	return security
+}
+
+// setNonEmpty 值不为空时设置查询参数
+func setNonEmpty(query url.Values, key string, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
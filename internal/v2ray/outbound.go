@@ -0,0 +1,149 @@
+package v2ray
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+// defaultWireGuardMTU 未配置 wireguard.mtu 时使用的MTU
+const defaultWireGuardMTU = 1420
+
+// healthInboundPrefix V2Ray/Xray健康检查入站的tag前缀，访问日志中这些入站的记录不计为流量
+const healthInboundPrefix = "health-"
+
+// healthProbe V2Ray/Xray中检查单个上游出站的本地SOCKS入站
+type healthProbe struct {
+	Inbound  string
+	Outbound string
+	Port     int
+}
+
+// healthProbes 按 outbounds 的顺序为每个上游出站分配检查端口
+func healthProbes(cfg config.V2RayConfig) []healthProbe {
+	var probes []healthProbe
+	for _, outbound := range cfg.Outbounds {
+		if !outbound.IsUpstream() {
+			continue
+		}
+		probes = append(probes, healthProbe{
+			Inbound:  healthInboundPrefix + outbound.Tag,
+			Outbound: outbound.Tag,
+			Port:     cfg.HealthCheck.Port + len(probes),
+		})
+	}
+	return probes
+}
+
+// wireGuardMTU 返回WireGuard出站的MTU
+func wireGuardMTU(wg config.WireGuardConfig) int {
+	if wg.MTU == 0 {
+		return defaultWireGuardMTU
+	}
+	return wg.MTU
+}
+
+// renderOutbound 生成V2Ray/Xray的额外出站
+func renderOutbound(outbound config.OutboundConfig) outboundSection {
+	section := outboundSection{
+		Protocol: outbound.Protocol,
+		Tag:      outbound.Tag,
+		Settings: map[string]interface{}{},
+	}
+
+	switch outbound.Protocol {
+	case "socks", "http":
+		server := map[string]interface{}{"address": outbound.Address, "port": outbound.Port}
+		if outbound.Username != "" {
+			server["users"] = []map[string]interface{}{{"user": outbound.Username, "pass": outbound.Password}}
+		}
+		section.Settings["servers"] = []interface{}{server}
+	case "vmess", "vless":
+		user := map[string]interface{}{"id": outbound.UUID}
+		if outbound.Protocol == "vmess" {
+			user["security"] = "auto"
+		} else {
+			user["encryption"] = "none"
+			if outbound.Flow != "" {
+				user["flow"] = outbound.Flow
+			}
+		}
+		section.Settings["vnext"] = []interface{}{map[string]interface{}{
+			"address": outbound.Address,
+			"port":    outbound.Port,
+			"users":   []interface{}{user},
+		}}
+	case "wireguard":
+		peer := map[string]interface{}{
+			"publicKey": outbound.WireGuard.PublicKey,
+			"endpoint":  net.JoinHostPort(outbound.Address, strconv.Itoa(outbound.Port)),
+		}
+		if outbound.WireGuard.PreSharedKey != "" {
+			peer["preSharedKey"] = outbound.WireGuard.PreSharedKey
+		}
+		section.Settings["secretKey"] = outbound.WireGuard.PrivateKey
+		section.Settings["address"] = outbound.WireGuard.LocalAddress
+		section.Settings["peers"] = []interface{}{peer}
+		section.Settings["mtu"] = wireGuardMTU(outbound.WireGuard)
+	}
+
+	if outbound.Security == "tls" {
+		section.StreamSettings = &streamSettings{
+			Network:     "tcp",
+			Security:    "tls",
+			TLSSettings: &tlsSettings{ServerName: outbound.ServerName},
+		}
+	}
+	return section
+}
+
+// renderSingBoxOutbound 生成sing-box的额外出站，freedom对应 direct
+func renderSingBoxOutbound(outbound config.OutboundConfig) singBoxOutbound {
+	if outbound.Protocol == "freedom" {
+		return singBoxOutbound{Type: "direct", Tag: outbound.Tag}
+	}
+
+	section := singBoxOutbound{
+		Type:       outbound.Protocol,
+		Tag:        outbound.Tag,
+		Server:     outbound.Address,
+		ServerPort: outbound.Port,
+		Username:   outbound.Username,
+		Password:   outbound.Password,
+		UUID:       outbound.UUID,
+		Flow:       outbound.Flow,
+	}
+	switch outbound.Protocol {
+	case "socks":
+		section.Version = "5"
+	case "vmess":
+		section.Security = "auto"
+	}
+	if outbound.Security == "tls" {
+		section.TLS = &singBoxClientTLS{Enabled: true, ServerName: outbound.ServerName}
+	}
+	return section
+}
+
+// renderSingBoxWireGuard 生成WireGuard端点，所有流量都经隧道转发
+func renderSingBoxWireGuard(outbound config.OutboundConfig) singBoxEndpoint {
+	addresses := make([]string, len(outbound.WireGuard.LocalAddress))
+	for i, address := range outbound.WireGuard.LocalAddress {
+		addresses[i] = ipPrefix(address)
+	}
+	return singBoxEndpoint{
+		Type:       "wireguard",
+		Tag:        outbound.Tag,
+		Address:    addresses,
+		PrivateKey: outbound.WireGuard.PrivateKey,
+		MTU:        wireGuardMTU(outbound.WireGuard),
+		Peers: []singBoxPeer{{
+			Address:      outbound.Address,
+			Port:         outbound.Port,
+			PublicKey:    outbound.WireGuard.PublicKey,
+			PreSharedKey: outbound.WireGuard.PreSharedKey,
+			AllowedIPs:   []string{"0.0.0.0/0", "::/0"},
+		}},
+	}
+}
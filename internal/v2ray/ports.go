@@ -0,0 +1,330 @@
+package v2ray
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/firewall"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+// portsStateKey 轮换后的入站端口在状态文件中的键，值为 入站tag -> PortState
+const portsStateKey = "ports"
+
+// ErrInboundNotFound 入站不存在或未配置端口轮换
+var ErrInboundNotFound = errors.New("inbound not found")
+
+// PortState 入站轮换后的监听端口，覆盖配置中的 port
+// Base 为轮换时配置中的端口，配置中的端口此后被修改或端口不在轮换范围内时以配置为准。
+type PortState struct {
+	Port      int            `json:"port"`
+	Base      int            `json:"base"`
+	RotatedAt time.Time      `json:"rotated_at"`
+	Previous  []PreviousPort `json:"previous,omitempty"`
+}
+
+// PreviousPort 轮换前的端口，到期前重定向到当前端口
+type PreviousPort struct {
+	Port      int       `json:"port"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PortRotationResult 单个入站的端口轮换结果
+type PortRotationResult struct {
+	Tag               string    `json:"tag"`
+	Port              int       `json:"port"`
+	PreviousPort      int       `json:"previous_port"`
+	RotatedAt         time.Time `json:"rotated_at"`
+	PreviousExpiresAt time.Time `json:"previous_expires_at"` // 旧端口停止重定向的时间
+}
+
+// loadPorts 读取所有入站轮换后的端口
+func loadPorts(store *state.Store) (map[string]PortState, error) {
+	ports := make(map[string]PortState)
+	if _, err := store.Get(portsStateKey, &ports); err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+// ApplyPorts 返回用轮换后的端口替换入站端口的副本，用于生成核心配置、分享链接和订阅
+func ApplyPorts(store *state.Store, cfg config.V2RayConfig) (config.V2RayConfig, error) {
+	if store == nil {
+		return cfg, nil
+	}
+	ports, err := loadPorts(store)
+	if err != nil {
+		return cfg, err
+	}
+	return applyPorts(cfg, ports), nil
+}
+
+// applyPorts 替换为轮换后的端口
+func applyPorts(cfg config.V2RayConfig, ports map[string]PortState) config.V2RayConfig {
+	if len(cfg.Inbounds) == 0 {
+		return cfg
+	}
+	inbounds := make([]config.InboundConfig, len(cfg.Inbounds))
+	for i, inbound := range cfg.Inbounds {
+		if port, ok := effectivePort(inbound, ports); ok {
+			inbound.Port = port.Port
+		}
+		inbounds[i] = inbound
+	}
+	cfg.Inbounds = inbounds
+	return cfg
+}
+
+// effectivePort 返回入站仍然有效的轮换记录
+func effectivePort(inbound config.InboundConfig, ports map[string]PortState) (PortState, bool) {
+	port, ok := ports[inbound.Tag]
+	if !ok || inbound.PortRotation.Range == "" || port.Base != inbound.Port {
+		return PortState{}, false
+	}
+	r, err := config.ParsePortRange(inbound.PortRotation.Range)
+	if err != nil || (port.Port != port.Base && !r.Contains(port.Port)) {
+		return PortState{}, false
+	}
+	return port, true
+}
+
+// RotatePort 将入站的监听端口换到轮换范围内的随机端口，当前端口在 overlap 内重定向到新端口
+func RotatePort(store *state.Store, cfg config.V2RayConfig, tag string, reserved ...int) (*PortRotationResult, error) {
+	inbound, ok := findInbound(cfg, tag)
+	if !ok || inbound.PortRotation.Range == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInboundNotFound, tag)
+	}
+
+	// 在store的锁内读取和保存，与其他轮换互斥
+	ports := make(map[string]PortState)
+	var result *PortRotationResult
+	err := store.Update(portsStateKey, &ports, func() (bool, error) {
+		var err error
+		result, err = rotatePort(cfg, ports, inbound, time.Now(), reserved)
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RotateDuePorts 轮换距上次轮换已超过 port_rotation.interval 的入站，并清理过期的旧端口
+// 返回轮换结果，以及监听端口或重定向规则是否需要更新。reserved 为不能使用的其他端口，如API端口。
+func RotateDuePorts(store *state.Store, cfg config.V2RayConfig, reserved ...int) ([]PortRotationResult, bool, error) {
+	now := time.Now()
+	var results []PortRotationResult
+	changed := false
+	// 在store的锁内读取和保存，与其他轮换互斥
+	ports := make(map[string]PortState)
+	err := store.Update(portsStateKey, &ports, func() (bool, error) {
+		dirty := false
+		rotating := make(map[string]bool)
+		for _, inbound := range cfg.Inbounds {
+			rotation := inbound.PortRotation
+			if rotation.Range == "" {
+				continue
+			}
+			rotating[inbound.Tag] = true
+			port, ok := effectivePort(inbound, ports)
+
+			switch {
+			case !ok:
+				// 未轮换过或配置已修改，轮换结果本就不生效，只重新开始计时
+				if _, recorded := ports[inbound.Tag]; recorded || rotation.Interval > 0 {
+					ports[inbound.Tag] = PortState{Port: inbound.Port, Base: inbound.Port, RotatedAt: now}
+					dirty = true
+				}
+			case rotation.Interval > 0 && now.Sub(port.RotatedAt) >= rotation.Interval.Std():
+				result, err := rotatePort(cfg, ports, inbound, now, reserved)
+				if err != nil {
+					return false, err
+				}
+				results = append(results, *result)
+				changed = true
+			default:
+				if kept := unexpiredPorts(port.Previous, now); len(kept) != len(port.Previous) {
+					port.Previous = kept
+					ports[inbound.Tag] = port
+					changed = true
+				}
+			}
+		}
+		for tag := range ports {
+			if !rotating[tag] {
+				delete(ports, tag)
+				dirty = true
+			}
+		}
+		return changed || dirty, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return results, changed, nil
+}
+
+// rotatePort 为入站选择新端口并更新 ports
+func rotatePort(cfg config.V2RayConfig, ports map[string]PortState, inbound config.InboundConfig, now time.Time, reserved []int) (*PortRotationResult, error) {
+	r, err := config.ParsePortRange(inbound.PortRotation.Range)
+	if err != nil {
+		return nil, err
+	}
+
+	current := PortState{Port: inbound.Port, Base: inbound.Port}
+	if port, ok := effectivePort(inbound, ports); ok {
+		current = port
+	}
+
+	// 不能使用的端口：所有入站当前的端口、跳跃范围、仍在重定向的旧端口以及调用方保留的端口
+	used := make(map[int]bool)
+	for _, port := range reserved {
+		used[port] = true
+	}
+	var hopping []config.PortRange
+	for _, other := range applyPorts(cfg, ports).Inbounds {
+		used[other.Port] = true
+		if other.PortRange != "" {
+			if h, err := config.ParsePortRange(other.PortRange); err == nil {
+				hopping = append(hopping, h)
+			}
+		}
+	}
+	for _, other := range ports {
+		for _, old := range unexpiredPorts(other.Previous, now) {
+			used[old.Port] = true
+		}
+	}
+	if port, _, err := net.SplitHostPort(cfg.Decoy.Listen); err == nil {
+		if n, err := strconv.Atoi(port); err == nil {
+			used[n] = true
+		}
+	}
+
+	next, err := pickPort(r, func(port int) bool {
+		if used[port] {
+			return false
+		}
+		for _, h := range hopping {
+			if h.Contains(port) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("inbound %s: %w", inbound.Tag, err)
+	}
+
+	overlap := inbound.PortRotation.Overlap.Std()
+	previous := unexpiredPorts(current.Previous, now)
+	if overlap > 0 {
+		previous = append(previous, PreviousPort{Port: current.Port, ExpiresAt: now.Add(overlap)})
+	}
+	ports[inbound.Tag] = PortState{Port: next, Base: inbound.Port, RotatedAt: now, Previous: previous}
+	return &PortRotationResult{
+		Tag:               inbound.Tag,
+		Port:              next,
+		PreviousPort:      current.Port,
+		RotatedAt:         now,
+		PreviousExpiresAt: now.Add(overlap),
+	}, nil
+}
+
+// pickPort 在范围内随机选择一个可用端口，随机尝试失败后按顺序查找
+func pickPort(r config.PortRange, available func(port int) bool) (int, error) {
+	size := big.NewInt(int64(r.To - r.From + 1))
+	for i := 0; i < 32; i++ {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return 0, fmt.Errorf("failed to pick port: %w", err)
+		}
+		if port := r.From + int(n.Int64()); available(port) {
+			return port, nil
+		}
+	}
+	for port := r.From; port <= r.To; port++ {
+		if available(port) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port in range %s", r)
+}
+
+// unexpiredPorts 返回在now仍需重定向的旧端口
+func unexpiredPorts(previous []PreviousPort, now time.Time) []PreviousPort {
+	var kept []PreviousPort
+	for _, old := range previous {
+		if now.Before(old.ExpiresAt) {
+			kept = append(kept, old)
+		}
+	}
+	return kept
+}
+
+// findInbound 按tag查找配置的入站
+func findInbound(cfg config.V2RayConfig, tag string) (config.InboundConfig, bool) {
+	for _, inbound := range cfg.Inbounds {
+		if inbound.Tag == tag {
+			return inbound, true
+		}
+	}
+	return config.InboundConfig{}, false
+}
+
+// PortRedirects 返回需要的重定向规则：跳跃范围和重叠期内的旧端口都重定向到入站当前的端口
+func PortRedirects(store *state.Store, cfg config.V2RayConfig) ([]firewall.Redirect, error) {
+	ports, err := loadPorts(store)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var redirects []firewall.Redirect
+	for _, inbound := range applyPorts(cfg, ports).Inbounds {
+		if inbound.PortRange != "" {
+			r, err := config.ParsePortRange(inbound.PortRange)
+			if err != nil {
+				return nil, err
+			}
+			redirects = append(redirects, firewall.Redirect{From: r.From, To: r.To, Target: inbound.Port})
+		}
+	}
+	for _, inbound := range cfg.Inbounds {
+		port, ok := effectivePort(inbound, ports)
+		if !ok {
+			continue
+		}
+		for _, old := range unexpiredPorts(port.Previous, now) {
+			redirects = append(redirects, firewall.Redirect{From: old.Port, To: old.Port, Target: port.Port})
+		}
+	}
+	return redirects, nil
+}
+
+// OpenPorts 返回客户端需要访问的端口范围：入站当前的端口、跳跃范围和重叠期内的旧端口
+func OpenPorts(store *state.Store, cfg config.V2RayConfig) ([]config.PortRange, error) {
+	resolved, err := ApplyPorts(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+	redirects, err := PortRedirects(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var open []config.PortRange
+	for _, inbound := range resolved.EffectiveInbounds() {
+		open = append(open, config.PortRange{From: inbound.Port, To: inbound.Port})
+	}
+	for _, r := range redirects {
+		open = append(open, config.PortRange{From: r.From, To: r.To})
+	}
+	return open, nil
+}
@@ -0,0 +1,82 @@
+package v2ray
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+// testRotationConfig 返回一个在 range 内轮换端口的入站配置
+func testRotationConfig(t *testing.T, portRange string) config.V2RayConfig {
+	t.Helper()
+	cfg := testV2RayConfig(t)
+	cfg.Inbounds = []config.InboundConfig{{
+		Tag:          "vmess-in",
+		Protocol:     "vmess",
+		Port:         20000,
+		PortRotation: config.PortRotationConfig{Range: portRange, Overlap: config.Duration(time.Hour)},
+	}}
+	return cfg
+}
+
+func TestRotatePortConcurrent(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	cfg := testRotationConfig(t, "30000-40000")
+
+	// 并发轮换不会丢失任何一次轮换，每次轮换前的端口在重叠期内重定向到当前端口
+	const rotations = 10
+	var wg sync.WaitGroup
+	for range rotations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := RotatePort(store, cfg, "vmess-in", 443); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ports, err := loadPorts(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ports["vmess-in"]
+	if len(port.Previous) != rotations || port.Previous[0].Port != 20000 {
+		t.Fatalf("previous ports = %v, want %d starting with the configured port", port.Previous, rotations)
+	}
+	if port.Port < 30000 || port.Port > 40000 {
+		t.Errorf("port = %d, want a port in the rotation range", port.Port)
+	}
+
+	applied, err := ApplyPorts(store, cfg)
+	if err != nil || applied.Inbounds[0].Port != port.Port {
+		t.Errorf("ApplyPorts() port = %d, %v, want %d", applied.Inbounds[0].Port, err, port.Port)
+	}
+	redirects, err := PortRedirects(store, cfg)
+	if err != nil || len(redirects) != rotations {
+		t.Errorf("PortRedirects() = %v, %v, want one redirect per previous port", redirects, err)
+	}
+}
+
+func TestRotatePortReserved(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	cfg := testRotationConfig(t, "30000-30001")
+
+	// 范围内唯一的其他端口被保留时无法轮换，状态不变
+	if _, err := RotatePort(store, cfg, "vmess-in", 30000, 30001); err == nil {
+		t.Fatal("RotatePort() succeeded without a free port")
+	}
+	if ports, err := loadPorts(store); err != nil || len(ports) != 0 {
+		t.Errorf("ports = %v, %v, want nothing saved", ports, err)
+	}
+	if _, err := RotatePort(store, cfg, "missing"); err == nil {
+		t.Error("RotatePort() succeeded for an unknown inbound")
+	}
+}
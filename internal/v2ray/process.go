@@ -0,0 +1,237 @@
+package v2ray
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// procDir proc文件系统挂载点
+const procDir = "/proc"
+
+// clockTicks /proc/<pid>/stat 中时间字段的单位（USER_HZ），Linux上固定为100
+const clockTicks = 100
+
+// ProcessInfo 正在运行的代理核心进程信息
+type ProcessInfo struct {
+	PID       int       `json:"pid"`
+	Exe       string    `json:"exe"`
+	StartedAt time.Time `json:"started_at,omitzero"`
+	RSSBytes  int64     `json:"rss_bytes"`
+	Listeners []string  `json:"listeners,omitempty"` // 监听的地址，如 "tcp [::]:10086"
+}
+
+// FindProcess 在/proc中查找可执行文件为binary的进程，未运行时返回nil
+// 只比较 /proc/<pid>/exe，命令行中包含核心名称的其他进程（如编辑器、tail）不会被匹配。
+func FindProcess(binary string) (*ProcessInfo, error) {
+	return findProcess(procDir, binary)
+}
+
+// findProcess 在procRoot中查找可执行文件为binary的进程
+func findProcess(procRoot, binary string) (*ProcessInfo, error) {
+	// 二进制可能是符号链接，/proc/<pid>/exe 指向的是真实路径
+	if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+		binary = resolved
+	}
+
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", procRoot, err)
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		exe, err := os.Readlink(filepath.Join(procRoot, entry.Name(), "exe"))
+		if err != nil {
+			// 进程已退出或无权限读取
+			continue
+		}
+		// 二进制在进程运行期间被替换时链接目标带有 " (deleted)" 后缀
+		if strings.TrimSuffix(exe, " (deleted)") != binary {
+			continue
+		}
+
+		info := &ProcessInfo{PID: pid, Exe: exe}
+		info.StartedAt, _ = processStartTime(procRoot, pid)
+		info.RSSBytes, _ = processRSS(procRoot, pid)
+		info.Listeners, _ = processListeners(procRoot, pid)
+		return info, nil
+	}
+	return nil, nil
+}
+
+// processStartTime 由 /proc/<pid>/stat 的starttime和 /proc/stat 的btime计算进程启动时间
+func processStartTime(procRoot string, pid int) (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// comm 字段可能包含空格，从最后一个 ')' 之后开始按空格拆分
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return time.Time{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	// starttime 是第22个字段，')' 之后的第一个字段是第3个
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	startTicks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	bootTime, err := systemBootTime(procRoot)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return bootTime.Add(time.Duration(startTicks) * time.Second / clockTicks), nil
+}
+
+// systemBootTime 读取 /proc/stat 中的系统启动时间
+func systemBootTime(procRoot string) (time.Time, error) {
+	file, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "btime ")
+		if !ok {
+			continue
+		}
+		seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("btime not found in %s/stat", procRoot)
+}
+
+// processRSS 读取 /proc/<pid>/status 中的VmRSS，单位字节
+func processRSS(procRoot string, pid int) (int64, error) {
+	file, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "VmRSS:")
+		if !ok {
+			continue
+		}
+		// 格式为 "VmRSS:     12345 kB"
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			break
+		}
+		kb, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return kb * 1024, nil
+	}
+	return 0, nil
+}
+
+// processListeners 返回进程监听的TCP端口和绑定的UDP端口
+// 先从 /proc/<pid>/fd 收集socket inode，再在进程所在网络命名空间的 /proc/<pid>/net/* 中匹配。
+func processListeners(procRoot string, pid int) ([]string, error) {
+	pidDir := filepath.Join(procRoot, strconv.Itoa(pid))
+	fds, err := os.ReadDir(filepath.Join(pidDir, "fd"))
+	if err != nil {
+		return nil, err
+	}
+	inodes := make(map[string]bool)
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(pidDir, "fd", fd.Name()))
+		if err != nil {
+			continue
+		}
+		if inode, ok := strings.CutPrefix(link, "socket:["); ok {
+			inodes[strings.TrimSuffix(inode, "]")] = true
+		}
+	}
+	if len(inodes) == 0 {
+		return nil, nil
+	}
+
+	var listeners []string
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		// TCP只统计LISTEN(0A)状态，UDP只统计未连接(07)的绑定socket
+		state := "0A"
+		if strings.HasPrefix(proto, "udp") {
+			state = "07"
+		}
+		addrs, err := socketAddresses(filepath.Join(pidDir, "net", proto), state, inodes)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			listeners = append(listeners, strings.TrimSuffix(proto, "6")+" "+addr)
+		}
+	}
+	return listeners, nil
+}
+
+// socketAddresses 解析 /proc/net/{tcp,udp}[6]，返回inode属于进程且状态匹配的本地地址
+func socketAddresses(path string, state string, inodes map[string]bool) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // 跳过表头
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state || !inodes[fields[9]] {
+			continue
+		}
+		addr, err := parseSocketAddress(fields[1])
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, scanner.Err()
+}
+
+// parseSocketAddress 解析十六进制的 "IP:端口"，IP按32位字为单位以主机字节序（小端）存储
+func parseSocketAddress(s string) (string, error) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", fmt.Errorf("malformed socket address %q", s)
+	}
+	raw, err := hex.DecodeString(ipHex)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", fmt.Errorf("malformed socket address %q", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("malformed socket address %q", s)
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10)), nil
+}
@@ -0,0 +1,235 @@
+package v2ray
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+	"go.uber.org/zap"
+)
+
+// defaultRealityFingerprint 未配置 reality.fingerprint 时客户端使用的uTLS指纹
+const defaultRealityFingerprint = "chrome"
+
+// realityDestTimeout 检查REALITY目标站点的超时时间
+const realityDestTimeout = 5 * time.Second
+
+// RealityKeys REALITY入站的密钥和short ID，保存在状态文件中
+type RealityKeys struct {
+	PrivateKey string   `json:"private_key,omitempty"`
+	PublicKey  string   `json:"public_key,omitempty"`
+	ShortIDs   []string `json:"short_ids,omitempty"`
+}
+
+// RealityParams 客户端连接REALITY入站所需的参数
+type RealityParams struct {
+	Tag         string   `json:"tag"`
+	Port        int      `json:"port"`
+	Flow        string   `json:"flow,omitempty"`
+	PublicKey   string   `json:"public_key"`
+	ServerName  string   `json:"server_name"` // 分享链接使用的SNI，为 server_names 的第一个
+	ServerNames []string `json:"server_names"`
+	ShortID     string   `json:"short_id"` // 分享链接使用的short ID，为 short_ids 的第一个
+	ShortIDs    []string `json:"short_ids"`
+	Fingerprint string   `json:"fingerprint"`
+	Dest        string   `json:"dest"`
+}
+
+// realityStateKey 返回入站密钥在状态文件中的键
+func realityStateKey(tag string) string {
+	return "reality." + tag
+}
+
+// LoadRealityKeys 返回入站的REALITY密钥
+// 配置中的 private_key 和 short_ids 优先；未配置时使用状态文件中保存的值，
+// 状态文件中也没有时生成新的x25519密钥对和short ID并保存。读取和生成在store的锁内完成，
+// 并发调用不会生成不同的密钥。
+func LoadRealityKeys(store *state.Store, inbound config.InboundConfig) (*RealityKeys, error) {
+	var saved RealityKeys
+	var keys *RealityKeys
+	resolve := func() (bool, error) {
+		var generated bool
+		var err error
+		keys, generated, err = resolveRealityKeys(inbound, &saved)
+		return generated, err
+	}
+
+	if store == nil {
+		generated, err := resolve()
+		if err != nil {
+			return nil, err
+		}
+		if generated {
+			return nil, errors.New("no state store to save generated reality keys")
+		}
+		return keys, nil
+	}
+	if err := store.Update(realityStateKey(inbound.Tag), &saved, resolve); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// resolveRealityKeys 按配置和已保存的值确定入站的密钥，缺少的值生成后写入saved，返回是否生成了新值
+func resolveRealityKeys(inbound config.InboundConfig, saved *RealityKeys) (*RealityKeys, bool, error) {
+	generated := false
+	keys := &RealityKeys{PrivateKey: inbound.Reality.PrivateKey, ShortIDs: inbound.Reality.ShortIDs}
+	if keys.PrivateKey == "" {
+		if saved.PrivateKey == "" {
+			privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to generate reality key: %w", err)
+			}
+			saved.PrivateKey = base64.RawURLEncoding.EncodeToString(privateKey.Bytes())
+			saved.PublicKey = base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes())
+			generated = true
+		}
+		keys.PrivateKey = saved.PrivateKey
+	}
+	if len(keys.ShortIDs) == 0 {
+		if len(saved.ShortIDs) == 0 {
+			id := make([]byte, 8)
+			if _, err := rand.Read(id); err != nil {
+				return nil, false, fmt.Errorf("failed to generate reality short id: %w", err)
+			}
+			saved.ShortIDs = []string{hex.EncodeToString(id)}
+			generated = true
+		}
+		keys.ShortIDs = saved.ShortIDs
+	}
+
+	publicKey, err := realityPublicKey(keys.PrivateKey)
+	if err != nil {
+		return nil, false, err
+	}
+	keys.PublicKey = publicKey
+	return keys, generated, nil
+}
+
+// realityPublicKey 由base64url编码的x25519私钥计算公钥
+func realityPublicKey(privateKey string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %w", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// resolveReality 返回填入REALITY密钥后的配置副本，供生成核心配置使用
+func resolveReality(store *state.Store, cfg config.V2RayConfig) (config.V2RayConfig, error) {
+	inbounds := make([]config.InboundConfig, len(cfg.Inbounds))
+	for i, inbound := range cfg.Inbounds {
+		if inbound.Security == "reality" {
+			keys, err := LoadRealityKeys(store, inbound)
+			if err != nil {
+				return cfg, fmt.Errorf("inbound %s: %w", inbound.Tag, err)
+			}
+			inbound.Reality.PrivateKey = keys.PrivateKey
+			inbound.Reality.ShortIDs = keys.ShortIDs
+		}
+		inbounds[i] = inbound
+	}
+	cfg.Inbounds = inbounds
+	return cfg, nil
+}
+
+// RealityClientParams 返回所有REALITY入站的客户端参数
+func RealityClientParams(store *state.Store, cfg config.V2RayConfig) ([]RealityParams, error) {
+	params := []RealityParams{}
+	for _, inbound := range cfg.EffectiveInbounds() {
+		if inbound.Security != "reality" {
+			continue
+		}
+		keys, err := LoadRealityKeys(store, inbound)
+		if err != nil {
+			return nil, fmt.Errorf("inbound %s: %w", inbound.Tag, err)
+		}
+		fingerprint := inbound.Reality.Fingerprint
+		if fingerprint == "" {
+			fingerprint = defaultRealityFingerprint
+		}
+		params = append(params, RealityParams{
+			Tag:         inbound.Tag,
+			Port:        inbound.Port,
+			Flow:        inbound.Flow,
+			PublicKey:   keys.PublicKey,
+			ServerName:  inbound.Reality.ServerNames[0],
+			ServerNames: inbound.Reality.ServerNames,
+			ShortID:     keys.ShortIDs[0],
+			ShortIDs:    keys.ShortIDs,
+			Fingerprint: fingerprint,
+			Dest:        inbound.Reality.Dest,
+		})
+	}
+	return params, nil
+}
+
+// CheckRealityDest 从本机以TLS 1.3连接REALITY目标站点，确认其可达且证书包含第一个SNI
+// 目标站点不可达或不支持TLS 1.3时，REALITY入站无法正常伪装。
+func CheckRealityDest(ctx context.Context, reality config.RealityConfig) error {
+	serverName := ""
+	if len(reality.ServerNames) > 0 {
+		serverName = reality.ServerNames[0]
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: realityDestTimeout},
+		Config: &tls.Config{
+			ServerName: serverName,
+			MinVersion: tls.VersionTLS13,
+			// 本机可能缺少CA证书，只检查证书中的域名
+			InsecureSkipVerify: true,
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, realityDestTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", reality.Dest)
+	if err != nil {
+		return fmt.Errorf("failed to connect to reality dest %s: %w", reality.Dest, err)
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("reality dest %s sent no certificate", reality.Dest)
+	}
+	if serverName != "" {
+		if err := certs[0].VerifyHostname(serverName); err != nil {
+			return fmt.Errorf("reality dest %s: %w", reality.Dest, err)
+		}
+	}
+	return nil
+}
+
+// checkRealityDests 检查所有REALITY入站的目标站点，不可达时只记录警告
+// 目标站点可能只是暂时不可达，不应阻止部署。
+func checkRealityDests(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig) {
+	for _, inbound := range cfg.Inbounds {
+		if inbound.Security != "reality" {
+			continue
+		}
+		if err := CheckRealityDest(ctx, inbound.Reality); err != nil {
+			log.Warn("REALITY dest check failed",
+				zap.String("inbound", inbound.Tag),
+				zap.String("dest", inbound.Reality.Dest),
+				zap.Error(err))
+			continue
+		}
+		log.Info("REALITY dest reachable",
+			zap.String("inbound", inbound.Tag),
+			zap.String("dest", inbound.Reality.Dest))
+	}
+}
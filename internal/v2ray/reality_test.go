@@ -0,0 +1,62 @@
+package v2ray
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+func TestLoadRealityKeysConcurrent(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	inbound := config.InboundConfig{Tag: "vless-reality", Protocol: "vless", Port: 443, Security: "reality"}
+
+	// 并发首次读取只生成一组密钥
+	keys := make([]*RealityKeys, 20)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k, err := LoadRealityKeys(store, inbound)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			keys[i] = k
+		}()
+	}
+	wg.Wait()
+	for _, k := range keys[1:] {
+		if k == nil || k.PrivateKey != keys[0].PrivateKey || k.ShortIDs[0] != keys[0].ShortIDs[0] {
+			t.Fatalf("concurrent loads returned different keys")
+		}
+	}
+
+	var saved RealityKeys
+	if ok, err := store.Get(realityStateKey(inbound.Tag), &saved); !ok || err != nil || saved.PrivateKey != keys[0].PrivateKey {
+		t.Errorf("saved keys = %+v, %v, want the returned keys", saved, err)
+	}
+}
+
+func TestLoadRealityKeysWithoutStore(t *testing.T) {
+	t.Parallel()
+
+	inbound := config.InboundConfig{Tag: "vless-reality", Protocol: "vless", Port: 443, Security: "reality"}
+	if _, err := LoadRealityKeys(nil, inbound); err == nil {
+		t.Error("LoadRealityKeys() generated keys without a store to save them")
+	}
+
+	generated, err := LoadRealityKeys(newTestStore(t), inbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound.Reality.PrivateKey = generated.PrivateKey
+	inbound.Reality.ShortIDs = generated.ShortIDs
+	keys, err := LoadRealityKeys(nil, inbound)
+	if err != nil || keys.PublicKey != generated.PublicKey {
+		t.Errorf("LoadRealityKeys() = %+v, %v, want configured keys", keys, err)
+	}
+}
@@ -0,0 +1,284 @@
+package v2ray
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+// serverConfig V2Ray/Xray配置文件结构（仅包含Agent生成的部分）
+type serverConfig struct {
+	Log       logSection        `json:"log"`
+	Inbounds  []inboundSection  `json:"inbounds"`
+	Outbounds []outboundSection `json:"outbounds"`
+	Routing   *routingSection   `json:"routing,omitempty"`
+	DNS       *dnsSection       `json:"dns,omitempty"`
+	Stats     *struct{}         `json:"stats,omitempty"`
+	API       *apiSection       `json:"api,omitempty"`
+	Policy    *policySection    `json:"policy,omitempty"`
+}
+
+// apiSection 核心的gRPC API，经同名tag的入站访问
+type apiSection struct {
+	Tag      string   `json:"tag"`
+	Services []string `json:"services"`
+}
+
+// policySection 本地策略，只用于开启用户流量统计
+type policySection struct {
+	Levels map[string]policyLevel `json:"levels"`
+}
+
+// policyLevel 用户等级的策略，所有用户使用等级0
+type policyLevel struct {
+	StatsUserUplink   bool `json:"statsUserUplink"`
+	StatsUserDownlink bool `json:"statsUserDownlink"`
+}
+
+// logSection 日志配置
+type logSection struct {
+	Access   string `json:"access"`
+	Error    string `json:"error"`
+	LogLevel string `json:"loglevel"`
+}
+
+// inboundSection 入站配置
+type inboundSection struct {
+	Tag            string          `json:"tag,omitempty"`
+	Listen         string          `json:"listen,omitempty"`
+	Port           int             `json:"port"`
+	Protocol       string          `json:"protocol"`
+	Settings       inboundSettings `json:"settings"`
+	StreamSettings *streamSettings `json:"streamSettings,omitempty"`
+	Sniffing       *sniffing       `json:"sniffing,omitempty"`
+}
+
+// sniffing 流量探测，按域名或协议路由时需要开启
+type sniffing struct {
+	Enabled      bool     `json:"enabled"`
+	DestOverride []string `json:"destOverride"`
+}
+
+// inboundSettings 入站协议设置
+type inboundSettings struct {
+	Clients    []clientSection   `json:"clients,omitempty"`
+	Decryption string            `json:"decryption,omitempty"` // VLESS固定为 none
+	Method     string            `json:"method,omitempty"`     // Shadowsocks
+	Password   string            `json:"password,omitempty"`   // Shadowsocks
+	Network    string            `json:"network,omitempty"`    // Shadowsocks
+	Auth       string            `json:"auth,omitempty"`       // SOCKS
+	Fallbacks  []fallbackSection `json:"fallbacks,omitempty"`  // VLESS/Trojan
+	Address    string            `json:"address,omitempty"`    // dokodemo-door
+}
+
+// clientSection 入站用户，VMess/VLESS使用id，Trojan使用password
+type clientSection struct {
+	ID       string `json:"id,omitempty"`
+	Password string `json:"password,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// streamSettings 传输层配置
+type streamSettings struct {
+	Network         string           `json:"network"`
+	Security        string           `json:"security"`
+	TLSSettings     *tlsSettings     `json:"tlsSettings,omitempty"`
+	RealitySettings *realitySettings `json:"realitySettings,omitempty"`
+}
+
+// tlsSettings TLS配置
+type tlsSettings struct {
+	ServerName   string               `json:"serverName,omitempty"`
+	Certificates []certificateSection `json:"certificates,omitempty"`
+}
+
+// certificateSection TLS证书文件
+type certificateSection struct {
+	CertificateFile string `json:"certificateFile"`
+	KeyFile         string `json:"keyFile"`
+}
+
+// realitySettings Xray REALITY配置，未认证的连接转发到dest
+type realitySettings struct {
+	Show        bool     `json:"show"`
+	Dest        string   `json:"dest"`
+	Xver        int      `json:"xver"`
+	ServerNames []string `json:"serverNames"`
+	PrivateKey  string   `json:"privateKey"`
+	ShortIDs    []string `json:"shortIds"`
+}
+
+// outboundSection 出站配置
+type outboundSection struct {
+	Protocol       string                 `json:"protocol"`
+	Tag            string                 `json:"tag"`
+	Settings       map[string]interface{} `json:"settings"`
+	StreamSettings *streamSettings        `json:"streamSettings,omitempty"`
+}
+
+// renderCoreConfig 根据Agent配置生成V2Ray/Xray配置文件内容
+func renderCoreConfig(cfg config.V2RayConfig, errorLog string) ([]byte, error) {
+	server := serverConfig{
+		Log: logSection{
+			Access:   cfg.AccessLog,
+			Error:    errorLog,
+			LogLevel: "info",
+		},
+		// 第一个出站为默认出站
+		Outbounds: []outboundSection{
+			{
+				Protocol: "freedom",
+				Tag:      config.OutboundDirect,
+				Settings: map[string]interface{}{},
+			},
+			{
+				Protocol: "blackhole",
+				Tag:      config.OutboundBlock,
+				Settings: map[string]interface{}{},
+			},
+		},
+	}
+	for _, outbound := range cfg.Outbounds {
+		server.Outbounds = append(server.Outbounds, renderOutbound(outbound))
+	}
+	if strategy := freedomDomainStrategy(cfg.DNS); strategy != "" {
+		for _, outbound := range server.Outbounds {
+			if outbound.Protocol == "freedom" {
+				outbound.Settings["domainStrategy"] = strategy
+			}
+		}
+	}
+	server.DNS = renderDNS(cfg.DNS)
+
+	rules := routingRules(cfg)
+	probes := healthProbes(cfg)
+	server.Routing = renderRouting(rules, probes, cfg.DNS.DomainStrategy)
+	users := cfg.Users()
+	if cfg.StatsAPI != "" {
+		// 流量按email统计，默认用户也需要email
+		users[0].Email = DefaultUserEmail
+	}
+	for _, inbound := range cfg.EffectiveInbounds() {
+		section := renderInbound(inbound, users)
+		section.Settings.Fallbacks = renderFallbacks(cfg, inbound)
+		if needsSniffing(rules) {
+			section.Sniffing = &sniffing{Enabled: true, DestOverride: []string{"http", "tls"}}
+		}
+		server.Inbounds = append(server.Inbounds, section)
+	}
+	// 健康检查使用的本地SOCKS入站
+	for _, probe := range probes {
+		server.Inbounds = append(server.Inbounds, inboundSection{
+			Tag:      probe.Inbound,
+			Listen:   "127.0.0.1",
+			Port:     probe.Port,
+			Protocol: "socks",
+			Settings: inboundSettings{Auth: "noauth"},
+		})
+	}
+	if cfg.StatsAPI != "" {
+		if err := renderStatsAPI(&server, cfg.StatsAPI); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.MarshalIndent(server, "", "  ")
+}
+
+// renderStatsAPI 开启用户流量统计，并在address上提供StatsService供 api 子命令查询
+func renderStatsAPI(server *serverConfig, address string) error {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return err
+	}
+
+	server.Stats = &struct{}{}
+	server.API = &apiSection{Tag: statsAPITag, Services: []string{"StatsService"}}
+	server.Policy = &policySection{Levels: map[string]policyLevel{
+		"0": {StatsUserUplink: true, StatsUserDownlink: true},
+	}}
+	server.Inbounds = append(server.Inbounds, inboundSection{
+		Tag:      statsAPITag,
+		Listen:   host,
+		Port:     port,
+		Protocol: "dokodemo-door",
+		Settings: inboundSettings{Address: host},
+	})
+	// API入站的规则须在所有规则之前
+	if server.Routing == nil {
+		server.Routing = &routingSection{DomainStrategy: "AsIs"}
+	}
+	rule := routingRule{Type: "field", InboundTag: []string{statsAPITag}, OutboundTag: statsAPITag}
+	server.Routing.Rules = append([]routingRule{rule}, server.Routing.Rules...)
+	return nil
+}
+
+// renderInbound 生成单个入站，所有用户都加入入站的用户列表
+func renderInbound(inbound config.InboundConfig, users []config.ClientConfig) inboundSection {
+	section := inboundSection{
+		Tag:      inbound.Tag,
+		Port:     inbound.Port,
+		Protocol: inbound.Protocol,
+	}
+
+	switch inbound.Protocol {
+	case "shadowsocks":
+		section.Settings = inboundSettings{
+			Method:   inbound.Method,
+			Password: inbound.Password,
+			Network:  "tcp,udp",
+		}
+	default:
+		for _, user := range users {
+			client := clientSection{Email: user.Email}
+			switch inbound.Protocol {
+			case "trojan":
+				client.Password = user.UUID
+			case "vless":
+				client.ID = user.UUID
+				client.Flow = inbound.Flow
+			default:
+				client.ID = user.UUID
+			}
+			section.Settings.Clients = append(section.Settings.Clients, client)
+		}
+		if inbound.Protocol == "vless" {
+			section.Settings.Decryption = "none"
+		}
+	}
+
+	switch inbound.Security {
+	case "tls":
+		section.StreamSettings = &streamSettings{
+			Network:  "tcp",
+			Security: "tls",
+			TLSSettings: &tlsSettings{
+				ServerName: inbound.TLS.ServerName,
+				Certificates: []certificateSection{{
+					CertificateFile: inbound.TLS.CertFile,
+					KeyFile:         inbound.TLS.KeyFile,
+				}},
+			},
+		}
+	case "reality":
+		// 密钥已由 resolveReality 填入
+		section.StreamSettings = &streamSettings{
+			Network:  "tcp",
+			Security: "reality",
+			RealitySettings: &realitySettings{
+				Dest:        inbound.Reality.Dest,
+				ServerNames: inbound.Reality.ServerNames,
+				PrivateKey:  inbound.Reality.PrivateKey,
+				ShortIDs:    inbound.Reality.ShortIDs,
+			},
+		}
+	}
+	return section
+}
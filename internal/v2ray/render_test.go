@@ -0,0 +1,121 @@
+package v2ray
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// loadRenderConfig 读取 testdata/render/<name>.yaml，经过与Agent相同的默认值填充和校验
+func loadRenderConfig(t *testing.T, name string) config.V2RayConfig {
+	t.Helper()
+	path := filepath.Join("testdata", "render", name+".yaml")
+	cfg, _, err := config.NewLoader(path, config.WithLookupEnv(func(string) (string, bool) { return "", false })).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clash API密钥运行时从状态文件填入，测试中固定
+	cfg.V2Ray.ClashSecret = "327cd6428a872f749922e359e8e5467d"
+	return cfg.V2Ray
+}
+
+// checkGolden 比较got与golden文件，-update 时改写golden文件
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test ./internal/v2ray -run %s -update to create it)", err, t.Name())
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run with -update to accept):\n%s", path, got)
+	}
+}
+
+func TestRenderGolden(t *testing.T) {
+	t.Parallel()
+
+	allBackends := []string{BackendV2Ray, BackendXray, BackendSingBox}
+	tests := []struct {
+		name     string
+		backends []string
+	}{
+		// 默认的VMess入站和额外用户
+		{name: "basic", backends: allBackends},
+		// 内置拦截规则、按顺序的路由规则和用户的默认出站
+		{name: "routing", backends: allBackends},
+		// 上游出站和健康检查使用的本地SOCKS入站
+		{name: "outbounds", backends: allBackends},
+		// V2Ray不支持WireGuard出站，sing-box中为endpoint
+		{name: "wireguard", backends: []string{BackendXray, BackendSingBox}},
+		// DoH、TCP和只用于部分域名的DNS服务器，直连出站按查询策略解析
+		{name: "dns", backends: allBackends},
+		// sing-box不支持静态解析
+		{name: "dns_hosts", backends: []string{BackendV2Ray, BackendXray}},
+		// V2Ray/Xray不支持DoT
+		{name: "dns_dot", backends: []string{BackendSingBox}},
+		// 只有VLESS/Trojan的TLS入站回落到伪装站点，sing-box只有Trojan支持回落
+		{name: "decoy", backends: allBackends},
+		// REALITY入站将未认证的连接转发到dest，不回落到伪装站点
+		{name: "decoy_reality", backends: []string{BackendXray, BackendSingBox}},
+	}
+	for _, tt := range tests {
+		for _, name := range tt.backends {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				cfg := loadRenderConfig(t, tt.name)
+				backend, err := NewBackend(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := backend.Validate(cfg); err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				got, err := backend.RenderConfig(cfg)
+				if err != nil {
+					t.Fatalf("RenderConfig() error = %v", err)
+				}
+				checkGolden(t, filepath.Join("testdata", "render", tt.name+"."+name+".json"), append(got, '\n'))
+			})
+		}
+	}
+}
+
+func TestRenderFallbacksOnlyForTLS(t *testing.T) {
+	t.Parallel()
+
+	cfg := loadRenderConfig(t, "decoy")
+	want := map[string]bool{"vless-tls": true, "trojan-tls": true}
+	for _, name := range []string{BackendV2Ray, BackendXray} {
+		backend, err := NewBackend(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := backend.RenderConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var server serverConfig
+		if err := json.Unmarshal(data, &server); err != nil {
+			t.Fatal(err)
+		}
+		for _, inbound := range server.Inbounds {
+			if got := len(inbound.Settings.Fallbacks) > 0; got != want[inbound.Tag] {
+				t.Errorf("%s inbound %s has fallbacks %v, want %v", name, inbound.Tag, got, want[inbound.Tag])
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package v2ray
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+// sing-box规则集下载地址，geosite/geoip分类转换为对应的远程规则集
+const (
+	singBoxGeositeURL = "https://raw.githubusercontent.com/SagerNet/sing-geosite/rule-set/geosite-%s.srs"
+	singBoxGeoIPURL   = "https://raw.githubusercontent.com/SagerNet/sing-geoip/rule-set/geoip-%s.srs"
+)
+
+// routingRules 返回生效的路由规则，依次为内置拦截规则、routing.rules 和用户的出站映射
+func routingRules(cfg config.V2RayConfig) []config.RoutingRule {
+	routing := cfg.Routing
+	var rules []config.RoutingRule
+	if routing.BlockPrivate {
+		rules = append(rules, config.RoutingRule{IP: []string{"geoip:private"}, Outbound: config.OutboundBlock})
+	}
+	if len(routing.BlockPorts) > 0 {
+		ports := make([]string, len(routing.BlockPorts))
+		for i, port := range routing.BlockPorts {
+			ports[i] = strconv.Itoa(port)
+		}
+		rules = append(rules, config.RoutingRule{Port: strings.Join(ports, ","), Outbound: config.OutboundBlock})
+	}
+	if routing.BlockBitTorrent {
+		rules = append(rules, config.RoutingRule{Protocol: []string{"bittorrent"}, Outbound: config.OutboundBlock})
+	}
+	rules = append(rules, routing.Rules...)
+	for _, client := range cfg.Clients {
+		if client.Outbound != "" {
+			rules = append(rules, config.RoutingRule{User: []string{client.Email}, Outbound: client.Outbound})
+		}
+	}
+	return rules
+}
+
+// needsSniffing 规则按域名或协议匹配时，入站需要开启流量探测
+func needsSniffing(rules []config.RoutingRule) bool {
+	for _, rule := range rules {
+		if len(rule.Domain) > 0 || len(rule.Protocol) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesIP 规则中是否有按IP匹配的条件，目标为域名时需要先解析
+func matchesIP(rules []config.RoutingRule) bool {
+	for _, rule := range rules {
+		if len(rule.IP) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// blockTags 返回丢弃连接的出站tag，包括内置的 block 和 blackhole 类型的额外出站
+func blockTags(outbounds []config.OutboundConfig) map[string]bool {
+	tags := map[string]bool{config.OutboundBlock: true}
+	for _, outbound := range outbounds {
+		if outbound.Protocol == "blackhole" {
+			tags[outbound.Tag] = true
+		}
+	}
+	return tags
+}
+
+// routingSection V2Ray/Xray路由配置
+type routingSection struct {
+	DomainStrategy string        `json:"domainStrategy"`
+	Rules          []routingRule `json:"rules"`
+}
+
+// routingRule V2Ray/Xray路由规则，与Agent配置中的写法一致
+type routingRule struct {
+	Type        string   `json:"type"`
+	Domain      []string `json:"domain,omitempty"`
+	IP          []string `json:"ip,omitempty"`
+	Port        string   `json:"port,omitempty"`
+	Protocol    []string `json:"protocol,omitempty"`
+	User        []string `json:"user,omitempty"`
+	InboundTag  []string `json:"inboundTag,omitempty"`
+	OutboundTag string   `json:"outboundTag"`
+}
+
+// renderRouting 生成V2Ray/Xray的路由配置，健康检查入站的流量固定使用对应的出站
+// 未指定 domainStrategy 时，有IP规则则使用 IPIfNonMatch，目标为域名且未匹配域名规则时解析后再按IP匹配。
+func renderRouting(rules []config.RoutingRule, probes []healthProbe, domainStrategy string) *routingSection {
+	if len(rules) == 0 && len(probes) == 0 {
+		return nil
+	}
+	section := &routingSection{DomainStrategy: domainStrategy}
+	if section.DomainStrategy == "" {
+		section.DomainStrategy = "AsIs"
+		if matchesIP(rules) {
+			section.DomainStrategy = "IPIfNonMatch"
+		}
+	}
+	for _, probe := range probes {
+		section.Rules = append(section.Rules, routingRule{
+			Type:        "field",
+			InboundTag:  []string{probe.Inbound},
+			OutboundTag: probe.Outbound,
+		})
+	}
+	for _, rule := range rules {
+		section.Rules = append(section.Rules, routingRule{
+			Type:        "field",
+			Domain:      rule.Domain,
+			IP:          rule.IP,
+			Port:        rule.Port,
+			Protocol:    rule.Protocol,
+			User:        rule.User,
+			OutboundTag: rule.Outbound,
+		})
+	}
+	return section
+}
+
+// singBoxRoute sing-box路由配置
+type singBoxRoute struct {
+	Rules   []singBoxRouteRule `json:"rules,omitempty"`
+	RuleSet []singBoxRuleSet   `json:"rule_set,omitempty"`
+	Final   string             `json:"final"`
+}
+
+// singBoxRouteRule sing-box路由规则，logical 规则的子规则不设置动作
+type singBoxRouteRule struct {
+	Type          string             `json:"type,omitempty"`
+	Mode          string             `json:"mode,omitempty"`
+	Rules         []singBoxRouteRule `json:"rules,omitempty"`
+	Domain        []string           `json:"domain,omitempty"`
+	DomainSuffix  []string           `json:"domain_suffix,omitempty"`
+	DomainKeyword []string           `json:"domain_keyword,omitempty"`
+	DomainRegex   []string           `json:"domain_regex,omitempty"`
+	IPCIDR        []string           `json:"ip_cidr,omitempty"`
+	IPIsPrivate   bool               `json:"ip_is_private,omitempty"`
+	RuleSet       []string           `json:"rule_set,omitempty"`
+	Port          []int              `json:"port,omitempty"`
+	PortRange     []string           `json:"port_range,omitempty"`
+	Protocol      []string           `json:"protocol,omitempty"`
+	AuthUser      []string           `json:"auth_user,omitempty"`
+	Action        string             `json:"action,omitempty"`
+	Outbound      string             `json:"outbound,omitempty"`
+}
+
+// singBoxRuleSet sing-box远程规则集
+type singBoxRuleSet struct {
+	Type           string `json:"type"`
+	Tag            string `json:"tag"`
+	Format         string `json:"format"`
+	URL            string `json:"url"`
+	DownloadDetour string `json:"download_detour"`
+}
+
+// singBoxRuleSets 路由和DNS规则引用的远程规则集，geosite/geoip 分类各对应一个规则集
+type singBoxRuleSets struct {
+	tags map[string]bool
+	list []singBoxRuleSet
+}
+
+// add 返回分类对应的规则集tag，首次引用时加入列表
+func (r *singBoxRuleSets) add(kind string, name string, urlFormat string) string {
+	tag := kind + "-" + name
+	if r.tags == nil {
+		r.tags = make(map[string]bool)
+	}
+	if !r.tags[tag] {
+		r.tags[tag] = true
+		r.list = append(r.list, singBoxRuleSet{
+			Type:           "remote",
+			Tag:            tag,
+			Format:         "binary",
+			URL:            fmt.Sprintf(urlFormat, name),
+			DownloadDetour: config.OutboundDirect,
+		})
+	}
+	return tag
+}
+
+// domainRule 将域名匹配条件转换为sing-box规则中的匹配字段
+func (r *singBoxRuleSets) domainRule(domains []string) singBoxRouteRule {
+	var rule singBoxRouteRule
+	for _, domain := range domains {
+		kind, pattern, ok := strings.Cut(domain, ":")
+		if !ok {
+			kind, pattern = "keyword", domain
+		}
+		switch kind {
+		case "domain":
+			rule.DomainSuffix = append(rule.DomainSuffix, pattern)
+		case "full":
+			rule.Domain = append(rule.Domain, pattern)
+		case "keyword":
+			rule.DomainKeyword = append(rule.DomainKeyword, pattern)
+		case "regexp":
+			rule.DomainRegex = append(rule.DomainRegex, pattern)
+		case "geosite":
+			rule.RuleSet = append(rule.RuleSet, r.add("geosite", pattern, singBoxGeositeURL))
+		}
+	}
+	return rule
+}
+
+// renderSingBoxRoute 生成sing-box路由配置，引用的规则集记录在 ruleSets 中
+// sing-box中同一规则的域名和IP条件是“或”的关系，两者同时存在时用 logical 规则保持“与”的语义；
+// 丢弃连接使用 reject 动作。domainStrategy 为 AsIs 时不解析目标域名，为其他值时总是解析。
+func renderSingBoxRoute(rules []config.RoutingRule, outbounds []config.OutboundConfig, domainStrategy string, ruleSets *singBoxRuleSets) (*singBoxRoute, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	route := &singBoxRoute{Final: config.OutboundDirect}
+	if needsSniffing(rules) {
+		route.Rules = append(route.Rules, singBoxRouteRule{Action: "sniff"})
+	}
+	resolve := matchesIP(rules)
+	if domainStrategy != "" {
+		resolve = domainStrategy != "AsIs"
+	}
+	if resolve {
+		route.Rules = append(route.Rules, singBoxRouteRule{Action: "resolve"})
+	}
+
+	blocked := blockTags(outbounds)
+	for _, rule := range rules {
+		var ipRule, otherRule singBoxRouteRule
+		domainRule := ruleSets.domainRule(rule.Domain)
+		for _, ip := range rule.IP {
+			switch name, ok := strings.CutPrefix(ip, "geoip:"); {
+			case ok && name == "private":
+				ipRule.IPIsPrivate = true
+			case ok:
+				ipRule.RuleSet = append(ipRule.RuleSet, ruleSets.add("geoip", name, singBoxGeoIPURL))
+			default:
+				ipRule.IPCIDR = append(ipRule.IPCIDR, ipPrefix(ip))
+			}
+		}
+		if rule.Port != "" {
+			ranges, err := config.ParsePorts(rule.Port)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range ranges {
+				if r.From == r.To {
+					otherRule.Port = append(otherRule.Port, r.From)
+				} else {
+					otherRule.PortRange = append(otherRule.PortRange, fmt.Sprintf("%d:%d", r.From, r.To))
+				}
+			}
+		}
+		otherRule.Protocol = rule.Protocol
+		otherRule.AuthUser = rule.User
+
+		var result singBoxRouteRule
+		if len(rule.Domain) > 0 && len(rule.IP) > 0 {
+			result = singBoxRouteRule{Type: "logical", Mode: "and", Rules: []singBoxRouteRule{domainRule, mergeRouteRules(ipRule, otherRule)}}
+		} else {
+			result = mergeRouteRules(mergeRouteRules(domainRule, ipRule), otherRule)
+		}
+		if blocked[rule.Outbound] {
+			result.Action = "reject"
+		} else {
+			result.Action = "route"
+			result.Outbound = rule.Outbound
+		}
+		route.Rules = append(route.Rules, result)
+	}
+	return route, nil
+}
+
+// mergeRouteRules 合并两条只包含匹配条件的规则
+func mergeRouteRules(a, b singBoxRouteRule) singBoxRouteRule {
+	a.Domain = append(a.Domain, b.Domain...)
+	a.DomainSuffix = append(a.DomainSuffix, b.DomainSuffix...)
+	a.DomainKeyword = append(a.DomainKeyword, b.DomainKeyword...)
+	a.DomainRegex = append(a.DomainRegex, b.DomainRegex...)
+	a.IPCIDR = append(a.IPCIDR, b.IPCIDR...)
+	a.IPIsPrivate = a.IPIsPrivate || b.IPIsPrivate
+	a.RuleSet = append(a.RuleSet, b.RuleSet...)
+	a.Port = append(a.Port, b.Port...)
+	a.PortRange = append(a.PortRange, b.PortRange...)
+	a.Protocol = append(a.Protocol, b.Protocol...)
+	a.AuthUser = append(a.AuthUser, b.AuthUser...)
+	return a
+}
+
+// ipPrefix 将单个IP转换为 /32 或 /128 前缀，CIDR原样返回
+func ipPrefix(value string) string {
+	switch {
+	case net.ParseIP(value) == nil:
+		return value
+	case strings.Contains(value, ":"):
+		return value + "/128"
+	default:
+		return value + "/32"
+	}
+}
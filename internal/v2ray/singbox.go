@@ -0,0 +1,451 @@
+package v2ray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"go.uber.org/zap"
+)
+
+// sing-box安装路径
+const (
+	singBoxBinaryPath = "/usr/local/bin/sing-box"
+	singBoxConfigPath = "/usr/local/etc/sing-box/config.json"
+	singBoxLogDir     = "/var/log/sing-box"
+	singBoxReleaseURL = "https://github.com/SagerNet/sing-box/releases"
+)
+
+// singBoxVersionPattern 匹配 "sing-box version 1.10.1" 中的版本号
+var singBoxVersionPattern = regexp.MustCompile(`sing-box version\s+v?(\S+)`)
+
+// singBoxArchs GOARCH 到发布包平台名的映射
+var singBoxArchs = map[string]string{
+	"amd64":   "amd64",
+	"386":     "386",
+	"arm64":   "arm64",
+	"arm":     "armv7",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// singBoxBackend sing-box核心，支持Hysteria2、TUIC等基于QUIC的协议
+// 流量统计来自Clash API：累计流量变化或存在活动连接时记为活动。
+type singBoxBackend struct {
+	mu         sync.Mutex
+	lastTotal  int64     // 上次查询到的累计上下行流量
+	lastActive time.Time // 最近一次观察到流量的时间，初始为核心创建时间
+}
+
+// newSingBox 创建sing-box核心，流量统计的状态属于各自的实例。
+// 最近活动时间从创建时开始计算，新节点在空闲超时内不会因为还没有连接而被判定为空闲
+func newSingBox() ProxyBackend {
+	return &singBoxBackend{lastActive: time.Now()}
+}
+
+// Name 返回核心名称
+func (b *singBoxBackend) Name() string {
+	return BackendSingBox
+}
+
+// BinaryPath 返回二进制安装路径
+func (b *singBoxBackend) BinaryPath() string {
+	return singBoxBinaryPath
+}
+
+// ConfigPath 返回配置文件路径
+func (b *singBoxBackend) ConfigPath() string {
+	return singBoxConfigPath
+}
+
+// LogDir 返回OpenRC方式下输出日志所在目录
+func (b *singBoxBackend) LogDir() string {
+	return singBoxLogDir
+}
+
+// AssetDir sing-box的geosite/geoip分类使用远程规则集，不使用geo数据文件
+func (b *singBoxBackend) AssetDir() string {
+	return ""
+}
+
+// ServiceSpec 返回服务描述，supervisor方式下直接运行sing-box
+func (b *singBoxBackend) ServiceSpec() service.Spec {
+	return service.Spec{
+		Name:    BackendSingBox,
+		Command: []string{singBoxBinaryPath, "run", "-c", singBoxConfigPath},
+	}
+}
+
+// Validate 检查sing-box所需的配置，共用模型中除DNS静态解析外的功能sing-box均支持
+func (b *singBoxBackend) Validate(cfg config.V2RayConfig) error {
+	problems := &config.ValidationError{}
+	if cfg.ClashAPI == "" {
+		problems.Problems = append(problems.Problems, "v2ray.clash_api is required by backend sing-box for traffic stats")
+	}
+	if len(cfg.DNS.Hosts) > 0 {
+		problems.Problems = append(problems.Problems,
+			fmt.Sprintf("v2ray.dns.hosts is not supported by backend %s, use backend %s or %s", BackendSingBox, BackendXray, BackendV2Ray))
+	}
+	// sing-box的VLESS入站不支持回落，至少需要一个Trojan入站使用伪装站点
+	if cfg.Decoy.Enabled && !hasTrojanTLS(cfg) {
+		problems.Problems = append(problems.Problems,
+			fmt.Sprintf("v2ray.decoy requires a trojan inbound with security tls on backend %s, vless fallbacks need backend %s or %s", BackendSingBox, BackendXray, BackendV2Ray))
+	}
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// hasTrojanTLS 是否有可以回落到伪装站点的Trojan入站
+func hasTrojanTLS(cfg config.V2RayConfig) bool {
+	for _, inbound := range cfg.EffectiveInbounds() {
+		if inbound.Protocol == "trojan" && inbound.AcceptsFallback() {
+			return true
+		}
+	}
+	return false
+}
+
+// Install 安装二进制和服务文件，sing-box不需要geo数据文件
+func (b *singBoxBackend) Install(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig, serviceKind string) error {
+	if err := b.StageBinary(ctx, log, cfg, singBoxBinaryPath); err != nil {
+		return err
+	}
+	log.Info("Proxy binary installed", zap.String("path", singBoxBinaryPath))
+
+	return installServiceFiles(log, serviceUnit{
+		Spec:          b.ServiceSpec(),
+		Description:   "sing-box Service",
+		Documentation: "https://sing-box.sagernet.org/",
+		LogDir:        singBoxLogDir,
+	}, serviceKind)
+}
+
+// StageBinary 只将发布包中的二进制解压到dst
+// 未配置 v2ray.install.sha256 时使用发布包对应的 .sha256sum 文件校验，本地发布包取同目录下的文件。
+func (b *singBoxBackend) StageBinary(ctx context.Context, log *zap.Logger, cfg config.V2RayConfig, dst string) error {
+	var archiveURL string
+	if cfg.Install.Archive == "" {
+		arch, ok := singBoxArchs[runtime.GOARCH]
+		if !ok {
+			return fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+		}
+		base := cfg.Install.ReleaseURL
+		if base == "" {
+			base = singBoxReleaseURL
+		}
+		// 发布包文件名包含版本号，latest 需要先解析出具体版本
+		version := strings.TrimPrefix(cfg.Version, "v")
+		if version == "" || version == "latest" {
+			latest, err := latestVersion(ctx, cfg.Install.Proxy, base)
+			if err != nil {
+				return err
+			}
+			log.Info("Resolved latest sing-box release", zap.String("version", latest))
+			version = latest
+		}
+		archiveURL = releaseURL(base, version, fmt.Sprintf("sing-box-%s-linux-%s.tar.gz", version, arch))
+	}
+
+	archive, expected, cleanup, err := fetchRelease(ctx, log, cfg, archiveURL, ".sha256sum")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if err := verifyRelease(log, archive, expected, cfg.Install.SkipVerify); err != nil {
+		return err
+	}
+	return installTarGzEntry(archive, "sing-box", dst, 0755)
+}
+
+// Version 执行 "sing-box version" 获取版本号，失败时返回 "unknown"
+func (b *singBoxBackend) Version(ctx context.Context, log *zap.Logger, runner command.Runner, binary string) string {
+	output, err := runner.Run(ctx, binary, "version")
+	if err != nil {
+		log.Debug("Failed to get proxy version", zap.Error(err))
+	} else if match := singBoxVersionPattern.FindSubmatch(output); match != nil {
+		return string(match[1])
+	}
+	log.Warn("Failed to detect proxy version", zap.String("path", binary))
+	return "unknown"
+}
+
+// TestConfig 使用 "sing-box check" 校验配置文件
+func (b *singBoxBackend) TestConfig(ctx context.Context, runner command.Runner, binary string, configPath string) error {
+	output, err := runner.Run(ctx, binary, "check", "-c", configPath)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Stats 通过Clash API统计流量
+// Clash API无法连接说明sing-box未在运行，不会产生新流量，此时返回上次观察到的活动时间。
+func (b *singBoxBackend) Stats(ctx context.Context, cfg config.V2RayConfig) (*TrafficStats, error) {
+	connections, err := queryClashConnections(ctx, cfg.ClashAPI, cfg.ClashSecret)
+	var opErr *net.OpError
+	if err != nil && !errors.As(err, &opErr) {
+		return nil, fmt.Errorf("failed to query sing-box clash api: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if connections != nil {
+		// 累计流量在sing-box重启后归零，只要与上次不同且不为零就说明有新流量
+		total := connections.DownloadTotal + connections.UploadTotal
+		if len(connections.Connections) > 0 || (total != b.lastTotal && total > 0) {
+			b.lastActive = time.Now()
+		}
+		b.lastTotal = total
+	}
+	return &TrafficStats{
+		LastActive: b.lastActive,
+		HasTraffic: true,
+	}, nil
+}
+
+// UserTraffic sing-box的发布版本不包含按用户统计流量的V2Ray API
+func (b *singBoxBackend) UserTraffic(ctx context.Context, runner command.Runner, cfg config.V2RayConfig) (map[string]UserTraffic, error) {
+	return nil, ErrUserTrafficUnsupported
+}
+
+// CheckOutbound 通过Clash API的延迟测试检查出站，测试连接不计入流量统计
+func (b *singBoxBackend) CheckOutbound(ctx context.Context, cfg config.V2RayConfig, tag string) (time.Duration, error) {
+	return queryClashDelay(ctx, cfg.ClashAPI, cfg.ClashSecret, tag, cfg.HealthCheck)
+}
+
+// singBoxConfig sing-box配置文件结构（仅包含Agent生成的部分）
+type singBoxConfig struct {
+	Log          singBoxLog          `json:"log"`
+	Inbounds     []singBoxInbound    `json:"inbounds"`
+	Outbounds    []singBoxOutbound   `json:"outbounds"`
+	Endpoints    []singBoxEndpoint   `json:"endpoints,omitempty"`
+	Route        *singBoxRoute       `json:"route,omitempty"`
+	DNS          *singBoxDNS         `json:"dns,omitempty"`
+	Experimental singBoxExperimental `json:"experimental"`
+}
+
+// singBoxLog 日志配置，info级别包含每个连接的记录，相当于访问日志
+type singBoxLog struct {
+	Level     string `json:"level"`
+	Output    string `json:"output"`
+	Timestamp bool   `json:"timestamp"`
+}
+
+// singBoxInbound 入站配置
+type singBoxInbound struct {
+	Type              string           `json:"type"`
+	Tag               string           `json:"tag"`
+	Listen            string           `json:"listen"`
+	ListenPort        int              `json:"listen_port"`
+	Users             []singBoxUser    `json:"users,omitempty"`
+	Method            string           `json:"method,omitempty"`             // Shadowsocks
+	Password          string           `json:"password,omitempty"`           // Shadowsocks
+	CongestionControl string           `json:"congestion_control,omitempty"` // TUIC
+	TLS               *singBoxTLS      `json:"tls,omitempty"`
+	Fallback          *singBoxFallback `json:"fallback,omitempty"` // Trojan
+}
+
+// singBoxUser 入站用户
+type singBoxUser struct {
+	Name     string `json:"name,omitempty"`
+	UUID     string `json:"uuid,omitempty"`
+	Password string `json:"password,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+}
+
+// singBoxTLS 入站TLS配置
+type singBoxTLS struct {
+	Enabled         bool            `json:"enabled"`
+	ServerName      string          `json:"server_name,omitempty"`
+	ALPN            []string        `json:"alpn,omitempty"`
+	CertificatePath string          `json:"certificate_path,omitempty"`
+	KeyPath         string          `json:"key_path,omitempty"`
+	Reality         *singBoxReality `json:"reality,omitempty"`
+}
+
+// singBoxReality 入站REALITY配置，未认证的连接转发到handshake指定的站点
+type singBoxReality struct {
+	Enabled    bool             `json:"enabled"`
+	Handshake  singBoxHandshake `json:"handshake"`
+	PrivateKey string           `json:"private_key"`
+	ShortID    []string         `json:"short_id"`
+}
+
+// singBoxHandshake REALITY握手目标
+type singBoxHandshake struct {
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+}
+
+// singBoxOutbound 出站配置，上游出站包含服务器地址和认证信息
+type singBoxOutbound struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server,omitempty"`
+	ServerPort int               `json:"server_port,omitempty"`
+	Version    string            `json:"version,omitempty"` // SOCKS版本
+	Username   string            `json:"username,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	UUID       string            `json:"uuid,omitempty"`
+	Security   string            `json:"security,omitempty"` // VMess加密方式
+	Flow       string            `json:"flow,omitempty"`
+	TLS        *singBoxClientTLS `json:"tls,omitempty"`
+}
+
+// singBoxEndpoint sing-box端点，WireGuard出站从1.11起作为端点配置
+type singBoxEndpoint struct {
+	Type       string        `json:"type"`
+	Tag        string        `json:"tag"`
+	Address    []string      `json:"address"`
+	PrivateKey string        `json:"private_key"`
+	MTU        int           `json:"mtu"`
+	Peers      []singBoxPeer `json:"peers"`
+}
+
+// singBoxPeer WireGuard对端
+type singBoxPeer struct {
+	Address      string   `json:"address"`
+	Port         int      `json:"port"`
+	PublicKey    string   `json:"public_key"`
+	PreSharedKey string   `json:"pre_shared_key,omitempty"`
+	AllowedIPs   []string `json:"allowed_ips"`
+}
+
+// singBoxExperimental 实验性功能，Clash API用于流量统计
+type singBoxExperimental struct {
+	ClashAPI singBoxClashAPI `json:"clash_api"`
+}
+
+// singBoxClashAPI Clash API配置
+type singBoxClashAPI struct {
+	ExternalController string `json:"external_controller"`
+	Secret             string `json:"secret"`
+}
+
+// RenderConfig 按共用配置模型生成sing-box配置
+func (b *singBoxBackend) RenderConfig(cfg config.V2RayConfig) ([]byte, error) {
+	server := singBoxConfig{
+		Log: singBoxLog{
+			Level:     "info",
+			Output:    cfg.AccessLog,
+			Timestamp: true,
+		},
+		Outbounds: []singBoxOutbound{{Type: "direct", Tag: "direct"}},
+		Experimental: singBoxExperimental{
+			ClashAPI: singBoxClashAPI{
+				ExternalController: cfg.ClashAPI,
+				Secret:             cfg.ClashSecret,
+			},
+		},
+	}
+	for _, inbound := range cfg.EffectiveInbounds() {
+		section := renderSingBoxInbound(inbound, cfg.Users())
+		section.Fallback = renderSingBoxFallback(cfg, inbound)
+		server.Inbounds = append(server.Inbounds, section)
+	}
+
+	// blackhole出站由路由规则的 reject 动作代替
+	for _, outbound := range cfg.Outbounds {
+		switch outbound.Protocol {
+		case "blackhole":
+		case "wireguard":
+			server.Endpoints = append(server.Endpoints, renderSingBoxWireGuard(outbound))
+		default:
+			server.Outbounds = append(server.Outbounds, renderSingBoxOutbound(outbound))
+		}
+	}
+	ruleSets := &singBoxRuleSets{}
+	route, err := renderSingBoxRoute(routingRules(cfg), cfg.Outbounds, cfg.DNS.DomainStrategy, ruleSets)
+	if err != nil {
+		return nil, err
+	}
+	server.DNS = renderSingBoxDNS(cfg.DNS, ruleSets)
+	// 规则集在路由配置中声明，只有DNS规则引用时也需要路由配置
+	if len(ruleSets.list) > 0 {
+		if route == nil {
+			route = &singBoxRoute{Final: config.OutboundDirect}
+		}
+		route.RuleSet = ruleSets.list
+	}
+	server.Route = route
+
+	return json.MarshalIndent(server, "", "  ")
+}
+
+// renderSingBoxInbound 生成单个sing-box入站，所有用户都加入入站的用户列表
+func renderSingBoxInbound(inbound config.InboundConfig, users []config.ClientConfig) singBoxInbound {
+	section := singBoxInbound{
+		Type:       inbound.Protocol,
+		Tag:        inbound.Tag,
+		Listen:     "::",
+		ListenPort: inbound.Port,
+	}
+
+	switch inbound.Protocol {
+	case "shadowsocks":
+		section.Method = inbound.Method
+		section.Password = inbound.Password
+	default:
+		for _, user := range users {
+			client := singBoxUser{Name: user.Email}
+			switch inbound.Protocol {
+			case "trojan", "hysteria2":
+				client.Password = user.UUID
+			case "tuic":
+				client.UUID = user.UUID
+				client.Password = user.UUID
+			case "vless":
+				client.UUID = user.UUID
+				client.Flow = inbound.Flow
+			default:
+				client.UUID = user.UUID
+			}
+			section.Users = append(section.Users, client)
+		}
+	}
+	if inbound.Protocol == "tuic" {
+		section.CongestionControl = "bbr"
+	}
+
+	switch inbound.Security {
+	case "tls":
+		section.TLS = &singBoxTLS{
+			Enabled:         true,
+			ServerName:      inbound.TLS.ServerName,
+			CertificatePath: inbound.TLS.CertFile,
+			KeyPath:         inbound.TLS.KeyFile,
+		}
+		// 基于QUIC的协议使用HTTP/3的ALPN
+		if inbound.Protocol == "hysteria2" || inbound.Protocol == "tuic" {
+			section.TLS.ALPN = []string{"h3"}
+		}
+	case "reality":
+		// dest已校验为 host:port，密钥已由 resolveReality 填入
+		host, port, _ := net.SplitHostPort(inbound.Reality.Dest)
+		serverPort, _ := strconv.Atoi(port)
+		section.TLS = &singBoxTLS{
+			Enabled:    true,
+			ServerName: inbound.Reality.ServerNames[0],
+			Reality: &singBoxReality{
+				Enabled:    true,
+				Handshake:  singBoxHandshake{Server: host, ServerPort: serverPort},
+				PrivateKey: inbound.Reality.PrivateKey,
+				ShortID:    inbound.Reality.ShortIDs,
+			},
+		}
+	}
+	return section
+}
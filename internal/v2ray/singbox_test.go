@@ -0,0 +1,142 @@
+package v2ray
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+// newClashAPI 启动返回固定连接统计的Clash API，只接受使用secret的请求
+func newClashAPI(t *testing.T, secret string, body string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+secret {
+			http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/connections" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestSingBoxIdle(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		body        string
+		idleTimeout time.Duration
+		wantIdle    bool
+	}{
+		{
+			name:        "new node without connections within idle timeout",
+			body:        `{"downloadTotal":0,"uploadTotal":0,"connections":[]}`,
+			idleTimeout: time.Hour,
+			wantIdle:    false,
+		},
+		{
+			name:        "new node without connections past idle timeout",
+			body:        `{"downloadTotal":0,"uploadTotal":0,"connections":[]}`,
+			idleTimeout: time.Nanosecond,
+			wantIdle:    true,
+		},
+		{
+			name:        "open connection",
+			body:        `{"downloadTotal":0,"uploadTotal":0,"connections":[{"id":"1"}]}`,
+			idleTimeout: time.Millisecond,
+			wantIdle:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.DefaultConfig().V2Ray
+			cfg.UUID = testUUID
+			cfg.ClashSecret = "327cd6428a872f749922e359e8e5467d"
+			cfg.ClashAPI = newClashAPI(t, cfg.ClashSecret, tt.body)
+
+			backend, err := NewBackend(BackendSingBox)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 超过空闲超时后再检查，连接中的流量刷新最近活动时间
+			time.Sleep(2 * time.Millisecond)
+			monitor := NewTrafficMonitor(backend, cfg, tt.idleTimeout)
+			idle, err := monitor.IsIdle(context.Background())
+			if err != nil {
+				t.Fatalf("IsIdle() error = %v", err)
+			}
+			if idle != tt.wantIdle {
+				t.Errorf("IsIdle() = %v, want %v", idle, tt.wantIdle)
+			}
+		})
+	}
+}
+
+func TestSingBoxStatsAfterRotation(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	backend, err := NewBackend(BackendSingBox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testV2RayConfig(t)
+	monitorConfig, err := ApplyClashSecret(store, backend, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if monitorConfig.ClashSecret == "" {
+		t.Fatal("ApplyClashSecret() left the secret empty")
+	}
+
+	// 轮换默认用户后按与configure相同的方式生成核心配置
+	if _, err := RotateCredentials(store, cfg, DefaultUserEmail, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := resolveCredentials(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.UUID == cfg.UUID {
+		t.Fatal("RotateCredentials() did not change the default user's uuid")
+	}
+	resolved, err = ApplyClashSecret(store, backend, resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := backend.RenderConfig(resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rendered singBoxConfig
+	if err := json.Unmarshal(data, &rendered); err != nil {
+		t.Fatal(err)
+	}
+	secret := rendered.Experimental.ClashAPI.Secret
+	if secret != monitorConfig.ClashSecret {
+		t.Fatalf("rendered clash api secret = %q, want %q used by the monitors", secret, monitorConfig.ClashSecret)
+	}
+
+	// 监控器使用配置文件中的UUID，仍能通过核心的Clash API认证
+	monitorConfig.ClashAPI = newClashAPI(t, secret, `{"downloadTotal":1024,"uploadTotal":512,"connections":[]}`)
+	stats, err := backend.Stats(context.Background(), monitorConfig)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if !stats.HasTraffic {
+		t.Error("Stats() HasTraffic = false, want true")
+	}
+}
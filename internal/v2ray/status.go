@@ -1,79 +1,76 @@
 package v2ray
 
 import (
-	"os/exec"
-	"strings"
+	"context"
 
-	"github.com/yuhai94/anywhere_agent/internal/logger"
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/service"
 	"go.uber.org/zap"
 )
 
-// IsV2RayRunning 检查V2Ray是否正在运行
-func IsV2RayRunning() bool {
-	logger.Debug("Checking if V2Ray is running")
-
-	// 尝试使用systemctl检查
-	cmd := exec.Command("systemctl", "is-active", "v2ray")
-	logger.Debug("Executing command", zap.String("command", cmd.String()))
-	output, err := cmd.CombinedOutput()
-	logger.Debug("systemctl is-active v2ray output",
-		zap.String("output", strings.TrimSpace(string(output))),
-		zap.Error(err))
-	if err == nil && strings.TrimSpace(string(output)) == "active" {
-		logger.Debug("V2Ray is running (systemctl)")
-		return true
-	}
+// IsRunning 检查代理核心是否正在运行
+// 优先在/proc中按可执行文件路径查找进程，/proc不可用时使用服务管理器的状态。
+func IsRunning(ctx context.Context, log *zap.Logger, backend ProxyBackend, svc service.ServiceManager) bool {
+	return isRunning(ctx, log, procDir, backend, svc)
+}
 
-	// 尝试使用service命令检查
-	cmd = exec.Command("service", "v2ray", "status")
-	logger.Debug("Executing command", zap.String("command", cmd.String()))
-	output, err = cmd.CombinedOutput()
-	logger.Debug("service v2ray status output",
-		zap.String("output", string(output)[:100]+"..."), // 只显示前100字符
-		zap.Error(err))
-	if err == nil && (strings.Contains(string(output), "running") || strings.Contains(string(output), "active")) {
-		logger.Debug("V2Ray is running (service)")
-		return true
+// isRunning 在procRoot中查找代理核心进程，procRoot不可读时使用服务管理器的状态
+func isRunning(ctx context.Context, log *zap.Logger, procRoot string, backend ProxyBackend, svc service.ServiceManager) bool {
+	process, err := findProcess(procRoot, backend.BinaryPath())
+	if err == nil {
+		log.Debug("Proxy process lookup completed", zap.Bool("running", process != nil))
+		return process != nil
 	}
+	log.Debug("Failed to inspect /proc, falling back to service status", zap.Error(err))
 
-	// 尝试使用ps命令检查进程
-	cmd = exec.Command("bash", "-c", "ps aux | grep v2ray | grep -v grep")
-	logger.Debug("Executing command", zap.String("command", cmd.String()))
-	output, err = cmd.CombinedOutput()
-	logger.Debug("ps command output",
-		zap.String("output", string(output)),
-		zap.Error(err))
-	if err == nil && len(output) > 0 {
-		logger.Debug("V2Ray is running (ps)")
-		return true
+	status, err := svc.Status(ctx)
+	if err != nil {
+		log.Debug("Failed to get proxy service status", zap.Error(err))
+		return false
 	}
-
-	logger.Debug("V2Ray is not running")
-	return false
+	return status.Active
 }
 
-// GetV2RayStatus 获取V2Ray状态
-func GetV2RayStatus() (*DeployStatus, error) {
-	logger.Info("Getting V2Ray status")
+// GetStatus 获取代理核心状态
+func GetStatus(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend, svc service.ServiceManager) (*DeployStatus, error) {
+	log.Info("Getting proxy status", zap.String("backend", backend.Name()))
 
-	installed, version, err := CheckV2Ray()
+	installed, version, err := CheckInstalled(ctx, log, runner, backend)
 	if err != nil {
-		logger.Error("Failed to get V2Ray status", zap.Error(err))
+		log.Error("Failed to get proxy status", zap.Error(err))
 		return nil, err
 	}
 
-	running := IsV2RayRunning()
 	status := &DeployStatus{
-		Installed: installed,
-		Running:   running,
-		Version:   version,
-		Progress:  100,
-		Message:   "V2Ray status checked",
+		Backend:         backend.Name(),
+		Installed:       installed,
+		Version:         version,
+		PreviousVersion: PreviousVersion(ctx, log, runner, backend),
+		Progress:        100,
+		Message:         "Status checked",
+	}
+
+	// 服务状态包含PID、运行时长和退出码
+	serviceStatus, err := svc.Status(ctx)
+	if err != nil {
+		log.Warn("Failed to get proxy service status", zap.Error(err))
+	} else {
+		status.Running = serviceStatus.Active
+		status.Service = serviceStatus
+	}
+
+	// 进程信息以/proc为准
+	process, err := FindProcess(backend.BinaryPath())
+	if err != nil {
+		log.Warn("Failed to inspect proxy process", zap.Error(err))
+	} else {
+		status.Running = process != nil
+		status.Process = process
 	}
 
-	logger.Info("V2Ray status retrieved",
+	log.Info("Proxy status retrieved",
 		zap.Bool("installed", installed),
-		zap.Bool("running", running),
+		zap.Bool("running", status.Running),
 		zap.String("version", version))
 
 	return status, nil
@@ -0,0 +1,211 @@
+package v2ray
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"go.uber.org/zap"
+)
+
+// fakeProc 在临时目录中创建只包含 /proc/<pid>/exe 的proc文件系统
+func fakeProc(t *testing.T, exes map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for pid, exe := range exes {
+		if err := os.MkdirAll(filepath.Join(root, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(exe, filepath.Join(root, pid, "exe")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCheckInstalled(t *testing.T) {
+	t.Parallel()
+
+	t.Run("missing binary", func(t *testing.T) {
+		t.Parallel()
+
+		runner := commandtest.NewFakeRunner()
+		installed, version, err := CheckInstalled(context.Background(), zap.NewNop(), runner, newTestBackend(t, false))
+		if err != nil || installed || version != "" {
+			t.Errorf("CheckInstalled() = %v, %q, %v, want not installed", installed, version, err)
+		}
+		if len(runner.Calls()) != 0 {
+			t.Errorf("runner calls = %v, want none", runner.Lines())
+		}
+	})
+
+	t.Run("version", func(t *testing.T) {
+		t.Parallel()
+
+		backend := newTestBackend(t, true)
+		runner := commandtest.NewFakeRunner()
+		runner.On(backend.BinaryPath()+" version", "V2Ray 5.16.1 (V2Fly, a community-driven edition of V2Ray.)", nil)
+		installed, version, err := CheckInstalled(context.Background(), zap.NewNop(), runner, backend)
+		if err != nil || !installed || version != "5.16.1" {
+			t.Errorf("CheckInstalled() = %v, %q, %v, want installed 5.16.1", installed, version, err)
+		}
+	})
+
+	t.Run("falls back to -version", func(t *testing.T) {
+		t.Parallel()
+
+		backend := newTestBackend(t, true)
+		runner := commandtest.NewFakeRunner()
+		runner.On(backend.BinaryPath()+" -version", "V2Ray 4.45.2 (V2Fly, a community-driven edition of V2Ray.)", nil)
+		installed, version, err := CheckInstalled(context.Background(), zap.NewNop(), runner, backend)
+		if err != nil || !installed || version != "4.45.2" {
+			t.Errorf("CheckInstalled() = %v, %q, %v, want installed 4.45.2", installed, version, err)
+		}
+		want := []string{backend.BinaryPath() + " version", backend.BinaryPath() + " -version"}
+		if got := runner.Lines(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("runner calls = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		t.Parallel()
+
+		installed, version, err := CheckInstalled(context.Background(), zap.NewNop(), commandtest.NewFakeRunner(), newTestBackend(t, true))
+		if err != nil || !installed || version != "unknown" {
+			t.Errorf("CheckInstalled() = %v, %q, %v, want installed with unknown version", installed, version, err)
+		}
+	})
+}
+
+func TestIsRunning(t *testing.T) {
+	t.Parallel()
+
+	backend := newTestBackend(t, true)
+	binary, err := filepath.EvalSymlinks(backend.BinaryPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		procRoot   string
+		status     service.Status
+		statusErr  error
+		want       bool
+		wantStatus bool // 是否回退到服务管理器
+	}{
+		{name: "process found", procRoot: fakeProc(t, map[string]string{"1": "/sbin/init", "42": binary}), want: true},
+		{name: "replaced binary", procRoot: fakeProc(t, map[string]string{"42": binary + " (deleted)"}), want: true},
+		{name: "process not found", procRoot: fakeProc(t, map[string]string{"1": "/sbin/init"}), status: service.Status{Active: true}, want: false},
+		{name: "no proc, service active", procRoot: filepath.Join(t.TempDir(), "missing"), status: service.Status{Active: true}, want: true, wantStatus: true},
+		{name: "no proc, service inactive", procRoot: filepath.Join(t.TempDir(), "missing"), want: false, wantStatus: true},
+		{name: "no proc, status error", procRoot: filepath.Join(t.TempDir(), "missing"), statusErr: errors.New("no bus"), want: false, wantStatus: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := &fakeService{status: tt.status, statusErr: tt.statusErr}
+			if got := isRunning(context.Background(), zap.NewNop(), tt.procRoot, backend, svc); got != tt.want {
+				t.Errorf("isRunning() = %v, want %v", got, tt.want)
+			}
+			if svc.called("status") != tt.wantStatus {
+				t.Errorf("service calls = %v, want status fallback %v", svc.Calls(), tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestTestConfigFallback(t *testing.T) {
+	t.Parallel()
+
+	backend := newTestBackend(t, true)
+	binary := backend.BinaryPath()
+
+	t.Run("falls back to -test", func(t *testing.T) {
+		t.Parallel()
+
+		runner := commandtest.NewFakeRunner()
+		runner.On(binary+" -test -config /etc/v2ray/config.json", "Configuration OK.", nil)
+		if err := backend.TestConfig(context.Background(), runner, binary, "/etc/v2ray/config.json"); err != nil {
+			t.Errorf("TestConfig() error = %v", err)
+		}
+		if !runner.Called(binary + " test -config /etc/v2ray/config.json") {
+			t.Errorf("runner calls = %v, want v5 arguments tried first", runner.Lines())
+		}
+	})
+
+	t.Run("reports first failure", func(t *testing.T) {
+		t.Parallel()
+
+		runner := commandtest.NewFakeRunner()
+		runner.On(binary+" test -config /etc/v2ray/config.json", "invalid inbound", &commandtest.ExitError{Code: 23})
+		err := backend.TestConfig(context.Background(), runner, binary, "/etc/v2ray/config.json")
+		if err == nil || !strings.Contains(err.Error(), "invalid inbound") {
+			t.Errorf("TestConfig() error = %v, want output of the first attempt", err)
+		}
+		if len(runner.Calls()) != 2 {
+			t.Errorf("runner calls = %v, want both argument formats", runner.Lines())
+		}
+	})
+}
+
+func TestGetStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		installed   bool
+		previous    bool
+		status      service.Status
+		statusErr   error
+		wantVersion string
+		wantPrev    string
+	}{
+		{name: "not installed", status: service.Status{Manager: "fake", State: "inactive"}},
+		{name: "installed", installed: true, status: service.Status{Manager: "fake", Active: true, State: "active", PID: 4242}, wantVersion: "5.16.1"},
+		{name: "with previous version", installed: true, previous: true, status: service.Status{Manager: "fake", State: "inactive"}, wantVersion: "5.16.1", wantPrev: "5.15.3"},
+		{name: "service status error", installed: true, statusErr: errors.New("no bus"), wantVersion: "5.16.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			backend := newTestBackend(t, tt.installed)
+			binary := backend.BinaryPath()
+			runner := commandtest.NewFakeRunner()
+			runner.On(binary+" version", "V2Ray 5.16.1 (V2Fly, a community-driven edition of V2Ray.)", nil)
+			if tt.previous {
+				if err := os.WriteFile(binary+previousSuffix, []byte("#!/bin/sh\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				runner.On(binary+previousSuffix+" version", "V2Ray 5.15.3 (V2Fly, a community-driven edition of V2Ray.)", nil)
+			}
+			svc := &fakeService{status: tt.status, statusErr: tt.statusErr}
+
+			status, err := GetStatus(context.Background(), zap.NewNop(), runner, backend, svc)
+			if err != nil {
+				t.Fatalf("GetStatus() error = %v", err)
+			}
+			if status.Installed != tt.installed || status.Version != tt.wantVersion || status.PreviousVersion != tt.wantPrev {
+				t.Errorf("GetStatus() installed, version, previous = %v, %q, %q, want %v, %q, %q",
+					status.Installed, status.Version, status.PreviousVersion, tt.installed, tt.wantVersion, tt.wantPrev)
+			}
+			if tt.statusErr != nil {
+				if status.Service != nil {
+					t.Errorf("GetStatus() service = %+v, want nil when status fails", status.Service)
+				}
+			} else if status.Service == nil || *status.Service != tt.status {
+				t.Errorf("GetStatus() service = %+v, want %+v", status.Service, tt.status)
+			}
+			// 测试二进制没有运行，/proc中的结果优先于服务管理器报告的状态
+			if status.Running || status.Process != nil {
+				t.Errorf("GetStatus() running = %v, process = %+v, want not running", status.Running, status.Process)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package v2ray
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+	"gopkg.in/yaml.v3"
+)
+
+// 订阅格式
+const (
+	SubscriptionBase64  = "base64"
+	SubscriptionClash   = "clash"
+	SubscriptionSingBox = "sing-box"
+)
+
+// subscriptionStateKey 订阅token在状态文件中的键，值为 email -> token
+const subscriptionStateKey = "subscription.tokens"
+
+// SubscriptionInfo subscription-userinfo 响应头中的用量和到期时间
+// 流量为0表示未统计或不限制，Expire 为零值时不输出。
+type SubscriptionInfo struct {
+	Upload   int64
+	Download int64
+	Total    int64
+	Expire   time.Time
+}
+
+// Header 返回 subscription-userinfo 响应头的值
+func (i SubscriptionInfo) Header() string {
+	header := fmt.Sprintf("upload=%d; download=%d; total=%d", i.Upload, i.Download, i.Total)
+	if !i.Expire.IsZero() {
+		header += fmt.Sprintf("; expire=%d", i.Expire.Unix())
+	}
+	return header
+}
+
+// SubscriptionToken 返回用户的订阅token，首次查询时生成并保存
+// 默认用户使用 DefaultUserEmail 作为键。
+func SubscriptionToken(store *state.Store, email string) (string, error) {
+	if email == "" {
+		email = DefaultUserEmail
+	}
+
+	// 在store的锁内读取和生成，并发首次查询只生成一个token
+	tokens := make(map[string]string)
+	var token string
+	err := store.Update(subscriptionStateKey, &tokens, func() (bool, error) {
+		if saved, ok := tokens[email]; ok {
+			token = saved
+			return false, nil
+		}
+		raw := make([]byte, 16)
+		if _, err := rand.Read(raw); err != nil {
+			return false, fmt.Errorf("failed to generate subscription token: %w", err)
+		}
+		token = hex.EncodeToString(raw)
+		tokens[email] = token
+		return true, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// FindSubscriptionUser 按订阅token查找用户，已删除用户的token不再有效
+func FindSubscriptionUser(store *state.Store, cfg config.V2RayConfig, token string) (config.ClientConfig, bool, error) {
+	tokens := make(map[string]string)
+	if _, err := store.Get(subscriptionStateKey, &tokens); err != nil {
+		return config.ClientConfig{}, false, err
+	}
+
+	for email, saved := range tokens {
+		if subtle.ConstantTimeCompare([]byte(saved), []byte(token)) != 1 {
+			continue
+		}
+		user, ok := FindUser(cfg, email)
+		return user, ok, nil
+	}
+	return config.ClientConfig{}, false, nil
+}
+
+// RenderSubscription 按格式生成用户的订阅内容，返回内容和Content-Type
+func RenderSubscription(store *state.Store, cfg config.V2RayConfig, address string, user config.ClientConfig, format string) ([]byte, string, error) {
+	switch format {
+	case SubscriptionBase64:
+		links, err := ShareLinks(store, cfg, address, user)
+		if err != nil {
+			return nil, "", err
+		}
+		urls := make([]string, len(links))
+		for i, link := range links {
+			urls[i] = link.URL
+		}
+		data := base64.StdEncoding.EncodeToString([]byte(strings.Join(urls, "\n")))
+		return []byte(data), "text/plain; charset=utf-8", nil
+
+	case SubscriptionClash:
+		data, err := renderClashProfile(store, cfg, address, user)
+		return data, "text/yaml; charset=utf-8", err
+
+	case SubscriptionSingBox:
+		data, err := renderSingBoxOutbounds(store, cfg, address, user)
+		return data, "application/json; charset=utf-8", err
+	}
+	return nil, "", fmt.Errorf("unknown subscription format %q", format)
+}
+
+// clashProfile Clash Meta（mihomo）配置
+type clashProfile struct {
+	MixedPort   int          `yaml:"mixed-port"`
+	Mode        string       `yaml:"mode"`
+	Proxies     []clashProxy `yaml:"proxies"`
+	ProxyGroups []clashGroup `yaml:"proxy-groups"`
+	Rules       []string     `yaml:"rules"`
+}
+
+// clashProxy Clash Meta节点
+type clashProxy struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              int               `yaml:"port"`
+	UUID              string            `yaml:"uuid,omitempty"`
+	Password          string            `yaml:"password,omitempty"`
+	AlterID           *int              `yaml:"alterId,omitempty"`
+	Cipher            string            `yaml:"cipher,omitempty"`
+	Network           string            `yaml:"network,omitempty"`
+	UDP               bool              `yaml:"udp"`
+	TLS               bool              `yaml:"tls,omitempty"`
+	Flow              string            `yaml:"flow,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"` // vmess/vless
+	SNI               string            `yaml:"sni,omitempty"`        // trojan/hysteria2/tuic
+	ALPN              []string          `yaml:"alpn,omitempty"`
+	Congestion        string            `yaml:"congestion-controller,omitempty"`
+	Ports             string            `yaml:"ports,omitempty"` // hysteria2端口跳跃范围
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       *clashRealityOpts `yaml:"reality-opts,omitempty"`
+}
+
+// clashRealityOpts Clash Meta REALITY参数
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty"`
+}
+
+// clashGroup Clash代理组
+type clashGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+// renderClashProfile 生成包含用户所有节点的Clash Meta配置，所有流量走手动选择的节点
+func renderClashProfile(store *state.Store, cfg config.V2RayConfig, address string, user config.ClientConfig) ([]byte, error) {
+	realityByTag, err := realityParamsByTag(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := clashProfile{
+		MixedPort:   7890,
+		Mode:        "rule",
+		ProxyGroups: []clashGroup{{Name: "Proxy", Type: "select"}},
+		Rules:       []string{"MATCH,Proxy"},
+	}
+	for _, inbound := range cfg.EffectiveInbounds() {
+		proxy := clashProxy{
+			Name:   clientRemark(inbound, user),
+			Type:   inbound.Protocol,
+			Server: address,
+			Port:   inbound.Port,
+			UDP:    true,
+		}
+		switch inbound.Protocol {
+		case "vmess":
+			alterID := 0
+			proxy.UUID = user.UUID
+			proxy.AlterID = &alterID
+			proxy.Cipher = "auto"
+		case "vless":
+			proxy.UUID = user.UUID
+			proxy.Network = "tcp"
+			proxy.Flow = inbound.Flow
+		case "trojan":
+			proxy.Password = user.UUID
+		case "hysteria2":
+			proxy.Password = user.UUID
+			proxy.Ports = inbound.PortRange
+		case "shadowsocks":
+			proxy.Type = "ss"
+			proxy.Cipher = inbound.Method
+			proxy.Password = inbound.Password
+		case "tuic":
+			proxy.UUID = user.UUID
+			proxy.Password = user.UUID
+			proxy.ALPN = []string{"h3"}
+			proxy.Congestion = "bbr"
+		}
+
+		switch inbound.Security {
+		case "tls":
+			switch inbound.Protocol {
+			case "vmess", "vless":
+				proxy.TLS = true
+				proxy.ServerName = inbound.TLS.ServerName
+			default:
+				proxy.SNI = inbound.TLS.ServerName
+			}
+		case "reality":
+			reality := realityByTag[inbound.Tag]
+			proxy.TLS = true
+			proxy.ServerName = reality.ServerName
+			proxy.ClientFingerprint = reality.Fingerprint
+			proxy.RealityOpts = &clashRealityOpts{PublicKey: reality.PublicKey, ShortID: reality.ShortID}
+		}
+
+		profile.Proxies = append(profile.Proxies, proxy)
+		profile.ProxyGroups[0].Proxies = append(profile.ProxyGroups[0].Proxies, proxy.Name)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(profile); err != nil {
+		return nil, fmt.Errorf("failed to encode clash profile: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode clash profile: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// singBoxClientOutbound sing-box客户端出站
+type singBoxClientOutbound struct {
+	Type              string            `json:"type"`
+	Tag               string            `json:"tag"`
+	Server            string            `json:"server"`
+	ServerPort        int               `json:"server_port"`
+	ServerPorts       []string          `json:"server_ports,omitempty"` // hysteria2端口跳跃范围，如 20000:20100
+	UUID              string            `json:"uuid,omitempty"`
+	Password          string            `json:"password,omitempty"`
+	Method            string            `json:"method,omitempty"`
+	Security          string            `json:"security,omitempty"` // VMess加密方式
+	Flow              string            `json:"flow,omitempty"`
+	CongestionControl string            `json:"congestion_control,omitempty"`
+	TLS               *singBoxClientTLS `json:"tls,omitempty"`
+}
+
+// singBoxClientTLS sing-box客户端TLS配置
+type singBoxClientTLS struct {
+	Enabled    bool                  `json:"enabled"`
+	ServerName string                `json:"server_name,omitempty"`
+	ALPN       []string              `json:"alpn,omitempty"`
+	UTLS       *singBoxUTLS          `json:"utls,omitempty"`
+	Reality    *singBoxClientReality `json:"reality,omitempty"`
+}
+
+// singBoxUTLS uTLS指纹
+type singBoxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// singBoxClientReality sing-box客户端REALITY参数
+type singBoxClientReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+// renderSingBoxOutbounds 生成包含用户所有节点的sing-box出站列表
+func renderSingBoxOutbounds(store *state.Store, cfg config.V2RayConfig, address string, user config.ClientConfig) ([]byte, error) {
+	realityByTag, err := realityParamsByTag(store, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var outbounds []singBoxClientOutbound
+	for _, inbound := range cfg.EffectiveInbounds() {
+		outbound := singBoxClientOutbound{
+			Type:       inbound.Protocol,
+			Tag:        clientRemark(inbound, user),
+			Server:     address,
+			ServerPort: inbound.Port,
+		}
+		switch inbound.Protocol {
+		case "vmess":
+			outbound.UUID = user.UUID
+			outbound.Security = "auto"
+		case "vless":
+			outbound.UUID = user.UUID
+			outbound.Flow = inbound.Flow
+		case "trojan":
+			outbound.Password = user.UUID
+		case "hysteria2":
+			outbound.Password = user.UUID
+			if inbound.PortRange != "" {
+				outbound.ServerPorts = []string{strings.Replace(inbound.PortRange, "-", ":", 1)}
+			}
+		case "shadowsocks":
+			outbound.Method = inbound.Method
+			outbound.Password = inbound.Password
+		case "tuic":
+			outbound.UUID = user.UUID
+			outbound.Password = user.UUID
+			outbound.CongestionControl = "bbr"
+		}
+
+		switch inbound.Security {
+		case "tls":
+			outbound.TLS = &singBoxClientTLS{Enabled: true, ServerName: inbound.TLS.ServerName}
+			if inbound.Protocol == "hysteria2" || inbound.Protocol == "tuic" {
+				outbound.TLS.ALPN = []string{"h3"}
+			}
+		case "reality":
+			reality := realityByTag[inbound.Tag]
+			outbound.TLS = &singBoxClientTLS{
+				Enabled:    true,
+				ServerName: reality.ServerName,
+				UTLS:       &singBoxUTLS{Enabled: true, Fingerprint: reality.Fingerprint},
+				Reality:    &singBoxClientReality{Enabled: true, PublicKey: reality.PublicKey, ShortID: reality.ShortID},
+			}
+		}
+		outbounds = append(outbounds, outbound)
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{"outbounds": outbounds}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sing-box outbounds: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,49 @@
+package v2ray
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+func TestSubscriptionToken(t *testing.T) {
+	t.Parallel()
+
+	store := newTestStore(t)
+	cfg := testV2RayConfig(t)
+	cfg.Clients = []config.ClientConfig{{Email: "alice@example.com", UUID: "0b5a8a2e-7f1c-4c55-9a0e-3b0f6f1e2d4c"}}
+
+	// 并发首次查询只生成一个token
+	tokens := make([]string, 20)
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := SubscriptionToken(store, "alice@example.com")
+			if err != nil {
+				t.Error(err)
+			}
+			tokens[i] = token
+		}()
+	}
+	wg.Wait()
+	for _, token := range tokens[1:] {
+		if token != tokens[0] {
+			t.Fatalf("concurrent queries returned different tokens")
+		}
+	}
+
+	user, ok, err := FindSubscriptionUser(store, cfg, tokens[0])
+	if err != nil || !ok || user.Email != "alice@example.com" {
+		t.Errorf("FindSubscriptionUser() = %+v, %v, %v, want alice", user, ok, err)
+	}
+	if _, ok, _ := FindSubscriptionUser(store, cfg, "unknown"); ok {
+		t.Error("FindSubscriptionUser() matched an unknown token")
+	}
+	cfg.Clients = nil
+	if _, ok, _ := FindSubscriptionUser(store, cfg, tokens[0]); ok {
+		t.Error("FindSubscriptionUser() matched the token of a deleted user")
+	}
+}
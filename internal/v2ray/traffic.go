@@ -1,61 +1,83 @@
 package v2ray
 
 import (
+	"context"
+	"errors"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"go.uber.org/zap"
 )
 
 // TrafficStats 流量统计信息
 type TrafficStats struct {
-	LastActive time.Time `json:"last_active"` // 最后活动时间（文件修改时间）
+	LastActive time.Time `json:"last_active"` // 最后活动时间
 	HasTraffic bool      `json:"has_traffic"` // 是否有流量
 }
 
-// TrafficMonitor 流量监控器
+// TrafficMonitor 流量监控器，流量数据由代理核心提供
 type TrafficMonitor struct {
-	logPath     string
-	idleTimeout int
+	mu          sync.RWMutex
+	backend     ProxyBackend
+	cfg         config.V2RayConfig
+	idleTimeout time.Duration
+	log         *zap.Logger
+}
+
+// TrafficMonitorOption 流量监控器可选参数
+type TrafficMonitorOption func(*TrafficMonitor)
+
+// WithLogger 设置流量监控器使用的日志实例
+func WithLogger(log *zap.Logger) TrafficMonitorOption {
+	return func(tm *TrafficMonitor) {
+		tm.log = log
+	}
 }
 
 // NewTrafficMonitor 创建新的流量监控器
-func NewTrafficMonitor(logPath string, idleTimeout int) *TrafficMonitor {
-	return &TrafficMonitor{
-		logPath:     logPath,
+func NewTrafficMonitor(backend ProxyBackend, cfg config.V2RayConfig, idleTimeout time.Duration, opts ...TrafficMonitorOption) *TrafficMonitor {
+	tm := &TrafficMonitor{
+		backend:     backend,
+		cfg:         cfg,
 		idleTimeout: idleTimeout,
+		log:         zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(tm)
 	}
+	return tm
 }
 
-// CheckTraffic 检查最近30分钟的流量
-func (tm *TrafficMonitor) CheckTraffic() (*TrafficStats, error) {
-	// 检查access.log文件的修改时间
-	fileInfo, err := os.Stat(tm.logPath)
-	var lastActive time.Time
-	var hasTraffic bool
+// SetConfig 更新统计流量使用的配置，如访问日志路径
+func (tm *TrafficMonitor) SetConfig(cfg config.V2RayConfig) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.cfg = cfg
+}
 
-	if err != nil {
-		if os.IsNotExist(err) {
-			// 日志文件不存在，返回无流量
-			return &TrafficStats{
-				LastActive: time.Time{},
-				HasTraffic: false,
-			}, nil
-		}
-		return nil, err
-	}
+// SetIdleTimeout 更新空闲超时时间
+func (tm *TrafficMonitor) SetIdleTimeout(idleTimeout time.Duration) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.idleTimeout = idleTimeout
+}
 
-	// 获取文件修改时间
-	lastActive = fileInfo.ModTime()
-	hasTraffic = true
+// CheckTraffic 查询代理核心的最近流量活动
+func (tm *TrafficMonitor) CheckTraffic(ctx context.Context) (*TrafficStats, error) {
+	tm.mu.RLock()
+	cfg := tm.cfg
+	tm.mu.RUnlock()
 
-	return &TrafficStats{
-		LastActive: lastActive,
-		HasTraffic: hasTraffic,
-	}, nil
+	return tm.backend.Stats(ctx, cfg)
 }
 
 // IsIdle 检查是否处于空闲状态
-func (tm *TrafficMonitor) IsIdle() (bool, error) {
-	stats, err := tm.CheckTraffic()
+func (tm *TrafficMonitor) IsIdle(ctx context.Context) (bool, error) {
+	stats, err := tm.CheckTraffic(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -65,7 +87,87 @@ func (tm *TrafficMonitor) IsIdle() (bool, error) {
 		return true, nil
 	}
 
+	tm.mu.RLock()
+	idleTimeout := tm.idleTimeout
+	tm.mu.RUnlock()
+
 	// 检查最后活动时间是否超过空闲超时
 	idleTime := time.Since(stats.LastActive)
-	return idleTime > time.Duration(tm.idleTimeout)*time.Second, nil
+	tm.log.Debug("Traffic idle check",
+		zap.Time("last_active", stats.LastActive),
+		zap.Duration("idle_time", idleTime),
+		zap.Duration("idle_timeout", idleTimeout))
+	return idleTime > idleTimeout, nil
+}
+
+// accessLogTail 查找最后活动记录时读取的访问日志末尾字节数
+const accessLogTail = 64 * 1024
+
+// accessLogTimeLayout 访问日志每行开头的时间格式，Xray可能带有微秒部分
+const accessLogTimeLayout = "2006/01/02 15:04:05"
+
+// accessLogStats 以访问日志中最后一条非内部（健康检查、统计API）记录的时间作为最后活动时间，日志不存在时视为没有流量
+// 记录时间无法解析或日志为空时使用日志的修改时间；日志末尾只有内部记录时，使用其中最早一条的时间。
+func accessLogStats(logPath string) (*TrafficStats, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &TrafficStats{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	modified := &TrafficStats{LastActive: fileInfo.ModTime(), HasTraffic: true}
+
+	offset := max(fileInfo.Size()-accessLogTail, 0)
+	tail := make([]byte, fileInfo.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(tail), "\n"), "\n")
+	if offset > 0 {
+		// 第一行可能不完整
+		lines = lines[1:]
+	}
+
+	var earliestProbe time.Time
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := lines[i]
+		if line == "" {
+			continue
+		}
+		logged, parseErr := parseAccessLogTime(line)
+		if !internalAccess(line) {
+			if parseErr != nil {
+				return modified, nil
+			}
+			return &TrafficStats{LastActive: logged, HasTraffic: true}, nil
+		}
+		if parseErr == nil {
+			earliestProbe = logged
+		}
+	}
+
+	if earliestProbe.IsZero() {
+		return modified, nil
+	}
+	return &TrafficStats{LastActive: earliestProbe, HasTraffic: true}, nil
+}
+
+// internalAccess 检查访问日志记录是否来自Agent自身使用的入站：健康检查和统计API
+func internalAccess(line string) bool {
+	return strings.Contains(line, "["+healthInboundPrefix) || strings.Contains(line, "["+statsAPITag+" ")
+}
+
+// parseAccessLogTime 解析访问日志记录开头的本地时间
+func parseAccessLogTime(line string) (time.Time, error) {
+	if len(line) < len(accessLogTimeLayout) {
+		return time.Time{}, errors.New("access log line too short")
+	}
+	return time.ParseInLocation(accessLogTimeLayout, line[:len(accessLogTimeLayout)], time.Local)
 }
@@ -0,0 +1,220 @@
+package v2ray
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"go.uber.org/zap"
+)
+
+// 升级使用的二进制路径后缀
+const (
+	previousSuffix = ".previous" // 升级前的版本，用于回滚
+	stagedSuffix   = ".new"      // 待切换的新版本
+)
+
+// 升级后的健康检查参数
+const (
+	healthCheckTimeout  = 30 * time.Second
+	healthCheckStable   = 3 * time.Second // 进程需持续运行并监听端口的时间
+	healthCheckInterval = 500 * time.Millisecond
+)
+
+// UpgradeResult 升级结果
+type UpgradeResult struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Changed    bool   `json:"changed"`     // 是否切换了版本
+	RolledBack bool   `json:"rolled_back"` // 新版本未通过检查，已回滚到原版本
+}
+
+// restartChecker 重启代理核心并检查新进程是否正常运行
+type restartChecker func(ctx context.Context, log *zap.Logger, backend ProxyBackend, svc service.ServiceManager, inbound config.InboundConfig) error
+
+// Upgrade 将已安装的代理核心切换到指定版本，version 为空时使用 cfg.Version
+// 新版本先校验当前配置，再保留旧二进制并原子替换，重启后进行健康检查；
+// 新版本无法启动或未通过健康检查时自动回滚到旧版本。
+// v2ray.install.sha256 是 v2ray.version 对应发布包的摘要，切换到其他版本时不使用。
+func Upgrade(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend, svc service.ServiceManager, cfg config.V2RayConfig, version string) (*UpgradeResult, error) {
+	return upgrade(ctx, log, runner, backend, svc, cfg, version, restartAndCheck)
+}
+
+// upgrade 切换版本，重启后由check检查新进程，检查失败时回滚
+func upgrade(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend, svc service.ServiceManager, cfg config.V2RayConfig, version string, check restartChecker) (*UpgradeResult, error) {
+	version = strings.TrimPrefix(version, "v")
+	if version != "" && version != strings.TrimPrefix(cfg.Version, "v") {
+		if cfg.Install.SHA256 != "" {
+			log.Info("Ignoring v2ray.install.sha256 pinned for another version, using the release digest",
+				zap.String("pinned_version", cfg.Version),
+				zap.String("version", version))
+			cfg.Install.SHA256 = ""
+		}
+		cfg.Version = version
+	}
+	cfg.Version = strings.TrimPrefix(cfg.Version, "v")
+
+	installed, current, err := CheckInstalled(ctx, log, runner, backend)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		return nil, fmt.Errorf("%s is not installed", backend.Name())
+	}
+
+	result := &UpgradeResult{From: current, To: current}
+	if cfg.Version == current {
+		log.Info("Proxy already at desired version", zap.String("version", current))
+		return result, nil
+	}
+
+	// 获取并校验发布包，解压新二进制到临时路径
+	binary := backend.BinaryPath()
+	staged := binary + stagedSuffix
+	if err := backend.StageBinary(ctx, log, cfg, staged); err != nil {
+		return nil, err
+	}
+	defer os.Remove(staged)
+
+	target := backend.Version(ctx, log, runner, staged)
+	result.To = target
+	if target == current {
+		log.Info("Proxy already at desired version", zap.String("version", current))
+		return result, nil
+	}
+
+	// 新版本必须能解析当前配置
+	if err := backend.TestConfig(ctx, runner, staged, backend.ConfigPath()); err != nil {
+		return nil, fmt.Errorf("%s %s rejected the current config: %w", backend.Name(), target, err)
+	}
+
+	// 保留旧版本后原子替换
+	log.Info("Switching proxy version", zap.String("from", current), zap.String("to", target))
+	if err := copyFile(binary, binary+previousSuffix); err != nil {
+		return nil, fmt.Errorf("failed to keep previous %s binary: %w", backend.Name(), err)
+	}
+	if err := os.Rename(staged, binary); err != nil {
+		return nil, fmt.Errorf("failed to swap %s binary: %w", backend.Name(), err)
+	}
+	result.Changed = true
+
+	// 重启并检查新版本
+	inbound := cfg.EffectiveInbounds()[0]
+	err = check(ctx, log, backend, svc, inbound)
+	if err == nil {
+		log.Info("Proxy upgraded successfully", zap.String("from", current), zap.String("to", target))
+		return result, nil
+	}
+	log.Error("Proxy failed health check after upgrade, rolling back",
+		zap.String("version", target),
+		zap.Error(err))
+
+	if rollbackErr := rollback(ctx, log, backend, svc, inbound, check); rollbackErr != nil {
+		return result, fmt.Errorf("%s %s failed health check (%v) and rollback failed: %w", backend.Name(), target, err, rollbackErr)
+	}
+	result.RolledBack = true
+	return result, fmt.Errorf("%s %s failed health check, rolled back to %s: %w", backend.Name(), target, current, err)
+}
+
+// PreviousVersion 返回升级前保留的版本，没有旧版本时返回空
+func PreviousVersion(ctx context.Context, log *zap.Logger, runner command.Runner, backend ProxyBackend) string {
+	previous := backend.BinaryPath() + previousSuffix
+	if _, err := os.Stat(previous); err != nil {
+		return ""
+	}
+	return backend.Version(ctx, log, runner, previous)
+}
+
+// rollback 恢复旧版本二进制并重启，由check检查恢复后的进程
+func rollback(ctx context.Context, log *zap.Logger, backend ProxyBackend, svc service.ServiceManager, inbound config.InboundConfig, check restartChecker) error {
+	binary := backend.BinaryPath()
+	if err := copyFile(binary+previousSuffix, binary); err != nil {
+		return fmt.Errorf("failed to restore previous %s binary: %w", backend.Name(), err)
+	}
+	if err := check(ctx, log, backend, svc, inbound); err != nil {
+		return err
+	}
+	log.Info("Proxy rolled back to previous version")
+	return nil
+}
+
+// restartAndCheck 重启代理核心，等待新进程启动、监听入站端口并持续运行一段时间
+func restartAndCheck(ctx context.Context, log *zap.Logger, backend ProxyBackend, svc service.ServiceManager, inbound config.InboundConfig) error {
+	restartedAt := time.Now()
+	if err := svc.Restart(ctx); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", backend.Name(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	var healthySince time.Time
+	lastProblem := "process not found"
+	for {
+		problem := checkHealth(backend.BinaryPath(), restartedAt, inbound)
+		switch {
+		case problem != "":
+			healthySince = time.Time{}
+			lastProblem = problem
+		case healthySince.IsZero():
+			healthySince = time.Now()
+		case time.Since(healthySince) >= healthCheckStable:
+			log.Info("Proxy health check passed", zap.Int("port", inbound.Port))
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("health check timed out: %s", lastProblem)
+		}
+	}
+}
+
+// checkHealth 检查重启后的进程是否在运行并监听入站端口，返回发现的问题
+// Hysteria2、TUIC 基于QUIC，检查UDP端口，其他协议检查TCP监听。
+func checkHealth(binary string, restartedAt time.Time, inbound config.InboundConfig) string {
+	process, err := FindProcess(binary)
+	if err != nil {
+		return err.Error()
+	}
+	if process == nil {
+		return "process not found"
+	}
+	// /proc中的启动时间精度为10ms
+	if process.StartedAt.Before(restartedAt.Add(-time.Second)) {
+		return "process was not restarted"
+	}
+	network := "tcp"
+	if inbound.Protocol == "hysteria2" || inbound.Protocol == "tuic" {
+		network = "udp"
+	}
+	suffix := ":" + strconv.Itoa(inbound.Port)
+	for _, listener := range process.Listeners {
+		if strings.HasPrefix(listener, network+" ") && strings.HasSuffix(listener, suffix) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("not listening on %s port %d", network, inbound.Port)
+}
+
+// copyFile 原子复制文件，保留权限
+func copyFile(src string, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data, info.Mode().Perm())
+}
@@ -0,0 +1,155 @@
+package v2ray
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/service"
+	"go.uber.org/zap"
+)
+
+// fakeCheck 依次返回results中的结果，代替访问/proc的 restartAndCheck
+type fakeCheck struct {
+	mu      sync.Mutex
+	results []error
+	calls   int
+}
+
+func (c *fakeCheck) check(ctx context.Context, log *zap.Logger, backend ProxyBackend, svc service.ServiceManager, inbound config.InboundConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if len(c.results) == 0 {
+		return nil
+	}
+	err := c.results[0]
+	c.results = c.results[1:]
+	return err
+}
+
+// readBinary 读取文件内容，文件不存在时返回空
+func readBinary(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Parallel()
+
+	arch, err := releaseArch()
+	if err != nil {
+		t.Skip(err)
+	}
+	archive, digest := testArchive(t, map[string]string{"v2ray": "new binary"})
+	assetPath := "/download/v5.17.0/v2ray-linux-" + arch + ".zip"
+	badDigest := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name        string
+		version     string // Upgrade的version参数
+		cfgVersion  string
+		sha256      string
+		testErr     error   // 新版本校验配置的结果
+		checks      []error // 每次重启后的健康检查结果
+		wantErr     string
+		wantBinary  string
+		wantPrev    string
+		wantChecks  int
+		wantChanged bool
+		wantRolled  bool
+	}{
+		{
+			name: "upgrade", version: "5.17.0", cfgVersion: "5.16.1",
+			wantBinary: "new binary", wantPrev: "old binary", wantChecks: 1, wantChanged: true,
+		},
+		{
+			name: "configured version", cfgVersion: "v5.17.0",
+			wantBinary: "new binary", wantPrev: "old binary", wantChecks: 1, wantChanged: true,
+		},
+		{
+			name: "already at version", version: "5.16.1", cfgVersion: "latest",
+			wantBinary: "old binary",
+		},
+		{
+			name: "new version rejects config", version: "5.17.0", cfgVersion: "5.16.1",
+			testErr: &commandtest.ExitError{Code: 23}, wantErr: "rejected the current config",
+			wantBinary: "old binary",
+		},
+		{
+			name: "health check fails, rolled back", version: "5.17.0", cfgVersion: "5.16.1",
+			checks: []error{errors.New("not listening on tcp port 10086")}, wantErr: "rolled back to 5.16.1",
+			wantBinary: "old binary", wantPrev: "old binary", wantChecks: 2, wantChanged: true, wantRolled: true,
+		},
+		{
+			name: "rollback fails", version: "5.17.0", cfgVersion: "5.16.1",
+			checks:  []error{errors.New("process not found"), errors.New("process not found")},
+			wantErr: "rollback failed", wantBinary: "old binary", wantPrev: "old binary", wantChecks: 2, wantChanged: true,
+		},
+		{
+			// 固定的摘要属于 v2ray.version，切换到其他版本时使用发布的摘要文件
+			name: "pin for another version ignored", version: "5.17.0", cfgVersion: "5.16.1", sha256: badDigest,
+			wantBinary: "new binary", wantPrev: "old binary", wantChecks: 1, wantChanged: true,
+		},
+		{
+			name: "pin for configured version enforced", cfgVersion: "5.17.0", sha256: badDigest,
+			wantErr: "checksum mismatch", wantBinary: "old binary",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := newReleaseServer(t, map[string][]byte{assetPath: archive, assetPath + ".dgst": dgst(digest)})
+			backend := newTestBackend(t, true)
+			binary := backend.BinaryPath()
+			if err := os.WriteFile(binary, []byte("old binary"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			cfg := testV2RayConfig(t)
+			cfg.Version = tt.cfgVersion
+			cfg.Install.ReleaseURL = srv.URL
+			cfg.Install.SHA256 = tt.sha256
+
+			staged := binary + stagedSuffix
+			runner := commandtest.NewFakeRunner()
+			runner.On(binary+" version", "V2Ray 5.16.1 (V2Fly, a community-driven edition of V2Ray.)", nil)
+			runner.On(staged+" version", "V2Ray 5.17.0 (V2Fly, a community-driven edition of V2Ray.)", nil)
+			runner.On(staged+" test -config "+backend.ConfigPath(), "", tt.testErr)
+			check := &fakeCheck{results: tt.checks}
+
+			result, err := upgrade(context.Background(), zap.NewNop(), runner, backend, &fakeService{}, cfg, tt.version, check.check)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("upgrade() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("upgrade() error = %v", err)
+			}
+			if result != nil && (result.Changed != tt.wantChanged || result.RolledBack != tt.wantRolled) {
+				t.Errorf("upgrade() result = %+v, want changed %v, rolled back %v", result, tt.wantChanged, tt.wantRolled)
+			}
+			if got := readBinary(t, binary); got != tt.wantBinary {
+				t.Errorf("binary = %q, want %q", got, tt.wantBinary)
+			}
+			if got := readBinary(t, binary+previousSuffix); got != tt.wantPrev {
+				t.Errorf("previous binary = %q, want %q", got, tt.wantPrev)
+			}
+			if _, err := os.Stat(staged); !os.IsNotExist(err) {
+				t.Errorf("staged binary %s left behind", staged)
+			}
+			if check.calls != tt.wantChecks {
+				t.Errorf("health checks = %d, want %d", check.calls, tt.wantChecks)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package v2ray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yuhai94/anywhere_agent/internal/command"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+	"github.com/yuhai94/anywhere_agent/internal/state"
+)
+
+// ErrUserTrafficUnsupported 代理核心不支持或未启用按用户统计流量
+var ErrUserTrafficUnsupported = errors.New("per-user traffic stats are not supported")
+
+// userTrafficStateKey 用户累计流量在状态文件中的键，值为 email -> UserTraffic，默认用户使用 DefaultUserEmail
+const userTrafficStateKey = "traffic.users"
+
+// statsAPITag V2Ray/Xray统计API的入站和出站tag，访问日志中该入站的记录不计为流量
+const statsAPITag = "stats-api"
+
+// UserTraffic 用户的上行（客户端上传）和下行（客户端下载）字节数
+type UserTraffic struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// CollectUserTraffic 读取并清零核心中的用户流量计数，累加到状态文件中
+// 核心重启时计数归零，上次收集之后、重启之前的流量不会被统计。
+func CollectUserTraffic(ctx context.Context, runner command.Runner, backend ProxyBackend, store *state.Store, cfg config.V2RayConfig) error {
+	delta, err := backend.UserTraffic(ctx, runner, cfg)
+	if err != nil {
+		return err
+	}
+	if len(delta) == 0 {
+		return nil
+	}
+
+	totals := make(map[string]UserTraffic)
+	return store.Update(userTrafficStateKey, &totals, func() (bool, error) {
+		for email, traffic := range delta {
+			total := totals[email]
+			total.Upload += traffic.Upload
+			total.Download += traffic.Download
+			totals[email] = total
+		}
+		return true, nil
+	})
+}
+
+// GetUserTraffic 返回用户的累计流量，尚未统计时为零值
+// 默认用户使用 DefaultUserEmail 作为键。
+func GetUserTraffic(store *state.Store, email string) (UserTraffic, error) {
+	totals := make(map[string]UserTraffic)
+	if _, err := store.Get(userTrafficStateKey, &totals); err != nil {
+		return UserTraffic{}, err
+	}
+	return totals[credentialKey(email)], nil
+}
+
+// statsQueryResponse V2Ray "api stats -json" 和Xray "api statsquery" 的输出
+// 两者均为protobuf的JSON形式，int64以字符串表示，值为0时省略。
+type statsQueryResponse struct {
+	Stat []struct {
+		Name  string      `json:"name"`
+		Value json.Number `json:"value"`
+	} `json:"stat"`
+}
+
+// parseUserTraffic 从统计查询的输出中提取用户流量
+// 计数名为 "user>>>{email}>>>traffic>>>uplink|downlink"，轮换前的UUID（"{email}#previous{n}"）计入原用户。
+func parseUserTraffic(output []byte) (map[string]UserTraffic, error) {
+	var response statsQueryResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse stats query output: %w", err)
+	}
+
+	traffic := make(map[string]UserTraffic)
+	for _, stat := range response.Stat {
+		parts := strings.Split(stat.Name, ">>>")
+		if len(parts) != 4 || parts[0] != "user" || parts[2] != "traffic" {
+			continue
+		}
+		var value int64
+		if stat.Value != "" {
+			v, err := stat.Value.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for stat %s", stat.Value, stat.Name)
+			}
+			value = v
+		}
+		email, _, _ := strings.Cut(parts[1], "#previous")
+		total := traffic[email]
+		switch parts[3] {
+		case "uplink":
+			total.Upload += value
+		case "downlink":
+			total.Download += value
+		default:
+			continue
+		}
+		traffic[email] = total
+	}
+	return traffic, nil
+}
@@ -0,0 +1,147 @@
+package v2ray
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuhai94/anywhere_agent/internal/command/commandtest"
+	"github.com/yuhai94/anywhere_agent/internal/config"
+)
+
+func TestParseUserTraffic(t *testing.T) {
+	t.Parallel()
+
+	// Xray 和 V2Ray 均以字符串输出int64，值为0时省略
+	output := `{"stat": [
+		{"name": "user>>>alice@example.com>>>traffic>>>uplink", "value": "100"},
+		{"name": "user>>>alice@example.com>>>traffic>>>downlink", "value": "2000"},
+		{"name": "user>>>alice@example.com#previous1>>>traffic>>>downlink", "value": 500},
+		{"name": "user>>>default>>>traffic>>>uplink"},
+		{"name": "inbound>>>vmess>>>traffic>>>uplink", "value": "9999"}
+	]}`
+	traffic, err := parseUserTraffic([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]UserTraffic{
+		"alice@example.com": {Upload: 100, Download: 2500},
+		"default":           {},
+	}
+	if len(traffic) != len(want) {
+		t.Fatalf("parseUserTraffic() = %+v, want %+v", traffic, want)
+	}
+	for email, w := range want {
+		if traffic[email] != w {
+			t.Errorf("traffic[%q] = %+v, want %+v", email, traffic[email], w)
+		}
+	}
+
+	if _, err := parseUserTraffic([]byte("failed to dial")); err == nil {
+		t.Error("parseUserTraffic() accepted non-JSON output")
+	}
+}
+
+func TestCollectUserTraffic(t *testing.T) {
+	t.Parallel()
+
+	backend := newTestBackend(t, true)
+	store := newTestStore(t)
+	cfg := testV2RayConfig(t)
+	line := backend.binaryPath + " api stats -json -reset -s 127.0.0.1:10085"
+
+	// 每次查询返回清零后新增的流量，累加保存
+	runner := commandtest.NewFakeRunner().
+		On(line, `{"stat":[{"name":"user>>>default>>>traffic>>>uplink","value":"10"},{"name":"user>>>default>>>traffic>>>downlink","value":"20"}]}`, nil).
+		On(line, `{"stat":[{"name":"user>>>default>>>traffic>>>downlink","value":"5"}]}`, nil).
+		On(line, `{}`, nil)
+	for i := 0; i < 3; i++ {
+		if err := CollectUserTraffic(context.Background(), runner, backend, store, cfg); err != nil {
+			t.Fatalf("CollectUserTraffic() error = %v", err)
+		}
+	}
+	if traffic, err := GetUserTraffic(store, ""); err != nil || traffic != (UserTraffic{Upload: 10, Download: 25}) {
+		t.Errorf("GetUserTraffic() = %+v, %v, want 10/25", traffic, err)
+	}
+	if traffic, _ := GetUserTraffic(store, "alice@example.com"); traffic != (UserTraffic{}) {
+		t.Errorf("GetUserTraffic() for an unknown user = %+v, want zero", traffic)
+	}
+
+	// 查询失败时不修改已保存的流量
+	failing := commandtest.NewFakeRunner()
+	if err := CollectUserTraffic(context.Background(), failing, backend, store, cfg); err == nil {
+		t.Error("CollectUserTraffic() succeeded with a failing query")
+	}
+	if traffic, _ := GetUserTraffic(store, ""); traffic != (UserTraffic{Upload: 10, Download: 25}) {
+		t.Errorf("GetUserTraffic() after failure = %+v, want unchanged", traffic)
+	}
+
+	// 未配置统计API和sing-box均不支持
+	cfg.StatsAPI = ""
+	if err := CollectUserTraffic(context.Background(), runner, backend, store, cfg); !errors.Is(err, ErrUserTrafficUnsupported) {
+		t.Errorf("CollectUserTraffic() without stats_api error = %v, want ErrUserTrafficUnsupported", err)
+	}
+	singBox, _ := NewBackend(BackendSingBox)
+	if err := CollectUserTraffic(context.Background(), runner, singBox, store, testV2RayConfig(t)); !errors.Is(err, ErrUserTrafficUnsupported) {
+		t.Errorf("CollectUserTraffic() with sing-box error = %v, want ErrUserTrafficUnsupported", err)
+	}
+}
+
+func TestRenderStatsAPI(t *testing.T) {
+	t.Parallel()
+
+	cfg := testV2RayConfig(t)
+	cfg.Clients = []config.ClientConfig{{Email: "alice@example.com", UUID: "0b5a8a2e-7f1c-4c55-9a0e-3b0f6f1e2d4c"}}
+	data, err := renderCoreConfig(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server serverConfig
+	if err := json.Unmarshal(data, &server); err != nil {
+		t.Fatal(err)
+	}
+	if server.Stats == nil || server.API == nil || server.API.Tag != statsAPITag || !server.Policy.Levels["0"].StatsUserUplink {
+		t.Errorf("stats, api and policy sections missing: %s", data)
+	}
+	if server.Routing == nil || len(server.Routing.Rules) == 0 || server.Routing.Rules[0].OutboundTag != statsAPITag {
+		t.Errorf("first routing rule is not the stats API rule: %+v", server.Routing)
+	}
+	last := server.Inbounds[len(server.Inbounds)-1]
+	if last.Tag != statsAPITag || last.Listen != "127.0.0.1" || last.Port != 10085 {
+		t.Errorf("stats API inbound = %+v, want 127.0.0.1:10085", last)
+	}
+	clients := server.Inbounds[0].Settings.Clients
+	if len(clients) != 2 || clients[0].Email != DefaultUserEmail || clients[1].Email != "alice@example.com" {
+		t.Errorf("clients = %+v, want emails for all users", clients)
+	}
+
+	// 未配置统计API时不生成
+	cfg.StatsAPI = ""
+	data, _ = renderCoreConfig(cfg, "")
+	if strings.Contains(string(data), statsAPITag) || strings.Contains(string(data), DefaultUserEmail) {
+		t.Errorf("stats API rendered without v2ray.stats_api: %s", data)
+	}
+}
+
+func TestAccessLogStatsIgnoresStatsAPI(t *testing.T) {
+	t.Parallel()
+
+	path := testV2RayConfig(t).AccessLog
+	log := "2024/01/01 08:00:00 from 1.2.3.4:5000 accepted tcp:example.com:443 [vmess -> direct] email: default\n" +
+		"2024/01/01 09:00:00 from 127.0.0.1:5001 accepted tcp:127.0.0.1:0 [stats-api >> stats-api]\n"
+	if err := os.WriteFile(path, []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := accessLogStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 1, 1, 8, 0, 0, 0, time.Local)
+	if !stats.LastActive.Equal(want) {
+		t.Errorf("LastActive = %v, want %v (stats API queries are not traffic)", stats.LastActive, want)
+	}
+}
@@ -0,0 +1,46 @@
+#!/bin/bash
+
+# Anywhere Agent 卸载脚本
+
+echo "=== Anywhere Agent Uninstall Script ==="
+
+# 检查是否以root权限运行
+if [ "$EUID" -ne 0 ]; then
+  echo "Error: Please run this script as root"
+  exit 1
+fi
+
+INSTALL_TARGET="/opt/aw_agent"
+
+# 停止服务，Agent退出时删除自己的端口重定向规则
+echo "Stopping aw_agent service..."
+systemctl stop aw_agent 2>/dev/null
+systemctl disable aw_agent 2>/dev/null
+
+# Agent异常退出时规则可能残留，再次删除
+echo "Removing port redirect rules..."
+if command -v nft >/dev/null 2>&1; then
+  nft delete table inet aw_agent 2>/dev/null
+fi
+for cmd in iptables ip6tables; do
+  if command -v "$cmd" >/dev/null 2>&1; then
+    while "$cmd" -t nat -D PREROUTING -j AW_AGENT 2>/dev/null; do :; done
+    "$cmd" -t nat -F AW_AGENT 2>/dev/null
+    "$cmd" -t nat -X AW_AGENT 2>/dev/null
+  fi
+done
+
+# 删除服务文件
+echo "Removing systemd service..."
+rm -f /etc/systemd/system/aw_agent.service
+systemctl daemon-reload
+
+# 删除安装目录，保留日志和状态文件
+echo "Removing installation directory: $INSTALL_TARGET"
+rm -rf "$INSTALL_TARGET"
+
+echo
+echo "=== Uninstall Complete ==="
+echo "Logs kept at: /var/log/aw_agent"
+echo "State kept at: /var/lib/aw_agent"
+echo "Security group rules described as anywhere-agent are not removed"